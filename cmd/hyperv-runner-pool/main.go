@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/urfave/cli/v3"
 
@@ -23,6 +26,42 @@ var (
 	date    = "unknown"
 )
 
+// configReloadInterval is how often the config file is checked for changes
+const configReloadInterval = 30 * time.Second
+
+// watchConfig reloads the config file whenever it is modified and hands it to the orchestrator
+// An invalid file is logged and ignored, so a typo never takes the pool down
+func watchConfig(ctx context.Context, configPath string, orch *orchestrator.Orchestrator, log *slog.Logger) {
+	var modTime time.Time
+	if info, err := os.Stat(configPath); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(configReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(configPath)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+
+			cfg, err := config.LoadFromFile(configPath)
+			if err != nil {
+				log.Error("Failed to reload config, keeping the current one", "config_file", configPath, "error", err)
+				continue
+			}
+			log.Info("Config file changed, reloading", "config_file", configPath, "pool_size", cfg.Runners.PoolSize)
+			orch.Reload(*cfg)
+		}
+	}
+}
+
 func main() {
 	app := &cli.Command{
 		Name:    "hyperv-runner-pool",
@@ -57,16 +96,42 @@ func main() {
 			log.Info("Configuration loaded",
 				"config_file", configPath,
 				"pool_size", cfg.Runners.PoolSize,
+				"instance_id", cfg.Runners.InstanceID,
 				"mock_mode", cfg.Debug.UseMock)
-			log.Info("Using template path", "path", cfg.HyperV.TemplatePath)
-			log.Info("Using storage path", "path", cfg.HyperV.VMStoragePath)
+			log.Info("Using template path", "path", cfg.HyperV.TemplatePath, "version", cfg.HyperV.TemplateVersion)
+			if cfg.HyperV.Rollout.Candidate != "" {
+				log.Info("Rolling out template version",
+					"candidate", cfg.HyperV.Rollout.Candidate,
+					"canary_percent", cfg.HyperV.Rollout.CanaryPercent)
+			}
+			log.Info("Using storage paths", "paths", cfg.HyperV.StoragePaths, "reserve_gb", cfg.HyperV.Storage.ReserveGB)
+			if policy := cfg.HyperV.Network.Policy; policy.Enabled() {
+				log.Info("Applying network policy",
+					"allowed_egress_rules", len(policy.AllowedEgress),
+					"block_vm_to_vm", policy.BlockVMToVM,
+					"blocked_networks", policy.BlockedNetworks)
+			}
+			log.Info("Using provisioning mode", "mode", cfg.HyperV.ProvisioningMode, "config_injection", cfg.HyperV.ConfigInjection)
 
 			// Determine VM manager based on config
 			var vmMgr vmmanager.VMManager
 
-			if cfg.Debug.UseMock {
+			if cfg.Debug.UseMock && cfg.Debug.MockScenario != nil {
+				log.Info("Using Mock VM Manager with scenario (development mode)", "seed", cfg.Debug.MockScenario.Seed)
+				vmMgr = vmmanager.NewMockVMManagerWithScenario(*cfg.Debug.MockScenario, log)
+			} else if cfg.Debug.UseMock {
 				log.Info("Using Mock VM Manager (development mode)")
 				vmMgr = vmmanager.NewMockVMManager(log)
+			} else if len(cfg.HyperV.Hosts) > 0 {
+				for _, host := range cfg.HyperV.Hosts {
+					log.Info("Using Hyper-V host",
+						"host", host.Name,
+						"address", host.Address,
+						"transport", host.Transport,
+						"capacity", host.Capacity)
+				}
+				log.Info("Using multi-host Hyper-V VM Manager (production mode)", "hosts", len(cfg.HyperV.Hosts))
+				vmMgr = vmmanager.NewMultiHostManager(*cfg, log)
 			} else {
 				log.Info("Using Hyper-V VM Manager (production mode)")
 				vmMgr = vmmanager.NewHyperVManager(*cfg, log)
@@ -87,22 +152,34 @@ func main() {
 
 			// Initialize VM pool
 			log.Info("Initializing VM pool...")
-			if err := orch.InitializePool(); err != nil {
+			if err := orch.InitializePool(); errors.Is(err, vmmanager.ErrTemplateInvalid) {
+				log.Error("Template failed pre-flight checks, no VMs were created", "error", err)
+				logger.Close()
+				return err
+			} else if err != nil {
 				log.Error("Failed to initialize pool", "error", err)
 				log.Warn("Some VMs may not be ready, but continuing to run. Press Ctrl+C to shutdown.")
 			} else {
 				log.Info("Pool initialized successfully")
 			}
 
+			// Apply edits to the config file while running
+			go watchConfig(ctx, configPath, orch, log)
+
 			log.Info("Press Ctrl+C to shutdown gracefully")
 
 			// Setup signal handling for graceful shutdown
 			sigChan := make(chan os.Signal, 1)
 			signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-			// Wait for shutdown signal
-			sig := <-sigChan
-			log.Info("Received shutdown signal", "signal", sig.String())
+			// Wait for a shutdown signal, or for an error that stopped the pool
+			var fatalErr error
+			select {
+			case sig := <-sigChan:
+				log.Info("Received shutdown signal", "signal", sig.String())
+			case fatalErr = <-orch.Fatal():
+				log.Error("Shutting down after an unrecoverable error", "error", fatalErr)
+			}
 
 			// Perform graceful shutdown
 			if err := orch.Shutdown(); err != nil {
@@ -115,7 +192,7 @@ func main() {
 			// Close log file to ensure all data is flushed
 			logger.Close()
 
-			return nil
+			return fatalErr
 		},
 	}
 
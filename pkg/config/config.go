@@ -2,8 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -38,28 +41,350 @@ func (c *GitHubConfig) GetAccount() string {
 
 // RunnersConfig holds runner pool configuration
 type RunnersConfig struct {
-	PoolSize    int      `yaml:"pool_size"`
-	NamePrefix  string   `yaml:"name_prefix"`
-	Labels      []string `yaml:"labels"`       // Custom labels to add to runners
-	RunnerGroup string   `yaml:"runner_group"` // Runner group (org-level runners only)
-	CacheURL    string   `yaml:"cache_url"`    // Optional: URL to custom cache server (must end with /)
+	PoolSize                int      `yaml:"pool_size"`
+	NamePrefix              string   `yaml:"name_prefix"`
+	Labels                  []string `yaml:"labels"`                    // Custom labels to add to runners
+	RunnerGroup             string   `yaml:"runner_group"`              // Runner group (org-level runners only)
+	CacheURL                string   `yaml:"cache_url"`                 // Optional: URL to custom cache server (must end with /)
+	InstanceID              string   `yaml:"instance_id"`               // Owner ID of this orchestrator, tagged on its VMs and runners (default: host name)
+	MaxConcurrentOperations int      `yaml:"max_concurrent_operations"` // VM creations, recreations and removals in progress at once (default: 0, no limit)
+	MaxJobDurationMinutes   int      `yaml:"max_job_duration_minutes"`  // Longest a job may run before it is cancelled and its VM recycled (default: 0, no limit)
+}
+
+// OwnerLabelPrefix starts the owner label of every instance
+const OwnerLabelPrefix = "owner-"
+
+// OwnerLabel returns the runner label that marks runners registered by this instance
+func (c *RunnersConfig) OwnerLabel() string {
+	return OwnerLabelPrefix + c.InstanceID
 }
 
 // HyperVConfig holds Hyper-V specific configuration
 type HyperVConfig struct {
-	TemplatePath  string `yaml:"template_path"`
-	VMStoragePath string `yaml:"storage_path"`
-	VMUsername    string `yaml:"vm_username"`   // PowerShell Direct credentials
-	VMPassword    string `yaml:"vm_password"`   // PowerShell Direct credentials
-	VMMemoryMB    int    `yaml:"vm_memory_mb"`  // VM memory in MB (default: 4096)
-	VMCPUCount    int    `yaml:"vm_cpu_count"`  // VM CPU count (default: 2)
+	TemplatePath  string        `yaml:"template_path"` // Path of the active template version (set from templates when those are used)
+	VMStoragePath string        `yaml:"storage_path"`  // Primary storage path for VM disks
+	StoragePaths  []string      `yaml:"storage_paths"` // Optional: storage paths VMs are spread over by free space (default: [storage_path])
+	Storage       StorageConfig `yaml:"storage"`       // Disk space limits
+	VMUsername    string        `yaml:"vm_username"`   // PowerShell Direct credentials
+	VMPassword    string        `yaml:"vm_password"`   // PowerShell Direct credentials
+	VMMemoryMB    int           `yaml:"vm_memory_mb"`  // VM memory in MB (default: 4096)
+	VMCPUCount    int           `yaml:"vm_cpu_count"`  // VM CPU count (default: 2)
+
+	GuestOS                 string `yaml:"guest_os"`                  // Operating system of the template: windows, linux (default: windows)
+	ProvisioningMode        string `yaml:"provisioning_mode"`         // How slot VMs are provisioned: cold, checkpoint (default: cold)
+	CheckpointPath          string `yaml:"checkpoint_path"`           // Where the pre-booted checkpoint VM is exported (default: <storage_path>\checkpoint)
+	CheckpointSettleSeconds int    `yaml:"checkpoint_settle_seconds"` // Time to let the guest settle before saving the checkpoint (default: 30)
+	ConfigInjection         string `yaml:"config_injection"`          // How runner config reaches a cold-booted guest: mount, iso, kvp, copy_file (default: mount)
+
+	Templates       []TemplateVersionConfig `yaml:"templates"`        // Optional: named template versions (default: a single "default" version at template_path)
+	TemplateVersion string                  `yaml:"template_version"` // Active template version (default: first entry in templates)
+	Rollout         RolloutConfig           `yaml:"rollout"`          // Optional: canary rollout of a new template version
+
+	SkipTemplateValidation  bool `yaml:"skip_template_validation"`  // Skip template pre-flight checks (not recommended)
+	RequireTemplateManifest bool `yaml:"require_template_manifest"` // Fail validation when <template>.sha256 is missing
+
+	Network     NetworkConfig     `yaml:"network"`     // VM network adapters (default: one adapter on "Default Switch")
+	Hardware    HardwareConfig    `yaml:"hardware"`    // Advanced VM hardware profile
+	Diagnostics DiagnosticsConfig `yaml:"diagnostics"` // Guest diagnostics collected before an unhealthy VM is destroyed
+	Quarantine  QuarantineConfig  `yaml:"quarantine"`  // Keep failed VMs for inspection instead of destroying them
+	Metering    MeteringConfig    `yaml:"metering"`    // Per-VM resource usage sampled with Hyper-V resource metering
+	Disks       DisksConfig       `yaml:"disks"`       // Optional: extra disks attached to every VM
+
+	Hosts []HostConfig `yaml:"hosts"` // Optional: Hyper-V hosts VMs are placed on (default: the local host only)
+}
+
+// HostConfig describes one Hyper-V host driven by the orchestrator
+// Paths in the hyperv section (template, storage, checkpoint, diagnostics, quarantine) are paths on each host
+type HostConfig struct {
+	Name         string `yaml:"name"`          // Host name used in logs and placement (default: address, or "local")
+	Address      string `yaml:"address"`       // DNS name or IP address of the host (not used by the local transport)
+	Transport    string `yaml:"transport"`     // How scripts reach the host: local, winrm, ssh (default: winrm)
+	Port         int    `yaml:"port"`          // Transport port (default: 5985, 5986 with use_ssl, 22 for ssh)
+	UseSSL       bool   `yaml:"use_ssl"`       // WinRM over HTTPS
+	Username     string `yaml:"username"`      // Optional: remote account (default: the service account, or the ssh default)
+	Password     string `yaml:"password"`      // WinRM password for username
+	IdentityFile string `yaml:"identity_file"` // Optional: SSH private key file
+	Capacity     int    `yaml:"capacity"`      // Most VMs placed on the host at once (default: pool_size)
+}
+
+// Host transports for HostConfig.Transport
+const (
+	TransportLocal = "local" // powershell.exe on the machine running the orchestrator
+	TransportWinRM = "winrm" // PowerShell remoting over WinRM
+	TransportSSH   = "ssh"   // PowerShell over the OpenSSH client, key based authentication only
+)
+
+// DisksConfig holds the extra disks attached to every VM next to its system disk
+type DisksConfig struct {
+	ToolCache ToolCacheDiskConfig `yaml:"tool_cache"` // Shared read-only disk with pre-warmed tool caches
+	Scratch   ScratchDiskConfig   `yaml:"scratch"`    // Blank per-slot disk for build output
+}
+
+// ToolCacheDiskConfig describes the shared tool cache disk
+// Every VM gets a differencing disk of it, so guest writes are discarded with the VM
+type ToolCacheDiskConfig struct {
+	Path        string `yaml:"path"`         // Read-only VHDX holding the tool caches (empty: no tool cache disk)
+	DriveLetter string `yaml:"drive_letter"` // Guest drive letter (default: T)
+}
+
+// ScratchDiskConfig describes the per-slot scratch disk, deleted with the VM
+type ScratchDiskConfig struct {
+	SizeGB      int    `yaml:"size_gb"`      // Maximum size of the dynamically expanding disk (default: 0, no scratch disk)
+	DriveLetter string `yaml:"drive_letter"` // Guest drive letter, also used for the runner work directory (default: S)
+}
+
+// MeteringConfig controls per-VM resource usage sampling
+type MeteringConfig struct {
+	Enabled         bool `yaml:"enabled"`          // Enable resource metering on every VM and sample it (default: false)
+	IntervalSeconds int  `yaml:"interval_seconds"` // How often each VM is sampled (default: 60)
+}
+
+// QuarantineConfig controls how failed VMs are kept for forensic inspection
+type QuarantineConfig struct {
+	Enabled     bool   `yaml:"enabled"`       // Quarantine VMs that fail creation or health checks (default: false)
+	Path        string `yaml:"path"`          // Where quarantined VMs and their disks are moved (default: <storage_path>\quarantine)
+	MaxVMs      int    `yaml:"max_vms"`       // Quarantined VMs kept at once, oldest removed first (default: 2)
+	MaxAgeHours int    `yaml:"max_age_hours"` // Remove quarantined VMs older than this (default: 48)
+}
+
+// DiagnosticsConfig controls the diagnostics bundle pulled out of a guest before teardown
+type DiagnosticsConfig struct {
+	Enabled        bool     `yaml:"enabled"`         // Collect a bundle when a running VM fails its health checks (default: false)
+	Path           string   `yaml:"path"`            // Where bundles are stored, one directory per slot and lifecycle (default: <cwd>\vms\diagnostics)
+	EventLogs      []string `yaml:"event_logs"`      // Windows event logs to include (default: System, Application)
+	MaxEvents      int      `yaml:"max_events"`      // Newest events exported per event log (default: 500)
+	TimeoutSeconds int      `yaml:"timeout_seconds"` // Give up on a guest that does not answer in time (default: 120)
+	KeepPerSlot    int      `yaml:"keep_per_slot"`   // Bundles kept per slot, oldest removed first (default: 10)
+	MaxAgeDays     int      `yaml:"max_age_days"`    // Remove bundles older than this (default: 0, no age limit)
+}
+
+// StorageConfig holds disk space limits for VM storage
+type StorageConfig struct {
+	ReserveGB int `yaml:"reserve_gb"`  // Free space to keep on a storage path; VMs are not created below it (default: 20)
+	MaxDiskGB int `yaml:"max_disk_gb"` // Recycle a VM once its differencing disk grows beyond this size (default: 0, no cap)
+}
+
+// TemplateVersionConfig names one VM template VHDX
+type TemplateVersionConfig struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+// RolloutConfig holds canary rollout settings for a candidate template version
+type RolloutConfig struct {
+	Candidate     string `yaml:"candidate"`      // Template version being rolled out (empty: no rollout)
+	CanaryPercent int    `yaml:"canary_percent"` // Percentage of VM creations that use the candidate (default: 25)
+	PromoteAfter  int    `yaml:"promote_after"`  // Healthy canary VMs required to promote the candidate (default: 2)
+	RollbackAfter int    `yaml:"rollback_after"` // Failed canary VMs that roll the candidate back (default: 1)
+	StatePath     string `yaml:"state_path"`     // File the rollout progress and outcome are kept in across restarts (default: rollout-state.json next to the config file)
+}
+
+// DefaultTemplateVersion is the version name used when only template_path is configured
+const DefaultTemplateVersion = "default"
+
+// TemplatePathFor returns the VHDX path of a named template version
+func (h *HyperVConfig) TemplatePathFor(version string) (string, error) {
+	for _, template := range h.Templates {
+		if template.Name == version {
+			return template.Path, nil
+		}
+	}
+	return "", fmt.Errorf("unknown template version %q", version)
+}
+
+// HardwareConfig holds the advanced VM hardware profile applied when creating VMs
+type HardwareConfig struct {
+	DynamicMemory          DynamicMemoryConfig `yaml:"dynamic_memory"`
+	NestedVirtualization   bool                `yaml:"nested_virtualization"`   // Expose virtualization extensions to the guest (Docker, WSL2)
+	SecureBoot             *bool               `yaml:"secure_boot"`             // Enable Secure Boot (default: true)
+	SecureBootTemplate     string              `yaml:"secure_boot_template"`    // Optional: MicrosoftWindows, MicrosoftUEFICertificateAuthority, OpenSourceShieldedVM
+	VTPM                   bool                `yaml:"vtpm"`                    // Add a virtual TPM protected by a local key protector
+	CheckpointType         string              `yaml:"checkpoint_type"`         // Optional: Disabled, Production, ProductionOnly, Standard
+	ProcessorCompatibility bool                `yaml:"processor_compatibility"` // Limit CPU features so VMs can move between hosts with different processors
+}
+
+// DynamicMemoryConfig holds Hyper-V dynamic memory settings
+// vm_memory_mb is used as the startup memory
+type DynamicMemoryConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	MinimumMB     int  `yaml:"minimum_mb"`     // Minimum memory in MB (default: vm_memory_mb / 2)
+	MaximumMB     int  `yaml:"maximum_mb"`     // Maximum memory in MB (default: vm_memory_mb * 2)
+	BufferPercent int  `yaml:"buffer_percent"` // Extra memory to reserve above demand, 5-2000 (default: 20)
+}
+
+// SecureBootEnabled reports whether Secure Boot should be enabled
+func (h *HardwareConfig) SecureBootEnabled() bool {
+	return h.SecureBoot == nil || *h.SecureBoot
+}
+
+// NetworkConfig holds VM networking configuration
+type NetworkConfig struct {
+	Adapters []NetworkAdapterConfig `yaml:"adapters"` // Adapters attached to every VM, in order
+	Policy   NetworkPolicyConfig    `yaml:"policy"`   // Optional: port ACLs applied to every adapter of every VM
+}
+
+// NetworkPolicyConfig restricts what runner VMs can reach, enforced with Hyper-V port ACLs on the host
+type NetworkPolicyConfig struct {
+	AllowedEgress   []EgressRuleConfig `yaml:"allowed_egress"`   // Optional: when set, all other outbound traffic is denied
+	BlockVMToVM     bool               `yaml:"block_vm_to_vm"`   // Deny traffic from a runner VM to the subnets runner VMs live on
+	VMNetworks      []string           `yaml:"vm_networks"`      // Subnets runner VMs live on (default: the static_ip subnets)
+	BlockedNetworks []string           `yaml:"blocked_networks"` // Always denied in both directions, e.g. the host management network
+}
+
+// EgressRuleConfig allows outbound traffic to a network
+type EgressRuleConfig struct {
+	CIDR     string `yaml:"cidr"`     // Destination network, e.g. 0.0.0.0/0 or 10.20.0.5/32
+	Ports    []int  `yaml:"ports"`    // Optional: destination ports (default: all ports)
+	Protocol string `yaml:"protocol"` // Optional: tcp, udp (default: tcp when ports are set, otherwise any)
+}
+
+// Enabled reports whether any port ACLs have to be applied
+func (p *NetworkPolicyConfig) Enabled() bool {
+	return len(p.AllowedEgress) > 0 || p.BlockVMToVM || len(p.BlockedNetworks) > 0
+}
+
+// NetworkAdapterConfig describes one network adapter attached to every VM
+type NetworkAdapterConfig struct {
+	Name               string          `yaml:"name"`                 // Adapter name, visible in the guest via device naming (default: "Network Adapter <n>")
+	SwitchName         string          `yaml:"switch_name"`          // Hyper-V virtual switch (default: "Default Switch")
+	VLANID             int             `yaml:"vlan_id"`              // Access mode VLAN ID, 0 for untagged
+	MACAddressStart    string          `yaml:"mac_address_start"`    // Optional: first static MAC address, slot N gets start + N - 1
+	MACAddressSpoofing bool            `yaml:"mac_address_spoofing"` // Allow the guest to send traffic from other MAC addresses
+	StaticIP           *StaticIPConfig `yaml:"static_ip"`            // Optional: static IPv4 addressing instead of DHCP
+}
+
+// StaticIPConfig holds static IPv4 addressing for an adapter
+type StaticIPConfig struct {
+	StartAddress string   `yaml:"start_address"` // First address, slot N gets start + N - 1
+	PrefixLength int      `yaml:"prefix_length"` // Subnet prefix length (e.g. 24)
+	Gateway      string   `yaml:"gateway"`       // Optional: default gateway
+	DNSServers   []string `yaml:"dns_servers"`   // Optional: DNS server addresses
+}
+
+// MACAddressForSlot returns the static MAC address for a 1-based slot index
+// as 12 hex digits, or "" when the adapter uses dynamic MAC addresses
+func (a *NetworkAdapterConfig) MACAddressForSlot(index int) (string, error) {
+	if a.MACAddressStart == "" {
+		return "", nil
+	}
+
+	digits := strings.NewReplacer("-", "", ":", "", ".", "").Replace(a.MACAddressStart)
+	if len(digits) != 12 {
+		return "", fmt.Errorf("invalid MAC address %q", a.MACAddressStart)
+	}
+	start, err := strconv.ParseUint(digits, 16, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid MAC address %q", a.MACAddressStart)
+	}
+
+	mac := start + uint64(index-1)
+	if mac > 0xFFFFFFFFFFFF {
+		return "", fmt.Errorf("MAC address range starting at %s is exhausted at slot %d", a.MACAddressStart, index)
+	}
+	return fmt.Sprintf("%012X", mac), nil
+}
+
+// AddressForSlot returns the static IPv4 address for a 1-based slot index
+func (s *StaticIPConfig) AddressForSlot(index int) (netip.Addr, error) {
+	start, err := netip.ParseAddr(s.StartAddress)
+	if err != nil || !start.Is4() {
+		return netip.Addr{}, fmt.Errorf("invalid IPv4 start address %q", s.StartAddress)
+	}
+	prefix, err := start.Prefix(s.PrefixLength)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid prefix length %d", s.PrefixLength)
+	}
+
+	addr := start
+	for i := 1; i < index; i++ {
+		addr = addr.Next()
+	}
+	if !prefix.Contains(addr) || addr == lastAddress(prefix) {
+		return netip.Addr{}, fmt.Errorf("address range starting at %s leaves subnet %s at slot %d", s.StartAddress, prefix, index)
+	}
+	return addr, nil
+}
+
+// lastAddress returns the broadcast address of an IPv4 prefix
+func lastAddress(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr().As4()
+	hostBits := 32 - prefix.Bits()
+	for i := 3; i >= 0 && hostBits > 0; i-- {
+		bits := min(hostBits, 8)
+		addr[i] |= byte(1<<bits - 1)
+		hostBits -= bits
+	}
+	return netip.AddrFrom4(addr)
+}
+
+// Guest operating systems for HyperVConfig.GuestOS
+const (
+	GuestWindows = "windows" // Configured over PowerShell Direct with configure-runner.ps1
+	GuestLinux   = "linux"   // Configured by cloud-init from a NoCloud seed ISO with configure-runner.sh
+)
+
+// Provisioning modes for HyperVConfig.ProvisioningMode
+const (
+	ProvisioningCold       = "cold"       // Boot every VM from its differencing disk
+	ProvisioningCheckpoint = "checkpoint" // Restore every VM from a pre-booted saved state
+)
+
+// Config injection strategies for HyperVConfig.ConfigInjection
+const (
+	InjectionMount    = "mount"     // Mount the differencing disk on the host and write the config file
+	InjectionISO      = "iso"       // Attach a generated seed ISO as a DVD drive
+	InjectionKVP      = "kvp"       // Push the config through Hyper-V KVP data exchange
+	InjectionCopyFile = "copy_file" // Copy the config file with Copy-VMFile once the guest is running
+)
+
+// Drift actions for MonitoringConfig.DriftAction
+const (
+	DriftRecreate = "recreate" // Recreate a VM whose memory, CPU or disks were changed outside the orchestrator
+	DriftFlag     = "flag"     // Only log the change and keep the VM
+)
+
+// Health check actions for HealthCheckPolicy.Action
+const (
+	CheckActionRecreate   = "recreate"   // Destroy and recreate the VM
+	CheckActionQuarantine = "quarantine" // Quarantine the VM for inspection (with hyperv.quarantine enabled) and recreate it
+	CheckActionAlert      = "alert"      // Log an error and keep the VM
+)
+
+// HealthCheckPolicy is how many consecutive failed readings a health check tolerates, and what happens then
+type HealthCheckPolicy struct {
+	Threshold int    `yaml:"threshold"` // Consecutive failed readings before the action is taken
+	Action    string `yaml:"action"`    // recreate, quarantine or alert
+}
+
+// HealthChecksConfig holds the failure policy of the health checks that can fail transiently
+type HealthChecksConfig struct {
+	PowerStateErrors HealthCheckPolicy `yaml:"power_state_errors"` // Errors reading the VM's power state (default: 3, recreate)
+	RunnerOffline    HealthCheckPolicy `yaml:"runner_offline"`     // Readings of the runner as offline in GitHub (default: 3, quarantine)
+	RunnerNotFound   HealthCheckPolicy `yaml:"runner_not_found"`   // Readings without the runner in GitHub after the grace period (default: 2, quarantine)
+	GitHubErrors     HealthCheckPolicy `yaml:"github_errors"`      // Errors looking up the runner in GitHub (default: 10, alert)
+	GuestProbe       HealthCheckPolicy `yaml:"guest_probe"`        // Failed in-guest liveness probes (default: 2, quarantine)
+}
+
+// GuestProbeConfig configures the liveness probe run inside the guest with every health check
+// Windows guests are probed over PowerShell Direct; Linux guests report through KVP data exchange
+type GuestProbeConfig struct {
+	Enabled              bool `yaml:"enabled"`                 // Probe registered runners inside their guest (default: false)
+	TimeoutSeconds       int  `yaml:"timeout_seconds"`         // How long a guest may take to answer (default: 20)
+	HeartbeatSeconds     int  `yaml:"heartbeat_seconds"`       // How often the configure script writes its heartbeat; stale after three intervals (default: 0, no heartbeat; 30 for Linux guests)
+	MinFreeDiskGB        int  `yaml:"min_free_disk_gb"`        // Free space the guest's system disk must keep (default: 0, not checked)
+	MaxLogSilenceMinutes int  `yaml:"max_log_silence_minutes"` // Longest the runner log may go without a new line (default: 0, not checked)
 }
 
 // MonitoringConfig holds health monitoring configuration
 type MonitoringConfig struct {
-	HealthCheckIntervalSeconds int `yaml:"health_check_interval_seconds"` // How often to check health (default: 30)
-	CreationTimeoutMinutes     int `yaml:"creation_timeout_minutes"`      // Max time for VM to boot and register (default: 5)
-	GracePeriodMinutes         int `yaml:"grace_period_minutes"`          // Grace period before checking GitHub registration (default: 5)
+	HealthCheckIntervalSeconds int                `yaml:"health_check_interval_seconds"` // How often to check health (default: 30)
+	CreationTimeoutMinutes     int                `yaml:"creation_timeout_minutes"`      // Max time for VM to boot and register (default: 5)
+	GracePeriodMinutes         int                `yaml:"grace_period_minutes"`          // Grace period before checking GitHub registration (default: 5)
+	GCIntervalMinutes          int                `yaml:"gc_interval_minutes"`           // How often to collect orphaned VMs and disks (default: 10)
+	GCGracePeriodMinutes       int                `yaml:"gc_grace_period_minutes"`       // Minimum age of an orphaned VM or disk before it is collected (default: 30)
+	DriftAction                string             `yaml:"drift_action"`                  // What to do with a VM whose hardware changed: recreate or flag (default: recreate)
+	HealthChecks               HealthChecksConfig `yaml:"health_checks"`                 // Failure thresholds and actions per health check
+	GuestProbe                 GuestProbeConfig   `yaml:"guest_probe"`                   // Optional in-guest liveness probe
 }
 
 // LoggingConfig holds logging configuration
@@ -71,7 +396,27 @@ type LoggingConfig struct {
 
 // DebugConfig holds debugging configuration
 type DebugConfig struct {
-	UseMock bool `yaml:"use_mock"` // Use mock VM manager for development/testing
+	UseMock      bool                `yaml:"use_mock"`      // Use mock VM manager for development/testing
+	MockScenario *MockScenarioConfig `yaml:"mock_scenario"` // Optional: latency, failures and job completion simulated by the mock
+}
+
+// MockScenarioConfig scripts the behaviour of the mock VM manager for failure and soak testing
+type MockScenarioConfig struct {
+	Seed              uint64         `yaml:"seed"`               // Random seed, so a run can be replayed (default: 0, seeded from the clock)
+	CreateVM          MockCallConfig `yaml:"create_vm"`          // CreateVM latency and failures
+	DestroyVM         MockCallConfig `yaml:"destroy_vm"`         // DestroyVM latency and failures
+	GetVMState        MockCallConfig `yaml:"get_vm_state"`       // GetVMState latency and failures
+	JobMinSeconds     int            `yaml:"job_min_seconds"`    // Shortest simulated job; the VM turns Off when its job ends
+	JobMaxSeconds     int            `yaml:"job_max_seconds"`    // Longest simulated job (default: 0, VMs run forever)
+	VanishProbability float64        `yaml:"vanish_probability"` // Chance per GetVMState call that a VM was deleted externally
+}
+
+// MockCallConfig simulates latency and failures for one mock VM manager call
+type MockCallConfig struct {
+	LatencyMinMS       int     `yaml:"latency_min_ms"`      // Shortest simulated call latency
+	LatencyMaxMS       int     `yaml:"latency_max_ms"`      // Longest simulated call latency, drawn uniformly (default: latency_min_ms)
+	FailureProbability float64 `yaml:"failure_probability"` // Chance that a call fails, 0-1
+	FailCalls          []int   `yaml:"fail_calls"`          // Calls that always fail, numbered from 1
 }
 
 // LoadFromFile loads configuration from a YAML file
@@ -90,9 +435,18 @@ func LoadFromFile(path string) (*Config, error) {
 	if config.Runners.PoolSize == 0 {
 		config.Runners.PoolSize = 1
 	}
+	if config.Runners.MaxConcurrentOperations < 0 {
+		return nil, fmt.Errorf("runners.max_concurrent_operations must not be negative")
+	}
+	if config.Runners.MaxJobDurationMinutes < 0 {
+		return nil, fmt.Errorf("runners.max_job_duration_minutes must not be negative")
+	}
 	if config.Runners.NamePrefix == "" {
 		config.Runners.NamePrefix = "runner-"
 	}
+	if err := applyInstanceID(&config.Runners); err != nil {
+		return nil, err
+	}
 	if config.HyperV.VMUsername == "" {
 		config.HyperV.VMUsername = "Administrator"
 	}
@@ -114,6 +468,24 @@ func LoadFromFile(path string) (*Config, error) {
 	if config.Monitoring.GracePeriodMinutes == 0 {
 		config.Monitoring.GracePeriodMinutes = 5
 	}
+	if config.Monitoring.GCIntervalMinutes == 0 {
+		config.Monitoring.GCIntervalMinutes = 10
+	}
+	if config.Monitoring.GCGracePeriodMinutes == 0 {
+		config.Monitoring.GCGracePeriodMinutes = 30
+	}
+	if config.Monitoring.GCIntervalMinutes < 0 || config.Monitoring.GCGracePeriodMinutes < 0 {
+		return nil, fmt.Errorf("monitoring.gc_interval_minutes and gc_grace_period_minutes must not be negative")
+	}
+	if config.Monitoring.DriftAction == "" {
+		config.Monitoring.DriftAction = DriftRecreate
+	}
+	if config.Monitoring.DriftAction != DriftRecreate && config.Monitoring.DriftAction != DriftFlag {
+		return nil, fmt.Errorf("monitoring.drift_action must be %q or %q, got %q", DriftRecreate, DriftFlag, config.Monitoring.DriftAction)
+	}
+	if err := validateHealthChecks(&config.Monitoring.HealthChecks); err != nil {
+		return nil, err
+	}
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
@@ -146,6 +518,111 @@ func LoadFromFile(path string) (*Config, error) {
 	if config.HyperV.VMStoragePath == "" {
 		config.HyperV.VMStoragePath = fmt.Sprintf(`%s\vms\storage`, cwd)
 	}
+	if err := applyDiagnosticsDefaults(&config.HyperV.Diagnostics, cwd); err != nil {
+		return nil, err
+	}
+	if err := validateTemplates(&config.HyperV); err != nil {
+		return nil, err
+	}
+	if config.HyperV.Rollout.StatePath == "" {
+		config.HyperV.Rollout.StatePath = filepath.Join(filepath.Dir(path), "rollout-state.json")
+	}
+	if config.HyperV.Quarantine.Path == "" {
+		config.HyperV.Quarantine.Path = fmt.Sprintf(`%s\quarantine`, config.HyperV.VMStoragePath)
+	}
+	if config.HyperV.Quarantine.MaxVMs == 0 {
+		config.HyperV.Quarantine.MaxVMs = 2
+	}
+	if config.HyperV.Quarantine.MaxAgeHours == 0 {
+		config.HyperV.Quarantine.MaxAgeHours = 48
+	}
+	if config.HyperV.Quarantine.MaxVMs < 0 || config.HyperV.Quarantine.MaxAgeHours < 0 {
+		return nil, fmt.Errorf("hyperv.quarantine.max_vms and max_age_hours must not be negative")
+	}
+	if config.HyperV.Metering.IntervalSeconds == 0 {
+		config.HyperV.Metering.IntervalSeconds = 60
+	}
+	if config.HyperV.Metering.IntervalSeconds < 0 {
+		return nil, fmt.Errorf("hyperv.metering.interval_seconds must not be negative")
+	}
+	if len(config.HyperV.StoragePaths) == 0 {
+		config.HyperV.StoragePaths = []string{config.HyperV.VMStoragePath}
+	}
+	if config.HyperV.Storage.ReserveGB == 0 {
+		config.HyperV.Storage.ReserveGB = 20
+	}
+	if config.HyperV.Storage.ReserveGB < 0 || config.HyperV.Storage.MaxDiskGB < 0 {
+		return nil, fmt.Errorf("hyperv.storage.reserve_gb and max_disk_gb must not be negative")
+	}
+	if config.HyperV.ProvisioningMode == "" {
+		config.HyperV.ProvisioningMode = ProvisioningCold
+	}
+	if config.HyperV.CheckpointPath == "" {
+		config.HyperV.CheckpointPath = fmt.Sprintf(`%s\checkpoint`, config.HyperV.VMStoragePath)
+	}
+	if config.HyperV.CheckpointSettleSeconds == 0 {
+		config.HyperV.CheckpointSettleSeconds = 30
+	}
+
+	// Validate provisioning mode
+	switch config.HyperV.ProvisioningMode {
+	case ProvisioningCold, ProvisioningCheckpoint:
+	default:
+		return nil, fmt.Errorf("hyperv.provisioning_mode must be %q or %q, got %q",
+			ProvisioningCold, ProvisioningCheckpoint, config.HyperV.ProvisioningMode)
+	}
+
+	// Checkpoint restores always seed the config over PowerShell Direct
+	if config.HyperV.ConfigInjection != "" && config.HyperV.ProvisioningMode == ProvisioningCheckpoint {
+		return nil, fmt.Errorf("hyperv.config_injection only applies to %q provisioning", ProvisioningCold)
+	}
+	if config.HyperV.GuestOS == "" {
+		config.HyperV.GuestOS = GuestWindows
+	}
+	switch config.HyperV.GuestOS {
+	case GuestWindows, GuestLinux:
+	default:
+		return nil, fmt.Errorf("hyperv.guest_os must be %q or %q, got %q", GuestWindows, GuestLinux, config.HyperV.GuestOS)
+	}
+	if err := validateGuestProbe(&config.Monitoring.GuestProbe, config.HyperV.GuestOS); err != nil {
+		return nil, err
+	}
+	if config.HyperV.ConfigInjection == "" && config.HyperV.GuestOS == GuestLinux {
+		config.HyperV.ConfigInjection = InjectionISO
+	}
+	if config.HyperV.ConfigInjection == "" {
+		config.HyperV.ConfigInjection = InjectionMount
+	}
+	switch config.HyperV.ConfigInjection {
+	case InjectionMount, InjectionISO, InjectionKVP, InjectionCopyFile:
+	default:
+		return nil, fmt.Errorf("hyperv.config_injection must be one of %q, %q, %q or %q, got %q",
+			InjectionMount, InjectionISO, InjectionKVP, InjectionCopyFile, config.HyperV.ConfigInjection)
+	}
+
+	if err := validateHardware(&config.HyperV); err != nil {
+		return nil, err
+	}
+	if err := validateDisks(&config.HyperV.Disks); err != nil {
+		return nil, err
+	}
+	if err := validateHosts(config.HyperV.Hosts, config.Runners.PoolSize); err != nil {
+		return nil, err
+	}
+
+	if len(config.HyperV.Network.Adapters) == 0 {
+		config.HyperV.Network.Adapters = []NetworkAdapterConfig{{}}
+	}
+	if err := validateNetwork(&config.HyperV.Network, config.Runners.PoolSize); err != nil {
+		return nil, err
+	}
+	if err := validateLinuxGuest(&config.HyperV); err != nil {
+		return nil, err
+	}
+
+	if err := validateMockScenario(config.Debug.MockScenario); err != nil {
+		return nil, err
+	}
 
 	// Validate cache URL if provided
 	if config.Runners.CacheURL != "" && !strings.HasSuffix(config.Runners.CacheURL, "/") {
@@ -182,3 +659,472 @@ func LoadFromFile(path string) (*Config, error) {
 
 	return &config, nil
 }
+
+// validateLinuxGuest rejects settings that need PowerShell Direct or a Windows guest
+// when the template runs Linux
+func validateLinuxGuest(hyperv *HyperVConfig) error {
+	if hyperv.GuestOS != GuestLinux {
+		return nil
+	}
+
+	if hyperv.ProvisioningMode != ProvisioningCold {
+		return fmt.Errorf("hyperv.guest_os %q only supports %q provisioning", GuestLinux, ProvisioningCold)
+	}
+	if hyperv.ConfigInjection != InjectionISO && hyperv.ConfigInjection != InjectionKVP {
+		return fmt.Errorf("hyperv.guest_os %q requires hyperv.config_injection %q or %q, got %q",
+			GuestLinux, InjectionISO, InjectionKVP, hyperv.ConfigInjection)
+	}
+	if hyperv.Diagnostics.Enabled {
+		return fmt.Errorf("hyperv.diagnostics collects guest files over PowerShell Direct and is not supported for %q guests", GuestLinux)
+	}
+
+	// cloud-init matches network settings to adapters by MAC address, since Linux does not see Hyper-V
+	// device names, and a network config with static addresses must list every adapter
+	static := slices.ContainsFunc(hyperv.Network.Adapters, func(adapter NetworkAdapterConfig) bool {
+		return adapter.StaticIP != nil
+	})
+	for i, adapter := range hyperv.Network.Adapters {
+		if static && adapter.MACAddressStart == "" {
+			return fmt.Errorf("hyperv.network.adapters[%d].mac_address_start is required when static_ip is used with %q guests", i, GuestLinux)
+		}
+	}
+
+	// Linux boot loaders are signed by the Microsoft UEFI CA, not the Windows key
+	if hyperv.Hardware.SecureBootEnabled() && hyperv.Hardware.SecureBootTemplate == "" {
+		hyperv.Hardware.SecureBootTemplate = "MicrosoftUEFICertificateAuthority"
+	}
+	return nil
+}
+
+// validateNetwork fills in adapter defaults and checks that every slot in the pool
+// gets a valid MAC and IP address
+func validateNetwork(network *NetworkConfig, poolSize int) error {
+	names := make(map[string]bool)
+	for i := range network.Adapters {
+		adapter := &network.Adapters[i]
+		field := fmt.Sprintf("hyperv.network.adapters[%d]", i)
+
+		if adapter.Name == "" {
+			adapter.Name = fmt.Sprintf("Network Adapter %d", i+1)
+		}
+		if adapter.SwitchName == "" {
+			adapter.SwitchName = "Default Switch"
+		}
+		if names[adapter.Name] {
+			return fmt.Errorf("%s.name %q is used by more than one adapter", field, adapter.Name)
+		}
+		names[adapter.Name] = true
+
+		if adapter.VLANID < 0 || adapter.VLANID > 4094 {
+			return fmt.Errorf("%s.vlan_id must be between 0 and 4094", field)
+		}
+		if _, err := adapter.MACAddressForSlot(poolSize); err != nil {
+			return fmt.Errorf("%s.mac_address_start: %w", field, err)
+		}
+
+		if ip := adapter.StaticIP; ip != nil {
+			if ip.PrefixLength < 1 || ip.PrefixLength > 30 {
+				return fmt.Errorf("%s.static_ip.prefix_length must be between 1 and 30", field)
+			}
+			if _, err := ip.AddressForSlot(poolSize); err != nil {
+				return fmt.Errorf("%s.static_ip: %w", field, err)
+			}
+			if ip.Gateway != "" {
+				if gateway, err := netip.ParseAddr(ip.Gateway); err != nil || !gateway.Is4() {
+					return fmt.Errorf("%s.static_ip.gateway %q is not a valid IPv4 address", field, ip.Gateway)
+				}
+			}
+			for _, dns := range ip.DNSServers {
+				if _, err := netip.ParseAddr(dns); err != nil {
+					return fmt.Errorf("%s.static_ip.dns_servers: %q is not a valid IP address", field, dns)
+				}
+			}
+		}
+	}
+	return validateNetworkPolicy(network)
+}
+
+// validateNetworkPolicy fills in policy defaults and checks networks, ports and protocols
+func validateNetworkPolicy(network *NetworkConfig) error {
+	policy := &network.Policy
+
+	for i := range policy.AllowedEgress {
+		rule := &policy.AllowedEgress[i]
+		field := fmt.Sprintf("hyperv.network.policy.allowed_egress[%d]", i)
+
+		if _, err := netip.ParsePrefix(rule.CIDR); err != nil {
+			return fmt.Errorf("%s.cidr %q is not a valid CIDR", field, rule.CIDR)
+		}
+		for _, port := range rule.Ports {
+			if port < 1 || port > 65535 {
+				return fmt.Errorf("%s.ports: %d is not a valid port", field, port)
+			}
+		}
+		rule.Protocol = strings.ToLower(rule.Protocol)
+		if rule.Protocol == "" && len(rule.Ports) > 0 {
+			rule.Protocol = "tcp"
+		}
+		switch rule.Protocol {
+		case "", "tcp", "udp":
+		default:
+			return fmt.Errorf("%s.protocol must be tcp or udp, got %q", field, rule.Protocol)
+		}
+	}
+
+	if policy.BlockVMToVM && len(policy.VMNetworks) == 0 {
+		for _, adapter := range network.Adapters {
+			if adapter.StaticIP == nil {
+				continue
+			}
+			start, _ := netip.ParseAddr(adapter.StaticIP.StartAddress)
+			subnet, _ := start.Prefix(adapter.StaticIP.PrefixLength)
+			policy.VMNetworks = append(policy.VMNetworks, subnet.String())
+		}
+		if len(policy.VMNetworks) == 0 {
+			return fmt.Errorf("hyperv.network.policy.block_vm_to_vm needs vm_networks when no adapter uses static_ip")
+		}
+	}
+	for _, cidr := range policy.VMNetworks {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("hyperv.network.policy.vm_networks: %q is not a valid CIDR", cidr)
+		}
+	}
+	for _, cidr := range policy.BlockedNetworks {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("hyperv.network.policy.blocked_networks: %q is not a valid CIDR", cidr)
+		}
+	}
+	return nil
+}
+
+// applyInstanceID defaults the instance ID to the host name and checks that it fits in a runner label
+func applyInstanceID(runners *RunnersConfig) error {
+	if runners.InstanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("runners.instance_id is not set and the host name is unavailable: %w", err)
+		}
+		runners.InstanceID = strings.ToLower(hostname)
+	}
+
+	if len(runners.InstanceID) > 64 {
+		return fmt.Errorf("runners.instance_id must be at most 64 characters, got %q", runners.InstanceID)
+	}
+	for _, ch := range runners.InstanceID {
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '.') {
+			return fmt.Errorf("runners.instance_id may only contain letters, digits, '-', '_' and '.', got %q", runners.InstanceID)
+		}
+	}
+	return nil
+}
+
+// validateDisks fills in extra disk defaults and checks the guest drive letters
+func validateDisks(disks *DisksConfig) error {
+	if disks.ToolCache.DriveLetter == "" {
+		disks.ToolCache.DriveLetter = "T"
+	}
+	if disks.Scratch.DriveLetter == "" {
+		disks.Scratch.DriveLetter = "S"
+	}
+	if disks.Scratch.SizeGB < 0 {
+		return fmt.Errorf("hyperv.disks.scratch.size_gb must not be negative")
+	}
+
+	for _, letter := range []*string{&disks.ToolCache.DriveLetter, &disks.Scratch.DriveLetter} {
+		*letter = strings.ToUpper(strings.TrimSuffix(*letter, ":"))
+		if len(*letter) != 1 || (*letter)[0] < 'D' || (*letter)[0] > 'Z' {
+			return fmt.Errorf("hyperv.disks drive letters must be between D and Z, got %q", *letter)
+		}
+	}
+	if disks.ToolCache.Path != "" && disks.Scratch.SizeGB > 0 && disks.ToolCache.DriveLetter == disks.Scratch.DriveLetter {
+		return fmt.Errorf("hyperv.disks.tool_cache and scratch must use different drive letters")
+	}
+	return nil
+}
+
+// validateHealthChecks fills in the default threshold and action of every health check and validates them
+func validateHealthChecks(checks *HealthChecksConfig) error {
+	for _, check := range []struct {
+		name     string
+		policy   *HealthCheckPolicy
+		defaults HealthCheckPolicy
+	}{
+		{"power_state_errors", &checks.PowerStateErrors, HealthCheckPolicy{Threshold: 3, Action: CheckActionRecreate}},
+		{"runner_offline", &checks.RunnerOffline, HealthCheckPolicy{Threshold: 3, Action: CheckActionQuarantine}},
+		{"runner_not_found", &checks.RunnerNotFound, HealthCheckPolicy{Threshold: 2, Action: CheckActionQuarantine}},
+		{"github_errors", &checks.GitHubErrors, HealthCheckPolicy{Threshold: 10, Action: CheckActionAlert}},
+		{"guest_probe", &checks.GuestProbe, HealthCheckPolicy{Threshold: 2, Action: CheckActionQuarantine}},
+	} {
+		field := "monitoring.health_checks." + check.name
+		if check.policy.Threshold == 0 {
+			check.policy.Threshold = check.defaults.Threshold
+		}
+		if check.policy.Threshold < 0 {
+			return fmt.Errorf("%s.threshold must not be negative", field)
+		}
+		if check.policy.Action == "" {
+			check.policy.Action = check.defaults.Action
+		}
+		switch check.policy.Action {
+		case CheckActionRecreate, CheckActionQuarantine, CheckActionAlert:
+		default:
+			return fmt.Errorf("%s.action must be one of %q, %q or %q, got %q",
+				field, CheckActionRecreate, CheckActionQuarantine, CheckActionAlert, check.policy.Action)
+		}
+	}
+	return nil
+}
+
+// validateGuestProbe fills in the guest probe defaults and validates them
+func validateGuestProbe(probe *GuestProbeConfig, guestOS string) error {
+	if probe.TimeoutSeconds < 0 || probe.HeartbeatSeconds < 0 || probe.MinFreeDiskGB < 0 || probe.MaxLogSilenceMinutes < 0 {
+		return fmt.Errorf("monitoring.guest_probe settings must not be negative")
+	}
+	if probe.TimeoutSeconds == 0 {
+		probe.TimeoutSeconds = 20
+	}
+	// Linux guests have no PowerShell Direct, so their heartbeat loop is what reports the probe results
+	if probe.Enabled && guestOS == GuestLinux && probe.HeartbeatSeconds == 0 {
+		probe.HeartbeatSeconds = 30
+	}
+	return nil
+}
+
+// validateHosts fills in host defaults and checks that the hosts can hold the whole pool
+func validateHosts(hosts []HostConfig, poolSize int) error {
+	names := make(map[string]bool)
+	capacity := 0
+	for i := range hosts {
+		host := &hosts[i]
+		field := fmt.Sprintf("hyperv.hosts[%d]", i)
+
+		if host.Transport == "" {
+			host.Transport = TransportWinRM
+		}
+		switch host.Transport {
+		case TransportLocal:
+			if host.Name == "" {
+				host.Name = "local"
+			}
+		case TransportWinRM, TransportSSH:
+			if host.Address == "" {
+				return fmt.Errorf("%s.address is required for the %s transport", field, host.Transport)
+			}
+		default:
+			return fmt.Errorf("%s.transport must be %q, %q or %q, got %q",
+				field, TransportLocal, TransportWinRM, TransportSSH, host.Transport)
+		}
+		if host.Name == "" {
+			host.Name = host.Address
+		}
+		if names[host.Name] {
+			return fmt.Errorf("%s.name %q is used by more than one host", field, host.Name)
+		}
+		names[host.Name] = true
+
+		if host.Port == 0 && host.Transport != TransportLocal {
+			switch {
+			case host.Transport == TransportSSH:
+				host.Port = 22
+			case host.UseSSL:
+				host.Port = 5986
+			default:
+				host.Port = 5985
+			}
+		}
+		if host.Transport == TransportSSH && host.Password != "" {
+			return fmt.Errorf("%s.password is not supported by the ssh transport, use identity_file", field)
+		}
+
+		if host.Capacity == 0 {
+			host.Capacity = poolSize
+		}
+		if host.Capacity < 0 {
+			return fmt.Errorf("%s.capacity must not be negative", field)
+		}
+		capacity += host.Capacity
+	}
+
+	if len(hosts) > 0 && capacity < poolSize {
+		return fmt.Errorf("hyperv.hosts capacity (%d) is smaller than runners.pool_size (%d)", capacity, poolSize)
+	}
+	return nil
+}
+
+// validateMockScenario fills in mock scenario defaults and checks ranges and probabilities
+func validateMockScenario(scenario *MockScenarioConfig) error {
+	if scenario == nil {
+		return nil
+	}
+
+	calls := []struct {
+		field string
+		call  *MockCallConfig
+	}{
+		{"create_vm", &scenario.CreateVM},
+		{"destroy_vm", &scenario.DestroyVM},
+		{"get_vm_state", &scenario.GetVMState},
+	}
+	for _, c := range calls {
+		field := "debug.mock_scenario." + c.field
+		if c.call.LatencyMaxMS == 0 {
+			c.call.LatencyMaxMS = c.call.LatencyMinMS
+		}
+		if c.call.LatencyMinMS < 0 || c.call.LatencyMaxMS < c.call.LatencyMinMS {
+			return fmt.Errorf("%s requires 0 <= latency_min_ms <= latency_max_ms", field)
+		}
+		if c.call.FailureProbability < 0 || c.call.FailureProbability > 1 {
+			return fmt.Errorf("%s.failure_probability must be between 0 and 1", field)
+		}
+		for _, n := range c.call.FailCalls {
+			if n < 1 {
+				return fmt.Errorf("%s.fail_calls are numbered from 1, got %d", field, n)
+			}
+		}
+	}
+
+	if scenario.JobMinSeconds < 0 || (scenario.JobMaxSeconds != 0 && scenario.JobMaxSeconds < scenario.JobMinSeconds) {
+		return fmt.Errorf("debug.mock_scenario requires 0 <= job_min_seconds <= job_max_seconds")
+	}
+	if scenario.JobMinSeconds > 0 && scenario.JobMaxSeconds == 0 {
+		scenario.JobMaxSeconds = scenario.JobMinSeconds
+	}
+	if scenario.VanishProbability < 0 || scenario.VanishProbability > 1 {
+		return fmt.Errorf("debug.mock_scenario.vanish_probability must be between 0 and 1")
+	}
+	return nil
+}
+
+// applyDiagnosticsDefaults fills in and validates the diagnostics settings
+func applyDiagnosticsDefaults(diagnostics *DiagnosticsConfig, cwd string) error {
+	if diagnostics.Path == "" {
+		diagnostics.Path = fmt.Sprintf(`%s\vms\diagnostics`, cwd)
+	}
+	if len(diagnostics.EventLogs) == 0 {
+		diagnostics.EventLogs = []string{"System", "Application"}
+	}
+	if diagnostics.MaxEvents == 0 {
+		diagnostics.MaxEvents = 500
+	}
+	if diagnostics.TimeoutSeconds == 0 {
+		diagnostics.TimeoutSeconds = 120
+	}
+	if diagnostics.KeepPerSlot == 0 {
+		diagnostics.KeepPerSlot = 10
+	}
+	if diagnostics.MaxEvents < 0 || diagnostics.TimeoutSeconds < 0 || diagnostics.KeepPerSlot < 0 || diagnostics.MaxAgeDays < 0 {
+		return fmt.Errorf("hyperv.diagnostics limits must not be negative")
+	}
+	return nil
+}
+
+// validateHardware fills in hardware profile defaults and rejects combinations Hyper-V refuses
+func validateHardware(hyperv *HyperVConfig) error {
+	hw := &hyperv.Hardware
+
+	if hyperv.VMMemoryMB%2 != 0 {
+		return fmt.Errorf("hyperv.vm_memory_mb must be a multiple of 2")
+	}
+	if hyperv.VMCPUCount < 1 {
+		return fmt.Errorf("hyperv.vm_cpu_count must be at least 1")
+	}
+
+	if dm := &hw.DynamicMemory; dm.Enabled {
+		if dm.MinimumMB == 0 {
+			dm.MinimumMB = hyperv.VMMemoryMB / 2
+			dm.MinimumMB -= dm.MinimumMB % 2
+		}
+		if dm.MaximumMB == 0 {
+			dm.MaximumMB = hyperv.VMMemoryMB * 2
+		}
+		if dm.BufferPercent == 0 {
+			dm.BufferPercent = 20
+		}
+
+		if dm.MinimumMB%2 != 0 || dm.MaximumMB%2 != 0 {
+			return fmt.Errorf("hyperv.hardware.dynamic_memory minimum_mb and maximum_mb must be multiples of 2")
+		}
+		if dm.MinimumMB > hyperv.VMMemoryMB || hyperv.VMMemoryMB > dm.MaximumMB {
+			return fmt.Errorf("hyperv.hardware.dynamic_memory requires minimum_mb (%d) <= vm_memory_mb (%d) <= maximum_mb (%d)",
+				dm.MinimumMB, hyperv.VMMemoryMB, dm.MaximumMB)
+		}
+		if dm.BufferPercent < 5 || dm.BufferPercent > 2000 {
+			return fmt.Errorf("hyperv.hardware.dynamic_memory.buffer_percent must be between 5 and 2000")
+		}
+		if hw.NestedVirtualization {
+			return fmt.Errorf("hyperv.hardware.nested_virtualization requires dynamic memory to be disabled")
+		}
+	}
+
+	switch hw.SecureBootTemplate {
+	case "", "MicrosoftWindows", "MicrosoftUEFICertificateAuthority", "OpenSourceShieldedVM":
+	default:
+		return fmt.Errorf("hyperv.hardware.secure_boot_template %q is not a known Secure Boot template", hw.SecureBootTemplate)
+	}
+	if hw.SecureBootTemplate != "" && !hw.SecureBootEnabled() {
+		return fmt.Errorf("hyperv.hardware.secure_boot_template is set but secure_boot is disabled")
+	}
+
+	switch hw.CheckpointType {
+	case "", "Disabled", "Production", "ProductionOnly", "Standard":
+	default:
+		return fmt.Errorf("hyperv.hardware.checkpoint_type %q must be Disabled, Production, ProductionOnly or Standard", hw.CheckpointType)
+	}
+
+	return nil
+}
+
+// validateTemplates resolves the active template version and checks the rollout settings
+func validateTemplates(hyperv *HyperVConfig) error {
+	if len(hyperv.Templates) == 0 {
+		hyperv.Templates = []TemplateVersionConfig{{Name: DefaultTemplateVersion, Path: hyperv.TemplatePath}}
+	}
+
+	names := make(map[string]bool)
+	for i, template := range hyperv.Templates {
+		if template.Name == "" || template.Path == "" {
+			return fmt.Errorf("hyperv.templates[%d] requires both name and path", i)
+		}
+		if names[template.Name] {
+			return fmt.Errorf("hyperv.templates[%d].name %q is used by more than one template", i, template.Name)
+		}
+		names[template.Name] = true
+	}
+
+	if hyperv.TemplateVersion == "" {
+		hyperv.TemplateVersion = hyperv.Templates[0].Name
+	}
+	path, err := hyperv.TemplatePathFor(hyperv.TemplateVersion)
+	if err != nil {
+		return fmt.Errorf("hyperv.template_version: %w", err)
+	}
+	hyperv.TemplatePath = path
+
+	rollout := &hyperv.Rollout
+	if rollout.Candidate == "" {
+		return nil
+	}
+	if _, err := hyperv.TemplatePathFor(rollout.Candidate); err != nil {
+		return fmt.Errorf("hyperv.rollout.candidate: %w", err)
+	}
+	if rollout.Candidate == hyperv.TemplateVersion {
+		return fmt.Errorf("hyperv.rollout.candidate must differ from hyperv.template_version")
+	}
+	if rollout.CanaryPercent == 0 {
+		rollout.CanaryPercent = 25
+	}
+	if rollout.PromoteAfter == 0 {
+		rollout.PromoteAfter = 2
+	}
+	if rollout.RollbackAfter == 0 {
+		rollout.RollbackAfter = 1
+	}
+	if rollout.CanaryPercent < 1 || rollout.CanaryPercent > 100 {
+		return fmt.Errorf("hyperv.rollout.canary_percent must be between 1 and 100")
+	}
+	if rollout.PromoteAfter < 1 || rollout.RollbackAfter < 1 {
+		return fmt.Errorf("hyperv.rollout.promote_after and rollback_after must be at least 1")
+	}
+
+	return nil
+}
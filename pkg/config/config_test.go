@@ -0,0 +1,573 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestConfig writes YAML to a temp file and loads it
+func loadTestConfig(t *testing.T, yamlContent string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return LoadFromFile(path)
+}
+
+func TestLoadFromFile_Defaults(t *testing.T) {
+	cfg, err := loadTestConfig(t, "debug:\n  use_mock: true\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.HyperV.ProvisioningMode != ProvisioningCold {
+		t.Errorf("Expected provisioning mode %q, got %q", ProvisioningCold, cfg.HyperV.ProvisioningMode)
+	}
+
+	if cfg.HyperV.Storage.ReserveGB != 20 || cfg.HyperV.Storage.MaxDiskGB != 0 {
+		t.Errorf("Unexpected storage defaults: %+v", cfg.HyperV.Storage)
+	}
+	if cfg.HyperV.Disks.ToolCache.DriveLetter != "T" || cfg.HyperV.Disks.Scratch.DriveLetter != "S" || cfg.HyperV.Disks.Scratch.SizeGB != 0 {
+		t.Errorf("Unexpected disk defaults: %+v", cfg.HyperV.Disks)
+	}
+	if cfg.HyperV.Metering.Enabled || cfg.HyperV.Metering.IntervalSeconds != 60 {
+		t.Errorf("Unexpected metering defaults: %+v", cfg.HyperV.Metering)
+	}
+	if cfg.Monitoring.GCIntervalMinutes != 10 || cfg.Monitoring.GCGracePeriodMinutes != 30 {
+		t.Errorf("Unexpected garbage collection defaults: %+v", cfg.Monitoring)
+	}
+	if cfg.Monitoring.DriftAction != DriftRecreate {
+		t.Errorf("Expected drift action %q, got %q", DriftRecreate, cfg.Monitoring.DriftAction)
+	}
+	if len(cfg.HyperV.StoragePaths) != 1 || cfg.HyperV.StoragePaths[0] != cfg.HyperV.VMStoragePath {
+		t.Errorf("Expected storage paths to default to [%s], got %v", cfg.HyperV.VMStoragePath, cfg.HyperV.StoragePaths)
+	}
+
+	adapters := cfg.HyperV.Network.Adapters
+	if len(adapters) != 1 {
+		t.Fatalf("Expected 1 default network adapter, got %d", len(adapters))
+	}
+	if adapters[0].SwitchName != "Default Switch" || adapters[0].Name != "Network Adapter 1" {
+		t.Errorf("Unexpected default adapter: %+v", adapters[0])
+	}
+}
+
+func TestLoadFromFile_ConfigInjection(t *testing.T) {
+	cfg, err := loadTestConfig(t, "debug:\n  use_mock: true\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.HyperV.ConfigInjection != InjectionMount {
+		t.Errorf("Expected default config injection %q, got %q", InjectionMount, cfg.HyperV.ConfigInjection)
+	}
+
+	if _, err := loadTestConfig(t, "debug:\n  use_mock: true\nhyperv:\n  config_injection: floppy\n"); err == nil {
+		t.Error("Expected error for unknown config injection strategy")
+	}
+	if _, err := loadTestConfig(t, "debug:\n  use_mock: true\nhyperv:\n  provisioning_mode: checkpoint\n  config_injection: iso\n"); err == nil {
+		t.Error("Expected error for config injection in checkpoint mode")
+	}
+}
+
+func TestLoadFromFile_InvalidProvisioningMode(t *testing.T) {
+	_, err := loadTestConfig(t, "debug:\n  use_mock: true\nhyperv:\n  provisioning_mode: warm\n")
+	if err == nil || !strings.Contains(err.Error(), "provisioning_mode") {
+		t.Errorf("Expected provisioning_mode error, got %v", err)
+	}
+}
+
+func TestLoadFromFile_InvalidMaxJobDuration(t *testing.T) {
+	_, err := loadTestConfig(t, "debug:\n  use_mock: true\nrunners:\n  max_job_duration_minutes: -1\n")
+	if err == nil || !strings.Contains(err.Error(), "max_job_duration_minutes") {
+		t.Errorf("Expected max_job_duration_minutes error, got %v", err)
+	}
+}
+
+func TestLoadFromFile_InvalidDriftAction(t *testing.T) {
+	_, err := loadTestConfig(t, "debug:\n  use_mock: true\nmonitoring:\n  drift_action: ignore\n")
+	if err == nil || !strings.Contains(err.Error(), "drift_action") {
+		t.Errorf("Expected drift_action error, got %v", err)
+	}
+}
+
+func TestLoadFromFile_HealthChecks(t *testing.T) {
+	cfg, err := loadTestConfig(t, "debug:\n  use_mock: true\nmonitoring:\n  health_checks:\n    runner_offline:\n      threshold: 5\n    github_errors:\n      action: recreate\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	checks := cfg.Monitoring.HealthChecks
+	if checks.PowerStateErrors != (HealthCheckPolicy{Threshold: 3, Action: CheckActionRecreate}) {
+		t.Errorf("Unexpected power state defaults: %+v", checks.PowerStateErrors)
+	}
+	if checks.RunnerOffline != (HealthCheckPolicy{Threshold: 5, Action: CheckActionQuarantine}) {
+		t.Errorf("Expected the offline threshold to be overridden, got %+v", checks.RunnerOffline)
+	}
+	if checks.RunnerNotFound != (HealthCheckPolicy{Threshold: 2, Action: CheckActionQuarantine}) {
+		t.Errorf("Unexpected runner not found defaults: %+v", checks.RunnerNotFound)
+	}
+	if checks.GitHubErrors != (HealthCheckPolicy{Threshold: 10, Action: CheckActionRecreate}) {
+		t.Errorf("Expected the GitHub error action to be overridden, got %+v", checks.GitHubErrors)
+	}
+
+	_, err = loadTestConfig(t, "debug:\n  use_mock: true\nmonitoring:\n  health_checks:\n    runner_offline:\n      action: ignore\n")
+	if err == nil || !strings.Contains(err.Error(), "runner_offline.action") {
+		t.Errorf("Expected runner_offline.action error, got %v", err)
+	}
+	if _, err := loadTestConfig(t, "debug:\n  use_mock: true\nmonitoring:\n  health_checks:\n    power_state_errors:\n      threshold: -1\n"); err == nil {
+		t.Error("Expected error for a negative threshold")
+	}
+}
+
+func TestLoadFromFile_GuestProbe(t *testing.T) {
+	cfg, err := loadTestConfig(t, "debug:\n  use_mock: true\nmonitoring:\n  guest_probe:\n    enabled: true\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if probe := cfg.Monitoring.GuestProbe; probe.TimeoutSeconds != 20 || probe.HeartbeatSeconds != 0 {
+		t.Errorf("Unexpected guest probe defaults: %+v", probe)
+	}
+	if cfg.Monitoring.HealthChecks.GuestProbe != (HealthCheckPolicy{Threshold: 2, Action: CheckActionQuarantine}) {
+		t.Errorf("Unexpected guest probe failure policy: %+v", cfg.Monitoring.HealthChecks.GuestProbe)
+	}
+
+	// Linux guests report through their heartbeat, so it is on by default
+	cfg, err = loadTestConfig(t, "debug:\n  use_mock: true\nhyperv:\n  guest_os: linux\nmonitoring:\n  guest_probe:\n    enabled: true\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Monitoring.GuestProbe.HeartbeatSeconds != 30 {
+		t.Errorf("Expected a 30 second heartbeat for Linux guests, got %d", cfg.Monitoring.GuestProbe.HeartbeatSeconds)
+	}
+
+	if _, err := loadTestConfig(t, "debug:\n  use_mock: true\nmonitoring:\n  guest_probe:\n    min_free_disk_gb: -1\n"); err == nil {
+		t.Error("Expected error for a negative free disk minimum")
+	}
+}
+
+func TestLoadFromFile_NetworkValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		wantErr string
+	}{
+		{
+			name:    "valid static addressing",
+			network: "adapters:\n      - switch_name: Build\n        vlan_id: 20\n        mac_address_start: 00-15-5D-0A-00-01\n        static_ip:\n          start_address: 10.0.5.10\n          prefix_length: 24\n          gateway: 10.0.5.1\n          dns_servers: [10.0.0.53]\n",
+		},
+		{
+			name:    "vlan out of range",
+			network: "adapters:\n      - vlan_id: 5000\n",
+			wantErr: "vlan_id",
+		},
+		{
+			name:    "bad mac",
+			network: "adapters:\n      - mac_address_start: not-a-mac\n",
+			wantErr: "mac_address_start",
+		},
+		{
+			name:    "duplicate adapter names",
+			network: "adapters:\n      - name: build\n      - name: build\n",
+			wantErr: "more than one adapter",
+		},
+		{
+			name:    "pool does not fit in subnet",
+			network: "adapters:\n      - static_ip:\n          start_address: 10.0.5.253\n          prefix_length: 24\n",
+			wantErr: "leaves subnet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, "debug:\n  use_mock: true\nrunners:\n  pool_size: 3\nhyperv:\n  network:\n    "+tt.network)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNetworkAdapterConfig_MACAddressForSlot(t *testing.T) {
+	adapter := NetworkAdapterConfig{MACAddressStart: "00:15:5D:0A:00:FF"}
+
+	mac, err := adapter.MACAddressForSlot(2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mac != "00155D0A0100" {
+		t.Errorf("Expected 00155D0A0100, got %s", mac)
+	}
+
+	dynamic := NetworkAdapterConfig{}
+	if mac, err := dynamic.MACAddressForSlot(1); err != nil || mac != "" {
+		t.Errorf("Expected dynamic MAC, got %q (%v)", mac, err)
+	}
+}
+
+func TestStaticIPConfig_AddressForSlot(t *testing.T) {
+	ip := StaticIPConfig{StartAddress: "10.0.6.254", PrefixLength: 23}
+
+	addr, err := ip.AddressForSlot(3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if addr.String() != "10.0.7.0" {
+		t.Errorf("Expected 10.0.7.0, got %s", addr)
+	}
+
+	// 10.0.7.255 is the broadcast address of 10.0.6.0/23
+	if _, err := ip.AddressForSlot(258); err == nil {
+		t.Error("Expected error for broadcast address")
+	}
+}
+
+func TestLoadFromFile_HardwareValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		hardware string
+		wantErr  string
+	}{
+		{
+			name:     "dynamic memory defaults",
+			hardware: "dynamic_memory:\n      enabled: true\n",
+		},
+		{
+			name:     "nested virtualization with secure boot template",
+			hardware: "nested_virtualization: true\n    secure_boot_template: MicrosoftUEFICertificateAuthority\n    vtpm: true\n",
+		},
+		{
+			name:     "startup memory below minimum",
+			hardware: "dynamic_memory:\n      enabled: true\n      minimum_mb: 8192\n",
+			wantErr:  "minimum_mb",
+		},
+		{
+			name:     "nested virtualization with dynamic memory",
+			hardware: "nested_virtualization: true\n    dynamic_memory:\n      enabled: true\n",
+			wantErr:  "requires dynamic memory to be disabled",
+		},
+		{
+			name:     "unknown secure boot template",
+			hardware: "secure_boot_template: Windows\n",
+			wantErr:  "secure_boot_template",
+		},
+		{
+			name:     "template with secure boot disabled",
+			hardware: "secure_boot: false\n    secure_boot_template: MicrosoftWindows\n",
+			wantErr:  "secure_boot is disabled",
+		},
+		{
+			name:     "unknown checkpoint type",
+			hardware: "checkpoint_type: Snapshot\n",
+			wantErr:  "checkpoint_type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, "debug:\n  use_mock: true\nhyperv:\n  vm_memory_mb: 4096\n  hardware:\n    "+tt.hardware)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if dm := cfg.HyperV.Hardware.DynamicMemory; dm.Enabled && (dm.MinimumMB != 2048 || dm.MaximumMB != 8192 || dm.BufferPercent != 20) {
+					t.Errorf("Unexpected dynamic memory defaults: %+v", dm)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadFromFile_TemplateVersions(t *testing.T) {
+	cfg, err := loadTestConfig(t, `debug:
+  use_mock: true
+hyperv:
+  template_path: C:\templates\legacy.vhdx
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.HyperV.TemplateVersion != DefaultTemplateVersion || len(cfg.HyperV.Templates) != 1 {
+		t.Errorf("Expected a single default template version, got %q %+v", cfg.HyperV.TemplateVersion, cfg.HyperV.Templates)
+	}
+
+	cfg, err = loadTestConfig(t, `debug:
+  use_mock: true
+hyperv:
+  templates:
+    - name: v1
+      path: C:\templates\v1.vhdx
+    - name: v2
+      path: C:\templates\v2.vhdx
+  template_version: v1
+  rollout:
+    candidate: v2
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.HyperV.TemplatePath != `C:\templates\v1.vhdx` {
+		t.Errorf("Expected template_path to follow the active version, got %s", cfg.HyperV.TemplatePath)
+	}
+	if r := cfg.HyperV.Rollout; r.CanaryPercent != 25 || r.PromoteAfter != 2 || r.RollbackAfter != 1 {
+		t.Errorf("Unexpected rollout defaults: %+v", r)
+	}
+	if r := cfg.HyperV.Rollout; filepath.Base(r.StatePath) != "rollout-state.json" || !filepath.IsAbs(r.StatePath) {
+		t.Errorf("Expected the rollout state next to the config file, got %q", r.StatePath)
+	}
+
+	_, err = loadTestConfig(t, `debug:
+  use_mock: true
+hyperv:
+  templates:
+    - name: v1
+      path: C:\templates\v1.vhdx
+  rollout:
+    candidate: v3
+`)
+	if err == nil || !strings.Contains(err.Error(), "rollout.candidate") {
+		t.Errorf("Expected unknown candidate error, got %v", err)
+	}
+}
+
+func TestLoadFromFile_Hosts(t *testing.T) {
+	cfg, err := loadTestConfig(t, `debug:
+  use_mock: true
+runners:
+  pool_size: 4
+hyperv:
+  hosts:
+    - transport: local
+      capacity: 1
+    - address: hv02.example.com
+    - name: hv03
+      address: 10.0.0.3
+      transport: ssh
+      username: pool
+      identity_file: C:\keys\pool
+      capacity: 2
+    - address: hv04.example.com
+      use_ssl: true
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	want := []HostConfig{
+		{Name: "local", Transport: TransportLocal, Capacity: 1},
+		{Name: "hv02.example.com", Address: "hv02.example.com", Transport: TransportWinRM, Port: 5985, Capacity: 4},
+		{Name: "hv03", Address: "10.0.0.3", Transport: TransportSSH, Port: 22, Username: "pool", IdentityFile: `C:\keys\pool`, Capacity: 2},
+		{Name: "hv04.example.com", Address: "hv04.example.com", Transport: TransportWinRM, Port: 5986, UseSSL: true, Capacity: 4},
+	}
+	if len(cfg.HyperV.Hosts) != len(want) {
+		t.Fatalf("Expected %d hosts, got %d", len(want), len(cfg.HyperV.Hosts))
+	}
+	for i := range want {
+		if cfg.HyperV.Hosts[i] != want[i] {
+			t.Errorf("Host %d: expected %+v, got %+v", i, want[i], cfg.HyperV.Hosts[i])
+		}
+	}
+
+	tests := []struct {
+		name    string
+		hosts   string
+		wantErr string
+	}{
+		{name: "missing address", hosts: "- transport: winrm\n", wantErr: "address is required"},
+		{name: "unknown transport", hosts: "- address: hv01\n      transport: rdp\n", wantErr: "transport"},
+		{name: "duplicate names", hosts: "- address: hv01\n    - address: hv01\n", wantErr: "more than one host"},
+		{name: "ssh password", hosts: "- address: hv01\n      transport: ssh\n      password: secret\n", wantErr: "identity_file"},
+		{name: "too little capacity", hosts: "- address: hv01\n      capacity: 1\n    - address: hv02\n      capacity: 1\n", wantErr: "capacity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, "debug:\n  use_mock: true\nrunners:\n  pool_size: 3\nhyperv:\n  hosts:\n    "+tt.hosts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadFromFile_MockScenario(t *testing.T) {
+	cfg, err := loadTestConfig(t, `debug:
+  use_mock: true
+  mock_scenario:
+    seed: 42
+    create_vm:
+      latency_min_ms: 200
+      fail_calls: [2, 5]
+    get_vm_state:
+      latency_min_ms: 10
+      latency_max_ms: 50
+      failure_probability: 0.1
+    job_min_seconds: 60
+    vanish_probability: 0.01
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	scenario := cfg.Debug.MockScenario
+	if scenario == nil {
+		t.Fatal("Expected a mock scenario")
+	}
+	if scenario.CreateVM.LatencyMaxMS != 200 {
+		t.Errorf("Expected latency_max_ms to default to latency_min_ms, got %d", scenario.CreateVM.LatencyMaxMS)
+	}
+	if scenario.JobMaxSeconds != 60 {
+		t.Errorf("Expected job_max_seconds to default to job_min_seconds, got %d", scenario.JobMaxSeconds)
+	}
+
+	for _, invalid := range []string{
+		"create_vm:\n      failure_probability: 1.5\n",
+		"destroy_vm:\n      latency_min_ms: 100\n      latency_max_ms: 50\n",
+		"get_vm_state:\n      fail_calls: [0]\n",
+		"job_min_seconds: 60\n    job_max_seconds: 30\n",
+		"vanish_probability: -1\n",
+	} {
+		if _, err := loadTestConfig(t, "debug:\n  use_mock: true\n  mock_scenario:\n    "+invalid); err == nil {
+			t.Errorf("Expected error for mock scenario %q", invalid)
+		}
+	}
+}
+
+func TestLoadFromFile_NetworkPolicy(t *testing.T) {
+	cfg, err := loadTestConfig(t, `debug:
+  use_mock: true
+hyperv:
+  network:
+    adapters:
+      - static_ip:
+          start_address: 10.0.5.10
+          prefix_length: 24
+    policy:
+      allowed_egress:
+        - cidr: 0.0.0.0/0
+          ports: [443]
+        - cidr: 10.20.0.0/16
+          protocol: UDP
+      block_vm_to_vm: true
+      blocked_networks: [192.168.10.0/24]
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	policy := cfg.HyperV.Network.Policy
+	if policy.AllowedEgress[0].Protocol != "tcp" || policy.AllowedEgress[1].Protocol != "udp" {
+		t.Errorf("Unexpected egress protocols: %+v", policy.AllowedEgress)
+	}
+	if len(policy.VMNetworks) != 1 || policy.VMNetworks[0] != "10.0.5.0/24" {
+		t.Errorf("Expected vm_networks to default to the static_ip subnet, got %v", policy.VMNetworks)
+	}
+
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{name: "bad cidr", policy: "allowed_egress:\n        - cidr: 10.0.0.0/33\n", wantErr: "cidr"},
+		{name: "bad port", policy: "allowed_egress:\n        - cidr: 0.0.0.0/0\n          ports: [70000]\n", wantErr: "port"},
+		{name: "bad protocol", policy: "allowed_egress:\n        - cidr: 0.0.0.0/0\n          protocol: icmp\n", wantErr: "protocol"},
+		{name: "vm networks unknown", policy: "block_vm_to_vm: true\n", wantErr: "vm_networks"},
+		{name: "bad blocked network", policy: "blocked_networks: [management]\n", wantErr: "blocked_networks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, "debug:\n  use_mock: true\nhyperv:\n  network:\n    policy:\n      "+tt.policy)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadFromFile_Disks(t *testing.T) {
+	cfg, err := loadTestConfig(t, `debug:
+  use_mock: true
+hyperv:
+  disks:
+    tool_cache:
+      path: C:\templates\toolcache.vhdx
+      drive_letter: "t:"
+    scratch:
+      size_gb: 100
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.HyperV.Disks.ToolCache.DriveLetter != "T" {
+		t.Errorf("Expected drive letter to be normalized to T, got %q", cfg.HyperV.Disks.ToolCache.DriveLetter)
+	}
+
+	for _, invalid := range []string{
+		"scratch:\n      size_gb: -1\n",
+		"scratch:\n      drive_letter: C\n",
+		"scratch:\n      drive_letter: SS\n",
+		"tool_cache:\n      path: C:\\toolcache.vhdx\n      drive_letter: S\n    scratch:\n      size_gb: 10\n",
+	} {
+		if _, err := loadTestConfig(t, "debug:\n  use_mock: true\nhyperv:\n  disks:\n    "+invalid); err == nil {
+			t.Errorf("Expected error for disks %q", invalid)
+		}
+	}
+}
+
+func TestLoadFromFile_LinuxGuest(t *testing.T) {
+	cfg, err := loadTestConfig(t, "debug:\n  use_mock: true\nhyperv:\n  guest_os: linux\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.HyperV.ConfigInjection != InjectionISO {
+		t.Errorf("Expected config injection to default to %q for Linux guests, got %q", InjectionISO, cfg.HyperV.ConfigInjection)
+	}
+	if cfg.HyperV.Hardware.SecureBootTemplate != "MicrosoftUEFICertificateAuthority" {
+		t.Errorf("Expected the UEFI CA Secure Boot template for Linux guests, got %q", cfg.HyperV.Hardware.SecureBootTemplate)
+	}
+
+	for _, invalid := range []string{
+		"guest_os: macos\n",
+		"guest_os: linux\n  provisioning_mode: checkpoint\n",
+		"guest_os: linux\n  config_injection: mount\n",
+		"guest_os: linux\n  diagnostics:\n    enabled: true\n",
+		"guest_os: linux\n  network:\n    adapters:\n      - static_ip:\n          start_address: 10.0.5.10\n          prefix_length: 24\n",
+	} {
+		if _, err := loadTestConfig(t, "debug:\n  use_mock: true\nhyperv:\n  "+invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestLoadFromFile_InstanceID(t *testing.T) {
+	cfg, err := loadTestConfig(t, "debug:\n  use_mock: true\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	if cfg.Runners.InstanceID != strings.ToLower(hostname) {
+		t.Errorf("Expected instance ID to default to the host name %q, got %q", hostname, cfg.Runners.InstanceID)
+	}
+
+	cfg, err = loadTestConfig(t, "debug:\n  use_mock: true\nrunners:\n  instance_id: build-host-01\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if label := cfg.Runners.OwnerLabel(); label != "owner-build-host-01" {
+		t.Errorf("Expected owner label owner-build-host-01, got %q", label)
+	}
+
+	for _, invalid := range []string{"build host", "pool,a", strings.Repeat("a", 65)} {
+		if _, err := loadTestConfig(t, "debug:\n  use_mock: true\nrunners:\n  instance_id: \""+invalid+"\"\n"); err == nil {
+			t.Errorf("Expected error for instance_id %q", invalid)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package github
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -36,7 +37,7 @@ func (c *Client) getAuthenticatedClient(ctx context.Context) (*github.Client, *g
 		c.config.GitHub.AppPrivateKeyPath,
 	)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create GitHub App transport: %w", err)
+		return nil, nil, fmt.Errorf("%w: failed to create GitHub App transport: %w", ErrUnauthorized, err)
 	}
 
 	// Create a GitHub client with app authentication to find installation
@@ -44,9 +45,9 @@ func (c *Client) getAuthenticatedClient(ctx context.Context) (*github.Client, *g
 
 	// List all installations for this GitHub App
 	// This approach works for both personal accounts and organizations
-	installations, _, err := appClient.Apps.ListInstallations(ctx, nil)
+	installations, resp, err := appClient.Apps.ListInstallations(ctx, nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to list installations: %w", err)
+		return nil, nil, apiError("list installations", resp, err)
 	}
 
 	// Find the installation matching our configured org/user account
@@ -60,7 +61,7 @@ func (c *Client) getAuthenticatedClient(ctx context.Context) (*github.Client, *g
 	}
 
 	if installation == nil {
-		return nil, nil, fmt.Errorf("GitHub App is not installed on account '%s'. Please install the app at: https://github.com/apps/YOUR_APP_NAME/installations/new", account)
+		return nil, nil, fmt.Errorf("%w on account '%s'. Please install the app at: https://github.com/apps/YOUR_APP_NAME/installations/new", ErrNotInstalled, account)
 	}
 
 	c.logger.Debug("Found GitHub App installation",
@@ -108,16 +109,16 @@ func (c *Client) GetRunnerToken() (string, error) {
 			c.config.GitHub.Repo,
 		)
 		if err != nil {
-			return "", fmt.Errorf("failed to create repo runner token: %w", err)
+			return "", apiError("create repo runner token", resp, err)
 		}
 	} else if isUserAccount {
 		// Personal accounts don't support account-level runners
-		return "", fmt.Errorf("personal accounts (User type) require a repository to be specified. Please set 'github.repo' in your config")
+		return "", fmt.Errorf("%w. Please set 'github.repo' in your config", ErrRepositoryRequired)
 	} else {
 		// Organization-level runner (only for organizations)
 		token, resp, err = client.Actions.CreateOrganizationRegistrationToken(ctx, c.config.GitHub.GetAccount())
 		if err != nil {
-			return "", fmt.Errorf("failed to create org runner token: %w", err)
+			return "", apiError("create org runner token", resp, err)
 		}
 	}
 
@@ -135,7 +136,18 @@ func (c *Client) GetRunnerToken() (string, error) {
 type RunnerInfo struct {
 	ID     int64
 	Name   string
-	Status string // "online", "offline"
+	Status string   // "online", "offline"
+	Busy   bool     // Whether the runner is running a job
+	Labels []string // Label names, including the owner label of the instance that registered it
+}
+
+// runnerLabels returns the label names of a runner
+func runnerLabels(runner *github.Runner) []string {
+	labels := make([]string, 0, len(runner.Labels))
+	for _, label := range runner.Labels {
+		labels = append(labels, label.GetName())
+	}
+	return labels
 }
 
 // ListRunners lists all runners for the configured repository or organization
@@ -169,7 +181,7 @@ func (c *Client) ListRunners() ([]RunnerInfo, error) {
 				opts,
 			)
 			if err != nil {
-				return nil, fmt.Errorf("failed to list repo runners: %w", err)
+				return nil, apiError("list repo runners", resp, err)
 			}
 
 			for _, runner := range runnerList.Runners {
@@ -181,6 +193,8 @@ func (c *Client) ListRunners() ([]RunnerInfo, error) {
 					ID:     runner.GetID(),
 					Name:   runner.GetName(),
 					Status: status,
+					Busy:   runner.GetBusy(),
+					Labels: runnerLabels(runner),
 				})
 			}
 
@@ -190,7 +204,7 @@ func (c *Client) ListRunners() ([]RunnerInfo, error) {
 			opts.Page = resp.NextPage
 		}
 	} else if isUserAccount {
-		return nil, fmt.Errorf("personal accounts require a repository to be specified")
+		return nil, ErrRepositoryRequired
 	} else {
 		// Organization-level runners
 		opts := &github.ListRunnersOptions{
@@ -203,7 +217,7 @@ func (c *Client) ListRunners() ([]RunnerInfo, error) {
 				opts,
 			)
 			if err != nil {
-				return nil, fmt.Errorf("failed to list org runners: %w", err)
+				return nil, apiError("list org runners", resp, err)
 			}
 
 			for _, runner := range runnerList.Runners {
@@ -215,6 +229,8 @@ func (c *Client) ListRunners() ([]RunnerInfo, error) {
 					ID:     runner.GetID(),
 					Name:   runner.GetName(),
 					Status: status,
+					Busy:   runner.GetBusy(),
+					Labels: runnerLabels(runner),
 				})
 			}
 
@@ -233,6 +249,7 @@ func (c *Client) ListRunners() ([]RunnerInfo, error) {
 }
 
 // RemoveRunner removes a runner from GitHub by ID
+// Returns ErrRunnerNotFound when the runner is already gone, e.g. an ephemeral runner after its job
 func (c *Client) RemoveRunner(runnerID int64, runnerName string) error {
 	// In mock mode, just log
 	if c.config.Debug.UseMock {
@@ -257,14 +274,17 @@ func (c *Client) RemoveRunner(runnerID int64, runnerName string) error {
 			c.config.GitHub.Repo,
 			runnerID,
 		)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %s (%d)", ErrRunnerNotFound, runnerName, runnerID)
+		}
 		if err != nil {
-			return fmt.Errorf("failed to remove repo runner: %w", err)
+			return apiError("remove repo runner", resp, err)
 		}
 		if resp.StatusCode != http.StatusNoContent {
 			return fmt.Errorf("unexpected status code %d when removing runner", resp.StatusCode)
 		}
 	} else if isUserAccount {
-		return fmt.Errorf("personal accounts require a repository to be specified")
+		return ErrRepositoryRequired
 	} else {
 		// Organization-level runner
 		resp, err := client.Actions.RemoveOrganizationRunner(
@@ -272,8 +292,11 @@ func (c *Client) RemoveRunner(runnerID int64, runnerName string) error {
 			c.config.GitHub.GetAccount(),
 			runnerID,
 		)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %s (%d)", ErrRunnerNotFound, runnerName, runnerID)
+		}
 		if err != nil {
-			return fmt.Errorf("failed to remove org runner: %w", err)
+			return apiError("remove org runner", resp, err)
 		}
 		if resp.StatusCode != http.StatusNoContent {
 			return fmt.Errorf("unexpected status code %d when removing runner", resp.StatusCode)
@@ -304,3 +327,73 @@ func (c *Client) GetRunnerByName(name string) (*RunnerInfo, error) {
 	// Runner not found
 	return nil, nil
 }
+
+// CancelRunnerJob cancels the workflow run whose job is running on the named runner
+// GitHub cannot cancel a single job, so the whole run is cancelled
+// Returns the ID of the job that ran on the runner
+// Jobs are only looked up in the configured repository; returns ErrJobNotFound when no in-progress
+// job runs on the runner
+func (c *Client) CancelRunnerJob(runnerName string) (int64, error) {
+	// In mock mode, just log
+	if c.config.Debug.UseMock {
+		c.logger.Debug("Mock mode: skipping job cancellation", "runner_name", runnerName)
+		return 0, nil
+	}
+	if c.config.GitHub.Repo == "" {
+		return 0, fmt.Errorf("%w: jobs can only be looked up in a configured repository", ErrJobNotFound)
+	}
+
+	ctx := context.Background()
+
+	client, _, err := c.getAuthenticatedClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	owner, repo := c.config.GitHub.GetAccount(), c.config.GitHub.Repo
+	runOpts := &github.ListWorkflowRunsOptions{
+		Status:      "in_progress",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		runs, resp, err := client.Actions.ListRepositoryWorkflowRuns(ctx, owner, repo, runOpts)
+		if err != nil {
+			return 0, apiError("list workflow runs", resp, err)
+		}
+
+		for _, run := range runs.WorkflowRuns {
+			jobs, jobsResp, err := client.Actions.ListWorkflowJobs(ctx, owner, repo, run.GetID(), &github.ListWorkflowJobsOptions{
+				Filter:      "latest",
+				ListOptions: github.ListOptions{PerPage: 100},
+			})
+			if err != nil {
+				return 0, apiError("list workflow jobs", jobsResp, err)
+			}
+
+			for _, job := range jobs.Jobs {
+				if job.GetRunnerName() != runnerName || job.GetStatus() != "in_progress" {
+					continue
+				}
+				// GitHub accepts the cancellation and carries it out asynchronously
+				cancelResp, err := client.Actions.CancelWorkflowRunByID(ctx, owner, repo, run.GetID())
+				var accepted *github.AcceptedError
+				if err != nil && !errors.As(err, &accepted) {
+					return 0, apiError("cancel workflow run", cancelResp, err)
+				}
+				c.logger.Info("Cancelled workflow run",
+					"run_id", run.GetID(),
+					"job_id", job.GetID(),
+					"job_name", job.GetName(),
+					"runner_name", runnerName)
+				return job.GetID(), nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		runOpts.Page = resp.NextPage
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrJobNotFound, runnerName)
+}
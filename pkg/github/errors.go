@@ -0,0 +1,95 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v69/github"
+)
+
+// ErrRunnerNotFound is returned when a runner to remove no longer exists in GitHub
+var ErrRunnerNotFound = errors.New("runner not found")
+
+// ErrJobNotFound is returned when no in-progress job runs on a runner
+var ErrJobNotFound = errors.New("no running job found")
+
+// ErrUnauthorized is returned when GitHub rejects the app's credentials or the app lacks a permission
+var ErrUnauthorized = errors.New("GitHub authorization failed")
+
+// ErrForbidden is returned when GitHub answers 403 Forbidden to a single call, either for a permission
+// the endpoint needs or for a secondary rate limit; unlike ErrUnauthorized it may pass
+var ErrForbidden = errors.New("GitHub API call forbidden")
+
+// ErrRateLimited is returned when the GitHub API rate limit is exhausted
+var ErrRateLimited = errors.New("GitHub API rate limit exceeded")
+
+// ErrNotInstalled is returned when the GitHub App is not installed on the configured account
+var ErrNotInstalled = errors.New("GitHub App not installed")
+
+// ErrRepositoryRequired is returned for a personal account without github.repo; only organizations have account-level runners
+var ErrRepositoryRequired = errors.New("personal accounts require a repository to be specified")
+
+// APIError is a failed GitHub API call
+// It unwraps to ErrUnauthorized, ErrForbidden or ErrRateLimited when the failure is one of those
+type APIError struct {
+	Op         string    // What was attempted, e.g. "list org runners"
+	StatusCode int       // HTTP status of the response (0: no response)
+	RetryAt    time.Time // When a rate limited call may be retried (zero: unknown)
+	Err        error
+	kind       error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("failed to %s: %v", e.Op, e.Err)
+}
+
+func (e *APIError) Unwrap() []error {
+	if e.kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.kind, e.Err}
+}
+
+// apiError classifies a failed API call by its rate limit details and HTTP status
+func apiError(op string, resp *github.Response, err error) *APIError {
+	apiErr := &APIError{Op: op, Err: err}
+	if resp != nil {
+		apiErr.StatusCode = resp.StatusCode
+	}
+
+	var rateLimit *github.RateLimitError
+	var abuseLimit *github.AbuseRateLimitError
+	var transportErr *ghinstallation.HTTPError
+	switch {
+	case errors.As(err, &rateLimit):
+		apiErr.kind = ErrRateLimited
+		apiErr.RetryAt = rateLimit.Rate.Reset.Time
+		return apiErr
+	case errors.As(err, &abuseLimit):
+		apiErr.kind = ErrRateLimited
+		if abuseLimit.RetryAfter != nil {
+			apiErr.RetryAt = time.Now().Add(*abuseLimit.RetryAfter)
+		}
+		return apiErr
+	case errors.As(err, &transportErr) && transportErr.Response != nil:
+		// The installation access token could not be created: the app's credentials are rejected
+		apiErr.StatusCode = transportErr.Response.StatusCode
+		if apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden {
+			apiErr.kind = ErrUnauthorized
+			return apiErr
+		}
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusUnauthorized:
+		apiErr.kind = ErrUnauthorized
+	case http.StatusForbidden:
+		apiErr.kind = ErrForbidden
+	case http.StatusTooManyRequests:
+		apiErr.kind = ErrRateLimited
+	}
+	return apiErr
+}
@@ -0,0 +1,150 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"hyperv-runner-pool/pkg/github"
+	"hyperv-runner-pool/pkg/vmmanager"
+)
+
+// fatalErrorRepeats is how many errors classified as fatal must come in a row, across all slots,
+// before they stop the pool at runtime; a single one may be transient, e.g. a VHDX locked by a backup
+const fatalErrorRepeats = 3
+
+// forbiddenTimeout is how long GitHub may keep answering 403 Forbidden before the pool stops
+// GitHub also answers 403 to secondary rate limits, so only a lasting one means a missing permission
+const forbiddenTimeout = 30 * time.Minute
+
+// maxRetryDelay caps the back-off of a slot whose actions keep failing
+const maxRetryDelay = 15 * time.Minute
+
+// failurePolicy is how the orchestrator responds to a failed pool action
+type failurePolicy string
+
+const (
+	policyRetry      failurePolicy = "retry"      // Unrecognised: retry after one health check interval
+	policyBackOff    failurePolicy = "back_off"   // The host or GitHub is short of something: retry after a growing delay
+	policyQuarantine failurePolicy = "quarantine" // The new VM itself is broken: keep it for inspection, then retry
+	policyFatal      failurePolicy = "fatal"      // Misconfiguration that retrying cannot fix: stop the pool
+)
+
+// blamesVM reports whether a failed VM creation counts against the template version and is
+// quarantined when enabled; unrecognised failures are, so they can still be inspected
+func blamesVM(policy failurePolicy) bool {
+	return policy == policyQuarantine || policy == policyRetry
+}
+
+// failurePolicyFor classifies an error of the VM manager or the GitHub client
+func failurePolicyFor(err error) failurePolicy {
+	switch {
+	case errors.Is(err, vmmanager.ErrTemplateInvalid),
+		errors.Is(err, vmmanager.ErrSwitchNotFound),
+		errors.Is(err, vmmanager.ErrAccessDenied),
+		errors.Is(err, github.ErrUnauthorized),
+		errors.Is(err, github.ErrNotInstalled),
+		errors.Is(err, github.ErrRepositoryRequired):
+		return policyFatal
+	case errors.Is(err, vmmanager.ErrGuestUnreachable),
+		errors.Is(err, vmmanager.ErrGuestAuthFailed):
+		return policyQuarantine
+	case errors.Is(err, github.ErrRateLimited),
+		errors.Is(err, github.ErrForbidden),
+		errors.Is(err, vmmanager.ErrHostUnavailable),
+		errors.Is(err, vmmanager.ErrInsufficientMemory),
+		errors.Is(err, vmmanager.ErrInsufficientStorage),
+		errors.Is(err, vmmanager.ErrNoHostCapacity):
+		return policyBackOff
+	}
+	return policyRetry
+}
+
+// retryDelay returns how long a slot waits before its failed action is retried
+// Backing off doubles the delay with every consecutive failure, or waits for GitHub's rate limit reset
+func (o *Orchestrator) retryDelay(err error, policy failurePolicy, failures int) time.Duration {
+	interval := time.Duration(o.config.Monitoring.HealthCheckIntervalSeconds) * time.Second
+	if policy != policyBackOff {
+		return interval
+	}
+
+	var apiErr *github.APIError
+	if errors.As(err, &apiErr) && !apiErr.RetryAt.IsZero() {
+		return max(time.Until(apiErr.RetryAt), interval)
+	}
+	return min(interval<<min(failures-1, 10), maxRetryDelay)
+}
+
+// Fatal returns a channel that receives the error that stopped the pool, if one does
+func (o *Orchestrator) Fatal() <-chan error {
+	return o.fatal
+}
+
+// handleFailure classifies an error like failurePolicyFor and escalates the errors that stop the
+// pool once they persist; an error classified as fatal is backed off until the pool stops
+func (o *Orchestrator) handleFailure(err error) failurePolicy {
+	policy := failurePolicyFor(err)
+	switch {
+	case policy == policyFatal:
+		if !o.escalate(err) {
+			return policyBackOff
+		}
+	case errors.Is(err, github.ErrForbidden):
+		o.escalateForbidden(err)
+	}
+	return policy
+}
+
+// escalateForbidden stops the pool once GitHub answered 403 Forbidden for forbiddenTimeout without
+// a successful action or health check in between
+func (o *Orchestrator) escalateForbidden(err error) {
+	o.mu.Lock()
+	if o.forbiddenSince.IsZero() {
+		o.forbiddenSince = time.Now()
+	}
+	forbiddenFor := time.Since(o.forbiddenSince)
+	o.mu.Unlock()
+
+	if forbiddenFor < forbiddenTimeout {
+		return
+	}
+	o.stop(fmt.Errorf("GitHub kept refusing API calls for %s: %w", forbiddenFor.Round(time.Minute), err))
+}
+
+// escalate records an error classified as fatal and stops the pool once fatalErrorRepeats of them
+// came without a successful action or health check in between
+// Returns whether the pool was stopped
+func (o *Orchestrator) escalate(err error) bool {
+	o.mu.Lock()
+	o.fatalErrors++
+	repeats := o.fatalErrors
+	o.mu.Unlock()
+
+	if repeats < fatalErrorRepeats {
+		o.logger.Warn("Error looks unrecoverable; the pool stops if it keeps repeating",
+			"error", err,
+			"repeats", repeats,
+			"limit", fatalErrorRepeats)
+		return false
+	}
+	o.stop(err)
+	return true
+}
+
+// clearEscalations forgets the errors that stop the pool when they persist, after something succeeded
+// The caller holds mu
+func (o *Orchestrator) clearEscalations() {
+	o.fatalErrors = 0
+	o.forbiddenSince = time.Time{}
+}
+
+// stop halts the pool after an error that retrying cannot fix, e.g. a missing virtual switch
+// or rejected GitHub credentials; no further actions start, and Fatal reports the error
+func (o *Orchestrator) stop(err error) {
+	o.logger.Error("Stopping the pool after an unrecoverable error", "error", err)
+	select {
+	case o.fatal <- err:
+	default:
+	}
+	o.cancel()
+}
@@ -0,0 +1,34 @@
+package orchestrator
+
+import (
+	"time"
+
+	"hyperv-runner-pool/pkg/vmmanager"
+)
+
+// collectGarbage runs one garbage collection pass over the VM manager's inventory
+// Leftovers are otherwise only cleaned up at startup and shutdown
+func (o *Orchestrator) collectGarbage() {
+	// Slots with an action in progress are passed by name only: their VM and files are in flux
+	o.mu.Lock()
+	var slots []*vmmanager.VMSlot
+	for _, slot := range o.vmPool {
+		if o.inFlight[slot.Name] {
+			slots = append(slots, &vmmanager.VMSlot{Index: slot.Index, Name: slot.Name})
+		} else {
+			slots = append(slots, slot)
+		}
+	}
+	o.mu.Unlock()
+
+	gracePeriod := time.Duration(o.config.Monitoring.GCGracePeriodMinutes) * time.Minute
+	collected, err := o.vmManager.CollectGarbage(o.namePrefix(), slots, gracePeriod)
+	if err != nil {
+		o.logger.Warn("Garbage collection encountered errors", "error", err)
+	}
+	if len(collected) > 0 {
+		o.logger.Info("Garbage collected orphaned resources", "count", len(collected), "resources", collected)
+	} else {
+		o.logger.Debug("Garbage collection found no orphaned resources")
+	}
+}
@@ -0,0 +1,92 @@
+package orchestrator
+
+import (
+	"sync"
+	"time"
+
+	"hyperv-runner-pool/pkg/vmmanager"
+)
+
+// meteringConcurrency is how many VMs are measured at once, each measurement being a PowerShell
+// round-trip to the VM's host
+const meteringConcurrency = 8
+
+// meterSlots samples the resource usage of every VM without an action in progress
+// It runs on the reconcile loop, like the health checks that read the same slots, so VMs are
+// measured in parallel to keep a large or remote pool from delaying the next pass
+func (o *Orchestrator) meterSlots() {
+	o.mu.Lock()
+	var slots []*vmmanager.VMSlot
+	for _, slot := range o.vmPool {
+		if !o.inFlight[slot.Name] && slot.State != vmmanager.StateEmpty {
+			slots = append(slots, slot)
+		}
+	}
+	o.mu.Unlock()
+
+	limit := make(chan struct{}, meteringConcurrency)
+	var wg sync.WaitGroup
+	for _, slot := range slots {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-limit }()
+			o.meterVM(slot)
+		}()
+	}
+	wg.Wait()
+}
+
+// meterVM samples a VM's resource usage into its slot
+func (o *Orchestrator) meterVM(slot *vmmanager.VMSlot) {
+	usage, err := o.vmManager.MeasureVM(slot)
+	if err != nil {
+		o.logger.Warn("Failed to measure VM resource usage", "vm_name", slot.Name, "error", err)
+		return
+	}
+	usage.PeakMemoryDemandMB = max(usage.PeakMemoryDemandMB, slot.Usage.PeakMemoryDemandMB)
+	slot.Usage = usage
+
+	o.logger.Debug("VM resource usage",
+		"vm_name", slot.Name,
+		"host", slot.Host,
+		"avg_cpu_mhz", usage.AvgCPUMHz,
+		"avg_memory_mb", usage.AvgMemoryMB,
+		"memory_demand_mb", usage.MemoryDemandMB,
+		"disk_read_mb", usage.DiskReadMB,
+		"disk_written_mb", usage.DiskWrittenMB,
+		"network_in_mb", usage.NetworkInboundMB,
+		"network_out_mb", usage.NetworkOutboundMB)
+}
+
+// recordLifecycleUsage takes a final sample before a VM is torn down and logs the totals of its lifecycle
+// The metering counters start with the VM, so the last sample covers the whole job lifecycle
+func (o *Orchestrator) recordLifecycleUsage(slot *vmmanager.VMSlot) {
+	if !o.config.HyperV.Metering.Enabled {
+		return
+	}
+
+	o.meterVM(slot)
+	usage := slot.Usage
+	if usage.SampledAt.IsZero() {
+		return
+	}
+
+	o.logger.Info("VM lifecycle resource usage",
+		"vm_name", slot.Name,
+		"host", slot.Host,
+		"template_version", slot.TemplateVersion,
+		"lifetime", time.Since(slot.CreatedAt).Round(time.Second),
+		"metered_for", usage.MeteredFor,
+		"avg_cpu_mhz", usage.AvgCPUMHz,
+		"avg_memory_mb", usage.AvgMemoryMB,
+		"max_memory_mb", usage.MaxMemoryMB,
+		"peak_memory_demand_mb", usage.PeakMemoryDemandMB,
+		"vm_memory_mb", slot.BuiltMemoryMB,
+		"vm_cpu_count", slot.BuiltCPUCount,
+		"disk_read_mb", usage.DiskReadMB,
+		"disk_written_mb", usage.DiskWrittenMB,
+		"network_in_mb", usage.NetworkInboundMB,
+		"network_out_mb", usage.NetworkOutboundMB)
+}
@@ -1,81 +1,135 @@
 package orchestrator
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
+	"hyperv-runner-pool/pkg/config"
 	"hyperv-runner-pool/pkg/vmmanager"
 )
 
-// MonitorVMHealth performs comprehensive health monitoring and triggers recreation when unhealthy
-func (o *Orchestrator) MonitorVMHealth(slot *vmmanager.VMSlot) {
-	healthCheckInterval := time.Duration(o.config.Monitoring.HealthCheckIntervalSeconds) * time.Second
-	ticker := time.NewTicker(healthCheckInterval)
-	defer ticker.Stop()
-
-	o.logger.Debug("Started health monitoring", "vm_name", slot.Name)
-
-	for {
-		select {
-		case <-o.ctx.Done():
-			// Context cancelled, stop monitoring
-			o.logger.Debug("Stopping VM monitoring due to shutdown", "vm_name", slot.Name)
-			return
-		case <-ticker.C:
-			if shouldRecreate, reason := o.checkVMHealth(slot); shouldRecreate {
-				o.logger.Warn("VM health check failed, recreating",
-					"vm_name", slot.Name,
-					"reason", reason,
-					"state", slot.State,
-					"uptime", time.Since(slot.CreatedAt).Round(time.Second),
-					"consecutive_failures", slot.HealthCheckFailures+1)
-
-				ticker.Stop()
-
-				// Recreate the VM asynchronously
-				go func() {
-					if err := o.RecreateVM(slot.Name); err != nil {
-						o.logger.Error("Error recreating VM", "vm_name", slot.Name, "error", err)
-					}
-				}()
-				return
-			}
-		}
+// reasonPoweredOff is the recreate reason for a VM that shut down after its job
+const reasonPoweredOff = "VM power state is Off/Stopped"
+
+// reasonJobTimeout is the recreate reason for a VM whose job ran longer than the maximum job duration
+const reasonJobTimeout = "Job exceeded the maximum job duration"
+
+// Recreate reasons for VMs changed outside the orchestrator
+const (
+	reasonVMDeleted  = "VM was deleted outside the orchestrator"
+	reasonVMModified = "VM hardware was changed outside the orchestrator"
+)
+
+// externalChange reports whether a recreate reason is a change made outside the orchestrator
+// Such VMs did not fail, so they are neither quarantined nor held against their template version
+func externalChange(reason string) bool {
+	return reason == reasonVMDeleted || reason == reasonVMModified
+}
+
+// collectDiagnostics pulls a diagnostics bundle out of an unhealthy guest before it is destroyed
+// Only running guests can be reached over PowerShell Direct, so VMs that shut down are skipped
+func (o *Orchestrator) collectDiagnostics(slot *vmmanager.VMSlot, reason string) {
+	if !o.config.HyperV.Diagnostics.Enabled {
+		return
 	}
+
+	state, err := o.vmManager.GetVMState(slot.Name)
+	if err != nil || state != "Running" {
+		o.logger.Debug("Skipping diagnostics for VM that is not running", "vm_name", slot.Name, "state", state)
+		return
+	}
+
+	bundle, err := o.vmManager.CollectDiagnostics(slot, reason)
+	if err != nil {
+		o.logger.Warn("Failed to collect guest diagnostics", "vm_name", slot.Name, "bundle", bundle, "error", err)
+		return
+	}
+	o.logger.Info("Saved guest diagnostics", "vm_name", slot.Name, "bundle", bundle, "reason", reason)
 }
 
-// checkVMHealth performs all health checks and returns whether VM should be recreated
-// Returns (shouldRecreate bool, reason string)
-func (o *Orchestrator) checkVMHealth(slot *vmmanager.VMSlot) (bool, string) {
+// healthVerdict is the outcome of a slot's health checks
+type healthVerdict struct {
+	recreate   bool // The VM is replaced
+	quarantine bool // The VM failed and is kept for inspection first, when quarantine is enabled
+	reason     string
+}
+
+// failedCheck is the verdict for a VM that failed a health check outright
+func failedCheck(reason string) healthVerdict {
+	return healthVerdict{recreate: true, quarantine: true, reason: reason}
+}
+
+// checkVMHealth performs all health checks and returns whether and how the VM is replaced
+func (o *Orchestrator) checkVMHealth(slot *vmmanager.VMSlot) healthVerdict {
 	now := time.Now()
 	creationTimeout := time.Duration(o.config.Monitoring.CreationTimeoutMinutes) * time.Minute
 	gracePeriod := time.Duration(o.config.Monitoring.GracePeriodMinutes) * time.Minute
+	checks := o.config.Monitoring.HealthChecks
 
 	// 1. Check VM power state
 	state, err := o.vmManager.GetVMState(slot.Name)
+	if errors.Is(err, vmmanager.ErrVMNotFound) {
+		return healthVerdict{recreate: true, reason: reasonVMDeleted}
+	}
 	if err != nil {
 		o.logger.Error("Failed to get VM state", "vm_name", slot.Name, "error", err)
-		slot.HealthCheckFailures++
-		// Don't recreate on transient API errors, continue monitoring
-		return false, ""
+		if policy := o.handleFailure(err); policy == policyBackOff || policy == policyFatal {
+			// The whole host is unavailable or misconfigured, which is not this VM's fault
+			slot.HealthCheckFailures++
+			return healthVerdict{}
+		}
+		return o.countFailure(slot, "power_state_errors", checks.PowerStateErrors, &slot.PowerStateErrors, "VM power state could not be read")
 	}
+	slot.PowerStateErrors = 0
 
 	// If VM is stopped/off, it means job completed and VM shut down
 	if state == "Off" || state == "Stopped" {
-		return true, "VM power state is Off/Stopped"
+		return healthVerdict{recreate: true, reason: reasonPoweredOff}
 	}
 
 	// 2. Check if stuck in Creating state
 	if slot.State == vmmanager.StateCreating {
 		if time.Since(slot.CreatedAt) > creationTimeout {
-			return true, "VM stuck in Creating state (timeout)"
+			return failedCheck("VM stuck in Creating state (timeout)")
 		}
 		// Still within timeout, don't check GitHub yet
 		slot.LastHealthCheck = now
 		slot.HealthCheckFailures = 0
-		return false, ""
+		return healthVerdict{}
+	}
+
+	// 3. Check the running job against the maximum job duration; a hung job would hold the VM forever
+	maxJobDuration := time.Duration(o.config.Runners.MaxJobDurationMinutes) * time.Minute
+	if maxJobDuration > 0 && slot.State == vmmanager.StateRunning && now.Sub(slot.JobStartedAt) > maxJobDuration {
+		return healthVerdict{recreate: true, reason: reasonJobTimeout}
+	}
+
+	// 4. Check that memory, CPU and disks are still as the VM was found
+	if o.checkDrift(slot) && o.config.Monitoring.DriftAction != config.DriftFlag {
+		return healthVerdict{recreate: true, reason: reasonVMModified}
+	}
+
+	// 5. Check differencing disk growth against the configured cap
+	if maxDiskGB := o.config.HyperV.Storage.MaxDiskGB; maxDiskGB > 0 {
+		size, err := o.vmManager.GetDiskUsage(slot)
+		if err != nil {
+			o.logger.Warn("Failed to measure VM disk usage", "vm_name", slot.Name, "error", err)
+		} else {
+			o.logger.Debug("VM disk usage",
+				"vm_name", slot.Name,
+				"disk_size_mb", size>>20,
+				"growth_mb", (size-slot.DiskSizeBytes)>>20)
+			slot.DiskSizeBytes = size
+			if size > int64(maxDiskGB)<<30 {
+				return failedCheck("Differencing disk exceeded size cap")
+			}
+		}
 	}
 
-	// 3. Check GitHub runner status (only after grace period)
+	// 6. Check GitHub runner status (only after grace period)
 	timeSinceCreation := time.Since(slot.CreatedAt)
 	if timeSinceCreation > gracePeriod {
 		runner, err := o.githubClient.GetRunnerByName(slot.Name)
@@ -83,19 +137,64 @@ func (o *Orchestrator) checkVMHealth(slot *vmmanager.VMSlot) (bool, string) {
 			o.logger.Error("Failed to check runner status in GitHub",
 				"vm_name", slot.Name,
 				"error", err)
-			slot.HealthCheckFailures++
-			// Don't recreate on transient GitHub API errors
-			return false, ""
+			if policy := o.handleFailure(err); policy == policyBackOff || policy == policyFatal {
+				// Rate limited, refused or misconfigured, which is not this VM's fault
+				slot.HealthCheckFailures++
+				return healthVerdict{}
+			}
+			return o.countFailure(slot, "github_errors", checks.GitHubErrors, &slot.GitHubErrors, "Runner could not be looked up in GitHub")
 		}
+		slot.GitHubErrors = 0
 
 		// Runner not found in GitHub
+		// An ephemeral runner deregisters itself after its job, before its VM has powered off, so a
+		// runner last seen busy or a VM that is shutting down is expected to have none for up to the
+		// creation timeout; a VM still up after that, e.g. with a hung shutdown, counts as missing its runner
 		if runner == nil {
-			return true, "Runner not found in GitHub after grace period"
+			if slot.RunnerBusy || state == "Stopping" {
+				if slot.RunnerGoneAt.IsZero() {
+					slot.RunnerGoneAt = now
+				}
+				if now.Sub(slot.RunnerGoneAt) <= creationTimeout {
+					o.logger.Debug("Runner gone after its job, waiting for the VM to power off", "vm_name", slot.Name, "state", state)
+					slot.LastHealthCheck = now
+					return healthVerdict{}
+				}
+				return o.countFailure(slot, "runner_not_found", checks.RunnerNotFound, &slot.MissingReadings, "VM did not power off after its runner finished")
+			}
+			return o.countFailure(slot, "runner_not_found", checks.RunnerNotFound, &slot.MissingReadings, "Runner not found in GitHub after grace period")
+		}
+		slot.MissingReadings = 0
+		slot.RunnerGoneAt = time.Time{}
+
+		slot.RunnerID = runner.ID
+		slot.RunnerBusy = runner.Busy
+
+		// The slot is running from the first reading of its runner as busy until the VM is recreated
+		if runner.Busy && slot.State == vmmanager.StateReady {
+			slot.State = vmmanager.StateRunning
+			slot.JobStartedAt = now
+			o.logger.Debug("Runner picked up a job", "vm_name", slot.Name)
 		}
 
-		// Runner is offline
+		// Runner is offline; the status flaps briefly while a job is picked up
 		if runner.Status != "online" {
-			return true, "Runner is offline in GitHub"
+			return o.countFailure(slot, "runner_offline", checks.RunnerOffline, &slot.OfflineReadings, "Runner is offline in GitHub")
+		}
+		slot.OfflineReadings = 0
+
+		// First time the runner is seen online: the VM passed its health checks
+		if slot.RegisteredAt.IsZero() {
+			slot.RegisteredAt = now
+			o.rollout.recordHealthy(slot.TemplateVersion)
+		}
+
+		// 7. Probe the runner inside the guest; GitHub keeps a hung listener online for minutes
+		if o.config.Monitoring.GuestProbe.Enabled {
+			if reason := o.probeGuest(slot); reason != "" {
+				return o.countFailure(slot, "guest_probe", checks.GuestProbe, &slot.GuestProbeFailures, reason)
+			}
+			slot.GuestProbeFailures = 0
 		}
 
 		// Log successful health check at debug level
@@ -108,5 +207,119 @@ func (o *Orchestrator) checkVMHealth(slot *vmmanager.VMSlot) (bool, string) {
 	// All checks passed
 	slot.LastHealthCheck = now
 	slot.HealthCheckFailures = 0
-	return false, ""
+	o.mu.Lock()
+	o.clearEscalations()
+	o.mu.Unlock()
+	return healthVerdict{}
+}
+
+// probeGuest runs the in-guest liveness probe and returns why the guest failed it (empty: passed)
+func (o *Orchestrator) probeGuest(slot *vmmanager.VMSlot) string {
+	probe := o.config.Monitoring.GuestProbe
+	status, err := o.vmManager.ProbeGuest(slot)
+	if err != nil {
+		o.logger.Warn("Guest liveness probe failed", "vm_name", slot.Name, "error", err)
+		return "Guest did not answer the liveness probe"
+	}
+	slot.Guest = status
+
+	now := time.Now()
+	heartbeatTimeout := 3 * time.Duration(probe.HeartbeatSeconds) * time.Second
+	logSilence := time.Duration(probe.MaxLogSilenceMinutes) * time.Minute
+	switch {
+	case probe.HeartbeatSeconds > 0 && now.Sub(status.HeartbeatAt) > heartbeatTimeout:
+		return "Guest heartbeat is stale"
+	case !status.ListenerRunning:
+		return "Runner listener is not running in the guest"
+	case probe.MinFreeDiskGB > 0 && status.FreeDiskBytes < int64(probe.MinFreeDiskGB)<<30:
+		return "Guest is low on disk space"
+	case logSilence > 0 && !status.LastLogAt.IsZero() && now.Sub(status.LastLogAt) > logSilence:
+		return "Runner log went silent"
+	}
+
+	o.logger.Debug("Guest liveness probe passed",
+		"vm_name", slot.Name,
+		"free_disk_mb", status.FreeDiskBytes>>20,
+		"last_log", status.LastLogAt.Format(time.RFC3339))
+	return ""
+}
+
+// countFailure records a failed reading of a check that tolerates transient failures and takes the
+// check's action once it failed threshold times in a row; an alert only logs, and keeps the VM
+func (o *Orchestrator) countFailure(slot *vmmanager.VMSlot, check string, policy config.HealthCheckPolicy, failures *int, reason string) healthVerdict {
+	*failures++
+	slot.HealthCheckFailures++
+	if *failures < policy.Threshold {
+		o.logger.Debug("Health check failed, below its threshold",
+			"vm_name", slot.Name,
+			"check", check,
+			"failures", *failures,
+			"threshold", policy.Threshold)
+		return healthVerdict{}
+	}
+
+	switch policy.Action {
+	case config.CheckActionAlert:
+		// Only once per run of failures, so the log is not flooded
+		if *failures == max(policy.Threshold, 1) {
+			o.logger.Error("Health check failure threshold reached, keeping VM for an operator",
+				"vm_name", slot.Name,
+				"check", check,
+				"reason", reason,
+				"failures", *failures)
+		}
+		return healthVerdict{}
+	case config.CheckActionQuarantine:
+		return failedCheck(reason)
+	}
+	return healthVerdict{recreate: true, reason: reason}
+}
+
+// checkDrift compares a VM's memory, CPU and disks with what the first health check found and
+// reports whether someone changed them since, e.g. in Hyper-V Manager
+// The baseline is taken from the VM itself rather than the configuration, so VMs built before
+// a config reload are not mistaken for drifted ones
+func (o *Orchestrator) checkDrift(slot *vmmanager.VMSlot) bool {
+	hardware, err := o.vmManager.InspectVM(slot.Name)
+	if err != nil {
+		o.logger.Warn("Failed to inspect VM hardware", "vm_name", slot.Name, "error", err)
+		return false
+	}
+	if slot.Hardware.MemoryMB == 0 {
+		slot.Hardware = hardware
+		return false
+	}
+
+	drift := hardwareDrift(slot.Hardware, hardware)
+	if drift != "" && drift != slot.Drift {
+		o.logger.Warn("VM hardware changed outside the orchestrator",
+			"vm_name", slot.Name,
+			"drift", drift,
+			"action", o.config.Monitoring.DriftAction)
+	}
+	slot.Drift = drift
+	return drift != ""
+}
+
+// hardwareDrift describes how a VM's hardware differs from its baseline (empty: it does not)
+func hardwareDrift(baseline, current vmmanager.VMHardware) string {
+	var changes []string
+	if current.MemoryMB != baseline.MemoryMB {
+		changes = append(changes, fmt.Sprintf("memory %d MB -> %d MB", baseline.MemoryMB, current.MemoryMB))
+	}
+	if current.CPUCount != baseline.CPUCount {
+		changes = append(changes, fmt.Sprintf("CPU count %d -> %d", baseline.CPUCount, current.CPUCount))
+	}
+	// Hyper-V paths are case-insensitive
+	for _, disk := range baseline.Disks {
+		if !slices.ContainsFunc(current.Disks, func(d string) bool { return strings.EqualFold(d, disk) }) {
+			changes = append(changes, "disk detached: "+disk)
+		}
+	}
+	for _, disk := range current.Disks {
+		if !slices.ContainsFunc(baseline.Disks, func(d string) bool { return strings.EqualFold(d, disk) }) {
+			changes = append(changes, "disk attached: "+disk)
+		}
+	}
+	return strings.Join(changes, ", ")
 }
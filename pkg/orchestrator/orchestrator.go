@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,22 +14,46 @@ import (
 	"hyperv-runner-pool/pkg/vmmanager"
 )
 
+// githubAPI is the part of the GitHub client the orchestrator uses
+type githubAPI interface {
+	GetRunnerToken() (string, error)
+	ListRunners() ([]github.RunnerInfo, error)
+	GetRunnerByName(name string) (*github.RunnerInfo, error)
+	RemoveRunner(runnerID int64, runnerName string) error
+	CancelRunnerJob(runnerName string) (int64, error)
+}
+
+// shutdownActionTimeout is how long shutdown waits for running pool actions before cleaning up
+var shutdownActionTimeout = 2 * time.Minute
+
 // Orchestrator manages the pool of ephemeral VMs
 type Orchestrator struct {
 	config       config.Config
 	vmManager    vmmanager.VMManager
-	githubClient *github.Client
+	githubClient githubAPI
 	vmPool       []*vmmanager.VMSlot
+	rollout      *templateRollout
 	mu           sync.Mutex
 	logger       *slog.Logger
 	ctx          context.Context
 	cancel       context.CancelFunc
+
+	// Reconcile loop state, guarded by mu
+	inFlight       map[string]bool      // Slots with an action in progress, by VM name
+	retryAfter     map[string]time.Time // Back-off of slots whose last action failed, by VM name
+	restartBefore  time.Time            // VMs created before this are replaced (RestartAllVMs)
+	failures       map[string]int       // Consecutive failed actions of a slot, by VM name
+	fatalErrors    int                  // Errors classified as fatal in a row, across all slots
+	forbiddenSince time.Time            // Since when GitHub has answered 403 Forbidden without a success in between
+	pendingConfig  *config.Config       // Reloaded configuration waiting to be applied
+	wake           chan struct{}        // Requests a reconcile pass before the next tick
+	fatal          chan error           // Receives the error that stopped the pool
 }
 
 // New creates a new orchestrator instance
 func New(cfg config.Config, vmMgr vmmanager.VMManager, ghClient *github.Client, logger *slog.Logger) *Orchestrator {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Orchestrator{
+	o := &Orchestrator{
 		config:       cfg,
 		vmManager:    vmMgr,
 		githubClient: ghClient,
@@ -35,16 +61,28 @@ func New(cfg config.Config, vmMgr vmmanager.VMManager, ghClient *github.Client,
 		logger:       logger.With("component", "orchestrator"),
 		ctx:          ctx,
 		cancel:       cancel,
+		inFlight:     make(map[string]bool),
+		retryAfter:   make(map[string]time.Time),
+		failures:     make(map[string]int),
+		wake:         make(chan struct{}, 1),
+		fatal:        make(chan error, 1),
+	}
+	o.rollout = newTemplateRollout(cfg.HyperV, o.validateTemplateVersion, o.logger)
+	return o
+}
+
+// namePrefix returns the prefix of the pool's VM and runner names
+func (o *Orchestrator) namePrefix() string {
+	if o.config.Runners.NamePrefix == "" {
+		return "runner-"
 	}
+	return o.config.Runners.NamePrefix
 }
 
-// InitializePool creates the initial warm pool of VMs
+// InitializePool creates the initial warm pool of VMs and starts the reconcile loop that keeps it
 func (o *Orchestrator) InitializePool() error {
 	// First, cleanup any leftover resources from previous runs
-	namePrefix := o.config.Runners.NamePrefix
-	if namePrefix == "" {
-		namePrefix = "runner-"
-	}
+	namePrefix := o.namePrefix()
 
 	o.logger.Info("Performing startup cleanup", "name_prefix", namePrefix)
 
@@ -58,41 +96,28 @@ func (o *Orchestrator) InitializePool() error {
 		o.logger.Warn("GitHub runner cleanup encountered errors (continuing anyway)", "error", err)
 	}
 
-	o.logger.Info("Initializing warm pool of VMs", "pool_size", o.config.Runners.PoolSize)
-
-	var wg sync.WaitGroup
-	errChan := make(chan error, o.config.Runners.PoolSize)
-
-	for i := 0; i < o.config.Runners.PoolSize; i++ {
-		slotIndex := i
-		vmName := fmt.Sprintf("%s%d", namePrefix, i+1)
-
-		o.vmPool[slotIndex] = &vmmanager.VMSlot{
-			Name:  vmName,
-			State: vmmanager.StateEmpty,
+	// Expire quarantined VMs from previous runs; the reconcile loop keeps expiring them while running
+	if o.config.HyperV.Quarantine.Enabled {
+		if err := o.vmManager.PruneQuarantine(); err != nil {
+			o.logger.Warn("Quarantine pruning encountered errors (continuing anyway)", "error", err)
 		}
+	}
 
-		wg.Add(1)
-		go func(slot *vmmanager.VMSlot) {
-			defer wg.Done()
-			if err := o.createAndRegisterVM(slot); err != nil {
-				errChan <- fmt.Errorf("failed to initialize %s: %w", slot.Name, err)
-			}
-		}(o.vmPool[slotIndex])
+	// Verify the templates before any differencing disk is created from them
+	if err := o.validateTemplates(); err != nil {
+		return err
 	}
 
-	wg.Wait()
-	close(errChan)
+	stableVersion, candidateVersion := o.rollout.versions()
+	o.logger.Info("Initializing warm pool of VMs",
+		"pool_size", o.config.Runners.PoolSize,
+		"template_version", stableVersion,
+		"candidate_version", candidateVersion)
 
-	// Collect all errors
-	var errors []error
-	for err := range errChan {
-		if err != nil {
-			errors = append(errors, err)
-		}
-	}
+	// The first reconcile passes create every slot
+	errors := o.settle()
+	go o.reconcileLoop()
 
-	// Return combined error if any occurred
 	if len(errors) > 0 {
 		for _, err := range errors {
 			o.logger.Error("VM initialization failed", "error", err)
@@ -104,11 +129,74 @@ func (o *Orchestrator) InitializePool() error {
 	return nil
 }
 
+// validateTemplates runs the template pre-flight checks, and the same checks on the tool cache disk
+// An invalid active template blocks pool creation; an invalid candidate only cancels its rollout
+func (o *Orchestrator) validateTemplates() error {
+	stable, candidate := o.rollout.versions()
+
+	if err := o.validateTemplateVersion(stable); err != nil {
+		return fmt.Errorf("refusing to create VMs: %w", err)
+	}
+
+	// Every VM gets a differencing disk of the tool cache, so it needs the same guarantees as the template
+	if toolCache := o.config.HyperV.Disks.ToolCache.Path; toolCache != "" {
+		if err := o.vmManager.ValidateTemplate(toolCache); err != nil {
+			return fmt.Errorf("refusing to create VMs: tool cache disk: %w", err)
+		}
+	}
+
+	if candidate != "" {
+		if err := o.validateTemplateVersion(candidate); err != nil {
+			o.logger.Error("Candidate template failed validation, cancelling rollout",
+				"template_version", candidate,
+				"error", err)
+			o.rollout.abort()
+		}
+	}
+
+	return nil
+}
+
+// validateTemplateVersion runs the template pre-flight checks for a named template version
+func (o *Orchestrator) validateTemplateVersion(version string) error {
+	templatePath := o.config.HyperV.TemplatePath
+	if version != "" {
+		path, err := o.config.HyperV.TemplatePathFor(version)
+		if err != nil {
+			return err
+		}
+		templatePath = path
+	}
+	return o.vmManager.ValidateTemplate(templatePath)
+}
+
 // createAndRegisterVM creates a VM and registers it with GitHub
 func (o *Orchestrator) createAndRegisterVM(slot *vmmanager.VMSlot) error {
 	slot.State = vmmanager.StateCreating
 	slot.CreatedAt = time.Now()
 	slot.HealthCheckFailures = 0
+	slot.PowerStateErrors = 0
+	slot.OfflineReadings = 0
+	slot.MissingReadings = 0
+	slot.GitHubErrors = 0
+	slot.GuestProbeFailures = 0
+	slot.Guest = vmmanager.GuestStatus{}
+	slot.IPAddress = ""
+	slot.RegisteredAt = time.Time{}
+	slot.RunnerID = 0
+	slot.RunnerBusy = false
+	slot.JobStartedAt = time.Time{}
+	slot.RunnerGoneAt = time.Time{}
+	slot.HardwareProfile = o.hardwareProfile()
+	slot.BuiltMemoryMB = o.config.HyperV.VMMemoryMB
+	slot.BuiltCPUCount = o.config.HyperV.VMCPUCount
+	slot.Hardware = vmmanager.VMHardware{}
+	slot.Drift = ""
+	slot.StoragePath = ""
+	slot.DiskSizeBytes = 0
+	slot.Usage = vmmanager.ResourceUsage{}
+	slot.TemplateVersion = o.rollout.nextVersion()
+	slot.TemplatePath, _ = o.config.HyperV.TemplatePathFor(slot.TemplateVersion)
 
 	// Generate GitHub runner registration token
 	token, err := o.githubClient.GetRunnerToken()
@@ -120,28 +208,44 @@ func (o *Orchestrator) createAndRegisterVM(slot *vmmanager.VMSlot) error {
 
 	// Create the VM (config is injected during creation)
 	if err := o.vmManager.CreateVM(slot); err != nil {
+		// Host and configuration problems are not the VM's fault; anything else may be
+		if blamesVM(failurePolicyFor(err)) {
+			o.rollout.recordFailure(slot.TemplateVersion)
+			if o.config.HyperV.Quarantine.Enabled {
+				o.quarantineVM(slot, fmt.Sprintf("VM creation failed: %v", err))
+			}
+		}
 		return fmt.Errorf("failed to create VM: %w", err)
 	}
 
 	slot.State = vmmanager.StateReady
 
-	// Start monitoring VM health in background
-	go o.MonitorVMHealth(slot)
-
-	o.logger.Info("VM ready and waiting for jobs", "vm_name", slot.Name)
+	o.logger.Info("VM ready and waiting for jobs",
+		"vm_name", slot.Name,
+		"host", slot.Host,
+		"ip_address", slot.IPAddress,
+		"template_version", slot.TemplateVersion)
 	return nil
 }
 
 // RecreateVM destroys and recreates a VM after job completion
 func (o *Orchestrator) RecreateVM(vmName string) error {
+	return o.recreateVM(vmName, "")
+}
+
+// recreateVM replaces a slot's VM with a fresh one under the same name
+// A VM that failed (non-empty failureReason) is quarantined instead of destroyed when the policy is enabled
+func (o *Orchestrator) recreateVM(vmName, failureReason string) error {
 	// Find the slot
 	var slot *vmmanager.VMSlot
+	o.mu.Lock()
 	for _, s := range o.vmPool {
-		if s.Name == vmName {
+		if s != nil && s.Name == vmName {
 			slot = s
 			break
 		}
 	}
+	o.mu.Unlock()
 
 	if slot == nil {
 		return fmt.Errorf("VM slot not found: %s", vmName)
@@ -150,13 +254,22 @@ func (o *Orchestrator) RecreateVM(vmName string) error {
 	o.logger.Info("Recreating VM", "vm_name", vmName)
 
 	slot.State = vmmanager.StateDestroying
+	o.recordLifecycleUsage(slot)
+
+	// Quarantining renames the VM, which frees its name for the replacement
+	quarantined := failureReason != "" && o.config.HyperV.Quarantine.Enabled && o.quarantineVM(slot, failureReason)
 
 	// Destroy the VM
-	if err := o.vmManager.DestroyVM(slot); err != nil {
-		o.logger.Warn("Error destroying VM, continuing with recreation", "vm_name", vmName, "error", err)
-		// Continue anyway to try recreation
+	if !quarantined {
+		if err := o.vmManager.DestroyVM(slot); err != nil {
+			o.logger.Warn("Error destroying VM, continuing with recreation", "vm_name", vmName, "error", err)
+			// Continue anyway to try recreation
+		}
 	}
 
+	// The VM can no longer pick up jobs, so its runner record must not either
+	o.deregisterRunner(slot)
+
 	// Recreate the VM
 	if err := o.createAndRegisterVM(slot); err != nil {
 		return fmt.Errorf("failed to recreate VM: %w", err)
@@ -166,82 +279,33 @@ func (o *Orchestrator) RecreateVM(vmName string) error {
 	return nil
 }
 
-// RestartAllVMs restarts all VMs in the pool
-func (o *Orchestrator) RestartAllVMs() error {
-	o.logger.Info("Restarting all VMs in pool", "pool_size", len(o.vmPool))
-
+// RestartAllVMs requests that every VM in the pool is replaced, including VMs running a job
+// It returns at once: the reconcile loop replaces the VMs in the background, at most
+// runners.max_concurrent_operations at a time, and a replacement that fails is retried like
+// any other failed action
+func (o *Orchestrator) RestartAllVMs() {
 	o.mu.Lock()
-	defer o.mu.Unlock()
-
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(o.vmPool))
-
-	for _, slot := range o.vmPool {
-		if slot == nil {
-			continue
-		}
-
-		wg.Add(1)
-		go func(s *vmmanager.VMSlot) {
-			defer wg.Done()
-
-			o.logger.Info("Restarting VM", "vm_name", s.Name)
-
-			s.State = vmmanager.StateDestroying
-
-			// Destroy the VM
-			if err := o.vmManager.DestroyVM(s); err != nil {
-				o.logger.Warn("Error destroying VM during restart", "vm_name", s.Name, "error", err)
-				// Continue anyway to try recreation
-			}
-
-			// Recreate the VM
-			if err := o.createAndRegisterVM(s); err != nil {
-				errChan <- fmt.Errorf("failed to restart %s: %w", s.Name, err)
-				return
-			}
-
-			o.logger.Info("VM restarted successfully", "vm_name", s.Name)
-		}(slot)
-	}
-
-	wg.Wait()
-	close(errChan)
-
-	// Collect errors
-	var errors []error
-	for err := range errChan {
-		if err != nil {
-			errors = append(errors, err)
-		}
-	}
-
-	if len(errors) > 0 {
-		for _, err := range errors {
-			o.logger.Error("VM restart failed", "error", err)
-		}
-		return fmt.Errorf("failed to restart %d VMs: %v", len(errors), errors)
-	}
+	o.logger.Info("Restarting all VMs in pool", "pool_size", len(o.vmPool))
+	o.restartBefore = time.Now()
+	o.mu.Unlock()
 
-	o.logger.Info("All VMs restarted successfully")
-	return nil
+	o.wakeUp()
 }
 
 // Shutdown gracefully shuts down the orchestrator and cleans up all VMs
 func (o *Orchestrator) Shutdown() error {
 	o.logger.Info("Shutting down orchestrator and cleaning up VMs...")
 
-	// Cancel context to stop all monitoring goroutines
+	// Cancel context to stop the reconcile loop
 	o.cancel()
 
-	// Give monitoring goroutines a moment to stop
-	time.Sleep(1 * time.Second)
-
-	namePrefix := o.config.Runners.NamePrefix
-	if namePrefix == "" {
-		namePrefix = "runner-"
+	// A VM still being created would otherwise outlive the cleanup, together with its runner
+	if running := o.waitForActions(shutdownActionTimeout); running > 0 {
+		o.logger.Warn("Cleaning up while pool actions are still running", "running", running, "timeout", shutdownActionTimeout)
 	}
 
+	namePrefix := o.namePrefix()
+
 	// Cleanup offline runners from GitHub first (before destroying VMs)
 	// This ensures we remove any stale offline runners
 	if err := o.cleanupOfflineRunners(namePrefix); err != nil {
@@ -260,6 +324,7 @@ func (o *Orchestrator) Shutdown() error {
 }
 
 // cleanupOfflineRunners removes all runners from GitHub that match the name prefix
+// and carry this instance's owner label
 // Note: Despite the function name, this removes runners regardless of online/offline status
 // to handle cases where the program is restarted quickly before runners appear offline
 func (o *Orchestrator) cleanupOfflineRunners(namePrefix string) error {
@@ -283,37 +348,7 @@ func (o *Orchestrator) cleanupOfflineRunners(namePrefix string) error {
 		o.logger.Debug("GitHub runner details", "name", runner.Name, "id", runner.ID, "status", runner.Status)
 	}
 
-	// Find offline runners matching our name prefix
-	var toRemove []github.RunnerInfo
-	for _, runner := range runners {
-		// Check if runner name matches our prefix pattern (e.g., "windows-latest-1", "windows-latest-2")
-		// Use simple prefix match + digit check
-		if len(runner.Name) > len(namePrefix) &&
-			runner.Name[:len(namePrefix)] == namePrefix {
-
-			suffix := runner.Name[len(namePrefix):]
-
-			// Verify the suffix is digits (to match our pool naming pattern)
-			isDigitsOnly := true
-			for _, ch := range suffix {
-				if ch < '0' || ch > '9' {
-					isDigitsOnly = false
-					break
-				}
-			}
-
-			if !isDigitsOnly {
-				o.logger.Debug("Skipping runner - suffix not digits", "name", runner.Name, "suffix", suffix)
-				continue
-			}
-
-			// Remove all matching runners regardless of status
-			// (they may still show as "online" if we restarted quickly)
-			o.logger.Debug("Marking runner for removal", "name", runner.Name, "status", runner.Status)
-			toRemove = append(toRemove, runner)
-		}
-	}
-
+	toRemove := o.ownedRunners(runners, namePrefix)
 	if len(toRemove) == 0 {
 		o.logger.Info("No matching runners to remove")
 		return nil
@@ -338,3 +373,54 @@ func (o *Orchestrator) cleanupOfflineRunners(namePrefix string) error {
 	o.logger.Info("Successfully removed runners from GitHub", "count", len(toRemove))
 	return nil
 }
+
+// ownedRunners returns the runners named like pool slots (prefix followed by digits only)
+// that were registered by this instance, identified by its owner label
+// Runners of other instances sharing the prefix are skipped, so restarts never remove them
+// Runners without any owner label, registered by a version without owner labels, are adopted
+// unless another instance already labels its runners
+func (o *Orchestrator) ownedRunners(runners []github.RunnerInfo, namePrefix string) []github.RunnerInfo {
+	ownerLabel := o.config.Runners.OwnerLabel()
+
+	var candidates []github.RunnerInfo
+	for _, runner := range runners {
+		// Check if runner name matches our prefix pattern (e.g., "windows-latest-1", "windows-latest-2")
+		suffix, ok := strings.CutPrefix(runner.Name, namePrefix)
+		if !ok || suffix == "" {
+			continue
+		}
+
+		// Verify the suffix is digits (to match our pool naming pattern)
+		if strings.ContainsFunc(suffix, func(ch rune) bool { return ch < '0' || ch > '9' }) {
+			o.logger.Debug("Skipping runner - suffix not digits", "name", runner.Name, "suffix", suffix)
+			continue
+		}
+		candidates = append(candidates, runner)
+	}
+
+	adoptUntagged := !slices.ContainsFunc(candidates, func(runner github.RunnerInfo) bool {
+		return slices.ContainsFunc(runner.Labels, func(label string) bool {
+			return isOwnerLabel(label) && label != ownerLabel
+		})
+	})
+
+	var owned []github.RunnerInfo
+	for _, runner := range candidates {
+		untagged := !slices.ContainsFunc(runner.Labels, isOwnerLabel)
+		if !slices.Contains(runner.Labels, ownerLabel) && !(untagged && adoptUntagged) {
+			o.logger.Debug("Skipping runner - owned by another instance", "name", runner.Name, "labels", runner.Labels)
+			continue
+		}
+
+		// Remove all matching runners regardless of status
+		// (they may still show as "online" if we restarted quickly)
+		o.logger.Debug("Marking runner for removal", "name", runner.Name, "status", runner.Status, "adopted", untagged)
+		owned = append(owned, runner)
+	}
+	return owned
+}
+
+// isOwnerLabel reports whether a runner label is the owner label of any instance
+func isOwnerLabel(label string) bool {
+	return strings.HasPrefix(label, config.OwnerLabelPrefix)
+}
@@ -1,9 +1,16 @@
 package orchestrator
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"hyperv-runner-pool/pkg/config"
 	"hyperv-runner-pool/pkg/github"
@@ -40,6 +47,7 @@ func setupTestOrchestrator() *Orchestrator {
 	// Initialize VM slots for testing
 	for i := 0; i < orchestrator.config.Runners.PoolSize; i++ {
 		orchestrator.vmPool[i] = &vmmanager.VMSlot{
+			Index: i + 1,
 			Name:  "runner-" + string(rune('0'+i+1)),
 			State: vmmanager.StateEmpty,
 		}
@@ -80,6 +88,35 @@ func TestNewOrchestrator(t *testing.T) {
 	}
 }
 
+// invalidTemplateVMManager is a mock whose template always fails validation
+type invalidTemplateVMManager struct {
+	*vmmanager.MockVMManager
+	created int
+}
+
+func (m *invalidTemplateVMManager) ValidateTemplate(templatePath string) error {
+	return fmt.Errorf("%w: %s: file is not read-only", vmmanager.ErrTemplateInvalid, templatePath)
+}
+
+func (m *invalidTemplateVMManager) CreateVM(slot *vmmanager.VMSlot) error {
+	m.created++
+	return m.MockVMManager.CreateVM(slot)
+}
+
+func TestInitializePool_InvalidTemplateBlocksCreation(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	vmManager := &invalidTemplateVMManager{MockVMManager: vmmanager.NewMockVMManager(testLogger())}
+	orchestrator.vmManager = vmManager
+
+	err := orchestrator.InitializePool()
+	if !errors.Is(err, vmmanager.ErrTemplateInvalid) {
+		t.Fatalf("Expected ErrTemplateInvalid, got %v", err)
+	}
+	if vmManager.created != 0 {
+		t.Errorf("Expected no VMs to be created, got %d", vmManager.created)
+	}
+}
+
 func TestRecreateVM_VMNotFound(t *testing.T) {
 	orchestrator := setupTestOrchestrator()
 
@@ -114,3 +151,1255 @@ func TestOrchestratorInitializePool(t *testing.T) {
 		t.Errorf("Expected pool size 2, got %d", len(orchestrator.vmPool))
 	}
 }
+
+// growingDiskVMManager is a mock whose VMs are running with a fixed disk size
+type growingDiskVMManager struct {
+	*vmmanager.MockVMManager
+	diskSize int64
+}
+
+func (m *growingDiskVMManager) GetVMState(vmName string) (string, error) {
+	return "Running", nil
+}
+
+func (m *growingDiskVMManager) GetDiskUsage(slot *vmmanager.VMSlot) (int64, error) {
+	return m.diskSize, nil
+}
+
+func TestCheckVMHealth_DiskSizeCap(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	orchestrator.config.HyperV.Storage.MaxDiskGB = 10
+	orchestrator.config.Monitoring.GracePeriodMinutes = 60
+	vmManager := &growingDiskVMManager{MockVMManager: vmmanager.NewMockVMManager(testLogger())}
+	orchestrator.vmManager = vmManager
+
+	slot := orchestrator.vmPool[0]
+	slot.State = vmmanager.StateRunning
+	slot.CreatedAt = time.Now()
+
+	vmManager.diskSize = 5 << 30
+	if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+		t.Fatalf("Expected VM under the cap to stay, got recreate: %s", verdict.reason)
+	}
+	if slot.DiskSizeBytes != vmManager.diskSize {
+		t.Errorf("Expected disk size %d to be recorded, got %d", vmManager.diskSize, slot.DiskSizeBytes)
+	}
+
+	vmManager.diskSize = 11 << 30
+	if verdict := orchestrator.checkVMHealth(slot); !verdict.recreate {
+		t.Error("Expected VM over the cap to be recreated")
+	}
+}
+
+func TestCheckVMHealth_ExternalChanges(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	orchestrator.config.Monitoring.GracePeriodMinutes = 60
+	vmManager := vmmanager.NewMockVMManager(testLogger())
+	orchestrator.vmManager = vmManager
+
+	slot := orchestrator.vmPool[0]
+	if err := vmManager.CreateVM(slot); err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+	slot.State = vmmanager.StateRunning
+	slot.CreatedAt = time.Now()
+
+	if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+		t.Fatalf("Expected untouched VM to stay, got recreate: %s", verdict.reason)
+	}
+	if slot.Hardware.MemoryMB != 4096 || slot.Hardware.CPUCount != 2 {
+		t.Errorf("Expected the first check to record the VM's hardware, got %+v", slot.Hardware)
+	}
+
+	// Memory changed in Hyper-V Manager
+	altered := slot.Hardware
+	altered.MemoryMB = 8192
+	vmManager.AlterVM(slot.Name, altered)
+	if verdict := orchestrator.checkVMHealth(slot); !verdict.recreate || verdict.reason != reasonVMModified {
+		t.Errorf("Expected altered VM to be recreated, got %v (%s)", verdict.recreate, verdict.reason)
+	}
+
+	orchestrator.config.Monitoring.DriftAction = config.DriftFlag
+	if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+		t.Errorf("Expected flagged VM to stay, got recreate: %s", verdict.reason)
+	}
+	if slot.Drift != "memory 4096 MB -> 8192 MB" {
+		t.Errorf("Expected the memory change to be flagged, got %q", slot.Drift)
+	}
+
+	// VM deleted in Hyper-V Manager
+	vmManager.VanishVM(slot.Name)
+	if verdict := orchestrator.checkVMHealth(slot); !verdict.recreate || verdict.reason != reasonVMDeleted {
+		t.Errorf("Expected deleted VM to be recreated, got %v (%s)", verdict.recreate, verdict.reason)
+	}
+	if slot.HealthCheckFailures != 0 {
+		t.Errorf("Expected a deleted VM not to count as a transient failure, got %d", slot.HealthCheckFailures)
+	}
+}
+
+func TestCheckVMHealth_FailureThresholds(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	orchestrator.vmManager = &growingDiskVMManager{MockVMManager: vmmanager.NewMockVMManager(testLogger())}
+	orchestrator.config.Monitoring.HealthChecks = config.HealthChecksConfig{
+		RunnerOffline: config.HealthCheckPolicy{Threshold: 2, Action: config.CheckActionQuarantine},
+		GitHubErrors:  config.HealthCheckPolicy{Threshold: 2, Action: config.CheckActionAlert},
+	}
+	ghClient := &flakyGitHub{runners: []github.RunnerInfo{{ID: 1, Name: "runner-1", Status: "offline"}}}
+	orchestrator.githubClient = ghClient
+
+	slot := orchestrator.vmPool[0]
+	slot.State = vmmanager.StateRunning
+	slot.CreatedAt = time.Now().Add(-time.Minute)
+
+	// A single offline reading is tolerated, the second one quarantines the VM
+	if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+		t.Fatalf("Expected the first offline reading to be tolerated, got recreate: %s", verdict.reason)
+	}
+	verdict := orchestrator.checkVMHealth(slot)
+	if !verdict.recreate || !verdict.quarantine {
+		t.Errorf("Expected the second offline reading to quarantine the VM, got %+v", verdict)
+	}
+
+	// An online reading resets the count
+	ghClient.runners[0].Status = "online"
+	orchestrator.checkVMHealth(slot)
+	if slot.OfflineReadings != 0 || slot.HealthCheckFailures != 0 {
+		t.Errorf("Expected an online reading to reset the failures, got %d offline, %d total", slot.OfflineReadings, slot.HealthCheckFailures)
+	}
+
+	// GitHub errors past the threshold only alert and keep the VM
+	ghClient.lookupErr = errors.New("502 Bad Gateway")
+	for range 3 {
+		if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+			t.Errorf("Expected GitHub errors to keep the VM, got recreate: %s", verdict.reason)
+		}
+	}
+	if slot.GitHubErrors != 3 {
+		t.Errorf("Expected 3 GitHub errors to be counted, got %d", slot.GitHubErrors)
+	}
+
+	// Rate limiting is not the VM's fault and is not counted against it
+	ghClient.lookupErr = &github.APIError{Op: "list runners", Err: github.ErrRateLimited}
+	orchestrator.checkVMHealth(slot)
+	if slot.GitHubErrors != 3 {
+		t.Errorf("Expected rate limiting not to count, got %d GitHub errors", slot.GitHubErrors)
+	}
+}
+
+func TestCheckVMHealth_RunnerNotFound(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	orchestrator.vmManager = &growingDiskVMManager{MockVMManager: vmmanager.NewMockVMManager(testLogger())}
+	orchestrator.config.Monitoring.HealthChecks.RunnerNotFound = config.HealthCheckPolicy{Threshold: 2, Action: config.CheckActionQuarantine}
+	orchestrator.config.Monitoring.CreationTimeoutMinutes = 5
+	orchestrator.githubClient = &flakyGitHub{}
+
+	slot := orchestrator.vmPool[0]
+	slot.State = vmmanager.StateRunning
+	slot.CreatedAt = time.Now().Add(-time.Minute)
+
+	// A runner that finished its job deregistered itself; its VM is about to power off
+	slot.RunnerBusy = true
+	for range 3 {
+		if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+			t.Fatalf("Expected a runner gone after its job to be waited for, got recreate: %s", verdict.reason)
+		}
+	}
+
+	// A runner that never showed up is tolerated once, then quarantined
+	slot.RunnerBusy = false
+	if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+		t.Fatalf("Expected a single missing reading to be tolerated, got recreate: %s", verdict.reason)
+	}
+	if verdict := orchestrator.checkVMHealth(slot); !verdict.recreate || !verdict.quarantine {
+		t.Errorf("Expected the second missing reading to quarantine the VM, got %+v", verdict)
+	}
+}
+
+func TestCheckVMHealth_VMOutlivesFinishedRunner(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	orchestrator.vmManager = &growingDiskVMManager{MockVMManager: vmmanager.NewMockVMManager(testLogger())}
+	orchestrator.config.Monitoring.HealthChecks.RunnerNotFound = config.HealthCheckPolicy{Threshold: 2, Action: config.CheckActionQuarantine}
+	orchestrator.config.Monitoring.CreationTimeoutMinutes = 5
+	orchestrator.githubClient = &flakyGitHub{}
+
+	slot := orchestrator.vmPool[0]
+	slot.State = vmmanager.StateRunning
+	slot.CreatedAt = time.Now().Add(-time.Hour)
+	slot.RunnerBusy = true
+
+	if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+		t.Fatalf("Expected the VM to be given time to power off, got recreate: %s", verdict.reason)
+	}
+	if slot.RunnerGoneAt.IsZero() {
+		t.Fatal("Expected the time the runner disappeared to be recorded")
+	}
+
+	// The VM is still running long after its runner finished, e.g. its shutdown hung
+	slot.RunnerGoneAt = time.Now().Add(-6 * time.Minute)
+	if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+		t.Fatalf("Expected the first reading past the timeout to be counted, got recreate: %s", verdict.reason)
+	}
+	if verdict := orchestrator.checkVMHealth(slot); !verdict.recreate || !verdict.quarantine {
+		t.Errorf("Expected a VM that never powered off to be quarantined, got %+v", verdict)
+	}
+}
+
+func TestCheckVMHealth_GuestProbe(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	vmManager := vmmanager.NewMockVMManager(testLogger())
+	orchestrator.vmManager = vmManager
+	orchestrator.githubClient = &flakyGitHub{runners: []github.RunnerInfo{{ID: 1, Name: "runner-1", Status: "online"}}}
+	orchestrator.config.Monitoring.GuestProbe = config.GuestProbeConfig{Enabled: true, HeartbeatSeconds: 30, MinFreeDiskGB: 5, MaxLogSilenceMinutes: 10}
+	orchestrator.config.Monitoring.HealthChecks.GuestProbe = config.HealthCheckPolicy{Threshold: 1, Action: config.CheckActionRecreate}
+
+	slot := orchestrator.vmPool[0]
+	if err := vmManager.CreateVM(slot); err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+	slot.State = vmmanager.StateRunning
+	slot.CreatedAt = time.Now().Add(-time.Minute)
+
+	if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+		t.Fatalf("Expected a live guest to pass the probe, got recreate: %s", verdict.reason)
+	}
+	if slot.Guest.FreeDiskBytes == 0 {
+		t.Error("Expected the probe result to be recorded on the slot")
+	}
+
+	now := time.Now()
+	healthy := vmmanager.GuestStatus{ListenerRunning: true, FreeDiskBytes: 50 << 30, LastLogAt: now, HeartbeatAt: now}
+	tests := []struct {
+		name   string
+		alter  func(status *vmmanager.GuestStatus)
+		reason string
+	}{
+		{"stale heartbeat", func(status *vmmanager.GuestStatus) { status.HeartbeatAt = now.Add(-2 * time.Minute) }, "Guest heartbeat is stale"},
+		{"listener gone", func(status *vmmanager.GuestStatus) { status.ListenerRunning = false }, "Runner listener is not running in the guest"},
+		{"disk full", func(status *vmmanager.GuestStatus) { status.FreeDiskBytes = 1 << 30 }, "Guest is low on disk space"},
+		{"hung listener", func(status *vmmanager.GuestStatus) { status.LastLogAt = now.Add(-time.Hour) }, "Runner log went silent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := healthy
+			tt.alter(&status)
+			vmManager.SetGuestStatus(slot.Name, status)
+			if verdict := orchestrator.checkVMHealth(slot); !verdict.recreate || verdict.reason != tt.reason {
+				t.Errorf("Expected recreate with %q, got %+v", tt.reason, verdict)
+			}
+		})
+	}
+}
+
+func TestHardwareDrift(t *testing.T) {
+	baseline := vmmanager.VMHardware{MemoryMB: 4096, CPUCount: 2, Disks: []string{`D:\vms\runner-1.vhdx`}}
+
+	current := baseline
+	current.Disks = []string{`d:\VMS\runner-1.vhdx`}
+	if drift := hardwareDrift(baseline, current); drift != "" {
+		t.Errorf("Expected disk paths to compare case-insensitively, got %q", drift)
+	}
+
+	current = vmmanager.VMHardware{MemoryMB: 4096, CPUCount: 4, Disks: []string{`D:\vms\other.vhdx`}}
+	want := `CPU count 2 -> 4, disk detached: D:\vms\runner-1.vhdx, disk attached: D:\vms\other.vhdx`
+	if drift := hardwareDrift(baseline, current); drift != want {
+		t.Errorf("Expected %q, got %q", want, drift)
+	}
+}
+
+// diagnosticsVMManager is a mock that records diagnostics collection
+type diagnosticsVMManager struct {
+	*vmmanager.MockVMManager
+	state     string
+	collected []string
+}
+
+func (m *diagnosticsVMManager) GetVMState(vmName string) (string, error) {
+	return m.state, nil
+}
+
+func (m *diagnosticsVMManager) CollectDiagnostics(slot *vmmanager.VMSlot, reason string) (string, error) {
+	m.collected = append(m.collected, reason)
+	return "bundle", nil
+}
+
+func TestCollectDiagnostics(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	vmManager := &diagnosticsVMManager{MockVMManager: vmmanager.NewMockVMManager(testLogger()), state: "Running"}
+	orchestrator.vmManager = vmManager
+	slot := orchestrator.vmPool[0]
+
+	// Disabled by default
+	orchestrator.collectDiagnostics(slot, "Runner is offline in GitHub")
+	if len(vmManager.collected) != 0 {
+		t.Fatal("Expected no diagnostics when disabled")
+	}
+
+	orchestrator.config.HyperV.Diagnostics.Enabled = true
+	orchestrator.collectDiagnostics(slot, "Runner is offline in GitHub")
+	if len(vmManager.collected) != 1 || vmManager.collected[0] != "Runner is offline in GitHub" {
+		t.Errorf("Expected diagnostics for a running VM, got %v", vmManager.collected)
+	}
+
+	// Guests that already shut down cannot be reached
+	vmManager.state = "Off"
+	orchestrator.collectDiagnostics(slot, "VM power state is Off/Stopped")
+	if len(vmManager.collected) != 1 {
+		t.Errorf("Expected no diagnostics for a stopped VM, got %v", vmManager.collected)
+	}
+}
+
+// quarantineVMManager is a mock that records quarantined and destroyed VMs
+type quarantineVMManager struct {
+	*vmmanager.MockVMManager
+	quarantined []string
+	destroyed   []string
+}
+
+func (m *quarantineVMManager) QuarantineVM(slot *vmmanager.VMSlot, reason string) (string, error) {
+	m.quarantined = append(m.quarantined, slot.Name)
+	return m.MockVMManager.QuarantineVM(slot, reason)
+}
+
+func (m *quarantineVMManager) DestroyVM(slot *vmmanager.VMSlot) error {
+	m.destroyed = append(m.destroyed, slot.Name)
+	return m.MockVMManager.DestroyVM(slot)
+}
+
+func TestRecreateVM_QuarantinesFailedVMs(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	orchestrator.config.HyperV.Quarantine.Enabled = true
+	orchestrator.config.Monitoring.HealthCheckIntervalSeconds = 3600
+	defer orchestrator.cancel()
+	vmManager := &quarantineVMManager{MockVMManager: vmmanager.NewMockVMManager(testLogger())}
+	orchestrator.vmManager = vmManager
+
+	slot := orchestrator.vmPool[0]
+	if err := vmManager.CreateVM(slot); err != nil {
+		t.Fatalf("Failed to create VM: %v", err)
+	}
+
+	// A VM that finished its job is destroyed as usual
+	if err := orchestrator.recreateVM(slot.Name, ""); err != nil {
+		t.Fatalf("Failed to recreate VM: %v", err)
+	}
+	if len(vmManager.quarantined) != 0 || len(vmManager.destroyed) != 1 {
+		t.Fatalf("Expected destroy only, got quarantined=%v destroyed=%v", vmManager.quarantined, vmManager.destroyed)
+	}
+
+	// A failed VM is quarantined and the slot gets a fresh VM under its original name
+	if err := orchestrator.recreateVM(slot.Name, "Runner is offline in GitHub"); err != nil {
+		t.Fatalf("Failed to recreate VM: %v", err)
+	}
+	if len(vmManager.quarantined) != 1 || len(vmManager.destroyed) != 1 {
+		t.Errorf("Expected quarantine instead of destroy, got quarantined=%v destroyed=%v", vmManager.quarantined, vmManager.destroyed)
+	}
+	if state, err := vmManager.GetVMState(slot.Name); err != nil || state != "Running" {
+		t.Errorf("Expected a fresh VM named %s, got state=%q err=%v", slot.Name, state, err)
+	}
+}
+
+func TestMockScenario_RecreatesAfterJobsAndFailures(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	orchestrator.config.Monitoring.HealthCheckIntervalSeconds = 1
+	orchestrator.config.Monitoring.CreationTimeoutMinutes = 5
+	orchestrator.config.Monitoring.GracePeriodMinutes = 5
+	defer orchestrator.cancel()
+
+	// The first creation fails, then every VM finishes a one second job and shuts down
+	vmManager := vmmanager.NewMockVMManagerWithScenario(config.MockScenarioConfig{
+		Seed:          1,
+		CreateVM:      config.MockCallConfig{FailCalls: []int{1}},
+		JobMinSeconds: 1,
+		JobMaxSeconds: 1,
+	}, testLogger())
+	orchestrator.vmManager = vmManager
+
+	slot := orchestrator.vmPool[0]
+	if err := orchestrator.createAndRegisterVM(slot); err == nil {
+		t.Fatal("Expected the scripted CreateVM failure")
+	}
+	if err := orchestrator.createAndRegisterVM(slot); err != nil {
+		t.Fatalf("Failed to create VM: %v", err)
+	}
+
+	// The reconcile loop sees the VM turn off and replaces it
+	go orchestrator.reconcileLoop()
+	deadline := time.Now().Add(5 * time.Second)
+	for vmManager.Calls("DestroyVM") == 0 || vmManager.Calls("CreateVM") < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the finished VM to be recreated, got %d creates and %d destroys",
+				vmManager.Calls("CreateVM"), vmManager.Calls("DestroyVM"))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// meteringVMManager is a mock that returns scripted resource usage samples
+type meteringVMManager struct {
+	*vmmanager.MockVMManager
+	samples  []vmmanager.ResourceUsage
+	measured int
+}
+
+func (m *meteringVMManager) MeasureVM(slot *vmmanager.VMSlot) (vmmanager.ResourceUsage, error) {
+	sample := m.samples[min(m.measured, len(m.samples)-1)]
+	m.measured++
+	return sample, nil
+}
+
+func TestMeterVM_TracksLifecycleUsage(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	orchestrator.config.Monitoring.HealthCheckIntervalSeconds = 3600
+	defer orchestrator.cancel()
+	now := time.Now()
+	vmManager := &meteringVMManager{
+		MockVMManager: vmmanager.NewMockVMManager(testLogger()),
+		samples: []vmmanager.ResourceUsage{
+			{SampledAt: now, MemoryDemandMB: 3500, PeakMemoryDemandMB: 3500, DiskWrittenMB: 100},
+			{SampledAt: now, MemoryDemandMB: 1200, PeakMemoryDemandMB: 1200, DiskWrittenMB: 900},
+		},
+	}
+	orchestrator.vmManager = vmManager
+
+	slot := orchestrator.vmPool[0]
+	orchestrator.meterVM(slot)
+	orchestrator.meterVM(slot)
+	if slot.Usage.DiskWrittenMB != 900 || slot.Usage.MemoryDemandMB != 1200 {
+		t.Errorf("Expected the latest sample on the slot, got %+v", slot.Usage)
+	}
+	if slot.Usage.PeakMemoryDemandMB != 3500 {
+		t.Errorf("Expected peak memory demand 3500 to survive later samples, got %d", slot.Usage.PeakMemoryDemandMB)
+	}
+
+	// A final sample is only taken when metering is enabled
+	orchestrator.recordLifecycleUsage(slot)
+	if vmManager.measured != 2 {
+		t.Errorf("Expected no lifecycle sample with metering disabled, got %d samples", vmManager.measured)
+	}
+	orchestrator.config.HyperV.Metering.Enabled = true
+	orchestrator.config.HyperV.Metering.IntervalSeconds = 3600
+	if err := vmManager.CreateVM(slot); err != nil {
+		t.Fatalf("Failed to create VM: %v", err)
+	}
+	if err := orchestrator.recreateVM(slot.Name, ""); err != nil {
+		t.Fatalf("Failed to recreate VM: %v", err)
+	}
+	if vmManager.measured != 3 {
+		t.Errorf("Expected a final sample before the VM was destroyed, got %d samples", vmManager.measured)
+	}
+	if !slot.Usage.SampledAt.IsZero() {
+		t.Errorf("Expected the new VM to start without usage, got %+v", slot.Usage)
+	}
+}
+
+// barrierMeteringVMManager is a mock whose measurements only return once all of them have started
+type barrierMeteringVMManager struct {
+	*vmmanager.MockVMManager
+	arrived sync.WaitGroup
+	timeout atomic.Bool
+}
+
+func (m *barrierMeteringVMManager) MeasureVM(slot *vmmanager.VMSlot) (vmmanager.ResourceUsage, error) {
+	m.arrived.Done()
+	done := make(chan struct{})
+	go func() {
+		m.arrived.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		m.timeout.Store(true)
+	}
+	return vmmanager.ResourceUsage{SampledAt: time.Now()}, nil
+}
+
+func TestMeterSlots_MeasuresVMsInParallel(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	vmManager := &barrierMeteringVMManager{MockVMManager: vmmanager.NewMockVMManager(testLogger())}
+	vmManager.arrived.Add(len(orchestrator.vmPool))
+	orchestrator.vmManager = vmManager
+	for _, slot := range orchestrator.vmPool {
+		slot.State = vmmanager.StateReady
+	}
+
+	orchestrator.meterSlots()
+	if vmManager.timeout.Load() {
+		t.Error("Expected every VM to be measured at once, not one after another")
+	}
+	for _, slot := range orchestrator.vmPool {
+		if slot.Usage.SampledAt.IsZero() {
+			t.Errorf("Expected %s to be measured", slot.Name)
+		}
+	}
+}
+
+func newTestRollout() *templateRollout {
+	return newPersistedTestRollout("")
+}
+
+// newPersistedTestRollout returns the rollout of v2 over v1, kept in the state file at statePath
+func newPersistedTestRollout(statePath string) *templateRollout {
+	return newTemplateRollout(config.HyperVConfig{
+		TemplateVersion: "v1",
+		Rollout: config.RolloutConfig{
+			Candidate:     "v2",
+			CanaryPercent: 25,
+			PromoteAfter:  2,
+			RollbackAfter: 1,
+			StatePath:     statePath,
+		},
+	}, nil, testLogger())
+}
+
+func TestTemplateRollout_CanaryShare(t *testing.T) {
+	rollout := newTestRollout()
+
+	canaries := 0
+	for i := 0; i < 8; i++ {
+		if rollout.nextVersion() == "v2" {
+			canaries++
+		}
+	}
+	if canaries != 2 {
+		t.Errorf("Expected 2 of 8 creations to use the candidate, got %d", canaries)
+	}
+}
+
+func TestTemplateRollout_Promote(t *testing.T) {
+	rollout := newTestRollout()
+
+	// Healthy VMs on the stable version don't count towards promotion
+	rollout.recordHealthy("v1")
+	rollout.recordHealthy("v2")
+	if stable, candidate := rollout.versions(); stable != "v1" || candidate != "v2" {
+		t.Fatalf("Rollout finished too early: stable=%s candidate=%s", stable, candidate)
+	}
+
+	rollout.recordHealthy("v2")
+	stable, candidate := rollout.versions()
+	if stable != "v2" || candidate != "" {
+		t.Errorf("Expected v2 to be promoted, got stable=%s candidate=%s", stable, candidate)
+	}
+	if version := rollout.nextVersion(); version != "v2" {
+		t.Errorf("Expected new VMs to use v2 after promotion, got %s", version)
+	}
+}
+
+func TestTemplateRollout_Rollback(t *testing.T) {
+	rollout := newTestRollout()
+
+	rollout.recordHealthy("v2")
+	rollout.recordFailure("v2")
+
+	stable, candidate := rollout.versions()
+	if stable != "v1" || candidate != "" {
+		t.Errorf("Expected rollback to v1, got stable=%s candidate=%s", stable, candidate)
+	}
+	for i := 0; i < 8; i++ {
+		if version := rollout.nextVersion(); version != "v1" {
+			t.Fatalf("Expected only v1 after rollback, got %s", version)
+		}
+	}
+}
+
+func TestTemplateRollout_RollbackWhenCandidateFailsValidation(t *testing.T) {
+	rollout := newTestRollout()
+	rollout.validate = func(version string) error {
+		return fmt.Errorf("%w: %s", vmmanager.ErrTemplateInvalid, version)
+	}
+
+	rollout.recordHealthy("v2")
+	rollout.recordHealthy("v2")
+
+	stable, candidate := rollout.versions()
+	if stable != "v1" || candidate != "" {
+		t.Errorf("Expected rollback to v1, got stable=%s candidate=%s", stable, candidate)
+	}
+}
+
+func TestTemplateRollout_SurvivesRestart(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "rollout-state.json")
+
+	// Progress carries over a restart
+	rollout := newPersistedTestRollout(statePath)
+	rollout.recordHealthy("v2")
+	rollout = newPersistedTestRollout(statePath)
+	if rollout.healthy != 1 {
+		t.Fatalf("Expected the healthy canary to be remembered, got %d", rollout.healthy)
+	}
+	rollout.recordHealthy("v2")
+
+	// A promoted candidate stays promoted
+	rollout = newPersistedTestRollout(statePath)
+	if stable, candidate := rollout.versions(); stable != "v2" || candidate != "" {
+		t.Errorf("Expected v2 to stay promoted after a restart, got stable=%s candidate=%s", stable, candidate)
+	}
+
+	// A rolled back candidate is not rolled out again while the config still names it
+	statePath = filepath.Join(t.TempDir(), "rollout-state.json")
+	newPersistedTestRollout(statePath).recordFailure("v2")
+	rollout = newPersistedTestRollout(statePath)
+	if stable, candidate := rollout.versions(); stable != "v1" || candidate != "" {
+		t.Errorf("Expected v2 to stay rolled back after a restart, got stable=%s candidate=%s", stable, candidate)
+	}
+
+	// A different candidate starts a new rollout
+	cfg := config.HyperVConfig{
+		TemplateVersion: "v1",
+		Rollout:         config.RolloutConfig{Candidate: "v3", CanaryPercent: 25, PromoteAfter: 2, RollbackAfter: 1, StatePath: statePath},
+	}
+	if _, candidate := newTemplateRollout(cfg, nil, testLogger()).versions(); candidate != "v3" {
+		t.Errorf("Expected a new candidate to be rolled out, got %q", candidate)
+	}
+}
+
+func TestOwnedRunners_SkipsOtherInstances(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	orchestrator.config.Runners.InstanceID = "host-a"
+
+	runners := []github.RunnerInfo{
+		{ID: 1, Name: "runner-1", Status: "online", Labels: []string{"self-hosted", "owner-host-a"}},
+		{ID: 2, Name: "runner-2", Status: "offline", Labels: []string{"self-hosted", "owner-host-b"}},
+		{ID: 3, Name: "runner-3", Status: "offline", Labels: []string{"self-hosted"}},
+		{ID: 4, Name: "runner-template", Status: "offline", Labels: []string{"owner-host-a"}},
+		{ID: 5, Name: "runner-", Status: "offline", Labels: []string{"owner-host-a"}},
+		{ID: 6, Name: "other-1", Status: "offline", Labels: []string{"owner-host-a"}},
+		{ID: 7, Name: "runner-12", Status: "offline", Labels: []string{"owner-host-a"}},
+	}
+
+	owned := orchestrator.ownedRunners(runners, "runner-")
+	if len(owned) != 2 || owned[0].ID != 1 || owned[1].ID != 7 {
+		t.Errorf("Expected only runners 1 and 7 to be owned by host-a, got %+v", owned)
+	}
+}
+
+func TestOwnedRunners_AdoptsUntaggedRunnersAfterUpgrade(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	orchestrator.config.Runners.InstanceID = "host-a"
+
+	// Registered before owner labels existed
+	runners := []github.RunnerInfo{
+		{ID: 1, Name: "runner-1", Status: "offline", Labels: []string{"self-hosted"}},
+		{ID: 2, Name: "runner-2", Status: "online", Labels: []string{"self-hosted", "owner-host-a"}},
+		{ID: 3, Name: "runner-template", Status: "offline", Labels: []string{"self-hosted"}},
+	}
+	owned := orchestrator.ownedRunners(runners, "runner-")
+	if len(owned) != 2 || owned[0].ID != 1 || owned[1].ID != 2 {
+		t.Errorf("Expected untagged runner 1 to be adopted, got %+v", owned)
+	}
+
+	// Another instance labels its runners, so untagged ones may be its own
+	runners = append(runners, github.RunnerInfo{ID: 4, Name: "runner-4", Status: "online", Labels: []string{"owner-host-b"}})
+	owned = orchestrator.ownedRunners(runners, "runner-")
+	if len(owned) != 1 || owned[0].ID != 2 {
+		t.Errorf("Expected only runner 2 with another instance present, got %+v", owned)
+	}
+}
+
+// flakyGitHub is a fake GitHub API whose runner removals fail a scripted number of times
+type flakyGitHub struct {
+	*github.Client
+	runners      []github.RunnerInfo
+	lookupErr    error
+	removeErrors []error
+	removed      []int64
+	cancelled    []string
+}
+
+func (g *flakyGitHub) GetRunnerByName(name string) (*github.RunnerInfo, error) {
+	if g.lookupErr != nil {
+		return nil, g.lookupErr
+	}
+	for _, runner := range g.runners {
+		if runner.Name == name {
+			return &runner, nil
+		}
+	}
+	return nil, nil
+}
+
+func (g *flakyGitHub) CancelRunnerJob(runnerName string) (int64, error) {
+	g.cancelled = append(g.cancelled, runnerName)
+	return 99, nil
+}
+
+func (g *flakyGitHub) RemoveRunner(runnerID int64, runnerName string) error {
+	g.removed = append(g.removed, runnerID)
+	if len(g.removeErrors) > 0 {
+		err := g.removeErrors[0]
+		g.removeErrors = g.removeErrors[1:]
+		return err
+	}
+	return nil
+}
+
+func TestCheckVMHealth_MaxJobDuration(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	orchestrator.config.Runners.MaxJobDurationMinutes = 60
+	ghClient := &flakyGitHub{
+		Client:  github.NewClient(orchestrator.config, testLogger()),
+		runners: []github.RunnerInfo{{ID: 1, Name: "runner-1", Status: "online", Busy: true}},
+	}
+	orchestrator.githubClient = ghClient
+
+	slot := orchestrator.vmPool[0]
+	if err := orchestrator.createAndRegisterVM(slot); err != nil {
+		t.Fatalf("Failed to create VM: %v", err)
+	}
+
+	// The slot is running from the first busy reading of its runner
+	if verdict := orchestrator.checkVMHealth(slot); verdict.recreate {
+		t.Fatalf("Expected a fresh job to run, got recreate: %s", verdict.reason)
+	}
+	if slot.State != vmmanager.StateRunning || slot.JobStartedAt.IsZero() {
+		t.Fatalf("Expected the slot to be running, got %s since %v", slot.State, slot.JobStartedAt)
+	}
+
+	slot.JobStartedAt = time.Now().Add(-61 * time.Minute)
+	verdict := orchestrator.checkVMHealth(slot)
+	if !verdict.recreate || verdict.reason != reasonJobTimeout || verdict.quarantine {
+		t.Fatalf("Expected the overrunning job's VM to be recycled, got %+v", verdict)
+	}
+
+	// The job is cancelled before the VM is recycled
+	done := make(chan error, 1)
+	orchestrator.runAction(poolAction{kind: actionRepair, slot: slot, reason: verdict.reason}, done)
+	if err := <-done; err != nil {
+		t.Fatalf("Failed to recycle VM: %v", err)
+	}
+	if !slices.Equal(ghClient.cancelled, []string{"runner-1"}) {
+		t.Errorf("Expected runner-1's job to be cancelled, got %v", ghClient.cancelled)
+	}
+	if slot.State != vmmanager.StateReady || !slot.JobStartedAt.IsZero() {
+		t.Errorf("Expected a fresh idle VM, got %s since %v", slot.State, slot.JobStartedAt)
+	}
+}
+
+func TestRecreateVM_DeregistersRunner(t *testing.T) {
+	runnerRemovalRetryDelay = time.Millisecond
+	orchestrator := setupTestOrchestrator()
+	orchestrator.config.Monitoring.HealthCheckIntervalSeconds = 3600
+	defer orchestrator.cancel()
+	ghClient := &flakyGitHub{
+		Client:       github.NewClient(orchestrator.config, testLogger()),
+		removeErrors: []error{errors.New("502 Bad Gateway")},
+	}
+	orchestrator.githubClient = ghClient
+
+	slot := orchestrator.vmPool[0]
+	if err := orchestrator.createAndRegisterVM(slot); err != nil {
+		t.Fatalf("Failed to create VM: %v", err)
+	}
+	slot.RunnerID = 42
+
+	// The recorded runner is removed, retrying the transient failure
+	if err := orchestrator.recreateVM(slot.Name, "Runner is offline in GitHub"); err != nil {
+		t.Fatalf("Failed to recreate VM: %v", err)
+	}
+	if len(ghClient.removed) != 2 || ghClient.removed[0] != 42 || ghClient.removed[1] != 42 {
+		t.Errorf("Expected runner 42 to be removed on the second attempt, got %v", ghClient.removed)
+	}
+	if slot.RunnerID != 0 {
+		t.Errorf("Expected the fresh VM to have no runner ID yet, got %d", slot.RunnerID)
+	}
+}
+
+func TestRemoveSlotRunner(t *testing.T) {
+	runnerRemovalRetryDelay = time.Millisecond
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	orchestrator.config.Runners.InstanceID = "host-a"
+	slot := orchestrator.vmPool[0]
+
+	// A runner that is already gone counts as removed
+	ghClient := &flakyGitHub{removeErrors: []error{fmt.Errorf("%w: runner-1 (7)", github.ErrRunnerNotFound)}}
+	orchestrator.githubClient = ghClient
+	slot.RunnerID = 7
+	if err := orchestrator.removeSlotRunner(slot); err != nil {
+		t.Errorf("Expected a missing runner to count as removed, got %v", err)
+	}
+	if len(ghClient.removed) != 1 {
+		t.Errorf("Expected no retries for a missing runner, got %v", ghClient.removed)
+	}
+
+	// Persistent failures give up after the configured attempts
+	failure := errors.New("503 Service Unavailable")
+	ghClient = &flakyGitHub{removeErrors: []error{failure, failure, failure, failure}}
+	orchestrator.githubClient = ghClient
+	slot.RunnerID = 7
+	if err := orchestrator.removeSlotRunner(slot); !errors.Is(err, failure) {
+		t.Errorf("Expected the removal to fail, got %v", err)
+	}
+	if len(ghClient.removed) != runnerRemovalAttempts {
+		t.Errorf("Expected %d attempts, got %d", runnerRemovalAttempts, len(ghClient.removed))
+	}
+
+	// Without a recorded ID the runner is looked up by name, and only this instance's runner is removed
+	ghClient = &flakyGitHub{runners: []github.RunnerInfo{{ID: 8, Name: "runner-1", Labels: []string{"owner-host-b"}}}}
+	orchestrator.githubClient = ghClient
+	slot.RunnerID = 0
+	if err := orchestrator.removeSlotRunner(slot); err != nil || len(ghClient.removed) != 0 {
+		t.Errorf("Expected another instance's runner to be left alone, got removed=%v err=%v", ghClient.removed, err)
+	}
+	ghClient.runners[0].Labels = []string{"owner-host-a"}
+	if err := orchestrator.removeSlotRunner(slot); err != nil || len(ghClient.removed) != 1 || ghClient.removed[0] != 8 {
+		t.Errorf("Expected runner 8 to be removed by name, got removed=%v err=%v", ghClient.removed, err)
+	}
+}
+
+func TestCollectGarbage_RemovesVMsWithoutSlot(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	orchestrator.config.Monitoring.HealthCheckIntervalSeconds = 3600
+	defer orchestrator.cancel()
+	vmManager := vmmanager.NewMockVMManager(testLogger())
+	orchestrator.vmManager = vmManager
+
+	// runner-3 is left over from a larger pool
+	for _, slot := range append(orchestrator.vmPool, &vmmanager.VMSlot{Index: 3, Name: "runner-3"}) {
+		if err := vmManager.CreateVM(slot); err != nil {
+			t.Fatalf("Failed to create VM: %v", err)
+		}
+	}
+
+	orchestrator.collectGarbage()
+	if _, err := vmManager.GetVMState("runner-3"); err == nil {
+		t.Error("Expected the VM without a slot to be collected")
+	}
+	for _, slot := range orchestrator.vmPool {
+		if _, err := vmManager.GetVMState(slot.Name); err != nil {
+			t.Errorf("Expected the pool VM %s to be kept, got %v", slot.Name, err)
+		}
+	}
+}
+
+// setupReconcileTest returns an orchestrator whose reconcile passes only run VM checks,
+// with a mock that answers instantly
+func setupReconcileTest(scenario config.MockScenarioConfig) (*Orchestrator, *vmmanager.MockVMManager) {
+	orchestrator := setupTestOrchestrator()
+	orchestrator.config.Monitoring.HealthCheckIntervalSeconds = 3600
+	orchestrator.config.Monitoring.CreationTimeoutMinutes = 5
+	orchestrator.config.Monitoring.GracePeriodMinutes = 60
+	vmManager := vmmanager.NewMockVMManagerWithScenario(scenario, testLogger())
+	orchestrator.vmManager = vmManager
+	return orchestrator, vmManager
+}
+
+func TestReconcile_ScalesPoolOnReload(t *testing.T) {
+	orchestrator, vmManager := setupReconcileTest(config.MockScenarioConfig{})
+	defer orchestrator.cancel()
+
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to create the pool: %v", errs)
+	}
+
+	// Growing the pool creates the missing slots
+	cfg := orchestrator.config
+	cfg.Runners.PoolSize = 3
+	orchestrator.Reload(cfg)
+	if !orchestrator.applyPendingConfig() {
+		t.Fatal("Expected the reloaded configuration to be applied")
+	}
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to grow the pool: %v", errs)
+	}
+	if len(orchestrator.vmPool) != 3 {
+		t.Fatalf("Expected 3 slots, got %d", len(orchestrator.vmPool))
+	}
+	for _, name := range []string{"runner-1", "runner-2", "runner-3"} {
+		if state, err := vmManager.GetVMState(name); err != nil || state != "Running" {
+			t.Errorf("Expected %s to be running, got state=%q err=%v", name, state, err)
+		}
+	}
+
+	// Shrinking retires the surplus slots, but waits for a running job to finish
+	orchestrator.vmPool[1].RunnerBusy = true
+	cfg.Runners.PoolSize = 1
+	orchestrator.Reload(cfg)
+	orchestrator.applyPendingConfig()
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to shrink the pool: %v", errs)
+	}
+	if len(orchestrator.vmPool) != 2 {
+		t.Fatalf("Expected the busy slot to stay until its job ends, got %d slots", len(orchestrator.vmPool))
+	}
+	if _, err := vmManager.GetVMState("runner-3"); err == nil {
+		t.Error("Expected runner-3 to be destroyed")
+	}
+
+	orchestrator.vmPool[1].RunnerBusy = false
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to shrink the pool: %v", errs)
+	}
+	if len(orchestrator.vmPool) != 1 || orchestrator.vmPool[0].Name != "runner-1" {
+		t.Fatalf("Expected only runner-1 to remain, got %d slots", len(orchestrator.vmPool))
+	}
+	if _, err := vmManager.GetVMState("runner-2"); err == nil {
+		t.Error("Expected runner-2 to be destroyed")
+	}
+}
+
+func TestReconcile_ReplacesOutdatedIdleVMs(t *testing.T) {
+	orchestrator, vmManager := setupReconcileTest(config.MockScenarioConfig{})
+	defer orchestrator.cancel()
+
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to create the pool: %v", errs)
+	}
+	for _, slot := range orchestrator.vmPool {
+		slot.RegisteredAt = time.Now()
+	}
+	orchestrator.vmPool[1].RunnerBusy = true
+	previousProfile := orchestrator.vmPool[1].HardwareProfile
+
+	// Only the idle VM is rebuilt with the new hardware profile
+	cfg := orchestrator.config
+	cfg.HyperV.VMMemoryMB = 8192
+	orchestrator.Reload(cfg)
+	orchestrator.applyPendingConfig()
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to replace VMs: %v", errs)
+	}
+	if calls := vmManager.Calls("CreateVM"); calls != 3 {
+		t.Errorf("Expected one VM to be replaced, got %d creations", calls)
+	}
+	if orchestrator.vmPool[0].HardwareProfile != orchestrator.hardwareProfile() {
+		t.Error("Expected the idle VM to be rebuilt with the new hardware profile")
+	}
+	if orchestrator.vmPool[1].HardwareProfile != previousProfile {
+		t.Error("Expected the busy VM to keep running until its job ends")
+	}
+}
+
+func TestReconcile_ReplacesIdleVMsWhenTemplatePathChanges(t *testing.T) {
+	orchestrator, vmManager := setupReconcileTest(config.MockScenarioConfig{})
+	defer orchestrator.cancel()
+	// A single template_path is the "default" version
+	orchestrator.config.HyperV.TemplatePath = `C:\templates\runner.vhdx`
+	orchestrator.config.HyperV.TemplateVersion = config.DefaultTemplateVersion
+	orchestrator.config.HyperV.Templates = []config.TemplateVersionConfig{{Name: config.DefaultTemplateVersion, Path: orchestrator.config.HyperV.TemplatePath}}
+	orchestrator.rollout = newTemplateRollout(orchestrator.config.HyperV, nil, testLogger())
+
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to create the pool: %v", errs)
+	}
+	for _, slot := range orchestrator.vmPool {
+		slot.RegisteredAt = time.Now()
+	}
+	orchestrator.vmPool[1].RunnerBusy = true
+
+	cfg := orchestrator.config
+	cfg.HyperV.TemplatePath = `C:\templates\runner-v2.vhdx`
+	cfg.HyperV.Templates = []config.TemplateVersionConfig{{Name: config.DefaultTemplateVersion, Path: cfg.HyperV.TemplatePath}}
+	orchestrator.Reload(cfg)
+	if !orchestrator.applyPendingConfig() {
+		t.Fatal("Expected the reloaded configuration to be applied")
+	}
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to replace VMs: %v", errs)
+	}
+
+	// The version name is unchanged, yet the idle VM is rebuilt from the new template
+	if calls := vmManager.Calls("CreateVM"); calls != 3 {
+		t.Errorf("Expected one VM to be replaced, got %d creations", calls)
+	}
+	if path := orchestrator.vmPool[0].TemplatePath; path != cfg.HyperV.TemplatePath {
+		t.Errorf("Expected the idle VM to be rebuilt from %s, got %s", cfg.HyperV.TemplatePath, path)
+	}
+	if orchestrator.vmPool[1].TemplatePath == cfg.HyperV.TemplatePath {
+		t.Error("Expected the busy VM to keep running until its job ends")
+	}
+}
+
+func TestRestartAllVMs_ReplacesEverySlot(t *testing.T) {
+	orchestrator, vmManager := setupReconcileTest(config.MockScenarioConfig{})
+	defer orchestrator.cancel()
+	orchestrator.config.Runners.PoolSize = 3
+	orchestrator.vmPool = append(orchestrator.vmPool, &vmmanager.VMSlot{Index: 3, Name: "runner-3", State: vmmanager.StateEmpty})
+
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to create the pool: %v", errs)
+	}
+
+	// runner-1 is idle, runner-2 runs a job and runner-3 has not registered yet
+	orchestrator.vmPool[0].RegisteredAt = time.Now()
+	orchestrator.vmPool[1].RegisteredAt = time.Now()
+	orchestrator.vmPool[1].RunnerBusy = true
+	orchestrator.vmPool[1].State = vmmanager.StateRunning
+	previous := make(map[string]time.Time)
+	for _, slot := range orchestrator.vmPool {
+		previous[slot.Name] = slot.CreatedAt
+	}
+
+	// The restart only flags the pool; nothing is replaced until the next reconcile pass
+	orchestrator.RestartAllVMs()
+	if calls := vmManager.Calls("CreateVM"); calls != 3 {
+		t.Fatalf("Expected no VM to be replaced before the reconcile pass, got %d creations", calls)
+	}
+
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to restart VMs: %v", errs)
+	}
+	if calls := vmManager.Calls("CreateVM"); calls != 6 {
+		t.Errorf("Expected every VM to be replaced once, got %d creations", calls)
+	}
+	for _, slot := range orchestrator.vmPool {
+		if !slot.CreatedAt.After(previous[slot.Name]) {
+			t.Errorf("Expected %s to be replaced", slot.Name)
+		}
+		if slot.RunnerBusy {
+			t.Errorf("Expected %s to come back idle", slot.Name)
+		}
+	}
+}
+
+func TestReconcile_ConcurrencyLimitAndBackOff(t *testing.T) {
+	orchestrator, vmManager := setupReconcileTest(config.MockScenarioConfig{
+		CreateVM: config.MockCallConfig{FailCalls: []int{1}},
+	})
+	orchestrator.config.Runners.MaxConcurrentOperations = 1
+	defer orchestrator.cancel()
+
+	if started := orchestrator.reconcile(); len(started) != 1 {
+		t.Fatalf("Expected 1 action within the limit, got %d", len(started))
+	} else if err := <-started[0]; err == nil {
+		t.Fatal("Expected the scripted CreateVM failure")
+	}
+
+	// The failed slot backs off while the other slot is created
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to create VM: %v", errs)
+	}
+	if orchestrator.vmPool[0].State != vmmanager.StateEmpty || orchestrator.vmPool[1].State != vmmanager.StateReady {
+		t.Fatalf("Expected runner-1 to back off and runner-2 to be ready, got %s and %s",
+			orchestrator.vmPool[0].State, orchestrator.vmPool[1].State)
+	}
+
+	orchestrator.retryAfter["runner-1"] = time.Now()
+	if errs := orchestrator.settle(); len(errs) != 0 {
+		t.Fatalf("Failed to retry VM: %v", errs)
+	}
+	if orchestrator.vmPool[0].State != vmmanager.StateReady || vmManager.Calls("CreateVM") != 3 {
+		t.Errorf("Expected runner-1 to be created on retry, got %s after %d creations",
+			orchestrator.vmPool[0].State, vmManager.Calls("CreateVM"))
+	}
+}
+
+// failingCreateVMManager is a mock whose VMs are created but then fail with a given error
+type failingCreateVMManager struct {
+	*vmmanager.MockVMManager
+	err         error
+	quarantined []string
+}
+
+func (m *failingCreateVMManager) CreateVM(slot *vmmanager.VMSlot) error {
+	if err := m.MockVMManager.CreateVM(slot); err != nil {
+		return err
+	}
+	return m.err
+}
+
+func (m *failingCreateVMManager) QuarantineVM(slot *vmmanager.VMSlot, reason string) (string, error) {
+	m.quarantined = append(m.quarantined, slot.Name)
+	return m.MockVMManager.QuarantineVM(slot, reason)
+}
+
+func TestFailurePolicyFor(t *testing.T) {
+	tests := []struct {
+		err  error
+		want failurePolicy
+	}{
+		{errors.New("exit status 1"), policyRetry},
+		{fmt.Errorf("failed to create VM: %w", vmmanager.ErrSwitchNotFound), policyFatal},
+		{fmt.Errorf("failed to list runners: %w", github.ErrUnauthorized), policyFatal},
+		{fmt.Errorf("failed to configure runner in VM: %w", vmmanager.ErrGuestUnreachable), policyQuarantine},
+		{fmt.Errorf("failed to get runner token: %w", github.ErrRateLimited), policyBackOff},
+		{vmmanager.ErrNoHostCapacity, policyBackOff},
+		{&github.APIError{Op: "list repo runners", StatusCode: 403, Err: github.ErrForbidden}, policyBackOff},
+	}
+	for _, tt := range tests {
+		if got := failurePolicyFor(tt.err); got != tt.want {
+			t.Errorf("Expected %s for %v, got %s", tt.want, tt.err, got)
+		}
+	}
+}
+
+func TestHandleFailure_ForbiddenStopsOnlyWhenLasting(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	defer orchestrator.cancel()
+	forbidden := &github.APIError{Op: "list repo runners", StatusCode: 403, Err: github.ErrForbidden}
+
+	for range fatalErrorRepeats + 1 {
+		orchestrator.handleFailure(forbidden)
+	}
+	if orchestrator.ctx.Err() != nil {
+		t.Fatal("Expected repeated 403s within the timeout not to stop the pool")
+	}
+
+	// A success in between starts over
+	orchestrator.mu.Lock()
+	orchestrator.forbiddenSince = time.Now().Add(-forbiddenTimeout - time.Minute)
+	orchestrator.clearEscalations()
+	orchestrator.mu.Unlock()
+	orchestrator.handleFailure(forbidden)
+	if orchestrator.ctx.Err() != nil {
+		t.Fatal("Expected a success to reset the 403 timeout")
+	}
+
+	orchestrator.mu.Lock()
+	orchestrator.forbiddenSince = time.Now().Add(-forbiddenTimeout - time.Minute)
+	orchestrator.mu.Unlock()
+	orchestrator.handleFailure(forbidden)
+	select {
+	case err := <-orchestrator.Fatal():
+		if !errors.Is(err, github.ErrForbidden) {
+			t.Errorf("Expected the lasting 403s to stop the pool, got %v", err)
+		}
+	default:
+		t.Error("Expected 403s lasting past the timeout to stop the pool")
+	}
+}
+
+func TestRunAction_FailurePolicies(t *testing.T) {
+	orchestrator, mock := setupReconcileTest(config.MockScenarioConfig{})
+	defer orchestrator.cancel()
+	orchestrator.config.Runners.PoolSize = 1
+	orchestrator.vmPool = orchestrator.vmPool[:1]
+	orchestrator.config.Monitoring.HealthCheckIntervalSeconds = 10
+	orchestrator.config.HyperV.Quarantine.Enabled = true
+	vmManager := &failingCreateVMManager{MockVMManager: mock}
+	orchestrator.vmManager = vmManager
+
+	// Back-off doubles with every consecutive failure
+	vmManager.err = fmt.Errorf("failed to create VM: %w", vmmanager.ErrHostUnavailable)
+	for _, want := range []time.Duration{10 * time.Second, 20 * time.Second} {
+		orchestrator.retryAfter["runner-1"] = time.Now()
+		if errs := orchestrator.settle(); len(errs) != 1 {
+			t.Fatalf("Expected one failure, got %v", errs)
+		}
+		if delay := time.Until(orchestrator.retryAfter["runner-1"]); delay <= want-time.Second || delay > want {
+			t.Errorf("Expected a back-off of %s, got %s", want, delay)
+		}
+	}
+	if len(vmManager.quarantined) != 0 {
+		t.Errorf("Expected an unavailable host not to quarantine VMs, got %v", vmManager.quarantined)
+	}
+
+	// A guest that cannot be reached is kept for inspection
+	vmManager.err = fmt.Errorf("failed to configure runner in VM: %w", vmmanager.ErrGuestUnreachable)
+	orchestrator.retryAfter["runner-1"] = time.Now()
+	orchestrator.settle()
+	if !slices.Equal(vmManager.quarantined, []string{"runner-1"}) {
+		t.Errorf("Expected runner-1 to be quarantined, got %v", vmManager.quarantined)
+	}
+
+	// Misconfiguration stops the pool once it keeps repeating; until then it is backed off
+	vmManager.err = fmt.Errorf("failed to create VM: %w", vmmanager.ErrSwitchNotFound)
+	for range fatalErrorRepeats - 1 {
+		orchestrator.retryAfter["runner-1"] = time.Now()
+		orchestrator.settle()
+	}
+	if orchestrator.ctx.Err() != nil {
+		t.Fatal("Expected a single misconfiguration error not to stop the pool")
+	}
+	orchestrator.retryAfter["runner-1"] = time.Now()
+	orchestrator.settle()
+	select {
+	case err := <-orchestrator.Fatal():
+		if !errors.Is(err, vmmanager.ErrSwitchNotFound) {
+			t.Errorf("Expected the missing switch to stop the pool, got %v", err)
+		}
+	default:
+		t.Fatal("Expected the pool to be stopped")
+	}
+	orchestrator.retryAfter["runner-1"] = time.Now()
+	if started := orchestrator.reconcile(); len(started) != 0 {
+		t.Errorf("Expected a stopped pool to start no actions, got %d", len(started))
+	}
+}
+
+// blockingCreateVMManager holds every VM creation until release is closed
+type blockingCreateVMManager struct {
+	*vmmanager.MockVMManager
+	release  chan struct{}
+	mu       sync.Mutex
+	creating int
+	cleanups []int // VM creations still running at each cleanup
+}
+
+func (m *blockingCreateVMManager) CreateVM(slot *vmmanager.VMSlot) error {
+	m.mu.Lock()
+	m.creating++
+	m.mu.Unlock()
+	<-m.release
+	m.mu.Lock()
+	m.creating--
+	m.mu.Unlock()
+	return m.MockVMManager.CreateVM(slot)
+}
+
+func (m *blockingCreateVMManager) CleanupLeftoverResources(namePrefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleanups = append(m.cleanups, m.creating)
+	return nil
+}
+
+func TestShutdown_WaitsForRunningActions(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	vmManager := &blockingCreateVMManager{MockVMManager: vmmanager.NewMockVMManager(testLogger()), release: make(chan struct{})}
+	orchestrator.vmManager = vmManager
+
+	started := orchestrator.reconcile()
+	if len(started) != 2 {
+		t.Fatalf("Expected both empty slots to be created, got %d actions", len(started))
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- orchestrator.Shutdown() }()
+
+	select {
+	case <-shutdown:
+		t.Fatal("Expected shutdown to wait for the running creations")
+	case <-time.After(300 * time.Millisecond):
+	}
+	if started := orchestrator.reconcile(); len(started) != 0 {
+		t.Errorf("Expected no action to start once shutdown has begun, got %d", len(started))
+	}
+
+	close(vmManager.release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if len(vmManager.cleanups) != 1 || vmManager.cleanups[0] != 0 {
+		t.Errorf("Expected one cleanup after every creation finished, got creations running at cleanups %v", vmManager.cleanups)
+	}
+}
+
+func TestShutdown_StopsWaitingAfterTimeout(t *testing.T) {
+	orchestrator := setupTestOrchestrator()
+	vmManager := &blockingCreateVMManager{MockVMManager: vmmanager.NewMockVMManager(testLogger()), release: make(chan struct{})}
+	orchestrator.vmManager = vmManager
+	defer close(vmManager.release)
+
+	defaultTimeout := shutdownActionTimeout
+	shutdownActionTimeout = 200 * time.Millisecond
+	defer func() { shutdownActionTimeout = defaultTimeout }()
+
+	orchestrator.reconcile()
+	if err := orchestrator.Shutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if len(vmManager.cleanups) != 1 || vmManager.cleanups[0] != 2 {
+		t.Errorf("Expected cleanup to go ahead with both creations hung, got creations running at cleanups %v", vmManager.cleanups)
+	}
+}
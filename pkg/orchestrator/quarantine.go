@@ -0,0 +1,30 @@
+package orchestrator
+
+import (
+	"time"
+
+	"hyperv-runner-pool/pkg/vmmanager"
+)
+
+// quarantinePruneInterval is how often expired quarantined VMs are removed
+const quarantinePruneInterval = time.Hour
+
+// quarantineVM moves a failed VM out of the pool for inspection
+// Returns false when the VM was not quarantined and still needs to be destroyed
+func (o *Orchestrator) quarantineVM(slot *vmmanager.VMSlot, reason string) bool {
+	quarantinedName, err := o.vmManager.QuarantineVM(slot, reason)
+	if err != nil {
+		o.logger.Error("Failed to quarantine VM, destroying it instead", "vm_name", slot.Name, "error", err)
+		return false
+	}
+	if quarantinedName == "" {
+		return false
+	}
+
+	o.logger.Warn("Quarantined failed VM for inspection",
+		"vm_name", slot.Name,
+		"quarantined_name", quarantinedName,
+		"reason", reason,
+		"template_version", slot.TemplateVersion)
+	return true
+}
@@ -0,0 +1,399 @@
+package orchestrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"hyperv-runner-pool/pkg/vmmanager"
+)
+
+// actionKind is a change the reconcile loop makes to a slot
+type actionKind string
+
+const (
+	actionCreate  actionKind = "create"  // Build a VM for an empty slot
+	actionRepair  actionKind = "repair"  // Replace a VM that finished its job or failed its health checks
+	actionReplace actionKind = "replace" // Replace a healthy VM that no longer matches the desired state
+	actionRetire  actionKind = "retire"  // Remove a slot beyond the pool size, with its VM and runner
+)
+
+// actionPriority orders the actions of a pass: capacity is restored before healthy VMs are touched
+var actionPriority = []actionKind{actionRepair, actionCreate, actionRetire, actionReplace}
+
+// poolAction is one action planned by a reconcile pass
+type poolAction struct {
+	kind       actionKind
+	slot       *vmmanager.VMSlot
+	reason     string
+	quarantine bool // Repairs only: the VM failed and is quarantined first, when quarantine is enabled
+}
+
+// hardwareProfile returns a fingerprint of the hardware settings new VMs are built with
+func (o *Orchestrator) hardwareProfile() string {
+	profile, _ := json.Marshal(struct {
+		MemoryMB int
+		CPUCount int
+		Hardware any
+	}{o.config.HyperV.VMMemoryMB, o.config.HyperV.VMCPUCount, o.config.HyperV.Hardware})
+	sum := sha256.Sum256(profile)
+	return hex.EncodeToString(sum[:6])
+}
+
+// reconcileLoop runs reconcile passes on every health check interval and whenever an action
+// finishes, and samples, garbage collects and prunes on their own intervals
+// It is the only place the pool changes after startup
+func (o *Orchestrator) reconcileLoop() {
+	ticker := time.NewTicker(time.Duration(o.config.Monitoring.HealthCheckIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(quarantinePruneInterval)
+	defer pruneTicker.Stop()
+
+	// The metering and garbage collection intervals can change with a config reload
+	var meteringTicker, gcTicker *time.Ticker
+	stopTickers := func() {
+		for _, t := range []*time.Ticker{meteringTicker, gcTicker} {
+			if t != nil {
+				t.Stop()
+			}
+		}
+		meteringTicker, gcTicker = nil, nil
+	}
+	resetTickers := func() {
+		stopTickers()
+		ticker.Reset(time.Duration(o.config.Monitoring.HealthCheckIntervalSeconds) * time.Second)
+		if o.config.HyperV.Metering.Enabled {
+			meteringTicker = time.NewTicker(time.Duration(o.config.HyperV.Metering.IntervalSeconds) * time.Second)
+		}
+		if o.config.Monitoring.GCIntervalMinutes > 0 {
+			gcTicker = time.NewTicker(time.Duration(o.config.Monitoring.GCIntervalMinutes) * time.Minute)
+		}
+	}
+	resetTickers()
+	defer stopTickers()
+	tick := func(t *time.Ticker) <-chan time.Time {
+		if t == nil {
+			return nil
+		}
+		return t.C
+	}
+
+	o.logger.Debug("Started reconcile loop")
+
+	for {
+		select {
+		case <-o.ctx.Done():
+			o.logger.Debug("Stopping reconcile loop due to shutdown")
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		case <-tick(meteringTicker):
+			o.meterSlots()
+			continue
+		case <-tick(gcTicker):
+			o.collectGarbage()
+			continue
+		case <-pruneTicker.C:
+			if o.config.HyperV.Quarantine.Enabled {
+				if err := o.vmManager.PruneQuarantine(); err != nil {
+					o.logger.Warn("Failed to prune quarantined VMs", "error", err)
+				}
+			}
+			continue
+		}
+
+		if o.applyPendingConfig() {
+			resetTickers()
+		}
+		o.reconcile()
+	}
+}
+
+// waitForActions waits until no action is running, or the timeout has passed
+// Returns the number of actions still running
+func (o *Orchestrator) waitForActions(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		o.mu.Lock()
+		running := len(o.inFlight)
+		o.mu.Unlock()
+		if running == 0 || time.Now().After(deadline) {
+			return running
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// wakeUp requests a reconcile pass without waiting for the next tick
+func (o *Orchestrator) wakeUp() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// settle runs reconcile passes until one starts no action and returns the errors of all actions
+// Failed slots are retried after a back-off, so settling ends once every slot is ready or backing off
+func (o *Orchestrator) settle() []error {
+	var errs []error
+	for {
+		started := o.reconcile()
+		if len(started) == 0 {
+			return errs
+		}
+		for _, done := range started {
+			if err := <-done; err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+}
+
+// reconcile compares the desired pool (pool size, template versions, hardware profile) with the
+// observed slots, VMs and runners, and starts the actions that close the gap
+// Slots with an action in progress are left alone; returns the completion channels of the started actions
+func (o *Orchestrator) reconcile() []<-chan error {
+	// A stopped pool starts no further actions
+	if o.ctx.Err() != nil {
+		return nil
+	}
+
+	o.mu.Lock()
+	o.ensureSlots()
+	var slots []*vmmanager.VMSlot
+	for _, slot := range o.vmPool {
+		if !o.inFlight[slot.Name] {
+			slots = append(slots, slot)
+		}
+	}
+	restartBefore := o.restartBefore
+	retryAfter := make(map[string]time.Time, len(o.retryAfter))
+	for name, at := range o.retryAfter {
+		retryAfter[name] = at
+	}
+	o.mu.Unlock()
+
+	// Observe every VM in parallel; health checks only touch their own slot
+	verdicts := make([]healthVerdict, len(slots))
+	var wg sync.WaitGroup
+	for i, slot := range slots {
+		if slot.State == vmmanager.StateEmpty {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verdicts[i] = o.checkVMHealth(slot)
+		}()
+	}
+	wg.Wait()
+
+	var actions []poolAction
+	for i, slot := range slots {
+		if action, ok := o.plan(slot, verdicts[i], restartBefore, retryAfter[slot.Name]); ok {
+			actions = append(actions, action)
+		}
+	}
+	return o.start(actions)
+}
+
+// plan decides the action for one slot that has no action in progress
+func (o *Orchestrator) plan(slot *vmmanager.VMSlot, verdict healthVerdict, restartBefore, retryAfter time.Time) (poolAction, bool) {
+	surplus := slot.Index > o.config.Runners.PoolSize
+
+	switch {
+	case slot.State == vmmanager.StateEmpty && surplus:
+		return poolAction{kind: actionRetire, slot: slot, reason: "Pool size reduced"}, true
+
+	case slot.State == vmmanager.StateEmpty:
+		if time.Now().Before(retryAfter) {
+			return poolAction{}, false
+		}
+		return poolAction{kind: actionCreate, slot: slot}, true
+
+	case verdict.recreate:
+		reason := verdict.reason
+		o.logger.Warn("VM health check failed, recreating",
+			"vm_name", slot.Name,
+			"ip_address", slot.IPAddress,
+			"reason", reason,
+			"state", slot.State,
+			"uptime", time.Since(slot.CreatedAt).Round(time.Second),
+			"consecutive_failures", slot.HealthCheckFailures+1,
+			"template_version", slot.TemplateVersion)
+
+		// A VM that never registered counts against its template version, unless it was tampered with
+		if slot.RegisteredAt.IsZero() && !externalChange(reason) {
+			o.rollout.recordFailure(slot.TemplateVersion)
+		}
+		if surplus {
+			return poolAction{kind: actionRetire, slot: slot, reason: reason}, true
+		}
+		return poolAction{kind: actionRepair, slot: slot, reason: reason, quarantine: verdict.quarantine}, true
+
+	case surplus:
+		// Let a running job finish; the VM is retired once it shuts down
+		if slot.RunnerBusy {
+			return poolAction{}, false
+		}
+		return poolAction{kind: actionRetire, slot: slot, reason: "Pool size reduced"}, true
+
+	case slot.CreatedAt.Before(restartBefore):
+		return poolAction{kind: actionReplace, slot: slot, reason: "Restart requested"}, true
+	}
+
+	// Outdated VMs are only replaced while idle, so no job is cut short
+	if slot.RegisteredAt.IsZero() || slot.RunnerBusy {
+		return poolAction{}, false
+	}
+	if stable, candidate := o.rollout.versions(); slot.TemplateVersion != stable && slot.TemplateVersion != candidate {
+		return poolAction{kind: actionReplace, slot: slot, reason: fmt.Sprintf("Template version %q is no longer active", slot.TemplateVersion)}, true
+	}
+	// Editing template_path, or the path of a version, keeps the version name but changes the template
+	if path, err := o.config.HyperV.TemplatePathFor(slot.TemplateVersion); err == nil && slot.TemplatePath != "" && path != slot.TemplatePath {
+		return poolAction{kind: actionReplace, slot: slot, reason: fmt.Sprintf("Template version %q now points to another VHDX", slot.TemplateVersion)}, true
+	}
+	if slot.HardwareProfile != o.hardwareProfile() {
+		return poolAction{kind: actionReplace, slot: slot, reason: "Hardware profile changed"}, true
+	}
+	return poolAction{}, false
+}
+
+// start runs the planned actions in the background, highest priority first, without exceeding
+// runners.max_concurrent_operations; the remaining actions are planned again by a later pass
+func (o *Orchestrator) start(actions []poolAction) []<-chan error {
+	slices.SortStableFunc(actions, func(a, b poolAction) int {
+		return slices.Index(actionPriority, a.kind) - slices.Index(actionPriority, b.kind)
+	})
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	limit := o.config.Runners.MaxConcurrentOperations
+	var started []<-chan error
+	for _, action := range actions {
+		// Shutdown waits for running actions, so none may start once it has begun
+		if o.ctx.Err() != nil {
+			break
+		}
+		if limit > 0 && len(o.inFlight) >= limit {
+			o.logger.Debug("Concurrent operation limit reached, deferring actions",
+				"limit", limit,
+				"deferred", len(actions)-len(started))
+			break
+		}
+		o.inFlight[action.slot.Name] = true
+		done := make(chan error, 1)
+		started = append(started, done)
+		go o.runAction(action, done)
+	}
+	return started
+}
+
+// runAction performs one action and releases its slot
+func (o *Orchestrator) runAction(action poolAction, done chan<- error) {
+	slot := action.slot
+	o.logger.Debug("Starting pool action", "action", action.kind, "vm_name", slot.Name, "reason", action.reason)
+
+	var err error
+	switch action.kind {
+	case actionCreate:
+		err = o.createAndRegisterVM(slot)
+	case actionRepair:
+		// Cancel an overrunning job first, so GitHub reports it cancelled rather than losing its runner
+		if action.reason == reasonJobTimeout {
+			o.cancelJob(slot)
+		}
+		// A VM that shut down finished its job and one changed by hand did not fail
+		if action.reason != reasonPoweredOff && !externalChange(action.reason) {
+			// Save guest diagnostics before the VM is destroyed
+			o.collectDiagnostics(slot, action.reason)
+		}
+		failureReason := ""
+		if action.quarantine {
+			failureReason = action.reason
+		}
+		err = o.recreateVM(slot.Name, failureReason)
+	case actionReplace:
+		o.logger.Info("Replacing VM", "vm_name", slot.Name, "reason", action.reason)
+		err = o.recreateVM(slot.Name, "")
+	case actionRetire:
+		o.retireSlot(slot, action.reason)
+	}
+
+	var policy failurePolicy
+	if err != nil {
+		// Errors classified as fatal are retried with back-off until they persist and stop the pool
+		policy = o.handleFailure(err)
+		o.logger.Error("Pool action failed",
+			"action", action.kind,
+			"vm_name", slot.Name,
+			"policy", policy,
+			"error", err)
+		o.resetFailedSlot(slot)
+	}
+
+	o.mu.Lock()
+	delete(o.inFlight, slot.Name)
+	if err != nil {
+		o.failures[slot.Name]++
+		o.retryAfter[slot.Name] = time.Now().Add(o.retryDelay(err, policy, o.failures[slot.Name]))
+	} else {
+		delete(o.retryAfter, slot.Name)
+		delete(o.failures, slot.Name)
+		o.clearEscalations()
+	}
+	o.mu.Unlock()
+
+	done <- err
+	o.wakeUp()
+}
+
+// resetFailedSlot removes what a failed creation left behind and marks the slot empty,
+// so the next pass builds it from scratch
+func (o *Orchestrator) resetFailedSlot(slot *vmmanager.VMSlot) {
+	if err := o.vmManager.DestroyVM(slot); err != nil {
+		o.logger.Debug("No VM to clean up after failed creation", "vm_name", slot.Name, "error", err)
+	}
+	slot.State = vmmanager.StateEmpty
+}
+
+// retireSlot removes a slot beyond the pool size together with its VM and runner
+func (o *Orchestrator) retireSlot(slot *vmmanager.VMSlot, reason string) {
+	o.logger.Info("Retiring VM slot", "vm_name", slot.Name, "reason", reason)
+
+	if slot.State != vmmanager.StateEmpty {
+		slot.State = vmmanager.StateDestroying
+		o.recordLifecycleUsage(slot)
+		if err := o.vmManager.DestroyVM(slot); err != nil {
+			o.logger.Warn("Error destroying retired VM", "vm_name", slot.Name, "error", err)
+		}
+		o.deregisterRunner(slot)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.vmPool = slices.DeleteFunc(o.vmPool, func(s *vmmanager.VMSlot) bool { return s == slot })
+	delete(o.retryAfter, slot.Name)
+	delete(o.failures, slot.Name)
+}
+
+// ensureSlots adds an empty slot for every missing index up to the pool size; callers must hold o.mu
+func (o *Orchestrator) ensureSlots() {
+	pool := slices.DeleteFunc(o.vmPool, func(slot *vmmanager.VMSlot) bool { return slot == nil })
+	for index := 1; index <= o.config.Runners.PoolSize; index++ {
+		if !slices.ContainsFunc(pool, func(slot *vmmanager.VMSlot) bool { return slot.Index == index }) {
+			pool = append(pool, &vmmanager.VMSlot{
+				Index: index,
+				Name:  fmt.Sprintf("%s%d", o.namePrefix(), index),
+				State: vmmanager.StateEmpty,
+			})
+		}
+	}
+	slices.SortFunc(pool, func(a, b *vmmanager.VMSlot) int { return a.Index - b.Index })
+	o.vmPool = pool
+}
@@ -0,0 +1,88 @@
+package orchestrator
+
+import (
+	"reflect"
+
+	"hyperv-runner-pool/pkg/config"
+)
+
+// keepRestartSettings copies the settings that only take effect after a restart from src to dst
+// Everything else (pool size, hardware, templates, rollout, monitoring, ...) is applied at runtime
+func keepRestartSettings(dst *config.Config, src config.Config) {
+	dst.GitHub = src.GitHub
+	dst.Runners.NamePrefix = src.Runners.NamePrefix
+	dst.Runners.InstanceID = src.Runners.InstanceID
+	dst.HyperV.Hosts = src.HyperV.Hosts
+	dst.HyperV.VMStoragePath = src.HyperV.VMStoragePath
+	dst.HyperV.StoragePaths = src.HyperV.StoragePaths
+	dst.HyperV.GuestOS = src.HyperV.GuestOS
+	dst.HyperV.ProvisioningMode = src.HyperV.ProvisioningMode
+	dst.HyperV.ConfigInjection = src.HyperV.ConfigInjection
+	dst.Logging = src.Logging
+	dst.Debug = src.Debug
+}
+
+// Reload schedules a reloaded configuration; the reconcile loop applies it once no action is in
+// progress and then converges the pool on it like on any other change
+func (o *Orchestrator) Reload(cfg config.Config) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	merged := cfg
+	keepRestartSettings(&merged, o.config)
+	if !reflect.DeepEqual(merged, cfg) {
+		o.logger.Warn("Reloaded configuration changes settings that need a restart; they are ignored until then")
+	}
+	if reflect.DeepEqual(merged, o.config) {
+		o.logger.Debug("Reloaded configuration is unchanged")
+		return
+	}
+	o.pendingConfig = &merged
+	o.wakeUp()
+}
+
+// applyPendingConfig applies a reloaded configuration, unless an action is still in progress
+// Nothing else uses the configuration while no action runs, so it is swapped without further locking
+// Returns whether the configuration changed
+func (o *Orchestrator) applyPendingConfig() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.pendingConfig == nil {
+		return false
+	}
+	if len(o.inFlight) > 0 {
+		o.logger.Debug("Deferring config reload until running actions finish", "running", len(o.inFlight))
+		return false
+	}
+
+	previous := o.config
+	o.config = *o.pendingConfig
+	o.pendingConfig = nil
+	o.vmManager.UpdateConfig(o.config)
+
+	// New template versions get the same pre-flight checks as at startup
+	if !reflect.DeepEqual(previous.HyperV.Templates, o.config.HyperV.Templates) ||
+		previous.HyperV.TemplateVersion != o.config.HyperV.TemplateVersion ||
+		previous.HyperV.TemplatePath != o.config.HyperV.TemplatePath ||
+		previous.HyperV.Rollout != o.config.HyperV.Rollout ||
+		previous.HyperV.Disks.ToolCache != o.config.HyperV.Disks.ToolCache {
+		rollout := o.rollout
+		o.rollout = newTemplateRollout(o.config.HyperV, o.validateTemplateVersion, o.logger)
+		if err := o.validateTemplates(); err != nil {
+			o.logger.Error("Reloaded configuration rejected, keeping the current one", "error", err)
+			o.config = previous
+			o.rollout = rollout
+			o.vmManager.UpdateConfig(o.config)
+			return false
+		}
+	}
+
+	stable, candidate := o.rollout.versions()
+	o.logger.Info("Applied reloaded configuration",
+		"pool_size", o.config.Runners.PoolSize,
+		"template_version", stable,
+		"candidate_version", candidate,
+		"hardware_profile", o.hardwareProfile())
+	return true
+}
@@ -0,0 +1,256 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+
+	"hyperv-runner-pool/pkg/config"
+)
+
+// templateRollout decides which template version each new VM is built from
+// While a candidate version is being rolled out, a share of creations go to it;
+// the candidate is promoted once enough canary VMs pass health checks and rolled
+// back as soon as too many fail
+type templateRollout struct {
+	mu            sync.Mutex
+	base          string // Active version in the configuration, which the rollout started from
+	stable        string
+	candidate     string
+	canaryPercent int
+	promoteAfter  int
+	rollbackAfter int
+	credit        int // Accumulated canary share; a canary is created each time it reaches 100
+	healthy       int
+	failed        int
+	promoting     bool                       // Candidate is being validated before promotion
+	validate      func(version string) error // Template pre-flight check run before promotion
+	statePath     string                     // Where the rollout is persisted (empty: not persisted)
+	logger        *slog.Logger
+}
+
+// Outcomes of a persisted rollout
+const (
+	rolloutInProgress = "in_progress"
+	rolloutPromoted   = "promoted"
+	rolloutRolledBack = "rolled_back"
+)
+
+// rolloutState is the persisted progress of the rollout of candidate over stable
+type rolloutState struct {
+	Stable    string `json:"stable"`
+	Candidate string `json:"candidate"`
+	Outcome   string `json:"outcome"`
+	Credit    int    `json:"credit"`
+	Healthy   int    `json:"healthy"`
+	Failed    int    `json:"failed"`
+}
+
+// newTemplateRollout creates the rollout state from the Hyper-V configuration
+// A rollout of the same candidate over the same stable version that was persisted before a
+// restart resumes where it stopped; a promoted candidate stays promoted and a rolled back one
+// is not rolled out again
+func newTemplateRollout(cfg config.HyperVConfig, validate func(version string) error, logger *slog.Logger) *templateRollout {
+	r := &templateRollout{
+		validate:      validate,
+		base:          cfg.TemplateVersion,
+		stable:        cfg.TemplateVersion,
+		candidate:     cfg.Rollout.Candidate,
+		canaryPercent: cfg.Rollout.CanaryPercent,
+		promoteAfter:  cfg.Rollout.PromoteAfter,
+		rollbackAfter: cfg.Rollout.RollbackAfter,
+		statePath:     cfg.Rollout.StatePath,
+		logger:        logger,
+	}
+	if r.candidate != "" && r.statePath != "" {
+		r.restore()
+	}
+	return r
+}
+
+// restore resumes a persisted rollout of the configured candidate
+func (r *templateRollout) restore() {
+	data, err := os.ReadFile(r.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		r.save(rolloutInProgress)
+		return
+	}
+	var state rolloutState
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil {
+		r.logger.Warn("Failed to read rollout state, starting the rollout over", "state_path", r.statePath, "error", err)
+		r.save(rolloutInProgress)
+		return
+	}
+	if state.Stable != r.base || state.Candidate != r.candidate {
+		r.logger.Info("Starting a new template rollout",
+			"template_version", r.candidate,
+			"stable_version", r.base,
+			"previous_candidate", state.Candidate)
+		r.save(rolloutInProgress)
+		return
+	}
+
+	switch state.Outcome {
+	case rolloutPromoted:
+		r.logger.Info("Candidate template was promoted before the restart", "template_version", r.candidate)
+		r.stable = r.candidate
+		r.candidate = ""
+	case rolloutRolledBack:
+		r.logger.Warn("Candidate template was rolled back before the restart and is not rolled out again; delete the rollout state file to retry it",
+			"template_version", r.candidate,
+			"state_path", r.statePath)
+		r.candidate = ""
+	default:
+		r.credit, r.healthy, r.failed = state.Credit, state.Healthy, state.Failed
+		r.logger.Info("Resuming template rollout",
+			"template_version", r.candidate,
+			"healthy", r.healthy,
+			"failed", r.failed)
+	}
+}
+
+// save persists the rollout with the given outcome; callers must hold r.mu unless the rollout is
+// not shared yet
+func (r *templateRollout) save(outcome string) {
+	if r.statePath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(rolloutState{
+		Stable:    r.base,
+		Candidate: r.candidate,
+		Outcome:   outcome,
+		Credit:    r.credit,
+		Healthy:   r.healthy,
+		Failed:    r.failed,
+	}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(r.statePath, data, 0644)
+	}
+	if err != nil {
+		r.logger.Warn("Failed to save rollout state; a restart starts the rollout over", "state_path", r.statePath, "error", err)
+	}
+}
+
+// nextVersion returns the template version for the next VM creation
+// Canaries are spread evenly rather than randomly so small pools still get a predictable share
+func (r *templateRollout) nextVersion() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.candidate == "" {
+		return r.stable
+	}
+
+	r.credit += r.canaryPercent
+	defer r.save(rolloutInProgress)
+	if r.credit >= 100 {
+		r.credit -= 100
+		return r.candidate
+	}
+	return r.stable
+}
+
+// recordHealthy counts a VM built from version that passed its health checks
+// The candidate is validated again before it is promoted
+func (r *templateRollout) recordHealthy(version string) {
+	r.mu.Lock()
+	if r.candidate == "" || version != r.candidate || r.promoting {
+		r.mu.Unlock()
+		return
+	}
+
+	r.healthy++
+	r.logger.Info("Canary VM passed health checks",
+		"template_version", version,
+		"healthy", r.healthy,
+		"promote_after", r.promoteAfter)
+
+	if r.healthy < r.promoteAfter {
+		r.save(rolloutInProgress)
+		r.mu.Unlock()
+		return
+	}
+	r.promoting = true
+	r.mu.Unlock()
+
+	// Validation can hash the whole template, so it runs without holding the lock
+	var err error
+	if r.validate != nil {
+		err = r.validate(version)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.promoting = false
+
+	if r.candidate != version {
+		// Rolled back while validating
+		return
+	}
+	if err != nil {
+		r.logger.Error("Candidate template failed validation, rolling back", "template_version", version, "error", err)
+		r.finish(rolloutRolledBack)
+		return
+	}
+
+	r.logger.Info("Promoting template version", "template_version", version, "previous_version", r.stable)
+	r.stable = r.candidate
+	r.finish(rolloutPromoted)
+}
+
+// recordFailure counts a VM built from version that failed creation or health checks
+// before ever becoming healthy
+func (r *templateRollout) recordFailure(version string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.candidate == "" || version != r.candidate {
+		return
+	}
+
+	r.failed++
+	r.logger.Warn("Canary VM failed",
+		"template_version", version,
+		"failed", r.failed,
+		"rollback_after", r.rollbackAfter)
+
+	if r.failed >= r.rollbackAfter {
+		r.logger.Error("Rolling back template version; remaining canary VMs are replaced when they are next recreated",
+			"template_version", version,
+			"stable_version", r.stable)
+		r.finish(rolloutRolledBack)
+		return
+	}
+	r.save(rolloutInProgress)
+}
+
+// abort cancels the rollout without promoting the candidate
+// The candidate may only have been unreadable for now, so it is not recorded as rolled back
+func (r *templateRollout) abort() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finish("")
+}
+
+// finish ends the current rollout and persists its outcome (empty: not persisted); callers must hold r.mu
+func (r *templateRollout) finish(outcome string) {
+	if outcome != "" {
+		r.save(outcome)
+	}
+	r.candidate = ""
+	r.credit = 0
+	r.healthy = 0
+	r.failed = 0
+}
+
+// versions returns the stable and candidate template versions
+func (r *templateRollout) versions() (stable, candidate string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stable, r.candidate
+}
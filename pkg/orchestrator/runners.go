@@ -0,0 +1,90 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"hyperv-runner-pool/pkg/github"
+	"hyperv-runner-pool/pkg/vmmanager"
+)
+
+// runnerRemovalAttempts is how many times removing a slot's runner from GitHub is tried
+const runnerRemovalAttempts = 3
+
+// runnerRemovalRetryDelay is the delay before the first retry, doubled for every further retry
+var runnerRemovalRetryDelay = 2 * time.Second
+
+// deregisterRunner removes a destroyed slot's runner from GitHub, so the stale record
+// cannot attract queued jobs
+// Failures are logged only; the prefix sweep at startup and shutdown is the fallback
+func (o *Orchestrator) deregisterRunner(slot *vmmanager.VMSlot) {
+	if err := o.removeSlotRunner(slot); err != nil {
+		o.logger.Warn("Failed to deregister runner from GitHub",
+			"vm_name", slot.Name,
+			"runner_id", slot.RunnerID,
+			"error", err)
+	}
+}
+
+// cancelJob cancels the job of a slot that exceeded the maximum job duration
+// Failures are logged only; destroying the VM ends the job either way
+func (o *Orchestrator) cancelJob(slot *vmmanager.VMSlot) {
+	jobID, err := o.githubClient.CancelRunnerJob(slot.Name)
+	if err != nil {
+		o.logger.Warn("Failed to cancel job in GitHub, recycling its VM anyway",
+			"vm_name", slot.Name,
+			"error", err)
+		return
+	}
+	o.logger.Warn("Cancelled job that exceeded the maximum job duration",
+		"vm_name", slot.Name,
+		"job_id", jobID,
+		"running_for", time.Since(slot.JobStartedAt).Round(time.Second))
+}
+
+// removeSlotRunner removes the slot's runner, retrying transient GitHub API errors
+// A runner that is already gone counts as removed
+func (o *Orchestrator) removeSlotRunner(slot *vmmanager.VMSlot) error {
+	runnerID := slot.RunnerID
+	if runnerID == 0 {
+		// The VM died before its runner was seen; it may still have registered
+		runner, err := o.githubClient.GetRunnerByName(slot.Name)
+		if err != nil {
+			return fmt.Errorf("failed to look up runner: %w", err)
+		}
+		if runner == nil || !slices.Contains(runner.Labels, o.config.Runners.OwnerLabel()) {
+			return nil
+		}
+		runnerID = runner.ID
+	}
+
+	delay := runnerRemovalRetryDelay
+	var err error
+	for attempt := 1; attempt <= runnerRemovalAttempts; attempt++ {
+		err = o.githubClient.RemoveRunner(runnerID, slot.Name)
+		if err == nil || errors.Is(err, github.ErrRunnerNotFound) {
+			o.logger.Debug("Deregistered runner from GitHub", "vm_name", slot.Name, "runner_id", runnerID)
+			slot.RunnerID = 0
+			return nil
+		}
+		if attempt == runnerRemovalAttempts {
+			break
+		}
+
+		o.logger.Debug("Retrying runner removal",
+			"vm_name", slot.Name,
+			"runner_id", runnerID,
+			"attempt", attempt,
+			"error", err)
+		select {
+		case <-o.ctx.Done():
+			return fmt.Errorf("runner removal interrupted: %w", o.ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	return fmt.Errorf("failed to remove runner %d after %d attempts: %w", runnerID, runnerRemovalAttempts, err)
+}
@@ -0,0 +1,306 @@
+// Package seediso builds small ISO 9660 images used to seed configuration into VMs
+// Images have a single flat root directory and carry Joliet extensions so Windows
+// and Linux guests both see the original, case-preserved file names
+package seediso
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	sectorSize = 2048
+
+	// Fixed sector layout: system area, volume descriptors, then four path tables
+	primaryDescriptorSector = 16
+	jolietDescriptorSector  = 17
+	terminatorSector        = 18
+	pathTableSector         = 19 // Primary L, primary M, Joliet L, Joliet M
+	firstDirectorySector    = 23
+
+	maxVolumeIDLength = 16 // Joliet volume identifiers hold 16 UCS-2 characters
+	maxNameLength     = 64 // Joliet file identifiers hold 64 UCS-2 characters
+	maxPrimaryName    = 30 // ISO 9660 level 2 file identifier limit, excluding ";1"
+)
+
+// File is a file placed in the root directory of the image
+type File struct {
+	Name string
+	Data []byte
+}
+
+// dirEntry is a directory record before it is encoded
+type dirEntry struct {
+	identifier []byte
+	extent     uint32
+	size       uint32
+	isDir      bool
+	file       int // Index of the file the record describes
+}
+
+// Build returns an ISO 9660 image with Joliet extensions containing files in its root directory
+// The volume ID becomes the image label, e.g. "cidata" for cloud-init NoCloud seeds
+func Build(volumeID string, files []File) ([]byte, error) {
+	if volumeID == "" || len(volumeID) > maxVolumeIDLength {
+		return nil, fmt.Errorf("volume ID must be 1-%d characters, got %q", maxVolumeIDLength, volumeID)
+	}
+
+	primaryNames := make(map[string]string, len(files))
+	for _, file := range files {
+		if err := validateName(file.Name); err != nil {
+			return nil, err
+		}
+		name := primaryName(file.Name)
+		if other, ok := primaryNames[name]; ok {
+			return nil, fmt.Errorf("file names %q and %q collide as %q", other, file.Name, name)
+		}
+		primaryNames[name] = file.Name
+	}
+
+	// Both directory trees point at the same file extents, each sorted by its own identifiers
+	primaryEntries := make([]dirEntry, len(files))
+	jolietEntries := make([]dirEntry, len(files))
+	for i, file := range files {
+		primaryEntries[i] = dirEntry{identifier: []byte(primaryName(file.Name) + ";1"), file: i}
+		jolietEntries[i] = dirEntry{identifier: ucs2(file.Name), file: i}
+	}
+	for _, entries := range [][]dirEntry{primaryEntries, jolietEntries} {
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].identifier, entries[j].identifier) < 0 })
+	}
+
+	primaryDirSectors := directorySectors(primaryEntries)
+	jolietDirSectors := directorySectors(jolietEntries)
+	primaryDir := uint32(firstDirectorySector)
+	jolietDir := primaryDir + primaryDirSectors
+
+	next := jolietDir + jolietDirSectors
+	extents := make([]uint32, len(files))
+	for i, file := range files {
+		extents[i] = next
+		next += sectorsFor(len(file.Data))
+	}
+	totalSectors := next
+	for _, entries := range [][]dirEntry{primaryEntries, jolietEntries} {
+		for i := range entries {
+			entries[i].extent = extents[entries[i].file]
+			entries[i].size = uint32(len(files[entries[i].file].Data))
+		}
+	}
+
+	now := time.Now().UTC()
+	image := make([]byte, int(totalSectors)*sectorSize)
+
+	primaryRoot := dirEntry{identifier: []byte{0}, extent: primaryDir, size: primaryDirSectors * sectorSize, isDir: true}
+	jolietRoot := dirEntry{identifier: []byte{0}, extent: jolietDir, size: jolietDirSectors * sectorSize, isDir: true}
+
+	writeVolumeDescriptor(sector(image, primaryDescriptorSector), 1, false, volumeID, totalSectors, primaryRoot, pathTableSector, now)
+	writeVolumeDescriptor(sector(image, jolietDescriptorSector), 2, true, volumeID, totalSectors, jolietRoot, pathTableSector+2, now)
+
+	terminator := sector(image, terminatorSector)
+	terminator[0] = 255
+	copy(terminator[1:6], "CD001")
+	terminator[6] = 1
+
+	writePathTable(sector(image, pathTableSector), primaryDir, binary.LittleEndian)
+	writePathTable(sector(image, pathTableSector+1), primaryDir, binary.BigEndian)
+	writePathTable(sector(image, pathTableSector+2), jolietDir, binary.LittleEndian)
+	writePathTable(sector(image, pathTableSector+3), jolietDir, binary.BigEndian)
+
+	writeDirectory(image[int(primaryDir)*sectorSize:int(jolietDir)*sectorSize], primaryRoot, primaryEntries, now)
+	writeDirectory(image[int(jolietDir)*sectorSize:int(jolietDir+jolietDirSectors)*sectorSize], jolietRoot, jolietEntries, now)
+	for i, file := range files {
+		copy(image[int(extents[i])*sectorSize:], file.Data)
+	}
+
+	return image, nil
+}
+
+// validateName rejects names that cannot be stored in a flat Joliet directory
+func validateName(name string) error {
+	if name == "" || len([]rune(name)) > maxNameLength {
+		return fmt.Errorf("file name must be 1-%d characters, got %q", maxNameLength, name)
+	}
+	if strings.ContainsAny(name, `/\:;*?"<>|`) {
+		return fmt.Errorf("file name %q contains characters not allowed in an ISO image", name)
+	}
+	return nil
+}
+
+// primaryName maps a name to ISO 9660 d-characters for readers without Joliet support
+func primaryName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	mapped := b.String()
+	if len(mapped) > maxPrimaryName {
+		mapped = mapped[:maxPrimaryName]
+	}
+	return mapped
+}
+
+// ucs2 encodes a string as big-endian UCS-2, as Joliet requires
+func ucs2(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.BigEndian.PutUint16(out[2*i:], u)
+	}
+	return out
+}
+
+// sector returns the bytes of sector n of the image
+func sector(image []byte, n int) []byte {
+	return image[n*sectorSize : (n+1)*sectorSize]
+}
+
+// sectorsFor returns the number of sectors needed for size bytes
+func sectorsFor(size int) uint32 {
+	return uint32((size + sectorSize - 1) / sectorSize)
+}
+
+// recordLength returns the encoded size of a directory record with the given identifier
+func recordLength(identifier []byte) int {
+	length := 33 + len(identifier)
+	if length%2 != 0 {
+		length++
+	}
+	return length
+}
+
+// directorySectors returns how many sectors a root directory holding entries needs
+// Records never span sector boundaries
+func directorySectors(entries []dirEntry) uint32 {
+	sectors := uint32(1)
+	used := 2 * recordLength([]byte{0}) // "." and ".."
+	for _, entry := range entries {
+		length := recordLength(entry.identifier)
+		if used+length > sectorSize {
+			sectors++
+			used = 0
+		}
+		used += length
+	}
+	return sectors
+}
+
+// writeDirectory encodes the root directory records into dir
+func writeDirectory(dir []byte, root dirEntry, entries []dirEntry, modTime time.Time) {
+	parent := root
+	parent.identifier = []byte{1}
+
+	offset := 0
+	for _, entry := range append([]dirEntry{root, parent}, entries...) {
+		length := recordLength(entry.identifier)
+		if offset%sectorSize+length > sectorSize {
+			offset += sectorSize - offset%sectorSize
+		}
+		writeDirectoryRecord(dir[offset:offset+length], entry, modTime)
+		offset += length
+	}
+}
+
+// writeDirectoryRecord encodes a single directory record
+func writeDirectoryRecord(record []byte, entry dirEntry, modTime time.Time) {
+	record[0] = byte(len(record))
+	putBothEndian32(record[2:], entry.extent)
+	putBothEndian32(record[10:], entry.size)
+	record[18] = byte(modTime.Year() - 1900)
+	record[19] = byte(modTime.Month())
+	record[20] = byte(modTime.Day())
+	record[21] = byte(modTime.Hour())
+	record[22] = byte(modTime.Minute())
+	record[23] = byte(modTime.Second())
+	if entry.isDir {
+		record[25] = 0x02
+	}
+	putBothEndian16(record[28:], 1)
+	record[32] = byte(len(entry.identifier))
+	copy(record[33:], entry.identifier)
+}
+
+// writeVolumeDescriptor encodes a primary (type 1) or Joliet supplementary (type 2) volume descriptor
+func writeVolumeDescriptor(vd []byte, descriptorType byte, joliet bool, volumeID string, totalSectors uint32, root dirEntry, pathTable int, created time.Time) {
+	text := func(field []byte, value string) {
+		if joliet {
+			encoded := ucs2(value)
+			for i := 0; i+1 < len(field); i += 2 {
+				field[i], field[i+1] = 0, ' '
+			}
+			copy(field, encoded[:min(len(encoded), len(field)&^1)])
+			return
+		}
+		for i := range field {
+			field[i] = ' '
+		}
+		copy(field, value)
+	}
+
+	vd[0] = descriptorType
+	copy(vd[1:6], "CD001")
+	vd[6] = 1
+
+	if !joliet {
+		volumeID = primaryName(volumeID)
+	}
+	text(vd[8:40], "")
+	text(vd[40:72], volumeID)
+	putBothEndian32(vd[80:], totalSectors)
+	if joliet {
+		copy(vd[88:91], "%/E") // UCS-2 level 3
+	}
+	putBothEndian16(vd[120:], 1)
+	putBothEndian16(vd[124:], 1)
+	putBothEndian16(vd[128:], sectorSize)
+	putBothEndian32(vd[132:], pathTableLength)
+	binary.LittleEndian.PutUint32(vd[140:], uint32(pathTable))
+	binary.BigEndian.PutUint32(vd[148:], uint32(pathTable+1))
+	writeDirectoryRecord(vd[156:190], root, created)
+	text(vd[190:318], "")
+	text(vd[318:446], "")
+	text(vd[446:574], "")
+	text(vd[574:702], "")
+	text(vd[702:739], "")
+	text(vd[739:776], "")
+	text(vd[776:813], "")
+
+	timestamp := []byte(created.Format("20060102150405") + "00")
+	copy(vd[813:830], timestamp)
+	copy(vd[830:847], timestamp)
+	copy(vd[847:864], bytes.Repeat([]byte{'0'}, 16))
+	copy(vd[864:881], bytes.Repeat([]byte{'0'}, 16))
+	vd[881] = 1
+}
+
+// pathTableLength is the size of a path table holding only the root directory
+const pathTableLength = 10
+
+// writePathTable encodes a path table holding only the root directory
+func writePathTable(table []byte, rootExtent uint32, order binary.ByteOrder) {
+	table[0] = 1 // Identifier length
+	order.PutUint32(table[2:], rootExtent)
+	order.PutUint16(table[6:], 1) // Parent directory number
+	table[8] = 0                  // Root identifier
+}
+
+// putBothEndian16 writes v as little-endian followed by big-endian
+func putBothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+// putBothEndian32 writes v as little-endian followed by big-endian
+func putBothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
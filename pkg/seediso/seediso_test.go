@@ -0,0 +1,156 @@
+package seediso
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// readRoot returns the files in the root directory described by the volume descriptor in sector n
+func readRoot(t *testing.T, image []byte, n int, joliet bool) map[string][]byte {
+	t.Helper()
+
+	vd := sector(image, n)
+	if string(vd[1:6]) != "CD001" {
+		t.Fatalf("Sector %d is not a volume descriptor", n)
+	}
+	if got := binary.LittleEndian.Uint32(vd[80:]); int(got)*sectorSize != len(image) {
+		t.Fatalf("Volume space size %d does not match image size %d", got, len(image))
+	}
+
+	rootExtent := binary.LittleEndian.Uint32(vd[156+2:])
+	rootSize := binary.LittleEndian.Uint32(vd[156+10:])
+	dir := image[int(rootExtent)*sectorSize : int(rootExtent)*sectorSize+int(rootSize)]
+
+	files := make(map[string][]byte)
+	for offset := 0; offset < len(dir); {
+		length := int(dir[offset])
+		if length == 0 {
+			// Records never span sectors; skip to the next one
+			offset += sectorSize - offset%sectorSize
+			continue
+		}
+		record := dir[offset : offset+length]
+		offset += length
+
+		identifier := record[33 : 33+int(record[32])]
+		if record[25]&0x02 != 0 {
+			continue // "." and ".."
+		}
+
+		var name string
+		if joliet {
+			units := make([]uint16, len(identifier)/2)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(identifier[2*i:])
+			}
+			name = string(utf16.Decode(units))
+		} else {
+			name = string(identifier)
+		}
+
+		extent := binary.LittleEndian.Uint32(record[2:])
+		size := binary.LittleEndian.Uint32(record[10:])
+		if binary.BigEndian.Uint32(record[6:]) != extent || binary.BigEndian.Uint32(record[14:]) != size {
+			t.Errorf("Both-endian fields of %q do not match", name)
+		}
+		files[name] = image[int(extent)*sectorSize : int(extent)*sectorSize+int(size)]
+	}
+	return files
+}
+
+func TestBuild(t *testing.T) {
+	config := []byte(`{"name":"runner-1"}`)
+	large := bytes.Repeat([]byte("x"), 5000)
+
+	image, err := Build("cidata", []File{
+		{Name: "runner-config.json", Data: config},
+		{Name: "user-data", Data: large},
+		{Name: "meta-data", Data: []byte("instance-id: runner-1\n")},
+	})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(image)%sectorSize != 0 {
+		t.Fatalf("Image size %d is not a whole number of sectors", len(image))
+	}
+	if image[terminatorSector*sectorSize] != 255 {
+		t.Error("Missing volume descriptor set terminator")
+	}
+
+	primary := readRoot(t, image, primaryDescriptorSector, false)
+	if !bytes.Equal(primary["RUNNER_CONFIG.JSON;1"], config) {
+		t.Errorf("Primary directory missing RUNNER_CONFIG.JSON;1, got %v", keys(primary))
+	}
+	if got := strings.TrimSpace(string(sector(image, primaryDescriptorSector)[40:72])); got != "CIDATA" {
+		t.Errorf("Expected primary volume ID CIDATA, got %q", got)
+	}
+
+	joliet := readRoot(t, image, jolietDescriptorSector, true)
+	if !bytes.Equal(joliet["runner-config.json"], config) {
+		t.Errorf("Joliet directory missing runner-config.json, got %v", keys(joliet))
+	}
+	if !bytes.Equal(joliet["user-data"], large) {
+		t.Error("Joliet user-data content does not match")
+	}
+	if string(sector(image, jolietDescriptorSector)[88:91]) != "%/E" {
+		t.Error("Joliet descriptor missing UCS-2 escape sequence")
+	}
+	jolietLabel := sector(image, jolietDescriptorSector)[40:52]
+	if !bytes.Equal(jolietLabel, ucs2("cidata")) {
+		t.Errorf("Expected Joliet volume ID cidata, got %q", jolietLabel)
+	}
+}
+
+func TestBuild_ManyFilesSpanSectors(t *testing.T) {
+	var files []File
+	for i := 0; i < 60; i++ {
+		files = append(files, File{Name: strings.Repeat("f", 20) + string(rune('A'+i%26)) + string(rune('a'+i/26)), Data: []byte{byte(i)}})
+	}
+
+	image, err := Build("seed", files)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	joliet := readRoot(t, image, jolietDescriptorSector, true)
+	if len(joliet) != len(files) {
+		t.Fatalf("Expected %d files, got %d", len(files), len(joliet))
+	}
+	for _, file := range files {
+		if !bytes.Equal(joliet[file.Name], file.Data) {
+			t.Errorf("Content of %s does not match", file.Name)
+		}
+	}
+}
+
+func TestBuild_InvalidInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		volumeID string
+		files    []File
+	}{
+		{name: "empty volume ID", volumeID: "", files: nil},
+		{name: "long volume ID", volumeID: strings.Repeat("v", 17), files: nil},
+		{name: "path separator", volumeID: "seed", files: []File{{Name: "dir/file"}}},
+		{name: "primary name collision", volumeID: "seed", files: []File{{Name: "a-b"}, {Name: "a_b"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Build(tt.volumeID, tt.files); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func keys(m map[string][]byte) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
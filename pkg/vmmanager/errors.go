@@ -0,0 +1,133 @@
+package vmmanager
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrTemplateInvalid is returned when a template VHDX fails its pre-flight checks
+var ErrTemplateInvalid = errors.New("template validation failed")
+
+// ErrInsufficientStorage is returned when no storage path has more free space than the configured reserve
+var ErrInsufficientStorage = errors.New("insufficient free storage space")
+
+// ErrNoHostCapacity is returned when every Hyper-V host already runs as many VMs as its capacity allows
+var ErrNoHostCapacity = errors.New("no host capacity left")
+
+// ErrVMNotFound is returned when a slot's VM no longer exists, e.g. because it was deleted in Hyper-V Manager
+var ErrVMNotFound = errors.New("VM not found")
+
+// ErrSwitchNotFound is returned when a network adapter's virtual switch does not exist on the host
+var ErrSwitchNotFound = errors.New("virtual switch not found")
+
+// ErrAccessDenied is returned when the service account lacks the rights to manage Hyper-V on a host
+var ErrAccessDenied = errors.New("access denied")
+
+// ErrHostUnavailable is returned when a Hyper-V host or its management service cannot be reached
+var ErrHostUnavailable = errors.New("Hyper-V host unavailable")
+
+// ErrInsufficientMemory is returned when a host has too little free memory to start a VM
+var ErrInsufficientMemory = errors.New("insufficient host memory")
+
+// ErrGuestUnreachable is returned when a guest cannot be reached over PowerShell Direct or does not report a heartbeat
+var ErrGuestUnreachable = errors.New("guest unreachable")
+
+// ErrGuestAuthFailed is returned when the guest rejects the configured vm_username and vm_password
+var ErrGuestAuthFailed = errors.New("guest rejected credentials")
+
+// PowerShellError is a PowerShell script that failed on a Hyper-V host
+// It unwraps to the sentinel error its error output was classified as, if any, so callers can
+// tell a missing switch from an unreachable host without parsing PowerShell output
+type PowerShellError struct {
+	Host   string // Host the script ran on (empty: the local host)
+	Stdout string // End of the script's output, where many scripts report why they failed before throwing
+	Stderr string // Error output of the script
+	Err    error  // Why the script failed, e.g. its exit status
+	Kind   error  // Sentinel error the failure was classified as (nil: not recognised)
+}
+
+// maxErrorStdout is how much of a failed script's output is kept in its error
+const maxErrorStdout = 1024
+
+// newPowerShellError classifies a failed script by its error output
+// Only stderr is classified: the script's own output may quote anything, e.g. a guest's log
+func newPowerShellError(host, stdout, stderr string, err error) *PowerShellError {
+	stdout = strings.TrimSpace(stdout)
+	if len(stdout) > maxErrorStdout {
+		stdout = "..." + stdout[len(stdout)-maxErrorStdout:]
+	}
+	return &PowerShellError{Host: host, Stdout: stdout, Stderr: stderr, Err: err, Kind: classifyPowerShellError(stderr)}
+}
+
+func (e *PowerShellError) Error() string {
+	msg := "powershell error: " + e.Err.Error()
+	if e.Host != "" {
+		msg = "host " + e.Host + ": " + msg
+	}
+	if e.Stdout != "" {
+		msg += "\nstdout: " + e.Stdout
+	}
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += "\nstderr: " + stderr
+	}
+	return msg
+}
+
+func (e *PowerShellError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// powerShellFailures maps messages of Hyper-V cmdlets, WinRM, OpenSSH and PowerShell Direct to sentinel errors
+// The first match wins: guest failures come first, so a PowerShell Direct session that broke off is
+// not mistaken for a host access problem, and a WinRM connection refused with "Access is denied" is one
+var powerShellFailures = []struct {
+	message string
+	kind    error
+}{
+	{"unable to find a virtual machine", ErrVMNotFound},
+	{"unable to find a virtual switch", ErrSwitchNotFound},
+	{"the credential is invalid", ErrGuestAuthFailed},
+	{"a remote session might have ended", ErrGuestUnreachable},
+	{"is not in running state", ErrGuestUnreachable},
+	{"guest heartbeat not ok", ErrGuestUnreachable},
+	{"access is denied", ErrAccessDenied},
+	{"required permission", ErrAccessDenied},
+	{"permission denied (publickey", ErrAccessDenied},
+	{"virtual machine management service", ErrHostUnavailable},
+	{"winrm cannot complete the operation", ErrHostUnavailable},
+	{"connecting to remote server", ErrHostUnavailable},
+	{"could not resolve hostname", ErrHostUnavailable},
+	{"connection refused", ErrHostUnavailable},
+	{"connection timed out", ErrHostUnavailable},
+	{"not enough memory", ErrInsufficientMemory},
+	{"ran out of memory", ErrInsufficientMemory},
+}
+
+// classifyPowerShellError returns the sentinel error matching a script's error output, or nil
+func classifyPowerShellError(stderr string) error {
+	stderr = strings.ToLower(stderr)
+	for _, failure := range powerShellFailures {
+		if strings.Contains(stderr, failure.message) {
+			return failure.kind
+		}
+	}
+	return nil
+}
+
+// guestError reclassifies the failure of a script that ran code inside a guest over PowerShell Direct
+// What the guest itself raised, e.g. "Access is denied" from the configure script, is the guest's
+// failure and never a host-level one; only failing to reach the guest or its host keeps its kind
+func guestError(err error) error {
+	var psErr *PowerShellError
+	if errors.As(err, &psErr) {
+		switch psErr.Kind {
+		case ErrGuestAuthFailed, ErrGuestUnreachable, ErrHostUnavailable, ErrVMNotFound:
+		default:
+			psErr.Kind = nil
+		}
+	}
+	return err
+}
@@ -2,11 +2,13 @@ package vmmanager
 
 import (
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"os"
 	"strings"
+	"sync"
+	"unicode"
 
 	"hyperv-runner-pool/pkg/config"
 )
@@ -16,34 +18,72 @@ var configureRunnerScript string
 
 // HyperVManager implements VMManager for Windows Hyper-V
 type HyperVManager struct {
-	config config.Config
-	logger *slog.Logger
+	config   config.Config
+	logger   *slog.Logger
+	executor CommandExecutor // Runs the generated PowerShell on the Hyper-V host
+	injector configInjector  // Delivers runner config to cold-booted guests
+
+	// Pre-booted checkpoint state (provisioning_mode: checkpoint)
+	checkpointMu    sync.Mutex
+	checkpointReady map[string]string // Checkpoint export path -> checkpointSource it was built from
 }
 
-// NewHyperVManager creates a new Hyper-V manager
+// NewHyperVManager creates a new Hyper-V manager that runs PowerShell on the local machine
 func NewHyperVManager(cfg config.Config, logger *slog.Logger) *HyperVManager {
-	return &HyperVManager{
-		config: cfg,
-		logger: logger.With("component", "hyperv"),
+	return NewHyperVManagerWithExecutor(cfg, &localPowerShell{logger: logger.With("component", "hyperv")}, logger)
+}
+
+// NewHyperVManagerWithExecutor creates a Hyper-V manager that runs its PowerShell through executor
+func NewHyperVManagerWithExecutor(cfg config.Config, executor CommandExecutor, logger *slog.Logger) *HyperVManager {
+	h := &HyperVManager{
+		config:          cfg,
+		logger:          logger.With("component", "hyperv"),
+		executor:        executor,
+		checkpointReady: make(map[string]string),
 	}
+	h.injector = h.newConfigInjector()
+	return h
+}
+
+// UpdateConfig replaces the configuration used for future operations
+// It must not be called while other operations are running
+func (h *HyperVManager) UpdateConfig(cfg config.Config) {
+	h.config = cfg
 }
 
 // CreateVM creates a new Hyper-V VM from the template
 func (h *HyperVManager) CreateVM(slot *VMSlot) error {
+	if h.config.HyperV.ProvisioningMode == config.ProvisioningCheckpoint {
+		return h.createVMFromCheckpoint(slot)
+	}
+
 	vmName := slot.Name
-	vhdxPath := fmt.Sprintf("%s\\%s.vhdx", h.config.HyperV.VMStoragePath, vmName)
 
 	h.logger.Info("Starting VM creation", "vm_name", vmName)
 
+	// Place the VM on the storage path with the most free space, keeping the configured reserve
+	storagePath, err := h.selectStoragePath()
+	if err != nil {
+		return err
+	}
+	slot.StoragePath = storagePath
+	vhdxPath := fmt.Sprintf("%s\\%s.vhdx", storagePath, vmName)
+
 	// Create differencing disk (child VHDX) referencing the parent template
 	// This is much faster than copying the entire VHDX (~1s vs 15s) and uses less storage
 	// The child disk only stores changes from the parent template
 	// NOTE: Parent template must be read-only to prevent corruption of child disks
 	//       Run: Set-ItemProperty -Path "template.vhdx" -Name IsReadOnly -Value $true
-	h.logger.Debug("Creating differencing disk", "vm_name", vmName)
+	//       This is enforced by ValidateTemplate before the pool is created
+	templatePath, err := h.templatePath(slot)
+	if err != nil {
+		return err
+	}
+
+	h.logger.Debug("Creating differencing disk", "vm_name", vmName, "template_version", slot.TemplateVersion)
 	createDiffCmd := fmt.Sprintf(
 		`New-VHD -ParentPath "%s" -Path "%s" -Differencing`,
-		h.config.HyperV.TemplatePath,
+		templatePath,
 		vhdxPath,
 	)
 	if _, err := h.RunPowerShell(createDiffCmd); err != nil {
@@ -51,9 +91,88 @@ func (h *HyperVManager) CreateVM(slot *VMSlot) error {
 	}
 	h.logger.Debug("Differencing disk created", "vm_name", vmName)
 
-	// Inject runner config into VHDX (before creating VM)
-	// Build labels: start with defaults, then add custom labels
-	defaultLabels := []string{"self-hosted", "Windows", "X64", "ephemeral"}
+	runnerConfig, err := h.runnerConfigFor(slot)
+	if err != nil {
+		return err
+	}
+
+	// Create VM
+	h.logger.Debug("Creating VM in Hyper-V", "vm_name", vmName,
+		"memory_mb", h.config.HyperV.VMMemoryMB,
+		"cpu_count", h.config.HyperV.VMCPUCount,
+		"dynamic_memory", h.config.HyperV.Hardware.DynamicMemory.Enabled,
+		"nested_virtualization", h.config.HyperV.Hardware.NestedVirtualization)
+	networkCmd, err := h.networkAdapterCommands(vmName, slot.Index)
+	if err != nil {
+		return fmt.Errorf("failed to build network configuration: %w", err)
+	}
+	createCmd := h.newVMCommand(vmName, vhdxPath) + h.ownerCommands(vmName) + h.extraDiskCommands(slot) + networkCmd + h.meteringCommands(vmName)
+
+	if _, err := h.RunPowerShell(createCmd); err != nil {
+		return fmt.Errorf("failed to create VM: %w", err)
+	}
+	if err := h.verifyNetworkPolicy(vmName); err != nil {
+		return err
+	}
+	h.logger.Debug("VM created in Hyper-V", "vm_name", vmName)
+
+	// Inject runner config before boot (mount, iso) or prepare the VM for delivery after boot (kvp, copy_file)
+	h.logger.Debug("Injecting runner config", "vm_name", vmName, "strategy", h.config.HyperV.ConfigInjection)
+	if err := h.injector.beforeStart(slot, vhdxPath, runnerConfig); err != nil {
+		return fmt.Errorf("failed to inject config: %w", err)
+	}
+
+	// Start VM
+	h.logger.Debug("Starting VM", "vm_name", vmName)
+	startCmd := fmt.Sprintf(`Start-VM -Name "%s"`, vmName)
+	if _, err := h.RunPowerShell(startCmd); err != nil {
+		return fmt.Errorf("failed to start VM: %w", err)
+	}
+
+	h.logger.Info("VM created and started successfully", "vm_name", vmName)
+
+	if err := h.injector.afterStart(slot, runnerConfig); err != nil {
+		return fmt.Errorf("failed to inject config: %w", err)
+	}
+	h.logger.Debug("Runner config injected", "vm_name", vmName)
+	h.logger.Info("Waiting for VM to boot and configuring runner...", "vm_name", vmName)
+
+	if h.linuxGuest() {
+		// cloud-init starts configure-runner.sh from the seed ISO by itself
+		if err := h.waitForHeartbeat(vmName); err != nil {
+			return fmt.Errorf("failed to configure runner in VM: %w", err)
+		}
+	} else {
+		// Execute the embedded configure-runner script in the VM
+		// This will set up the scheduled task and start the runner
+		h.logger.Debug("Executing configure script in VM", "vm_name", vmName)
+		if err := h.ExecuteScriptInVM(vmName, configureRunnerScript); err != nil {
+			return fmt.Errorf("failed to configure runner in VM: %w", err)
+		}
+	}
+
+	h.recordIPAddress(slot, runnerConfig)
+	h.logger.Info("Runner configured successfully in VM", "vm_name", vmName, "ip_address", slot.IPAddress)
+
+	return nil
+}
+
+// templatePath returns the template VHDX for the slot's template version
+func (h *HyperVManager) templatePath(slot *VMSlot) (string, error) {
+	if slot.TemplateVersion == "" {
+		return h.config.HyperV.TemplatePath, nil
+	}
+	return h.config.HyperV.TemplatePathFor(slot.TemplateVersion)
+}
+
+// runnerConfigFor builds the runner registration config for a slot
+func (h *HyperVManager) runnerConfigFor(slot *VMSlot) (RunnerConfig, error) {
+	// Build labels: start with defaults for the guest OS, then add custom labels
+	osLabel := "Windows"
+	if h.linuxGuest() {
+		osLabel = "Linux"
+	}
+	defaultLabels := []string{"self-hosted", osLabel, "X64", "ephemeral", h.config.Runners.OwnerLabel()}
 	allLabels := append(defaultLabels, h.config.Runners.Labels...)
 	labelsStr := strings.Join(allLabels, ",")
 
@@ -61,7 +180,7 @@ func (h *HyperVManager) CreateVM(slot *VMSlot) error {
 		Token:        slot.RunnerToken,
 		Organization: h.config.GitHub.GetAccount(),
 		Repository:   h.config.GitHub.Repo,
-		Name:         vmName,
+		Name:         slot.Name,
 		Labels:       labelsStr,
 		RunnerGroup:  h.config.Runners.RunnerGroup,
 	}
@@ -72,49 +191,31 @@ func (h *HyperVManager) CreateVM(slot *VMSlot) error {
 		h.logger.Debug("Cache URL configured", "cache_url", h.config.Runners.CacheURL)
 	}
 
-	h.logger.Debug("Injecting runner config", "vm_name", vmName)
-	if err := h.InjectConfig(vhdxPath, runnerConfig); err != nil {
-		return fmt.Errorf("failed to inject config: %w", err)
+	network, err := h.staticAddressesFor(slot.Index)
+	if err != nil {
+		return RunnerConfig{}, fmt.Errorf("failed to assign static addresses: %w", err)
+	}
+	runnerConfig.Network = network
+	h.applyDiskLetters(&runnerConfig)
+	if h.config.Monitoring.GuestProbe.Enabled {
+		runnerConfig.HeartbeatSeconds = h.config.Monitoring.GuestProbe.HeartbeatSeconds
 	}
-	h.logger.Debug("Runner config injected", "vm_name", vmName)
 
-	// Create VM
-	h.logger.Debug("Creating VM in Hyper-V", "vm_name", vmName, "memory_mb", h.config.HyperV.VMMemoryMB, "cpu_count", h.config.HyperV.VMCPUCount)
-	createCmd := fmt.Sprintf(`
+	return runnerConfig, nil
+}
+
+// newVMCommand returns the PowerShell that creates a stopped VM booting from vhdxPath
+// with the configured hardware profile
+// Network adapters are added separately so checkpoint VMs can be saved without one
+func (h *HyperVManager) newVMCommand(vmName, vhdxPath string) string {
+	return fmt.Sprintf(`
 		New-VM -Name "%s" -MemoryStartupBytes %dMB -Generation 2 -VHDPath "%s"
 		Set-VM -Name "%s" -ProcessorCount %d
 		Set-VM -Name "%s" -AutomaticStartAction Nothing
 		Set-VM -Name "%s" -AutomaticStopAction ShutDown
-		Add-VMNetworkAdapter -VMName "%s" -SwitchName "Default Switch"
 		$vmDrive = Get-VMHardDiskDrive -VMName "%s"
 		Set-VMFirmware -VMName "%s" -BootOrder $vmDrive
-	`, vmName, h.config.HyperV.VMMemoryMB, vhdxPath, vmName, h.config.HyperV.VMCPUCount, vmName, vmName, vmName, vmName, vmName)
-
-	if _, err := h.RunPowerShell(createCmd); err != nil {
-		return fmt.Errorf("failed to create VM: %w", err)
-	}
-	h.logger.Debug("VM created in Hyper-V", "vm_name", vmName)
-
-	// Start VM
-	h.logger.Debug("Starting VM", "vm_name", vmName)
-	startCmd := fmt.Sprintf(`Start-VM -Name "%s"`, vmName)
-	if _, err := h.RunPowerShell(startCmd); err != nil {
-		return fmt.Errorf("failed to start VM: %w", err)
-	}
-
-	h.logger.Info("VM created and started successfully", "vm_name", vmName)
-	h.logger.Info("Waiting for VM to boot and configuring runner...", "vm_name", vmName)
-
-	// Execute the embedded configure-runner script in the VM
-	// This will set up the scheduled task and start the runner
-	h.logger.Debug("Executing configure script in VM", "vm_name", vmName)
-	if err := h.ExecuteScriptInVM(vmName, configureRunnerScript); err != nil {
-		return fmt.Errorf("failed to configure runner in VM: %w", err)
-	}
-
-	h.logger.Info("Runner configured successfully in VM", "vm_name", vmName)
-
-	return nil
+	`, vmName, h.config.HyperV.VMMemoryMB, vhdxPath, vmName, h.config.HyperV.VMCPUCount, vmName, vmName, vmName, vmName) + h.hardwareProfileCommands(vmName)
 }
 
 // DestroyVM destroys a Hyper-V VM and removes its disk
@@ -125,28 +226,43 @@ func (h *HyperVManager) DestroyVM(slot *VMSlot) error {
 	stopCmd := fmt.Sprintf(`Stop-VM -Name "%s" -TurnOff -Force -ErrorAction SilentlyContinue`, vmName)
 	_, _ = h.RunPowerShell(stopCmd) // Ignore errors if VM already stopped
 
-	// Remove VM
-	removeCmd := fmt.Sprintf(`Remove-VM -Name "%s" -Force`, vmName)
+	// Remove VM; one already deleted outside the orchestrator still has its files cleaned up
+	removeCmd := findVMCommand(vmName) + `
+		Remove-VM -VM $vm -Force
+	`
 	if _, err := h.RunPowerShell(removeCmd); err != nil {
 		return fmt.Errorf("failed to remove VM: %w", err)
 	}
 
-	// Delete VHDX file
-	vhdxPath := fmt.Sprintf("%s\\%s.vhdx", h.config.HyperV.VMStoragePath, vmName)
-	deleteCmd := fmt.Sprintf(`Remove-Item -Path "%s" -Force -ErrorAction SilentlyContinue`, vhdxPath)
-	_, _ = h.RunPowerShell(deleteCmd) // Ignore errors if file already deleted
+	// Delete VHDX file, the extra disks, the seed ISO, and the VM directory used by checkpoint restores
+	vhdxPath := fmt.Sprintf("%s\\%s.vhdx", h.storagePathFor(slot), vmName)
+	vmPath := fmt.Sprintf("%s\\%s", h.storagePathFor(slot), vmName)
+	deleteCmd := fmt.Sprintf(`
+		Remove-Item -Path "%s" -Force -ErrorAction SilentlyContinue
+		Remove-Item -Path "%s" -Force -ErrorAction SilentlyContinue
+		Remove-Item -Path "%s" -Force -ErrorAction SilentlyContinue
+		Remove-Item -Path "%s" -Force -ErrorAction SilentlyContinue
+		Remove-Item -Path "%s" -Recurse -Force -ErrorAction SilentlyContinue
+	`, vhdxPath, h.toolCacheDiskPath(slot), h.scratchDiskPath(slot), h.seedISOPath(slot), vmPath)
+	_, _ = h.RunPowerShell(deleteCmd) // Ignore errors if files already deleted
 
 	h.logger.Info("VM destroyed successfully", "vm_name", vmName)
 	return nil
 }
 
 // GetVMState returns the current state of a VM (Running, Off, Stopped, etc.)
+// Returns ErrVMNotFound when the VM no longer exists
 func (h *HyperVManager) GetVMState(vmName string) (string, error) {
-	cmd := fmt.Sprintf(`(Get-VM -Name "%s").State`, vmName)
+	cmd := findVMCommand(vmName) + `
+		$vm.State
+	`
 	output, err := h.RunPowerShell(cmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to get VM state: %w", err)
 	}
+	if vmNotFound(output) {
+		return "", fmt.Errorf("%w: %s", ErrVMNotFound, vmName)
+	}
 	return strings.TrimSpace(output), nil
 }
 
@@ -194,6 +310,17 @@ func (h *HyperVManager) InjectConfig(vhdxPath string, config RunnerConfig) error
 		Write-Output "DRIVE_LETTER:$driveLetter"
 	`, vhdxPath)
 
+	// Ensure we unmount on exit, including when the mount script failed after Mount-VHD
+	// or printed no usable drive letter, so the disk is never left mounted
+	defer func() {
+		unmountCmd := fmt.Sprintf(`Dismount-VHD -Path "%s"`, vhdxPath)
+		if _, err := h.RunPowerShell(unmountCmd); err != nil {
+			h.logger.Warn("Failed to unmount VHDX", "path", vhdxPath, "error", err)
+		} else {
+			h.logger.Debug("VHDX unmounted successfully", "path", vhdxPath)
+		}
+	}()
+
 	output, err := h.RunPowerShell(mountCmd)
 	if err != nil {
 		return fmt.Errorf("failed to mount VHDX: %w", err)
@@ -201,33 +328,13 @@ func (h *HyperVManager) InjectConfig(vhdxPath string, config RunnerConfig) error
 
 	h.logger.Debug("Mount output", "output", output)
 
-	// Parse drive letter from output
-	var driveLetter string
-	for _, line := range strings.Split(output, "\n") {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "DRIVE_LETTER:") {
-			driveLetter = strings.TrimPrefix(line, "DRIVE_LETTER:")
-			driveLetter = strings.TrimSpace(driveLetter)
-			break
-		}
-	}
-
-	if driveLetter == "" {
-		return fmt.Errorf("failed to extract drive letter from mount output: %s", output)
+	driveLetter, err := parseDriveLetter(output)
+	if err != nil {
+		return err
 	}
 
 	h.logger.Info("VHDX mounted successfully", "drive_letter", driveLetter)
 
-	// Ensure we unmount on exit
-	defer func() {
-		unmountCmd := fmt.Sprintf(`Dismount-VHD -Path "%s"`, vhdxPath)
-		if _, err := h.RunPowerShell(unmountCmd); err != nil {
-			h.logger.Warn("Failed to unmount VHDX", "path", vhdxPath, "error", err)
-		} else {
-			h.logger.Debug("VHDX unmounted successfully", "path", vhdxPath)
-		}
-	}()
-
 	// Write config file
 	configJSON, err := json.Marshal(config)
 	if err != nil {
@@ -236,31 +343,16 @@ func (h *HyperVManager) InjectConfig(vhdxPath string, config RunnerConfig) error
 
 	h.logger.Debug("Config JSON created", "size_bytes", len(configJSON))
 
-	// Write JSON to a temporary file first to avoid command line length/escaping issues
-	// Use VM-specific name to avoid race conditions when creating multiple VMs in parallel
-	tempFile := fmt.Sprintf("%s\\runner-config-%s.json", os.TempDir(), config.Name)
-	if err := os.WriteFile(tempFile, configJSON, 0644); err != nil {
-		return fmt.Errorf("failed to write temp config file: %w", err)
-	}
-	defer os.Remove(tempFile) // Clean up temp file
-
-	h.logger.Debug("Temp config file created", "path", tempFile)
-
-	// Copy the temp file to the mounted VHDX and verify
+	// Pass the JSON base64 encoded to avoid escaping issues; the script is run from a file,
+	// so its size is not limited by the command line
+	// Writing from the script rather than a local temp file also works when the host is remote
 	destPath := fmt.Sprintf("%s:\\runner-config.json", driveLetter)
 	copyAndVerifyCmd := fmt.Sprintf(`
 		$ErrorActionPreference = "Stop"
-		$source = "%s"
 		$dest = "%s"
 
-		Write-Output "Copying from: $source"
-		Write-Output "Copying to: $dest"
-
-		if (-not (Test-Path $source)) {
-			throw "Source file not found: $source"
-		}
-
-		Copy-Item -Path $source -Destination $dest -Force
+		Write-Output "Writing to: $dest"
+		[System.IO.File]::WriteAllBytes($dest, [System.Convert]::FromBase64String("%s"))
 
 		if (-not (Test-Path $dest)) {
 			throw "Copy failed - destination file not found: $dest"
@@ -274,7 +366,7 @@ func (h *HyperVManager) InjectConfig(vhdxPath string, config RunnerConfig) error
 		Write-Output "Content preview: $($content.Substring(0, [Math]::Min(100, $content.Length)))..."
 
 		Write-Output "SUCCESS"
-	`, tempFile, destPath)
+	`, destPath, base64.StdEncoding.EncodeToString(configJSON))
 
 	copyOutput, err := h.RunPowerShell(copyAndVerifyCmd)
 	if err != nil {
@@ -295,6 +387,23 @@ func (h *HyperVManager) InjectConfig(vhdxPath string, config RunnerConfig) error
 	return nil
 }
 
+// parseDriveLetter extracts the drive letter from the mount script output
+// The first DRIVE_LETTER: line wins; anything but a single letter is rejected
+func parseDriveLetter(output string) (string, error) {
+	for _, line := range strings.Split(output, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "DRIVE_LETTER:")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSuffix(strings.TrimSpace(value), ":")
+		if len(value) != 1 || !unicode.IsLetter(rune(value[0])) {
+			return "", fmt.Errorf("invalid drive letter %q in mount output", value)
+		}
+		return strings.ToUpper(value), nil
+	}
+	return "", fmt.Errorf("failed to extract drive letter from mount output: %s", output)
+}
+
 // ExecuteScriptInVM executes a PowerShell script inside a running VM using PowerShell Direct
 // This method uses stored credentials to avoid interactive prompts
 func (h *HyperVManager) ExecuteScriptInVM(vmName string, scriptContent string) error {
@@ -370,7 +479,7 @@ func (h *HyperVManager) ExecuteScriptInVM(vmName string, scriptContent string) e
 
 	output, err := h.RunPowerShell(execCmd)
 	if err != nil {
-		return fmt.Errorf("failed to execute script in VM: %w", err)
+		return fmt.Errorf("failed to execute script in VM: %w", guestError(err))
 	}
 
 	h.logger.Debug("Script execution output", "output", output)
@@ -384,20 +493,29 @@ func (h *HyperVManager) ExecuteScriptInVM(vmName string, scriptContent string) e
 }
 
 // CleanupLeftoverResources removes any VMs and VHDXs matching the name prefix from previous runs
+// Only VMs tagged with this instance's owner tag are removed, together with files named after them;
+// files named after another instance's VMs are kept
+// Untagged VMs left by a version without owner tags are removed too, unless another instance tags its VMs
 func (h *HyperVManager) CleanupLeftoverResources(namePrefix string) error {
 	h.logger.Info("Cleaning up leftover resources from previous runs", "name_prefix", namePrefix)
 
 	cleanupCmd := fmt.Sprintf(`
 		$ErrorActionPreference = "Continue"
 		$namePrefix = "%s"
-		$storagePath = "%s"
+		$storagePaths = @(%s)
 		$cleaned = 0
+		$foreignNames = @{}%s
 
 		# Find and remove VMs matching the prefix followed by digits only
 		# This ensures we only match numbered pool VMs like "github-runner-1", "github-runner-2"
 		# and NOT other VMs like "github-runner-basic", "github-runner-template", etc.
 		$vms = Get-VM | Where-Object { $_.Name -match "^$([regex]::Escape($namePrefix))\d+$" }
 		foreach ($vm in $vms) {
+			if (-not %s) {
+				Write-Output "Skipping VM not owned by this instance: $($vm.Name)"
+				$foreignNames[$vm.Name] = $true
+				continue
+			}
 			Write-Output "Removing VM: $($vm.Name)"
 			try {
 				Stop-VM -Name $vm.Name -TurnOff -Force -ErrorAction SilentlyContinue
@@ -409,22 +527,53 @@ func (h *HyperVManager) CleanupLeftoverResources(namePrefix string) error {
 			}
 		}
 
-		# Find and remove orphaned VHDX files matching the prefix followed by digits only
-		if (Test-Path $storagePath) {
-			$vhdxFiles = Get-ChildItem -Path $storagePath -Filter "$namePrefix*.vhdx" -ErrorAction SilentlyContinue |
-				Where-Object { $_.BaseName -match "^$([regex]::Escape($namePrefix))\d+$" }
-			foreach ($file in $vhdxFiles) {
-				Write-Output "Removing VHDX: $($file.Name)"
-				try {
-					# Try to dismount if mounted
-					Dismount-VHD -Path $file.FullName -ErrorAction SilentlyContinue
-
-					# Delete the file
-					Remove-Item -Path $file.FullName -Force -ErrorAction Stop
-					$cleaned++
-					Write-Output "  Removed successfully"
-				} catch {
-					Write-Output "  Warning: Failed to remove VHDX: $_"
+		# Find and remove orphaned VHDX files matching the prefix followed by digits only,
+		# including the tool cache and scratch disks of each slot
+		foreach ($storagePath in $storagePaths) {
+			if (Test-Path $storagePath) {
+				$vhdxFiles = Get-ChildItem -Path $storagePath -Filter "$namePrefix*.vhdx" -ErrorAction SilentlyContinue |
+					Where-Object { $_.BaseName -match "^($([regex]::Escape($namePrefix))\d+)(-toolcache|-scratch)?$" -and -not $foreignNames[$Matches[1]] }
+				foreach ($file in $vhdxFiles) {
+					Write-Output "Removing VHDX: $($file.Name)"
+					try {
+						# Try to dismount if mounted
+						Dismount-VHD -Path $file.FullName -ErrorAction SilentlyContinue
+
+						# Delete the file
+						Remove-Item -Path $file.FullName -Force -ErrorAction Stop
+						$cleaned++
+						Write-Output "  Removed successfully"
+					} catch {
+						Write-Output "  Warning: Failed to remove VHDX: $_"
+					}
+				}
+
+				# Find and remove seed ISOs attached by the iso config injection strategy
+				$isoFiles = Get-ChildItem -Path $storagePath -Filter "$namePrefix*-seed.iso" -ErrorAction SilentlyContinue |
+					Where-Object { $_.BaseName -match "^($([regex]::Escape($namePrefix))\d+)-seed$" -and -not $foreignNames[$Matches[1]] }
+				foreach ($file in $isoFiles) {
+					Write-Output "Removing seed ISO: $($file.Name)"
+					try {
+						Remove-Item -Path $file.FullName -Force -ErrorAction Stop
+						$cleaned++
+						Write-Output "  Removed successfully"
+					} catch {
+						Write-Output "  Warning: Failed to remove seed ISO: $_"
+					}
+				}
+
+				# Find and remove VM directories left by checkpoint restores
+				$vmDirs = Get-ChildItem -Path $storagePath -Directory -ErrorAction SilentlyContinue |
+					Where-Object { $_.Name -match "^$([regex]::Escape($namePrefix))\d+$" -and -not $foreignNames[$_.Name] }
+				foreach ($dir in $vmDirs) {
+					Write-Output "Removing VM directory: $($dir.Name)"
+					try {
+						Remove-Item -Path $dir.FullName -Recurse -Force -ErrorAction Stop
+						$cleaned++
+						Write-Output "  Removed successfully"
+					} catch {
+						Write-Output "  Warning: Failed to remove VM directory: $_"
+					}
 				}
 			}
 		}
@@ -433,7 +582,7 @@ func (h *HyperVManager) CleanupLeftoverResources(namePrefix string) error {
 		if ($cleaned -gt 0) {
 			Write-Output "CLEANUP_PERFORMED"
 		}
-	`, namePrefix, h.config.HyperV.VMStoragePath)
+	`, namePrefix, psStringList(h.storagePaths()), h.adoptUntaggedCommand(namePrefix), h.ownedOrAdoptedCondition("$vm"))
 
 	output, err := h.RunPowerShell(cleanupCmd)
 	if err != nil {
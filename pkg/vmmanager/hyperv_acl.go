@@ -0,0 +1,230 @@
+package vmmanager
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"hyperv-runner-pool/pkg/config"
+)
+
+// aclRule is one Hyper-V extended port ACL
+// Rules are evaluated by weight, highest first, so later rules in networkPolicyRules override earlier ones
+type aclRule struct {
+	Action    string // Allow or Deny
+	Direction string // Inbound or Outbound
+	RemoteIP  string // Remote network in CIDR notation (empty: any address)
+	Protocol  string // TCP or UDP (empty: any protocol)
+	Port      int    // Remote port (0: any port)
+	Weight    int
+}
+
+// networkPolicyRules translates the network policy into port ACLs, lowest priority first:
+// default egress deny, allowed egress, VM network deny, exceptions inside VM networks
+// (allowed egress, DNS, DHCP), and finally blocked networks
+func networkPolicyRules(network config.NetworkConfig) []aclRule {
+	policy := network.Policy
+	if !policy.Enabled() {
+		return nil
+	}
+
+	var vmNetworks []netip.Prefix
+	for _, cidr := range policy.VMNetworks {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && policy.BlockVMToVM {
+			vmNetworks = append(vmNetworks, prefix.Masked())
+		}
+	}
+	insideVMNetwork := func(cidr string) bool {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return false
+		}
+		return slices.ContainsFunc(vmNetworks, func(vmNetwork netip.Prefix) bool {
+			return vmNetwork.Bits() <= prefix.Bits() && vmNetwork.Contains(prefix.Addr())
+		})
+	}
+
+	var rules, exceptions []aclRule
+	if len(policy.AllowedEgress) > 0 {
+		rules = append(rules, aclRule{Action: "Deny", Direction: "Outbound"})
+	}
+	for _, egress := range policy.AllowedEgress {
+		allowed := egressRules(egress)
+		if insideVMNetwork(egress.CIDR) {
+			exceptions = append(exceptions, allowed...)
+		} else {
+			rules = append(rules, allowed...)
+		}
+	}
+
+	for _, vmNetwork := range vmNetworks {
+		rules = append(rules, aclRule{Action: "Deny", Direction: "Outbound", RemoteIP: vmNetwork.String()})
+	}
+
+	// Guests still need to resolve names and lease addresses once egress is restricted
+	// Resolvers handed out by DHCP are not known up front, so DHCP adapters may reach DNS anywhere
+	if len(policy.AllowedEgress) > 0 || len(vmNetworks) > 0 {
+		dhcpDNS := false
+		for _, adapter := range network.Adapters {
+			if adapter.StaticIP == nil {
+				dhcpDNS = true
+				continue
+			}
+			for _, dns := range adapter.StaticIP.DNSServers {
+				addr, err := netip.ParseAddr(dns)
+				if err != nil {
+					continue
+				}
+				remote := netip.PrefixFrom(addr, addr.BitLen()).String()
+				exceptions = append(exceptions,
+					aclRule{Action: "Allow", Direction: "Outbound", RemoteIP: remote, Protocol: "UDP", Port: 53},
+					aclRule{Action: "Allow", Direction: "Outbound", RemoteIP: remote, Protocol: "TCP", Port: 53})
+			}
+		}
+		if dhcpDNS || len(network.Adapters) == 0 {
+			exceptions = append(exceptions,
+				aclRule{Action: "Allow", Direction: "Outbound", Protocol: "UDP", Port: 53},
+				aclRule{Action: "Allow", Direction: "Outbound", Protocol: "TCP", Port: 53})
+		}
+		exceptions = append(exceptions, aclRule{Action: "Allow", Direction: "Outbound", Protocol: "UDP", Port: 67})
+	}
+	rules = append(rules, exceptions...)
+
+	for _, cidr := range policy.BlockedNetworks {
+		rules = append(rules,
+			aclRule{Action: "Deny", Direction: "Outbound", RemoteIP: cidr},
+			aclRule{Action: "Deny", Direction: "Inbound", RemoteIP: cidr})
+	}
+
+	for i := range rules {
+		rules[i].Weight = i + 1
+	}
+	return rules
+}
+
+// egressRules expands an allowed egress entry into one rule per port
+func egressRules(egress config.EgressRuleConfig) []aclRule {
+	protocol := strings.ToUpper(egress.Protocol)
+	if len(egress.Ports) == 0 {
+		return []aclRule{{Action: "Allow", Direction: "Outbound", RemoteIP: egress.CIDR, Protocol: protocol}}
+	}
+
+	rules := make([]aclRule, len(egress.Ports))
+	for i, port := range egress.Ports {
+		rules[i] = aclRule{Action: "Allow", Direction: "Outbound", RemoteIP: egress.CIDR, Protocol: protocol, Port: port}
+	}
+	return rules
+}
+
+// networkPolicyCommands returns the PowerShell that applies the network policy to one adapter
+func (h *HyperVManager) networkPolicyCommands(vmName, adapterName string) string {
+	var cmd strings.Builder
+	for _, rule := range networkPolicyRules(h.config.HyperV.Network) {
+		fmt.Fprintf(&cmd, `
+		Add-VMNetworkAdapterExtendedAcl -VMName "%s" -VMNetworkAdapterName "%s" -Action %s -Direction %s -Weight %d`,
+			vmName, adapterName, rule.Action, rule.Direction, rule.Weight)
+		if rule.RemoteIP != "" {
+			fmt.Fprintf(&cmd, ` -RemoteIPAddress "%s"`, rule.RemoteIP)
+		}
+		if rule.Protocol != "" {
+			fmt.Fprintf(&cmd, ` -Protocol "%s"`, rule.Protocol)
+		}
+		if rule.Port != 0 {
+			fmt.Fprintf(&cmd, ` -RemotePort "%d"`, rule.Port)
+		}
+	}
+	return cmd.String()
+}
+
+// verifyNetworkPolicy reads back the port ACLs of a VM and checks that every adapter
+// carries exactly the rules of the network policy
+// A VM that fails verification must never receive a runner token
+func (h *HyperVManager) verifyNetworkPolicy(vmName string) error {
+	rules := networkPolicyRules(h.config.HyperV.Network)
+	if len(rules) == 0 {
+		return nil
+	}
+
+	verifyCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		foreach ($adapter in Get-VMNetworkAdapter -VMName "%s") {
+			foreach ($acl in Get-VMNetworkAdapterExtendedAcl -VMNetworkAdapter $adapter) {
+				Write-Output "ACL:$($adapter.Name)|$($acl.Weight)|$($acl.Action)|$($acl.Direction)|$($acl.RemoteIPAddress)|$($acl.Protocol)|$($acl.RemotePort)"
+			}
+		}
+	`, vmName)
+	output, err := h.RunPowerShell(verifyCmd)
+	if err != nil {
+		return fmt.Errorf("failed to read port ACLs: %w", err)
+	}
+
+	got := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		if acl, ok := strings.CutPrefix(strings.TrimSpace(line), "ACL:"); ok {
+			got[normalizeACL(acl)] = true
+		}
+	}
+
+	var missing []string
+	for _, adapter := range h.config.HyperV.Network.Adapters {
+		for _, rule := range rules {
+			acl := aclKey(adapter.Name, rule)
+			if !got[acl] {
+				missing = append(missing, acl)
+			}
+			delete(got, acl)
+		}
+	}
+	if len(missing) > 0 || len(got) > 0 {
+		unexpected := make([]string, 0, len(got))
+		for acl := range got {
+			unexpected = append(unexpected, acl)
+		}
+		slices.Sort(unexpected)
+		return fmt.Errorf("network policy verification failed for %s: missing %v, unexpected %v", vmName, missing, unexpected)
+	}
+
+	h.logger.Debug("Network policy verified", "vm_name", vmName, "rules_per_adapter", len(rules))
+	return nil
+}
+
+// aclKey identifies a port ACL on an adapter in the form read back by verifyNetworkPolicy
+func aclKey(adapterName string, rule aclRule) string {
+	port := ""
+	if rule.Port != 0 {
+		port = strconv.Itoa(rule.Port)
+	}
+	return strings.Join([]string{adapterName, strconv.Itoa(rule.Weight), rule.Action, rule.Direction, rule.RemoteIP, rule.Protocol, port}, "|")
+}
+
+// normalizeACL rewrites a read back ACL into the form of aclKey
+// Hyper-V reports unset fields as ANY or *, protocols may come back as numbers and single addresses without a prefix length
+func normalizeACL(acl string) string {
+	fields := strings.Split(acl, "|")
+	if len(fields) != 7 {
+		return acl
+	}
+	for i := 4; i < len(fields); i++ {
+		if field := strings.TrimSpace(fields[i]); field == "*" || strings.EqualFold(field, "ANY") || field == "0" {
+			fields[i] = ""
+		} else {
+			fields[i] = field
+		}
+	}
+	if prefix, err := netip.ParsePrefix(fields[4]); err == nil {
+		fields[4] = prefix.Masked().String()
+	} else if addr, err := netip.ParseAddr(fields[4]); err == nil {
+		fields[4] = netip.PrefixFrom(addr, addr.BitLen()).String()
+	}
+	switch protocol := strings.ToUpper(fields[5]); protocol {
+	case "6":
+		fields[5] = "TCP"
+	case "17":
+		fields[5] = "UDP"
+	default:
+		fields[5] = protocol
+	}
+	return strings.Join(fields, "|")
+}
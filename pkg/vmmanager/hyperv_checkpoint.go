@@ -0,0 +1,219 @@
+package vmmanager
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// checkpointVMName returns the name of the VM that is booted once and exported as the checkpoint
+// The suffix is not numeric, so it never collides with pool slots or leftover cleanup
+func (h *HyperVManager) checkpointVMName() string {
+	return h.config.Runners.NamePrefix + "checkpoint"
+}
+
+// checkpointExportPath returns where the checkpoint of a template version is exported
+// Each template version gets its own checkpoint so canary rollouts work in checkpoint mode
+func (h *HyperVManager) checkpointExportPath(version string) string {
+	if version == "" {
+		version = h.config.HyperV.TemplateVersion
+	}
+	if version == "" {
+		return h.config.HyperV.CheckpointPath
+	}
+	return fmt.Sprintf("%s\\%s", h.config.HyperV.CheckpointPath, version)
+}
+
+// checkpointSource describes what a checkpoint is built from: the template and the memory, CPU
+// and hardware profile of the checkpoint VM, which every slot imported from it keeps
+func (h *HyperVManager) checkpointSource(templatePath string) string {
+	return templatePath + h.newVMCommand(h.checkpointVMName(), "")
+}
+
+// ensureCheckpoint builds the pre-booted checkpoint for the slot's template the first time it is needed
+// and again whenever what it is built from changes, e.g. after a reload edits template_path, the
+// VM hardware, or re-points the slot's version at another VHDX
+// Concurrent callers block until the build has finished
+func (h *HyperVManager) ensureCheckpoint(slot *VMSlot) error {
+	h.checkpointMu.Lock()
+	defer h.checkpointMu.Unlock()
+
+	templatePath, err := h.templatePath(slot)
+	if err != nil {
+		return err
+	}
+	exportPath := h.checkpointExportPath(slot.TemplateVersion)
+	source := h.checkpointSource(templatePath)
+	if h.checkpointReady[exportPath] == source {
+		return nil
+	}
+
+	if err := h.buildCheckpoint(templatePath, exportPath); err != nil {
+		delete(h.checkpointReady, exportPath)
+		return fmt.Errorf("failed to build checkpoint: %w", err)
+	}
+
+	h.checkpointReady[exportPath] = source
+	return nil
+}
+
+// buildCheckpoint boots a VM from the template, waits for the guest to reach a clean
+// pre-registration point, saves its running state and exports it for slots to import
+func (h *HyperVManager) buildCheckpoint(templatePath, exportPath string) error {
+	vmName := h.checkpointVMName()
+	vhdxPath := fmt.Sprintf("%s\\%s.vhdx", h.config.HyperV.VMStoragePath, vmName)
+
+	h.logger.Info("Building pre-booted checkpoint", "vm_name", vmName, "template_path", templatePath, "export_path", exportPath)
+
+	// Remove any checkpoint left behind by a previous run so it always matches the current template
+	resetCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		Stop-VM -Name "%s" -TurnOff -Force -ErrorAction SilentlyContinue
+		Remove-VM -Name "%s" -Force -ErrorAction SilentlyContinue
+		Remove-Item -Path "%s" -Force -ErrorAction SilentlyContinue
+		Remove-Item -Path "%s\%s" -Recurse -Force -ErrorAction SilentlyContinue
+		New-Item -Path "%s" -ItemType Directory -Force | Out-Null
+	`, vmName, vmName, vhdxPath, exportPath, vmName, exportPath)
+	if _, err := h.RunPowerShell(resetCmd); err != nil {
+		return fmt.Errorf("failed to reset checkpoint location: %w", err)
+	}
+
+	createDiffCmd := fmt.Sprintf(
+		`New-VHD -ParentPath "%s" -Path "%s" -Differencing`,
+		templatePath,
+		vhdxPath,
+	)
+	if _, err := h.RunPowerShell(createDiffCmd); err != nil {
+		return fmt.Errorf("failed to create differencing disk: %w", err)
+	}
+
+	// The checkpoint VM has no network adapter: a saved VM cannot change its MAC address,
+	// so each restored slot hot-adds its own adapter after resuming
+	createCmd := h.newVMCommand(vmName, vhdxPath) + fmt.Sprintf(`
+		Start-VM -Name "%s"
+	`, vmName)
+	if _, err := h.RunPowerShell(createCmd); err != nil {
+		return fmt.Errorf("failed to create checkpoint VM: %w", err)
+	}
+
+	// Reaching the guest over PowerShell Direct means it has finished booting
+	// Give background services time to settle so every restored VM starts from an idle guest
+	readyScript := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		if (Test-Path "C:\runner-config.json") {
+			throw "Template already contains runner-config.json; it is not at a clean pre-registration point"
+		}
+		Start-Sleep -Seconds %d
+		Write-Output "Guest ready for checkpoint"
+	`, h.config.HyperV.CheckpointSettleSeconds)
+	if err := h.ExecuteScriptInVM(vmName, readyScript); err != nil {
+		return fmt.Errorf("checkpoint VM did not become ready: %w", err)
+	}
+
+	saveCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		Save-VM -Name "%s"
+		Export-VM -Name "%s" -Path "%s"
+		Remove-VM -Name "%s" -Force
+		Remove-Item -Path "%s" -Force
+	`, vmName, vmName, exportPath, vmName, vhdxPath)
+	if _, err := h.RunPowerShell(saveCmd); err != nil {
+		return fmt.Errorf("failed to save and export checkpoint VM: %w", err)
+	}
+
+	h.logger.Info("Pre-booted checkpoint ready", "vm_name", vmName, "export_path", exportPath)
+	return nil
+}
+
+// createVMFromCheckpoint imports a copy of the saved checkpoint VM under the slot's name,
+// resumes it and injects the per-slot runner config over PowerShell Direct
+func (h *HyperVManager) createVMFromCheckpoint(slot *VMSlot) error {
+	vmName := slot.Name
+
+	if err := h.ensureCheckpoint(slot); err != nil {
+		return err
+	}
+
+	h.logger.Info("Starting VM creation from checkpoint", "vm_name", vmName, "template_version", slot.TemplateVersion)
+
+	storagePath, err := h.selectStoragePath()
+	if err != nil {
+		return err
+	}
+	slot.StoragePath = storagePath
+
+	// Each slot gets its own directory holding the copied config, saved state and disk
+	// The copied disk is still a differencing disk of the read-only template
+	vmPath := fmt.Sprintf("%s\\%s", storagePath, vmName)
+	networkCmd, err := h.networkAdapterCommands(vmName, slot.Index)
+	if err != nil {
+		return fmt.Errorf("failed to build network configuration: %w", err)
+	}
+	importCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$vmcx = Get-ChildItem -Path "%s\%s\Virtual Machines" -Filter "*.vmcx" | Select-Object -First 1
+		if (-not $vmcx) {
+			throw "No exported checkpoint VM found"
+		}
+		$vm = Import-VM -Path $vmcx.FullName -Copy -GenerateNewId -VirtualMachinePath "%s" -VhdDestinationPath "%s" -SnapshotFilePath "%s" -SmartPagingFilePath "%s"
+		Rename-VM -VM $vm -NewName "%s"
+		Start-VM -Name "%s"
+	`, h.checkpointExportPath(slot.TemplateVersion), h.checkpointVMName(), vmPath, vmPath, vmPath, vmPath, vmName, vmName) + h.ownerCommands(vmName) + h.extraDiskCommands(slot) + networkCmd + h.meteringCommands(vmName)
+	if _, err := h.RunPowerShell(importCmd); err != nil {
+		return fmt.Errorf("failed to restore VM from checkpoint: %w", err)
+	}
+	if err := h.verifyNetworkPolicy(vmName); err != nil {
+		return err
+	}
+
+	h.logger.Info("VM resumed from checkpoint", "vm_name", vmName)
+
+	runnerConfig, err := h.runnerConfigFor(slot)
+	if err != nil {
+		return err
+	}
+	seedScript, err := configSeedScript(runnerConfig)
+	if err != nil {
+		return err
+	}
+
+	h.logger.Debug("Executing configure script in VM", "vm_name", vmName)
+	if err := h.ExecuteScriptInVM(vmName, seedScript+configureRunnerScript); err != nil {
+		return fmt.Errorf("failed to configure runner in VM: %w", err)
+	}
+
+	h.recordIPAddress(slot, runnerConfig)
+	h.logger.Info("Runner configured successfully in VM", "vm_name", vmName, "ip_address", slot.IPAddress)
+	return nil
+}
+
+// configSeedScript returns a guest-side prelude that writes runner-config.json and waits
+// for the hot-added network adapter to get an address before the configure script runs
+// The config is base64 encoded so no quoting survives into the script
+func configSeedScript(config RunnerConfig) (string, error) {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	script := fmt.Sprintf(`
+$seedJson = [System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String("%s"))
+[System.IO.File]::WriteAllText("C:\runner-config.json", $seedJson)
+`, base64.StdEncoding.EncodeToString(configJSON))
+
+	// Static addresses are applied by the configure script itself, so there is no DHCP lease to wait for
+	if len(config.Network) > 0 {
+		return script, nil
+	}
+
+	return script + `
+$networkDeadline = (Get-Date).AddSeconds(120)
+while (-not (Get-NetIPAddress -AddressFamily IPv4 -ErrorAction SilentlyContinue |
+		Where-Object { $_.IPAddress -ne "127.0.0.1" -and -not $_.IPAddress.StartsWith("169.254.") })) {
+	if ((Get-Date) -gt $networkDeadline) {
+		throw "Network adapter did not receive an IPv4 address after resume"
+	}
+	Start-Sleep -Seconds 2
+}
+`, nil
+}
@@ -0,0 +1,172 @@
+package vmmanager
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"hyperv-runner-pool/pkg/config"
+	"hyperv-runner-pool/pkg/seediso"
+)
+
+//go:embed scripts/configure-runner.sh
+var configureRunnerLinuxScript string
+
+// cloudInitVolumeID is the label cloud-init looks for on a NoCloud seed
+const cloudInitVolumeID = "cidata"
+
+// linuxGuest reports whether the template runs Linux
+func (h *HyperVManager) linuxGuest() bool {
+	return h.config.HyperV.GuestOS == config.GuestLinux
+}
+
+// cloudInitInjector attaches a cloud-init NoCloud seed ISO that installs and starts configure-runner.sh
+// The runner config travels on the same ISO, or over KVP when kvp is set
+type cloudInitInjector struct {
+	h   *HyperVManager
+	kvp *kvpInjector
+}
+
+func (c *cloudInitInjector) beforeStart(slot *VMSlot, vhdxPath string, config RunnerConfig) error {
+	files, err := c.h.cloudInitSeedFiles(slot, config, c.kvp == nil)
+	if err != nil {
+		return err
+	}
+	image, err := seediso.Build(cloudInitVolumeID, files)
+	if err != nil {
+		return fmt.Errorf("failed to build cloud-init seed ISO: %w", err)
+	}
+	return c.h.attachSeedISO(slot, image)
+}
+
+func (c *cloudInitInjector) afterStart(slot *VMSlot, config RunnerConfig) error {
+	if c.kvp == nil {
+		return nil
+	}
+	return c.kvp.afterStart(slot, config)
+}
+
+// cloudInitSeedFiles returns the NoCloud seed for a slot: meta-data, user-data that writes
+// configure-runner.sh and starts it as a transient systemd unit, network-config when
+// static addresses are configured, and runner-config.json when includeConfig is set
+func (h *HyperVManager) cloudInitSeedFiles(slot *VMSlot, runnerConfig RunnerConfig, includeConfig bool) ([]seediso.File, error) {
+	// Every VM boots from a fresh child of the template, so the VM name is a unique instance ID
+	metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", slot.Name, slot.Name)
+
+	userData := fmt.Sprintf(`#cloud-config
+write_files:
+  - path: /usr/local/sbin/configure-runner.sh
+    permissions: "0755"
+    encoding: b64
+    content: %s
+runcmd:
+  - [systemd-run, --unit=configure-runner, --collect, /usr/local/sbin/configure-runner.sh]
+`, base64.StdEncoding.EncodeToString([]byte(configureRunnerLinuxScript)))
+
+	files := []seediso.File{
+		{Name: "meta-data", Data: []byte(metaData)},
+		{Name: "user-data", Data: []byte(userData)},
+	}
+
+	networkConfig, err := h.cloudInitNetworkConfig(slot.Index)
+	if err != nil {
+		return nil, err
+	}
+	if networkConfig != "" {
+		files = append(files, seediso.File{Name: "network-config", Data: []byte(networkConfig)})
+	}
+
+	if includeConfig {
+		configJSON, err := json.Marshal(runnerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal config: %w", err)
+		}
+		files = append(files, seediso.File{Name: "runner-config.json", Data: configJSON})
+	}
+	return files, nil
+}
+
+// cloudInitNetworkConfig returns a network-config (version 2) for a slot, or "" to keep DHCP everywhere
+// A network-config replaces the DHCP fallback on every interface, so each adapter is listed,
+// matched by its static MAC address
+func (h *HyperVManager) cloudInitNetworkConfig(slotIndex int) (string, error) {
+	adapters := h.config.HyperV.Network.Adapters
+	static := false
+	for _, adapter := range adapters {
+		static = static || adapter.StaticIP != nil
+	}
+	if !static {
+		return "", nil
+	}
+
+	var cfg strings.Builder
+	cfg.WriteString("version: 2\nethernets:\n")
+	for i, adapter := range adapters {
+		mac, err := adapter.MACAddressForSlot(slotIndex)
+		if err != nil {
+			return "", err
+		}
+		if mac == "" {
+			return "", fmt.Errorf("adapter %q needs a static MAC address for cloud-init network configuration", adapter.Name)
+		}
+		pairs := make([]string, 0, 6)
+		for j := 0; j < len(mac); j += 2 {
+			pairs = append(pairs, strings.ToLower(mac[j:j+2]))
+		}
+
+		fmt.Fprintf(&cfg, "  eth%d:\n    match:\n      macaddress: \"%s\"\n", i, strings.Join(pairs, ":"))
+		if adapter.StaticIP == nil {
+			cfg.WriteString("    dhcp4: true\n")
+			continue
+		}
+
+		addr, err := adapter.StaticIP.AddressForSlot(slotIndex)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&cfg, "    dhcp4: false\n    addresses: [\"%s/%d\"]\n", addr, adapter.StaticIP.PrefixLength)
+		if adapter.StaticIP.Gateway != "" {
+			fmt.Fprintf(&cfg, "    routes:\n      - to: default\n        via: \"%s\"\n", adapter.StaticIP.Gateway)
+		}
+		if len(adapter.StaticIP.DNSServers) > 0 {
+			fmt.Fprintf(&cfg, "    nameservers:\n      addresses: [\"%s\"]\n", strings.Join(adapter.StaticIP.DNSServers, `", "`))
+		}
+	}
+	return cfg.String(), nil
+}
+
+// waitForHeartbeat waits for the guest's heartbeat integration service to report OK
+// Linux guests have no PowerShell Direct, so a running heartbeat is the sign that the guest has
+// booted its Hyper-V daemons; cloud-init configures the runner from there on its own
+func (h *HyperVManager) waitForHeartbeat(vmName string) error {
+	h.logger.Debug("Waiting for guest heartbeat", "vm_name", vmName)
+
+	waitCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$deadline = (Get-Date).AddMinutes(%d)
+		while ($true) {
+			$heartbeat = Get-VMIntegrationService -VMName "%s" -Name "Heartbeat"
+			if ($heartbeat.PrimaryStatusDescription -eq "OK") {
+				Write-Output "HEARTBEAT_OK"
+				break
+			}
+			if ((Get-Date) -gt $deadline) {
+				throw "Guest heartbeat not OK before the deadline (status: $($heartbeat.PrimaryStatusDescription))"
+			}
+			Start-Sleep -Seconds 5
+		}
+	`, h.config.Monitoring.CreationTimeoutMinutes, vmName)
+
+	output, err := h.RunPowerShell(waitCmd)
+	if err != nil {
+		return fmt.Errorf("failed to wait for guest heartbeat: %w", err)
+	}
+	if !strings.Contains(output, "HEARTBEAT_OK") {
+		return fmt.Errorf("%w: guest heartbeat did not report OK: %s", ErrGuestUnreachable, strings.TrimSpace(output))
+	}
+
+	h.logger.Info("Guest heartbeat OK", "vm_name", vmName)
+	return nil
+}
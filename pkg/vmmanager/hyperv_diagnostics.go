@@ -0,0 +1,137 @@
+package vmmanager
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// diagnosticsMetadata describes the VM lifecycle a diagnostics bundle belongs to
+type diagnosticsMetadata struct {
+	VMName          string    `json:"vm_name"`
+	Reason          string    `json:"reason"`
+	TemplateVersion string    `json:"template_version,omitempty"`
+	IPAddress       string    `json:"ip_address,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	CollectedAt     time.Time `json:"collected_at"`
+}
+
+// diagnosticsBundlePath returns the per-slot, per-lifecycle directory for a bundle
+// Lifecycles are named after the VM creation time so they sort chronologically
+func (h *HyperVManager) diagnosticsBundlePath(slot *VMSlot, now time.Time) (slotDir, bundleDir string) {
+	createdAt := slot.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	slotDir = fmt.Sprintf("%s\\%s", h.config.HyperV.Diagnostics.Path, slot.Name)
+	return slotDir, fmt.Sprintf("%s\\%s", slotDir, createdAt.UTC().Format("20060102-150405"))
+}
+
+// CollectDiagnostics pulls the runner _diag logs, the configure script transcript and
+// selected event logs out of a running guest over PowerShell Direct
+// Returns the directory the bundle was written to
+func (h *HyperVManager) CollectDiagnostics(slot *VMSlot, reason string) (string, error) {
+	diagnostics := h.config.HyperV.Diagnostics
+	now := time.Now()
+	slotDir, bundleDir := h.diagnosticsBundlePath(slot, now)
+
+	metadataJSON, err := json.MarshalIndent(diagnosticsMetadata{
+		VMName:          slot.Name,
+		Reason:          reason,
+		TemplateVersion: slot.TemplateVersion,
+		IPAddress:       slot.IPAddress,
+		CreatedAt:       slot.CreatedAt,
+		CollectedAt:     now,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal diagnostics metadata: %w", err)
+	}
+
+	h.logger.Info("Collecting guest diagnostics", "vm_name", slot.Name, "bundle", bundleDir, "reason", reason)
+
+	// Retention runs first so a guest that never answers still leaves a bounded number of bundles
+	// The guest is reached from a background job so a hung VM cannot block teardown indefinitely
+	collectCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$slotDir = "%s"
+		$bundleDir = "%s"
+		New-Item -Path $bundleDir -ItemType Directory -Force | Out-Null
+		[System.IO.File]::WriteAllBytes("$bundleDir\metadata.json", [System.Convert]::FromBase64String("%s"))
+
+		$bundles = @(Get-ChildItem -Path $slotDir -Directory | Sort-Object Name -Descending)
+		$bundles | Select-Object -Skip %d | Remove-Item -Recurse -Force -ErrorAction SilentlyContinue
+		$maxAgeDays = %d
+		if ($maxAgeDays -gt 0) {
+			$bundles | Where-Object { $_.FullName -ne $bundleDir -and $_.CreationTime -lt (Get-Date).AddDays(-$maxAgeDays) } |
+				Remove-Item -Recurse -Force -ErrorAction SilentlyContinue
+		}
+
+		$job = Start-Job -ScriptBlock {
+			param($vmName, $username, $password, $bundleDir, $eventLogs, $maxEvents)
+			$ErrorActionPreference = "Stop"
+			$securePassword = ConvertTo-SecureString $password -AsPlainText -Force
+			$credential = New-Object System.Management.Automation.PSCredential ($username, $securePassword)
+
+			$session = New-PSSession -VMName $vmName -Credential $credential
+			try {
+				Invoke-Command -Session $session -ScriptBlock {
+					param($eventLogs, $maxEvents)
+					$ErrorActionPreference = "Stop"
+					$staging = "C:\Windows\Temp\runner-diagnostics"
+					Remove-Item -Path $staging -Recurse -Force -ErrorAction SilentlyContinue
+					New-Item -Path "$staging\eventlogs" -ItemType Directory -Force | Out-Null
+
+					if (Test-Path "C:\actions-runner\_diag") {
+						Copy-Item -Path "C:\actions-runner\_diag" -Destination "$staging\_diag" -Recurse
+					}
+					if (Test-Path "C:\configure-runner.log") {
+						Copy-Item -Path "C:\configure-runner.log" -Destination "$staging\configure-runner.log"
+					}
+					foreach ($log in $eventLogs) {
+						$file = Join-Path "$staging\eventlogs" (($log -replace "[\\/:*?<>|]", "_") + ".txt")
+						Get-WinEvent -LogName $log -MaxEvents $maxEvents -ErrorAction SilentlyContinue |
+							Format-List TimeCreated, Id, LevelDisplayName, ProviderName, Message |
+							Out-File -FilePath $file -Width 4096
+					}
+
+					Compress-Archive -Path "$staging\*" -DestinationPath "C:\Windows\Temp\runner-diagnostics.zip" -Force
+					Remove-Item -Path $staging -Recurse -Force -ErrorAction SilentlyContinue
+				} -ArgumentList $eventLogs, $maxEvents
+
+				Copy-Item -FromSession $session -Path "C:\Windows\Temp\runner-diagnostics.zip" -Destination "$bundleDir\guest.zip"
+			} finally {
+				Remove-PSSession $session
+			}
+		} -ArgumentList "%s", "%s", "%s", $bundleDir, @(%s), %d
+
+		if (-not (Wait-Job $job -Timeout %d)) {
+			Stop-Job $job
+			Remove-Job $job -Force
+			throw "Guest did not answer within %d seconds"
+		}
+		if ($job.State -eq "Failed") {
+			$reason = $job.ChildJobs[0].JobStateInfo.Reason
+			Remove-Job $job -Force
+			throw "Collecting guest files failed: $reason"
+		}
+		Remove-Job $job -Force
+		Write-Output "DIAGNOSTICS_BUNDLE:$bundleDir"
+	`, slotDir, bundleDir, base64.StdEncoding.EncodeToString(metadataJSON),
+		diagnostics.KeepPerSlot, diagnostics.MaxAgeDays,
+		slot.Name, h.config.HyperV.VMUsername, h.config.HyperV.VMPassword,
+		psStringList(diagnostics.EventLogs), diagnostics.MaxEvents,
+		diagnostics.TimeoutSeconds, diagnostics.TimeoutSeconds)
+
+	output, err := h.RunPowerShell(collectCmd)
+	if err != nil {
+		return bundleDir, fmt.Errorf("failed to collect diagnostics: %w", guestError(err))
+	}
+	if !strings.Contains(output, "DIAGNOSTICS_BUNDLE:") {
+		return bundleDir, fmt.Errorf("diagnostics collection did not complete: %s", output)
+	}
+
+	h.logger.Info("Guest diagnostics collected", "vm_name", slot.Name, "bundle", bundleDir)
+	return bundleDir, nil
+}
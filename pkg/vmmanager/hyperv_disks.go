@@ -0,0 +1,56 @@
+package vmmanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// toolCacheDiskPath returns the path of a slot's differencing disk of the shared tool cache
+func (h *HyperVManager) toolCacheDiskPath(slot *VMSlot) string {
+	return fmt.Sprintf("%s\\%s-toolcache.vhdx", h.storagePathFor(slot), slot.Name)
+}
+
+// scratchDiskPath returns the path of a slot's scratch disk
+func (h *HyperVManager) scratchDiskPath(slot *VMSlot) string {
+	return fmt.Sprintf("%s\\%s-scratch.vhdx", h.storagePathFor(slot), slot.Name)
+}
+
+// extraDiskCommands returns the PowerShell that creates and attaches the tool cache and scratch disks
+// The tool cache is attached through a per-slot differencing disk, so the shared VHDX stays
+// read-only and in use by every VM at once while guest writes are discarded with the VM
+func (h *HyperVManager) extraDiskCommands(slot *VMSlot) string {
+	disks := h.config.HyperV.Disks
+	var cmd strings.Builder
+
+	if disks.ToolCache.Path != "" {
+		diskPath := h.toolCacheDiskPath(slot)
+		fmt.Fprintf(&cmd, `
+		New-VHD -ParentPath "%s" -Path "%s" -Differencing -ErrorAction Stop | Out-Null
+		Add-VMHardDiskDrive -VMName "%s" -Path "%s" -ErrorAction Stop`,
+			disks.ToolCache.Path, diskPath, slot.Name, diskPath)
+	}
+
+	if disks.Scratch.SizeGB > 0 {
+		diskPath := h.scratchDiskPath(slot)
+		fmt.Fprintf(&cmd, `
+		New-VHD -Path "%s" -SizeBytes %dGB -Dynamic -ErrorAction Stop | Out-Null
+		Add-VMHardDiskDrive -VMName "%s" -Path "%s" -ErrorAction Stop`,
+			diskPath, disks.Scratch.SizeGB, slot.Name, diskPath)
+	}
+
+	if cmd.Len() == 0 {
+		return ""
+	}
+	return cmd.String() + "\n"
+}
+
+// applyDiskLetters tells the guest which drive letters the extra disks get
+func (h *HyperVManager) applyDiskLetters(runnerConfig *RunnerConfig) {
+	disks := h.config.HyperV.Disks
+	if disks.ToolCache.Path != "" {
+		runnerConfig.ToolCacheDrive = disks.ToolCache.DriveLetter
+	}
+	if disks.Scratch.SizeGB > 0 {
+		runnerConfig.ScratchDrive = disks.Scratch.DriveLetter
+	}
+}
@@ -0,0 +1,111 @@
+package vmmanager
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CollectGarbage removes pool VMs and per-slot files that no slot owns any more, and dismounts
+// slot disks left mounted on the host, e.g. by a failed config injection
+// A slot owns the VM under its name and the files in its storage path (any path while unknown)
+// Only resources older than the grace period are touched, so VMs and disks being created are safe
+// Returns a description of every removed or dismounted resource
+func (h *HyperVManager) CollectGarbage(namePrefix string, slots []*VMSlot, gracePeriod time.Duration) ([]string, error) {
+	var owned strings.Builder
+	for _, slot := range slots {
+		fmt.Fprintf(&owned, `
+		$owned["%s"] = "%s"`, slot.Name, slot.StoragePath)
+	}
+
+	gcCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Continue"
+		$namePrefix = "%s"
+		$storagePaths = @(%s)
+		$cutoff = (Get-Date).AddSeconds(-%d)
+		$owned = @{}%s
+		$keep = @{}%s
+
+		# Pool VMs without a slot, e.g. beyond a reduced pool size
+		$vms = Get-VM | Where-Object { $_.Name -match "^$([regex]::Escape($namePrefix))\d+$" }
+		foreach ($vm in $vms) {
+			if ($owned.ContainsKey($vm.Name) -or -not %s -or $vm.CreationTime -gt $cutoff) {
+				$keep[$vm.Name] = $true
+				continue
+			}
+			try {
+				Stop-VM -VM $vm -TurnOff -Force -ErrorAction SilentlyContinue
+				Remove-VM -VM $vm -Force -ErrorAction Stop
+				Write-Output "GC_REMOVED:VM $($vm.Name)"
+			} catch {
+				$keep[$vm.Name] = $true
+				Write-Output "GC_FAILED:VM $($vm.Name): $_"
+			}
+		}
+
+		# Slot disks still mounted on the host; injection takes seconds, so an old mount is stale
+		foreach ($disk in Get-Disk | Where-Object { $_.Location -like "*.vhdx" }) {
+			$file = Get-Item -Path $disk.Location -ErrorAction SilentlyContinue
+			if (-not $file -or $storagePaths -notcontains $file.DirectoryName -or $file.LastWriteTime -gt $cutoff) {
+				continue
+			}
+			if ($file.BaseName -match "^$([regex]::Escape($namePrefix))\d+(-toolcache|-scratch)?$") {
+				try {
+					Dismount-VHD -Path $file.FullName -ErrorAction Stop
+					Write-Output "GC_DISMOUNTED:$($file.FullName)"
+				} catch {
+					Write-Output "GC_FAILED:$($file.FullName): $_"
+				}
+			}
+		}
+
+		# Disks, seed ISOs and checkpoint directories of slots whose files live elsewhere or do not exist
+		foreach ($storagePath in $storagePaths) {
+			if (-not (Test-Path $storagePath)) {
+				continue
+			}
+			foreach ($item in Get-ChildItem -Path $storagePath -ErrorAction SilentlyContinue) {
+				if ($item.PSIsContainer) {
+					$orphan = $item.Name -match "^($([regex]::Escape($namePrefix))\d+)$"
+				} else {
+					$orphan = $item.Name -match "^($([regex]::Escape($namePrefix))\d+)((-toolcache|-scratch)?\.vhdx|-seed\.iso)$"
+				}
+				if (-not $orphan -or $item.LastWriteTime -gt $cutoff) {
+					continue
+				}
+				$name = $Matches[1]
+				if ($keep[$name] -or ($owned.ContainsKey($name) -and $owned[$name] -in @("", $storagePath))) {
+					continue
+				}
+				# A VM that still uses the file, e.g. one of another instance, keeps it in use
+				if (Get-VM -Name $name -ErrorAction SilentlyContinue) {
+					continue
+				}
+				try {
+					Remove-Item -Path $item.FullName -Recurse -Force -ErrorAction Stop
+					Write-Output "GC_REMOVED:$($item.FullName)"
+				} catch {
+					Write-Output "GC_FAILED:$($item.FullName): $_"
+				}
+			}
+		}
+	`, namePrefix, psStringList(h.storagePaths()), int(gracePeriod.Seconds()), owned.String(), h.adoptUntaggedCommand(namePrefix), h.ownedOrAdoptedCondition("$vm"))
+
+	output, err := h.RunPowerShell(gcCmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect garbage: %w", err)
+	}
+
+	var collected []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if resource, ok := strings.CutPrefix(line, "GC_REMOVED:"); ok {
+			collected = append(collected, "removed "+resource)
+		} else if resource, ok := strings.CutPrefix(line, "GC_DISMOUNTED:"); ok {
+			collected = append(collected, "dismounted "+resource)
+		} else if failure, ok := strings.CutPrefix(line, "GC_FAILED:"); ok {
+			h.logger.Warn("Garbage collection failed to remove a resource", "resource", failure)
+		}
+	}
+	return collected, nil
+}
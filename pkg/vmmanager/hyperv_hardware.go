@@ -0,0 +1,54 @@
+package vmmanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hardwareProfileCommands returns the PowerShell that applies the configured hardware profile
+// to a newly created, stopped VM
+func (h *HyperVManager) hardwareProfileCommands(vmName string) string {
+	hw := h.config.HyperV.Hardware
+	var cmd strings.Builder
+
+	if dm := hw.DynamicMemory; dm.Enabled {
+		fmt.Fprintf(&cmd, `
+		Set-VMMemory -VMName "%s" -DynamicMemoryEnabled $true -MinimumBytes %dMB -StartupBytes %dMB -MaximumBytes %dMB -Buffer %d`,
+			vmName, dm.MinimumMB, h.config.HyperV.VMMemoryMB, dm.MaximumMB, dm.BufferPercent)
+	}
+
+	if hw.NestedVirtualization {
+		fmt.Fprintf(&cmd, `
+		Set-VMProcessor -VMName "%s" -ExposeVirtualizationExtensions $true`, vmName)
+	}
+	if hw.ProcessorCompatibility {
+		fmt.Fprintf(&cmd, `
+		Set-VMProcessor -VMName "%s" -CompatibilityForMigrationEnabled $true`, vmName)
+	}
+
+	if !hw.SecureBootEnabled() {
+		fmt.Fprintf(&cmd, `
+		Set-VMFirmware -VMName "%s" -EnableSecureBoot Off`, vmName)
+	} else if hw.SecureBootTemplate != "" {
+		fmt.Fprintf(&cmd, `
+		Set-VMFirmware -VMName "%s" -EnableSecureBoot On -SecureBootTemplate "%s"`, vmName, hw.SecureBootTemplate)
+	}
+
+	// A vTPM needs a key protector; the local one is backed by the host's untrusted guardian
+	if hw.VTPM {
+		fmt.Fprintf(&cmd, `
+		Set-VMKeyProtector -VMName "%s" -NewLocalKeyProtector
+		Enable-VMTPM -VMName "%s"`, vmName, vmName)
+	}
+
+	if hw.CheckpointType != "" {
+		fmt.Fprintf(&cmd, `
+		Set-VM -Name "%s" -CheckpointType %s`, vmName, hw.CheckpointType)
+	}
+
+	if cmd.Len() == 0 {
+		return ""
+	}
+	cmd.WriteString("\n")
+	return cmd.String()
+}
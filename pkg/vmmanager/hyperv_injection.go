@@ -0,0 +1,235 @@
+package vmmanager
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"hyperv-runner-pool/pkg/config"
+	"hyperv-runner-pool/pkg/seediso"
+)
+
+const (
+	// seedISOVolumeID labels the seed ISO so the guest can tell it apart from other media
+	seedISOVolumeID = "RUNNERSEED"
+
+	// kvpChunkSize keeps each KVP value well below the 2048 byte limit of the data exchange
+	kvpChunkSize = 800
+)
+
+// configInjector delivers runner-config.json to a cold-booted guest
+// The Windows configure script looks for the config on C:\, on any DVD drive and in the KVP registry key
+type configInjector interface {
+	// beforeStart runs once the VM exists, before it is started
+	beforeStart(slot *VMSlot, vhdxPath string, config RunnerConfig) error
+	// afterStart runs once the VM is running, before the configure script
+	afterStart(slot *VMSlot, config RunnerConfig) error
+}
+
+// newConfigInjector returns the injection strategy selected in the Hyper-V config
+func (h *HyperVManager) newConfigInjector() configInjector {
+	if h.linuxGuest() {
+		injector := &cloudInitInjector{h: h}
+		if h.config.HyperV.ConfigInjection == config.InjectionKVP {
+			injector.kvp = &kvpInjector{h: h}
+		}
+		return injector
+	}
+
+	switch h.config.HyperV.ConfigInjection {
+	case config.InjectionISO:
+		return &isoInjector{h: h}
+	case config.InjectionKVP:
+		return &kvpInjector{h: h}
+	case config.InjectionCopyFile:
+		return &copyFileInjector{h: h}
+	default:
+		return &mountInjector{h: h}
+	}
+}
+
+// mountInjector writes the config onto the differencing disk by mounting it on the host
+type mountInjector struct {
+	h *HyperVManager
+}
+
+func (m *mountInjector) beforeStart(slot *VMSlot, vhdxPath string, config RunnerConfig) error {
+	return m.h.InjectConfig(vhdxPath, config)
+}
+
+func (m *mountInjector) afterStart(slot *VMSlot, config RunnerConfig) error {
+	return nil
+}
+
+// isoInjector attaches a generated seed ISO holding the config as a DVD drive
+type isoInjector struct {
+	h *HyperVManager
+}
+
+func (i *isoInjector) beforeStart(slot *VMSlot, vhdxPath string, config RunnerConfig) error {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	image, err := seediso.Build(seedISOVolumeID, []seediso.File{{Name: "runner-config.json", Data: configJSON}})
+	if err != nil {
+		return fmt.Errorf("failed to build seed ISO: %w", err)
+	}
+	return i.h.attachSeedISO(slot, image)
+}
+
+func (i *isoInjector) afterStart(slot *VMSlot, config RunnerConfig) error {
+	return nil
+}
+
+// attachSeedISO writes a seed ISO next to the slot's disk and attaches it as a DVD drive
+func (h *HyperVManager) attachSeedISO(slot *VMSlot, image []byte) error {
+	isoPath := h.seedISOPath(slot)
+	attachCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		[System.IO.File]::WriteAllBytes("%s", [System.Convert]::FromBase64String("%s"))
+		Add-VMDvdDrive -VMName "%s" -Path "%s"
+	`, isoPath, base64.StdEncoding.EncodeToString(image), slot.Name, isoPath)
+	if _, err := h.RunPowerShell(attachCmd); err != nil {
+		return fmt.Errorf("failed to attach seed ISO: %w", err)
+	}
+
+	h.logger.Debug("Seed ISO attached", "vm_name", slot.Name, "iso_path", isoPath, "size_bytes", len(image))
+	return nil
+}
+
+// seedISOPath returns where a slot's seed ISO is stored, next to its differencing disk
+func (h *HyperVManager) seedISOPath(slot *VMSlot) string {
+	return fmt.Sprintf("%s\\%s-seed.iso", h.storagePathFor(slot), slot.Name)
+}
+
+// kvpInjector pushes the config to the guest through Hyper-V KVP data exchange
+// The guest sees host-to-guest items under HKLM:\SOFTWARE\Microsoft\Virtual Machine\External
+type kvpInjector struct {
+	h *HyperVManager
+}
+
+func (k *kvpInjector) beforeStart(slot *VMSlot, vhdxPath string, config RunnerConfig) error {
+	return nil
+}
+
+func (k *kvpInjector) afterStart(slot *VMSlot, config RunnerConfig) error {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	var items strings.Builder
+	chunks := kvpChunks(base64.StdEncoding.EncodeToString(configJSON))
+	fmt.Fprintf(&items, `
+		$items["runner-config-chunks"] = "%d"`, len(chunks))
+	for n, chunk := range chunks {
+		fmt.Fprintf(&items, `
+		$items["runner-config-%d"] = "%s"`, n, chunk)
+	}
+
+	kvpCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$items = [ordered]@{}
+		%s
+
+		$vmms = Get-WmiObject -Namespace root\virtualization\v2 -Class Msvm_VirtualSystemManagementService
+		$vm = Get-WmiObject -Namespace root\virtualization\v2 -Class Msvm_ComputerSystem -Filter "ElementName='%s'"
+		if (-not $vm) {
+			throw "VM not found"
+		}
+
+		foreach ($item in $items.GetEnumerator()) {
+			$kvp = ([WMIClass]"root\virtualization\v2:Msvm_KvpExchangeDataItem").CreateInstance()
+			$kvp.Name = $item.Key
+			$kvp.Data = $item.Value
+			$kvp.Source = 0
+			$result = $vmms.AddKvpItems($vm, $kvp.PSBase.GetText(1))
+
+			# 4096 means the change runs as a job
+			if ($result.ReturnValue -eq 4096) {
+				$job = [WMI]$result.Job
+				while ($job.JobState -eq 3 -or $job.JobState -eq 4) {
+					Start-Sleep -Milliseconds 200
+					$job = [WMI]$result.Job
+				}
+				if ($job.JobState -ne 7) {
+					throw "Adding KVP item $($item.Key) failed: $($job.ErrorDescription)"
+				}
+			} elseif ($result.ReturnValue -ne 0) {
+				throw "Adding KVP item $($item.Key) failed with code $($result.ReturnValue)"
+			}
+		}
+		Write-Output "KVP items added: $($items.Count)"
+	`, items.String(), slot.Name)
+	if _, err := k.h.RunPowerShell(kvpCmd); err != nil {
+		return fmt.Errorf("failed to add KVP items: %w", err)
+	}
+
+	k.h.logger.Debug("Runner config pushed over KVP", "vm_name", slot.Name, "chunks", len(chunks))
+	return nil
+}
+
+// kvpChunks splits an encoded config into values that fit in single KVP items
+func kvpChunks(encoded string) []string {
+	var chunks []string
+	for len(encoded) > kvpChunkSize {
+		chunks = append(chunks, encoded[:kvpChunkSize])
+		encoded = encoded[kvpChunkSize:]
+	}
+	return append(chunks, encoded)
+}
+
+// copyFileInjector copies the config into the running guest with Copy-VMFile
+// This uses the Guest Service Interface integration service, which is enabled before boot
+type copyFileInjector struct {
+	h *HyperVManager
+}
+
+func (c *copyFileInjector) beforeStart(slot *VMSlot, vhdxPath string, config RunnerConfig) error {
+	enableCmd := fmt.Sprintf(`Enable-VMIntegrationService -VMName "%s" -Name "Guest Service Interface"`, slot.Name)
+	if _, err := c.h.RunPowerShell(enableCmd); err != nil {
+		return fmt.Errorf("failed to enable guest service interface: %w", err)
+	}
+	return nil
+}
+
+func (c *copyFileInjector) afterStart(slot *VMSlot, config RunnerConfig) error {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	// The guest services only answer once the guest has booted, so the copy is retried
+	copyCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$source = Join-Path $env:TEMP "runner-config-%s.json"
+		[System.IO.File]::WriteAllBytes($source, [System.Convert]::FromBase64String("%s"))
+
+		try {
+			$deadline = (Get-Date).AddSeconds(300)
+			while ($true) {
+				try {
+					Copy-VMFile -Name "%s" -SourcePath $source -DestinationPath "C:\runner-config.json" -FileSource Host -CreateFullPath -Force
+					break
+				} catch {
+					if ((Get-Date) -gt $deadline) {
+						throw "Copy-VMFile did not succeed before the deadline: $_"
+					}
+					Start-Sleep -Seconds 5
+				}
+			}
+		} finally {
+			Remove-Item -Path $source -Force -ErrorAction SilentlyContinue
+		}
+		Write-Output "Config copied into guest"
+	`, slot.Name, base64.StdEncoding.EncodeToString(configJSON), slot.Name)
+	if _, err := c.h.RunPowerShell(copyCmd); err != nil {
+		return fmt.Errorf("failed to copy config into VM: %w", err)
+	}
+
+	c.h.logger.Debug("Runner config copied into VM", "vm_name", slot.Name)
+	return nil
+}
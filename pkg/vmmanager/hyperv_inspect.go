@@ -0,0 +1,64 @@
+package vmmanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// vmNotFoundMarker is printed by findVMCommand when the VM does not exist
+const vmNotFoundMarker = "VM_NOT_FOUND"
+
+// findVMCommand returns the PowerShell that loads a VM into $vm, or prints vmNotFoundMarker and
+// stops the script when there is no VM of that name
+// Get-VM -Name fails the same way for a missing VM as for an unreachable VMMS, so all VMs are
+// listed instead and only a successful listing without the VM counts as not found
+func findVMCommand(vmName string) string {
+	return fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$vm = Get-VM | Where-Object { $_.Name -eq "%s" }
+		if (-not $vm) {
+			Write-Output "%s"
+			return
+		}`, vmName, vmNotFoundMarker)
+}
+
+// vmNotFound reports whether a findVMCommand script stopped because the VM does not exist
+func vmNotFound(output string) bool {
+	return strings.TrimSpace(output) == vmNotFoundMarker
+}
+
+// InspectVM returns the startup memory, processor count and attached disks of a VM
+// Returns ErrVMNotFound when the VM no longer exists
+func (h *HyperVManager) InspectVM(vmName string) (VMHardware, error) {
+	inspectCmd := findVMCommand(vmName) + `
+		Write-Output "MEMORY_MB:$($vm.MemoryStartup / 1MB)"
+		Write-Output "CPU_COUNT:$($vm.ProcessorCount)"
+		foreach ($drive in Get-VMHardDiskDrive -VM $vm) {
+			Write-Output "DISK:$($drive.Path)"
+		}
+	`
+	output, err := h.RunPowerShell(inspectCmd)
+	if err != nil {
+		return VMHardware{}, fmt.Errorf("failed to inspect VM: %w", err)
+	}
+	if vmNotFound(output) {
+		return VMHardware{}, fmt.Errorf("%w: %s", ErrVMNotFound, vmName)
+	}
+
+	var hardware VMHardware
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "MEMORY_MB:"); ok {
+			hardware.MemoryMB, _ = strconv.ParseInt(value, 10, 64)
+		} else if value, ok := strings.CutPrefix(line, "CPU_COUNT:"); ok {
+			hardware.CPUCount, _ = strconv.Atoi(value)
+		} else if path, ok := strings.CutPrefix(line, "DISK:"); ok && path != "" {
+			hardware.Disks = append(hardware.Disks, path)
+		}
+	}
+	if hardware.MemoryMB == 0 || hardware.CPUCount == 0 {
+		return VMHardware{}, fmt.Errorf("unexpected VM inspection output: %s", strings.TrimSpace(output))
+	}
+	return hardware, nil
+}
@@ -0,0 +1,85 @@
+package vmmanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// meteringCommands returns the PowerShell that starts resource metering on a new VM
+func (h *HyperVManager) meteringCommands(vmName string) string {
+	if !h.config.HyperV.Metering.Enabled {
+		return ""
+	}
+	return fmt.Sprintf(`
+		Enable-VMResourceMetering -VMName "%s"
+	`, vmName)
+}
+
+// MeasureVM samples a VM's resource metering counters
+// Network traffic is summed over the default metering ACLs, which cover all IPv4 and IPv6 addresses
+func (h *HyperVManager) MeasureVM(slot *VMSlot) (ResourceUsage, error) {
+	measureCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$vm = Get-VM -Name "%s"
+		$report = Measure-VM -VM $vm
+		$traffic = @($report.NetworkMeteredTrafficReport)
+		$inbound = ($traffic | Where-Object { $_.Direction -eq "Inbound" } | Measure-Object -Property TotalTraffic -Sum).Sum
+		$outbound = ($traffic | Where-Object { $_.Direction -eq "Outbound" } | Measure-Object -Property TotalTraffic -Sum).Sum
+		Write-Output ("METER:{0}|{1}|{2}|{3}|{4}|{5}|{6}|{7}|{8}" -f
+			[int64]$report.MeteringDuration.TotalSeconds,
+			[int64]$report.AvgCPU,
+			[int64]$report.AvgRAM,
+			[int64]$report.MaxRAM,
+			[int64]($vm.MemoryDemand / 1MB),
+			[int64]$report.AggregatedDiskDataRead,
+			[int64]$report.AggregatedDiskDataWritten,
+			[int64]$inbound,
+			[int64]$outbound)
+	`, slot.Name)
+
+	output, err := h.RunPowerShell(measureCmd)
+	if err != nil {
+		return ResourceUsage{}, fmt.Errorf("failed to measure VM: %w", err)
+	}
+	return parseMeterOutput(output, time.Now())
+}
+
+// parseMeterOutput reads the METER: line written by MeasureVM
+func parseMeterOutput(output string, sampledAt time.Time) (ResourceUsage, error) {
+	for _, line := range strings.Split(output, "\n") {
+		fields, ok := strings.CutPrefix(strings.TrimSpace(line), "METER:")
+		if !ok {
+			continue
+		}
+
+		parts := strings.Split(fields, "|")
+		if len(parts) != 9 {
+			return ResourceUsage{}, fmt.Errorf("unexpected metering output: %q", line)
+		}
+		values := make([]int64, len(parts))
+		for i, part := range parts {
+			value, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return ResourceUsage{}, fmt.Errorf("unexpected metering output: %q", line)
+			}
+			values[i] = value
+		}
+
+		return ResourceUsage{
+			SampledAt:          sampledAt,
+			MeteredFor:         time.Duration(values[0]) * time.Second,
+			AvgCPUMHz:          values[1],
+			AvgMemoryMB:        values[2],
+			MaxMemoryMB:        values[3],
+			MemoryDemandMB:     values[4],
+			PeakMemoryDemandMB: values[4],
+			DiskReadMB:         values[5],
+			DiskWrittenMB:      values[6],
+			NetworkInboundMB:   values[7],
+			NetworkOutboundMB:  values[8],
+		}, nil
+	}
+	return ResourceUsage{}, fmt.Errorf("no metering data in output: %s", output)
+}
@@ -0,0 +1,99 @@
+package vmmanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// networkAdapterCommands returns the PowerShell that attaches the configured network adapters to a VM
+// and applies the network policy to each of them
+// Device naming exposes each adapter name to the guest so static addressing can find it
+func (h *HyperVManager) networkAdapterCommands(vmName string, slotIndex int) (string, error) {
+	var cmd strings.Builder
+
+	for _, adapter := range h.config.HyperV.Network.Adapters {
+		mac, err := adapter.MACAddressForSlot(slotIndex)
+		if err != nil {
+			return "", err
+		}
+
+		macArg := ""
+		if mac != "" {
+			macArg = fmt.Sprintf(` -StaticMacAddress "%s"`, mac)
+		}
+		fmt.Fprintf(&cmd, `
+		Add-VMNetworkAdapter -VMName "%s" -Name "%s" -SwitchName "%s" -DeviceNaming On%s`,
+			vmName, adapter.Name, adapter.SwitchName, macArg)
+
+		if adapter.VLANID != 0 {
+			fmt.Fprintf(&cmd, `
+		Set-VMNetworkAdapterVlan -VMName "%s" -VMNetworkAdapterName "%s" -Access -VlanId %d`,
+				vmName, adapter.Name, adapter.VLANID)
+		}
+		if adapter.MACAddressSpoofing {
+			fmt.Fprintf(&cmd, `
+		Set-VMNetworkAdapter -VMName "%s" -Name "%s" -MacAddressSpoofing On`,
+				vmName, adapter.Name)
+		}
+		cmd.WriteString(h.networkPolicyCommands(vmName, adapter.Name))
+	}
+	cmd.WriteString("\n")
+
+	return cmd.String(), nil
+}
+
+// staticAddressesFor returns the static IPv4 settings the guest applies for a slot
+func (h *HyperVManager) staticAddressesFor(slotIndex int) ([]AdapterAddress, error) {
+	var addresses []AdapterAddress
+
+	for _, adapter := range h.config.HyperV.Network.Adapters {
+		if adapter.StaticIP == nil {
+			continue
+		}
+
+		addr, err := adapter.StaticIP.AddressForSlot(slotIndex)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, AdapterAddress{
+			AdapterName:  adapter.Name,
+			IPAddress:    addr.String(),
+			PrefixLength: adapter.StaticIP.PrefixLength,
+			Gateway:      adapter.StaticIP.Gateway,
+			DNSServers:   adapter.StaticIP.DNSServers,
+		})
+	}
+
+	return addresses, nil
+}
+
+// recordIPAddress stores the VM's primary IPv4 address on the slot
+// Static addresses are known up front; DHCP addresses are read from the integration services
+func (h *HyperVManager) recordIPAddress(slot *VMSlot, runnerConfig RunnerConfig) {
+	if len(h.config.HyperV.Network.Adapters) == 0 {
+		return
+	}
+
+	primary := h.config.HyperV.Network.Adapters[0].Name
+	for _, addr := range runnerConfig.Network {
+		if addr.AdapterName == primary {
+			slot.IPAddress = addr.IPAddress
+			return
+		}
+	}
+
+	cmd := fmt.Sprintf(`
+		$adapter = Get-VMNetworkAdapter -VMName "%s" -Name "%s"
+		$adapter.IPAddresses | Where-Object { $_ -match '^\d+\.\d+\.\d+\.\d+$' } | Select-Object -First 1
+	`, slot.Name, primary)
+	output, err := h.RunPowerShell(cmd)
+	if err != nil {
+		h.logger.Warn("Failed to read VM IP address", "vm_name", slot.Name, "error", err)
+		return
+	}
+
+	slot.IPAddress = strings.TrimSpace(output)
+	if slot.IPAddress == "" {
+		h.logger.Warn("VM has not reported an IPv4 address yet", "vm_name", slot.Name)
+	}
+}
@@ -0,0 +1,44 @@
+package vmmanager
+
+import "fmt"
+
+// ownerTagPrefix starts the owner tag of every orchestrator instance
+const ownerTagPrefix = "hyperv-runner-pool owner="
+
+// ownerTag is the line in a VM's Notes that marks it as created by this orchestrator instance
+// Cleanup and quarantine pruning leave VMs without it alone, so instances sharing a host or
+// a name prefix never remove each other's VMs
+func (h *HyperVManager) ownerTag() string {
+	return ownerTagPrefix + h.config.Runners.InstanceID
+}
+
+// ownerCommands returns the PowerShell that tags a new VM with the owner tag
+func (h *HyperVManager) ownerCommands(vmName string) string {
+	return fmt.Sprintf(`
+		Set-VM -Name "%s" -Notes "%s"
+	`, vmName, h.ownerTag())
+}
+
+// ownedCondition returns a PowerShell condition that is true when the VM in vmVar carries the owner tag
+func (h *HyperVManager) ownedCondition(vmVar string) string {
+	return fmt.Sprintf(`(@(%s.Notes -split "\r?\n") -contains "%s")`, vmVar, h.ownerTag())
+}
+
+// adoptUntaggedCommand returns the PowerShell that sets $adoptUntagged when no pool VM on the host
+// carries the owner tag of another instance
+// Pool VMs created before owner tags existed carry none, so after an upgrade they are adopted
+// instead of blocking their slots, unless another instance already tags its VMs
+func (h *HyperVManager) adoptUntaggedCommand(namePrefix string) string {
+	return fmt.Sprintf(`
+		$adoptUntagged = -not (Get-VM | Where-Object {
+			$_.Name -match "^$([regex]::Escape("%s"))\d+$" -and
+				(@($_.Notes -split "\r?\n") | Where-Object { $_ -like "%s*" -and $_ -ne "%s" })
+		})`, namePrefix, ownerTagPrefix, h.ownerTag())
+}
+
+// ownedOrAdoptedCondition returns a PowerShell condition that is true when the VM in vmVar carries
+// the owner tag, or no owner tag at all while $adoptUntagged is set
+func (h *HyperVManager) ownedOrAdoptedCondition(vmVar string) string {
+	return fmt.Sprintf(`(%s -or ($adoptUntagged -and -not (@(%s.Notes -split "\r?\n") -like "%s*")))`,
+		h.ownedCondition(vmVar), vmVar, ownerTagPrefix)
+}
@@ -2,16 +2,33 @@ package vmmanager
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"strings"
-	"syscall"
 	"time"
 )
 
-// RunPowerShell executes a PowerShell command by writing it to a temp file and executing it
-// This approach is more robust than -Command for multi-line scripts and avoids escaping issues
+// CommandExecutor runs PowerShell scripts on a Hyper-V host
+// Every host operation is built as a script and handed to the executor, so tests can
+// capture the generated scripts and feed back canned output
+type CommandExecutor interface {
+	Run(script string) (string, error)
+}
+
+// RunPowerShell executes a PowerShell command on the Hyper-V host
 func (h *HyperVManager) RunPowerShell(command string) (string, error) {
+	return h.executor.Run(command)
+}
+
+// localPowerShell runs scripts with powershell.exe on the local machine
+type localPowerShell struct {
+	logger *slog.Logger
+}
+
+// Run executes a PowerShell command by writing it to a temp file and executing it
+// This approach is more robust than -Command for multi-line scripts and avoids escaping issues
+func (p *localPowerShell) Run(command string) (string, error) {
 	// Create a temporary PowerShell script file
 	tempFile, err := os.CreateTemp("", "hyperv-runner-*.ps1")
 	if err != nil {
@@ -31,7 +48,7 @@ func (h *HyperVManager) RunPowerShell(command string) (string, error) {
 	if len(commandPreview) > 200 {
 		commandPreview = commandPreview[:200] + "... (truncated)"
 	}
-	h.logger.Debug("Executing PowerShell script",
+	p.logger.Debug("Executing PowerShell script",
 		"script_file", tempFile.Name(),
 		"command_preview", commandPreview,
 		"command_length", len(command))
@@ -41,9 +58,9 @@ func (h *HyperVManager) RunPowerShell(command string) (string, error) {
 		timestamp := time.Now().Format("20060102-150405.000")
 		debugFile := fmt.Sprintf("%s\\ps-%s.ps1", debugDir, timestamp)
 		if err := os.WriteFile(debugFile, []byte(command), 0644); err != nil {
-			h.logger.Warn("Failed to save debug script", "path", debugFile, "error", err)
+			p.logger.Warn("Failed to save debug script", "path", debugFile, "error", err)
 		} else {
-			h.logger.Debug("Saved PowerShell script to debug directory", "path", debugFile)
+			p.logger.Debug("Saved PowerShell script to debug directory", "path", debugFile)
 		}
 	}
 
@@ -53,10 +70,7 @@ func (h *HyperVManager) RunPowerShell(command string) (string, error) {
 
 	// On Windows, hide the console window for the PowerShell process
 	// This prevents PowerShell windows from flashing on screen
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		HideWindow:    true,
-		CreationFlags: 0x08000000, // CREATE_NO_WINDOW
-	}
+	hideWindow(cmd)
 
 	// Capture stdout and stderr separately for better debugging
 	var stdout, stderr strings.Builder
@@ -68,18 +82,13 @@ func (h *HyperVManager) RunPowerShell(command string) (string, error) {
 	stderrStr := stderr.String()
 
 	if err != nil {
-		// Build detailed error message
-		errMsg := fmt.Sprintf("powershell error: %v", err)
-		if len(stdoutStr) > 0 {
-			errMsg += fmt.Sprintf("\nstdout: %s", stdoutStr)
-		}
-		if len(stderrStr) > 0 {
-			errMsg += fmt.Sprintf("\nstderr: %s", stderrStr)
-		}
-		errMsg += fmt.Sprintf("\nscript_file: %s (saved for debugging)", tempFile.Name())
-		errMsg += fmt.Sprintf("\ncommand_preview: %s", commandPreview)
-
-		return stdoutStr + stderrStr, fmt.Errorf("%s", errMsg)
+		// The error is classified by stderr and keeps the end of stdout; the full output is logged for debugging
+		p.logger.Debug("PowerShell script failed",
+			"script_file", tempFile.Name(),
+			"command_preview", commandPreview,
+			"stdout", stdoutStr,
+			"stderr", stderrStr)
+		return stdoutStr + stderrStr, newPowerShellError("", stdoutStr, stderrStr, err)
 	}
 
 	// Return combined output (stdout + stderr)
@@ -88,7 +97,7 @@ func (h *HyperVManager) RunPowerShell(command string) (string, error) {
 		output += stderrStr
 	}
 
-	h.logger.Debug("PowerShell script executed successfully",
+	p.logger.Debug("PowerShell script executed successfully",
 		"output_length", len(output))
 
 	return output, nil
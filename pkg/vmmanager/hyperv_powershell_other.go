@@ -0,0 +1,8 @@
+//go:build !windows
+
+package vmmanager
+
+import "os/exec"
+
+// hideWindow is a no-op outside Windows, where there is no console window to hide
+func hideWindow(cmd *exec.Cmd) {}
@@ -0,0 +1,14 @@
+package vmmanager
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// hideWindow keeps the PowerShell console window from appearing
+func hideWindow(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow:    true,
+		CreationFlags: 0x08000000, // CREATE_NO_WINDOW
+	}
+}
@@ -0,0 +1,155 @@
+package vmmanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbeGuest checks the runner from inside the guest: whether Runner.Listener is alive, the free
+// space on the system disk, when the runner last logged and when the configure script last wrote
+// its heartbeat
+// Windows guests are asked over PowerShell Direct; Linux guests have none, so their configure
+// script reports the same readings as guest KVP items with every heartbeat
+func (h *HyperVManager) ProbeGuest(slot *VMSlot) (GuestStatus, error) {
+	if h.linuxGuest() {
+		return h.probeGuestKVP(slot.Name)
+	}
+	return h.probeGuestDirect(slot.Name)
+}
+
+// probeGuestDirect probes a Windows guest over PowerShell Direct
+// Ages are measured by the guest's own clock, so clock skew between host and guest does not matter
+func (h *HyperVManager) probeGuestDirect(vmName string) (GuestStatus, error) {
+	timeout := h.config.Monitoring.GuestProbe.TimeoutSeconds
+
+	// The guest is reached from a background job so a hung VM cannot block the health check
+	probeCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$job = Start-Job -ScriptBlock {
+			param($vmName, $username, $password)
+			$ErrorActionPreference = "Stop"
+			$securePassword = ConvertTo-SecureString $password -AsPlainText -Force
+			$credential = New-Object System.Management.Automation.PSCredential ($username, $securePassword)
+
+			Invoke-Command -VMName $vmName -Credential $credential -ScriptBlock {
+				$now = Get-Date
+				$listener = Get-Process -Name "Runner.Listener" -ErrorAction SilentlyContinue
+				Write-Output "LISTENER:$([bool]$listener)"
+				$disk = Get-CimInstance -ClassName Win32_LogicalDisk -Filter "DeviceID='$env:SystemDrive'"
+				Write-Output "FREE_DISK_BYTES:$($disk.FreeSpace)"
+				$log = Get-ChildItem -Path "C:\actions-runner\_diag\Runner_*.log" -ErrorAction SilentlyContinue |
+					Sort-Object LastWriteTime -Descending | Select-Object -First 1
+				if ($log) {
+					Write-Output "LOG_AGE_SECONDS:$([int]($now - $log.LastWriteTime).TotalSeconds)"
+				}
+				$heartbeat = Get-Item -Path "C:\actions-runner\heartbeat" -ErrorAction SilentlyContinue
+				if ($heartbeat) {
+					Write-Output "HEARTBEAT_AGE_SECONDS:$([int]($now - $heartbeat.LastWriteTime).TotalSeconds)"
+				}
+			}
+		} -ArgumentList "%s", "%s", "%s"
+
+		if (-not (Wait-Job $job -Timeout %d)) {
+			Stop-Job $job
+			Remove-Job $job -Force
+			throw "Guest did not answer the liveness probe within %d seconds"
+		}
+		if ($job.State -eq "Failed") {
+			$reason = $job.ChildJobs[0].JobStateInfo.Reason
+			Remove-Job $job -Force
+			throw "Guest liveness probe failed: $reason"
+		}
+		Receive-Job $job
+		Remove-Job $job -Force
+	`, vmName, h.config.HyperV.VMUsername, h.config.HyperV.VMPassword, timeout, timeout)
+
+	output, err := h.RunPowerShell(probeCmd)
+	if err != nil {
+		return GuestStatus{}, fmt.Errorf("failed to probe guest: %w", guestError(err))
+	}
+
+	now := time.Now()
+	status := GuestStatus{}
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "LISTENER:"); ok {
+			status.ListenerRunning = value == "True"
+			found = true
+		} else if value, ok := strings.CutPrefix(line, "FREE_DISK_BYTES:"); ok {
+			status.FreeDiskBytes, _ = strconv.ParseInt(value, 10, 64)
+		} else if value, ok := strings.CutPrefix(line, "LOG_AGE_SECONDS:"); ok {
+			status.LastLogAt = secondsAgo(now, value)
+		} else if value, ok := strings.CutPrefix(line, "HEARTBEAT_AGE_SECONDS:"); ok {
+			status.HeartbeatAt = secondsAgo(now, value)
+		}
+	}
+	if !found {
+		return GuestStatus{}, fmt.Errorf("%w: unexpected liveness probe output: %s", ErrGuestUnreachable, strings.TrimSpace(output))
+	}
+	return status, nil
+}
+
+// probeGuestKVP reads the readings a Linux guest's configure script reports as guest KVP items
+// The heartbeat item is the guest's clock when the readings were taken
+func (h *HyperVManager) probeGuestKVP(vmName string) (GuestStatus, error) {
+	probeCmd := findVMCommand(vmName) + `
+		$kvp = Get-CimInstance -Namespace "root\virtualization\v2" -ClassName "Msvm_KvpExchangeComponent" |
+			Where-Object { $_.SystemName -eq $vm.Id.ToString() }
+		foreach ($item in $kvp.GuestExchangeItems) {
+			$properties = ([xml]$item).INSTANCE.PROPERTY
+			$name = ($properties | Where-Object { $_.NAME -eq "Name" }).VALUE
+			$data = ($properties | Where-Object { $_.NAME -eq "Data" }).VALUE
+			if ($name -like "runner-*") {
+				Write-Output "KVP:$name=$data"
+			}
+		}
+	`
+	output, err := h.RunPowerShell(probeCmd)
+	if err != nil {
+		return GuestStatus{}, fmt.Errorf("failed to read guest KVP items: %w", err)
+	}
+	if vmNotFound(output) {
+		return GuestStatus{}, fmt.Errorf("%w: %s", ErrVMNotFound, vmName)
+	}
+
+	items := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if item, ok := strings.CutPrefix(strings.TrimSpace(line), "KVP:"); ok {
+			if name, value, ok := strings.Cut(item, "="); ok {
+				items[name] = value
+			}
+		}
+	}
+	if items["runner-heartbeat"] == "" {
+		return GuestStatus{}, fmt.Errorf("%w: guest reported no liveness probe readings", ErrGuestUnreachable)
+	}
+
+	status := GuestStatus{
+		ListenerRunning: items["runner-listener"] == "1",
+		HeartbeatAt:     unixTime(items["runner-heartbeat"]),
+		LastLogAt:       unixTime(items["runner-last-log"]),
+	}
+	status.FreeDiskBytes, _ = strconv.ParseInt(items["runner-free-disk-bytes"], 10, 64)
+	return status, nil
+}
+
+// secondsAgo returns the time a reported age in seconds points back to (zero: unparsable)
+func secondsAgo(now time.Time, value string) time.Time {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return now.Add(-time.Duration(seconds) * time.Second)
+}
+
+// unixTime parses a reported Unix timestamp (zero: missing or unparsable)
+func unixTime(value string) time.Time {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
@@ -0,0 +1,101 @@
+package vmmanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// quarantineNamePattern matches quarantined VM names: <prefix>quarantine-<slot index>-<yyyyMMdd-HHmmss>
+// They never match the <prefix><digits> pattern, so leftover cleanup leaves them alone
+func (h *HyperVManager) quarantineNamePattern() string {
+	return fmt.Sprintf(`^$([regex]::Escape("%s"))quarantine-\d+-(\d{8}-\d{6})$`, h.config.Runners.NamePrefix)
+}
+
+// quarantinePruneCommands returns PowerShell that removes quarantined VMs beyond the newest keep
+// or older than the configured maximum age
+// Quarantined VMs of other instances are never counted or removed
+func (h *HyperVManager) quarantinePruneCommands(keep int) string {
+	return fmt.Sprintf(`
+		$quarantinePath = "%s"
+		$quarantined = @()
+		foreach ($candidate in Get-VM) {
+			if ($candidate.Name -match "%s" -and %s) {
+				$quarantined += [PSCustomObject]@{
+					VM = $candidate
+					At = [datetime]::ParseExact($Matches[1], "yyyyMMdd-HHmmss", $null)
+				}
+			}
+		}
+		$quarantined = @($quarantined | Sort-Object At -Descending)
+		$expiry = (Get-Date).AddHours(-%d)
+		for ($i = 0; $i -lt $quarantined.Count; $i++) {
+			$entry = $quarantined[$i]
+			if ($i -ge %d -or $entry.At -lt $expiry) {
+				Write-Output "Removing quarantined VM: $($entry.VM.Name)"
+				Stop-VM -VM $entry.VM -TurnOff -Force -ErrorAction SilentlyContinue
+				Remove-VM -VM $entry.VM -Force
+				Remove-Item -Path (Join-Path $quarantinePath $entry.VM.Name) -Recurse -Force -ErrorAction SilentlyContinue
+			}
+		}
+	`, h.config.HyperV.Quarantine.Path, h.quarantineNamePattern(), h.ownedCondition("$candidate"), h.config.HyperV.Quarantine.MaxAgeHours, keep)
+}
+
+// QuarantineVM stops a failed VM, renames it out of the pool numbering and moves its
+// disks and configuration to the quarantine path so it can be booted for inspection
+// Returns the quarantined VM name, or "" when the VM does not exist
+func (h *HyperVManager) QuarantineVM(slot *VMSlot, reason string) (string, error) {
+	vmName := slot.Name
+
+	// Make room first, so the cap includes the VM being quarantined
+	quarantineCmd := findVMCommand(vmName) + fmt.Sprintf(`
+		%s
+
+		Stop-VM -VM $vm -TurnOff -Force -ErrorAction SilentlyContinue
+
+		# The seed ISO holds a spent registration token and is not moved with the VM
+		Get-VMDvdDrive -VM $vm | Remove-VMDvdDrive
+		Remove-Item -Path "%s" -Force -ErrorAction SilentlyContinue
+
+		$newName = "%squarantine-%d-$((Get-Date).ToString("yyyyMMdd-HHmmss"))"
+		Rename-VM -VM $vm -NewName $newName
+		Set-VM -VM $vm -Notes ("%s" + [Environment]::NewLine + "Quarantined from %s at " + (Get-Date -Format o) + ": " + '%s')
+		Move-VMStorage -VM $vm -DestinationStoragePath (Join-Path $quarantinePath $newName)
+
+		# Checkpoint restores leave an empty per-VM directory behind
+		Remove-Item -Path "%s\%s" -Recurse -Force -ErrorAction SilentlyContinue
+		Write-Output "QUARANTINED:$newName"
+	`, h.quarantinePruneCommands(h.config.HyperV.Quarantine.MaxVMs-1),
+		h.seedISOPath(slot),
+		h.config.Runners.NamePrefix, slot.Index,
+		h.ownerTag(), vmName, strings.ReplaceAll(reason, "'", "''"),
+		h.storagePathFor(slot), vmName)
+
+	output, err := h.RunPowerShell(quarantineCmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to quarantine VM: %w", err)
+	}
+
+	if vmNotFound(output) {
+		return "", nil
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), "QUARANTINED:"); ok {
+			h.logger.Info("VM quarantined", "vm_name", vmName, "quarantined_name", name, "path", h.config.HyperV.Quarantine.Path)
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("quarantine did not complete: %s", output)
+}
+
+// PruneQuarantine removes quarantined VMs that exceed the configured count or age
+func (h *HyperVManager) PruneQuarantine() error {
+	pruneCmd := `$ErrorActionPreference = "Stop"` + h.quarantinePruneCommands(h.config.HyperV.Quarantine.MaxVMs)
+	output, err := h.RunPowerShell(pruneCmd)
+	if err != nil {
+		return fmt.Errorf("failed to prune quarantined VMs: %w", err)
+	}
+	if output = strings.TrimSpace(output); output != "" {
+		h.logger.Info("Pruned quarantined VMs", "output", output)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package vmmanager
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"hyperv-runner-pool/pkg/config"
+)
+
+// newHostExecutor returns the executor that runs scripts on a configured Hyper-V host
+func newHostExecutor(host config.HostConfig, logger *slog.Logger) CommandExecutor {
+	local := &localPowerShell{logger: logger}
+	switch host.Transport {
+	case config.TransportSSH:
+		return &sshPowerShell{host: host, logger: logger}
+	case config.TransportWinRM:
+		return &winrmPowerShell{host: host, local: local}
+	default:
+		return local
+	}
+}
+
+// winrmPowerShell runs scripts on a remote host with PowerShell remoting over WinRM
+// The script is sent base64 encoded so it needs no escaping, and runs as a single script block
+type winrmPowerShell struct {
+	host  config.HostConfig
+	local CommandExecutor
+}
+
+func (p *winrmPowerShell) Run(script string) (string, error) {
+	credential := ""
+	if p.host.Username != "" {
+		credential = fmt.Sprintf(`
+		$password = ConvertTo-SecureString %s -AsPlainText -Force
+		$sessionArgs.Credential = New-Object System.Management.Automation.PSCredential(%s, $password)`,
+			psQuote(p.host.Password), psQuote(p.host.Username))
+	}
+
+	wrapper := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$script = [System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String("%s"))
+		$sessionArgs = @{
+			ComputerName = %s
+			Port         = %d
+			UseSSL       = $%t
+		}%s
+		Invoke-Command @sessionArgs -ErrorAction Stop -ArgumentList $script -ScriptBlock {
+			param($script)
+			& ([scriptblock]::Create($script))
+		}
+	`, base64.StdEncoding.EncodeToString([]byte(script)),
+		psQuote(p.host.Address), p.host.Port, p.host.UseSSL, credential)
+
+	output, err := p.local.Run(wrapper)
+	var psErr *PowerShellError
+	if errors.As(err, &psErr) {
+		psErr.Host = p.host.Name
+		return output, psErr
+	}
+	if err != nil {
+		return output, fmt.Errorf("host %s: %w", p.host.Name, err)
+	}
+	return output, nil
+}
+
+// sshBootstrap reads a base64 encoded script from stdin and runs it
+// It is passed with -EncodedCommand, which sidesteps the quoting of the remote login shell
+const sshBootstrap = `$encoded = [Console]::In.ReadToEnd().Trim()
+& ([scriptblock]::Create([System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String($encoded))))`
+
+// sshPowerShell runs scripts on a remote host through the OpenSSH client
+// Authentication is key based, no password prompts are possible
+type sshPowerShell struct {
+	host   config.HostConfig
+	logger *slog.Logger
+}
+
+func (p *sshPowerShell) Run(script string) (string, error) {
+	args := []string{"-o", "BatchMode=yes", "-p", strconv.Itoa(p.host.Port)}
+	if p.host.IdentityFile != "" {
+		args = append(args, "-i", p.host.IdentityFile)
+	}
+	target := p.host.Address
+	if p.host.Username != "" {
+		target = p.host.Username + "@" + target
+	}
+	args = append(args, target,
+		"powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand "+encodePowerShellCommand(sshBootstrap))
+
+	p.logger.Debug("Executing PowerShell script over SSH",
+		"host", p.host.Name,
+		"command_length", len(script))
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString([]byte(script)))
+	hideWindow(cmd)
+
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		p.logger.Debug("PowerShell script over SSH failed",
+			"host", p.host.Name,
+			"stdout", stdout.String(),
+			"stderr", stderr.String())
+		return stdout.String() + stderr.String(), newPowerShellError(p.host.Name, stdout.String(), stderr.String(), fmt.Errorf("ssh: %w", err))
+	}
+	return stdout.String() + stderr.String(), nil
+}
+
+// encodePowerShellCommand encodes a command for powershell.exe -EncodedCommand (base64 of UTF-16LE)
+func encodePowerShellCommand(command string) string {
+	units := utf16.Encode([]rune(command))
+	encoded := make([]byte, 0, len(units)*2)
+	for _, unit := range units {
+		encoded = append(encoded, byte(unit), byte(unit>>8))
+	}
+	return base64.StdEncoding.EncodeToString(encoded)
+}
+
+// psQuote returns s as a single-quoted PowerShell string literal
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,122 @@
+package vmmanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// bytesPerGB converts the GB values in the storage config to bytes
+const bytesPerGB = 1 << 30
+
+// storagePathFor returns the storage directory holding a slot's disks
+func (h *HyperVManager) storagePathFor(slot *VMSlot) string {
+	if slot.StoragePath != "" {
+		return slot.StoragePath
+	}
+	return h.config.HyperV.VMStoragePath
+}
+
+// storagePaths returns every configured storage path
+func (h *HyperVManager) storagePaths() []string {
+	if len(h.config.HyperV.StoragePaths) == 0 {
+		return []string{h.config.HyperV.VMStoragePath}
+	}
+	return h.config.HyperV.StoragePaths
+}
+
+// selectStoragePath picks the storage path with the most free space for a new VM
+// Fails with ErrInsufficientStorage when every path is below the configured reserve
+func (h *HyperVManager) selectStoragePath() (string, error) {
+	freeCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		foreach ($path in @(%s)) {
+			New-Item -Path $path -ItemType Directory -Force | Out-Null
+			$root = [System.IO.Path]::GetPathRoot((Resolve-Path -LiteralPath $path).ProviderPath)
+			$free = (New-Object System.IO.DriveInfo($root)).AvailableFreeSpace
+			Write-Output "STORAGE_FREE:$free|$path"
+		}
+	`, psStringList(h.storagePaths()))
+
+	output, err := h.RunPowerShell(freeCmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to check free storage space: %w", err)
+	}
+
+	reserve := int64(h.config.HyperV.Storage.ReserveGB) * bytesPerGB
+	path, free, err := chooseStoragePath(output, reserve)
+	if err != nil {
+		return "", err
+	}
+
+	h.logger.Debug("Selected storage path", "path", path, "free_gb", free/bytesPerGB)
+	return path, nil
+}
+
+// chooseStoragePath parses the free space check output and returns the path with the most free space
+func chooseStoragePath(output string, reserve int64) (string, int64, error) {
+	bestPath := ""
+	var bestFree int64 = -1
+	var summary []string
+
+	for _, line := range strings.Split(output, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "STORAGE_FREE:")
+		if !ok {
+			continue
+		}
+		freeStr, path, ok := strings.Cut(value, "|")
+		if !ok {
+			continue
+		}
+		free, err := strconv.ParseInt(freeStr, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		summary = append(summary, fmt.Sprintf("%s has %.1f GB free", path, float64(free)/bytesPerGB))
+		if free > bestFree {
+			bestPath, bestFree = path, free
+		}
+	}
+
+	if bestPath == "" {
+		return "", 0, fmt.Errorf("failed to read free storage space from output: %s", output)
+	}
+	if bestFree <= reserve {
+		return "", 0, fmt.Errorf("%w: %s; reserve is %d GB",
+			ErrInsufficientStorage, strings.Join(summary, ", "), reserve/bytesPerGB)
+	}
+	return bestPath, bestFree, nil
+}
+
+// GetDiskUsage returns the current file size of the VM's boot disk in bytes
+func (h *HyperVManager) GetDiskUsage(slot *VMSlot) (int64, error) {
+	cmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$drive = Get-VMHardDiskDrive -VMName "%s" | Sort-Object ControllerNumber, ControllerLocation | Select-Object -First 1
+		if (-not $drive) {
+			throw "VM has no hard disk"
+		}
+		(Get-VHD -Path $drive.Path).FileSize
+	`, slot.Name)
+
+	output, err := h.RunPowerShell(cmd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get disk usage: %w", err)
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse disk usage %q: %w", strings.TrimSpace(output), err)
+	}
+	return size, nil
+}
+
+// psStringList formats strings as a comma-separated list of PowerShell string literals
+func psStringList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf(`"%s"`, value)
+	}
+	return strings.Join(quoted, ", ")
+}
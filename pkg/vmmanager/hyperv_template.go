@@ -0,0 +1,116 @@
+package vmmanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// vhdxSignature is the file type identifier at the start of every VHDX file
+const vhdxSignature = "vhdxfile"
+
+// ValidateTemplate runs the pre-flight checks on a template VHDX before differencing disks are created from it:
+// the file exists, is read-only, has a VHDX header, matches its SHA-256 manifest (<template>.sha256)
+// and is not attached directly to a running VM
+func (h *HyperVManager) ValidateTemplate(templatePath string) error {
+	if h.config.HyperV.SkipTemplateValidation {
+		h.logger.Warn("Template validation is disabled", "template_path", templatePath)
+		return nil
+	}
+
+	h.logger.Info("Validating template", "template_path", templatePath)
+
+	checkCmd := fmt.Sprintf(`
+		$ErrorActionPreference = "Stop"
+		$path = "%s"
+
+		if (-not (Test-Path -LiteralPath $path -PathType Leaf)) {
+			Write-Output "TEMPLATE_EXISTS:false"
+			return
+		}
+		Write-Output "TEMPLATE_EXISTS:true"
+		Write-Output "TEMPLATE_READONLY:$((Get-Item -LiteralPath $path).IsReadOnly)"
+
+		# Read the file type identifier without locking out other readers
+		$stream = [System.IO.File]::Open($path, 'Open', 'Read', 'ReadWrite')
+		try {
+			$buffer = New-Object byte[] 8
+			$read = $stream.Read($buffer, 0, 8)
+			Write-Output "TEMPLATE_SIGNATURE:$([System.Text.Encoding]::ASCII.GetString($buffer, 0, $read))"
+		} finally {
+			$stream.Dispose()
+		}
+
+		$manifest = "$path.sha256"
+		if (Test-Path -LiteralPath $manifest -PathType Leaf) {
+			$expected = ((Get-Content -LiteralPath $manifest -Raw).Trim() -split '\s+')[0]
+			Write-Output "TEMPLATE_MANIFEST:$expected"
+			Write-Output "TEMPLATE_SHA256:$((Get-FileHash -LiteralPath $path -Algorithm SHA256).Hash)"
+		}
+
+		# A running VM booted from the template itself writes straight into the parent of every child disk
+		Get-VM | Where-Object { $_.State -ne 'Off' } | Get-VMHardDiskDrive |
+			Where-Object { $_.Path -eq $path } |
+			ForEach-Object { Write-Output "TEMPLATE_ATTACHED:$($_.VMName)" }
+	`, templatePath)
+
+	output, err := h.RunPowerShell(checkCmd)
+	if err != nil {
+		return fmt.Errorf("%w: %s: failed to run checks: %v", ErrTemplateInvalid, templatePath, err)
+	}
+
+	if err := parseTemplateCheck(templatePath, output, h.config.HyperV.RequireTemplateManifest); err != nil {
+		return err
+	}
+
+	h.logger.Info("Template validated", "template_path", templatePath)
+	return nil
+}
+
+// parseTemplateCheck turns the output of the template check script into a validation error
+// listing every problem found, or nil if the template is safe to use
+func parseTemplateCheck(templatePath, output string, requireManifest bool) error {
+	values := make(map[string]string)
+	var attached []string
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok || !strings.HasPrefix(key, "TEMPLATE_") {
+			continue
+		}
+		if key == "TEMPLATE_ATTACHED" {
+			attached = append(attached, value)
+			continue
+		}
+		values[key] = value
+	}
+
+	if values["TEMPLATE_EXISTS"] != "true" {
+		return fmt.Errorf("%w: %s: file not found", ErrTemplateInvalid, templatePath)
+	}
+
+	var problems []string
+	if !strings.EqualFold(values["TEMPLATE_READONLY"], "true") {
+		problems = append(problems, fmt.Sprintf(
+			"file is not read-only (run: Set-ItemProperty -Path \"%s\" -Name IsReadOnly -Value $true)", templatePath))
+	}
+	if values["TEMPLATE_SIGNATURE"] != vhdxSignature {
+		problems = append(problems, "file does not have a valid VHDX header")
+	}
+
+	expected, hasManifest := values["TEMPLATE_MANIFEST"]
+	switch {
+	case hasManifest && !strings.EqualFold(expected, values["TEMPLATE_SHA256"]):
+		problems = append(problems, fmt.Sprintf("SHA-256 %s does not match manifest %s",
+			strings.ToLower(values["TEMPLATE_SHA256"]), strings.ToLower(expected)))
+	case !hasManifest && requireManifest:
+		problems = append(problems, fmt.Sprintf("SHA-256 manifest %s.sha256 not found", templatePath))
+	}
+
+	if len(attached) > 0 {
+		problems = append(problems, fmt.Sprintf("attached directly to running VM(s): %s", strings.Join(attached, ", ")))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrTemplateInvalid, templatePath, strings.Join(problems, "; "))
+	}
+	return nil
+}
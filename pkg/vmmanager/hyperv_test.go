@@ -0,0 +1,1058 @@
+package vmmanager
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"hyperv-runner-pool/pkg/config"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// ========================================
+// Hyper-V Script Tests
+// ========================================
+
+// cannedResponse answers scripts containing match
+type cannedResponse struct {
+	match  string
+	output string
+	err    error
+}
+
+// scriptedExecutor records every generated script and answers with canned output
+// Scripts that match no response succeed with empty output
+type scriptedExecutor struct {
+	scripts   []string
+	responses []cannedResponse
+}
+
+func (e *scriptedExecutor) Run(script string) (string, error) {
+	e.scripts = append(e.scripts, script)
+	for _, response := range e.responses {
+		if strings.Contains(script, response.match) {
+			return response.output, response.err
+		}
+	}
+	return "", nil
+}
+
+// ran reports whether any recorded script contains substr
+func (e *scriptedExecutor) ran(substr string) bool {
+	for _, script := range e.scripts {
+		if strings.Contains(script, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// goldenConfig returns a fixed configuration so generated scripts are stable
+func goldenConfig() config.Config {
+	return config.Config{
+		GitHub: config.GitHubConfig{
+			Org:  "test-org",
+			Repo: "test-repo",
+		},
+		Runners: config.RunnersConfig{
+			PoolSize:   2,
+			NamePrefix: "runner-",
+			Labels:     []string{"gpu"},
+			InstanceID: "pool-a",
+		},
+		HyperV: config.HyperVConfig{
+			TemplatePath:     `C:\templates\runner.vhdx`,
+			VMStoragePath:    `D:\vms`,
+			StoragePaths:     []string{`D:\vms`, `E:\vms`},
+			Storage:          config.StorageConfig{ReserveGB: 20},
+			VMUsername:       "Administrator",
+			VMPassword:       "password",
+			VMMemoryMB:       4096,
+			VMCPUCount:       2,
+			ProvisioningMode: config.ProvisioningCold,
+			ConfigInjection:  config.InjectionMount,
+			Network: config.NetworkConfig{
+				Adapters: []config.NetworkAdapterConfig{{Name: "Network Adapter 1", SwitchName: "Default Switch"}},
+			},
+		},
+	}
+}
+
+// newScriptedManager returns a Hyper-V manager whose scripts are captured by the returned executor
+func newScriptedManager(responses ...cannedResponse) (*HyperVManager, *scriptedExecutor) {
+	executor := &scriptedExecutor{responses: responses}
+	return NewHyperVManagerWithExecutor(goldenConfig(), executor, testLogger()), executor
+}
+
+// assertGolden compares the recorded scripts with testdata/golden/<name>.ps1
+// Run "go test ./pkg/vmmanager -update" to rewrite the golden files after an intended change
+func assertGolden(t *testing.T, name string, scripts []string) {
+	t.Helper()
+
+	var got strings.Builder
+	for i, script := range scripts {
+		fmt.Fprintf(&got, "# ---- script %d ----\n%s\n", i+1, script)
+	}
+
+	path := filepath.Join("testdata", "golden", name+".ps1")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create golden directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got.String()), 0644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("Generated scripts differ from %s (run with -update if the change is intended)\n%s",
+			path, firstDifference(string(want), got.String()))
+	}
+}
+
+// firstDifference describes the first line where two script dumps differ
+func firstDifference(want, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d:\n  want: %q\n  got:  %q", i+1, w, g)
+		}
+	}
+	return ""
+}
+
+func TestHyperVManager_CreateVM_Golden(t *testing.T) {
+	manager, executor := newScriptedManager(
+		cannedResponse{match: "Invoke-Command -VMName", output: "SCRIPT_EXECUTION_SUCCESS\r\n"},
+		cannedResponse{match: "DriveInfo", output: "STORAGE_FREE:64424509440|D:\\vms\r\nSTORAGE_FREE:107374182400|E:\\vms\r\n"},
+		cannedResponse{match: "Mount-VHD -Path", output: "DiskNumber: 3\r\nDRIVE_LETTER:F\r\n"},
+		cannedResponse{match: "WriteAllBytes($dest", output: "SUCCESS\r\n"},
+		cannedResponse{match: "Get-VMNetworkAdapter", output: "192.168.0.10\r\n"},
+	)
+	slot := &VMSlot{Index: 1, Name: "runner-1", RunnerToken: "AABBCC"}
+
+	if err := manager.CreateVM(slot); err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+	if slot.StoragePath != `E:\vms` {
+		t.Errorf("Expected the storage path with the most free space, got %s", slot.StoragePath)
+	}
+	if slot.IPAddress != "192.168.0.10" {
+		t.Errorf("Expected IP address 192.168.0.10, got %q", slot.IPAddress)
+	}
+	assertGolden(t, "create_vm", executor.scripts)
+}
+
+func TestHyperVManager_DestroyVM_Golden(t *testing.T) {
+	manager, executor := newScriptedManager()
+	slot := &VMSlot{Index: 2, Name: "runner-2", StoragePath: `E:\vms`}
+
+	if err := manager.DestroyVM(slot); err != nil {
+		t.Fatalf("DestroyVM failed: %v", err)
+	}
+	assertGolden(t, "destroy_vm", executor.scripts)
+}
+
+func TestHyperVManager_InjectConfig_Golden(t *testing.T) {
+	manager, executor := newScriptedManager(
+		cannedResponse{match: "Mount-VHD -Path", output: "DRIVE_LETTER:G\r\n"},
+		cannedResponse{match: "WriteAllBytes($dest", output: "SUCCESS\r\n"},
+	)
+	config := RunnerConfig{Token: "AABBCC", Organization: "test-org", Name: "runner-1", Labels: "self-hosted"}
+
+	if err := manager.InjectConfig(`D:\vms\runner-1.vhdx`, config); err != nil {
+		t.Fatalf("InjectConfig failed: %v", err)
+	}
+	assertGolden(t, "inject_config", executor.scripts)
+}
+
+func TestHyperVManager_ExecuteScriptInVM_Golden(t *testing.T) {
+	manager, executor := newScriptedManager(
+		cannedResponse{match: "Invoke-Command -VMName", output: "Attempt 1 of 10 to connect to VM...\r\nSCRIPT_EXECUTION_SUCCESS\r\n"},
+	)
+
+	// Single quotes must be doubled to survive the here-string
+	if err := manager.ExecuteScriptInVM("runner-1", "Write-Output 'hello'"); err != nil {
+		t.Fatalf("ExecuteScriptInVM failed: %v", err)
+	}
+	assertGolden(t, "execute_script_in_vm", executor.scripts)
+}
+
+func TestHyperVManager_CleanupLeftoverResources_Golden(t *testing.T) {
+	manager, executor := newScriptedManager(
+		cannedResponse{match: "$cleaned", output: "Cleanup complete. Removed 0 resources.\r\n"},
+	)
+
+	if err := manager.CleanupLeftoverResources("runner-"); err != nil {
+		t.Fatalf("CleanupLeftoverResources failed: %v", err)
+	}
+	assertGolden(t, "cleanup_leftover_resources", executor.scripts)
+}
+
+func TestHyperVManager_CollectGarbage_Golden(t *testing.T) {
+	manager, executor := newScriptedManager(
+		cannedResponse{match: "GC_REMOVED", output: "GC_REMOVED:VM runner-3\r\n" +
+			"GC_DISMOUNTED:D:\\vms\\runner-3.vhdx\r\n" +
+			"GC_REMOVED:D:\\vms\\runner-3.vhdx\r\n" +
+			"GC_FAILED:E:\\vms\\runner-1-seed.iso: access denied\r\n"},
+	)
+
+	slots := []*VMSlot{{Index: 1, Name: "runner-1", StoragePath: `D:\vms`}, {Index: 2, Name: "runner-2"}}
+	collected, err := manager.CollectGarbage("runner-", slots, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	want := []string{"removed VM runner-3", `dismounted D:\vms\runner-3.vhdx`, `removed D:\vms\runner-3.vhdx`}
+	if !slices.Equal(collected, want) {
+		t.Errorf("Expected collected resources %q, got %q", want, collected)
+	}
+	assertGolden(t, "collect_garbage", executor.scripts)
+}
+
+func TestParseDriveLetter(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", output: "DRIVE_LETTER:F", want: "F"},
+		{name: "CRLF and chatter", output: "DiskNumber: 3\r\nPartitions found: 2\r\nDRIVE_LETTER:F\r\n", want: "F"},
+		{name: "padded lowercase", output: "  DRIVE_LETTER: e \r\n", want: "E"},
+		{name: "trailing colon", output: "DRIVE_LETTER:F:\r\n", want: "F"},
+		{name: "first marker wins", output: "DRIVE_LETTER:F\nDRIVE_LETTER:G\n", want: "F"},
+		{name: "empty value", output: "DRIVE_LETTER:\r\n", wantErr: true},
+		{name: "multiple letters", output: "DRIVE_LETTER:FG\r\n", wantErr: true},
+		{name: "digit", output: "DRIVE_LETTER:1\r\n", wantErr: true},
+		{name: "missing marker", output: "DiskNumber: 3\r\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDriveLetter(tt.output)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got drive letter %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected drive letter %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHyperVManager_InjectConfig_Failures(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []cannedResponse
+		wantErr   string
+	}{
+		{
+			name:      "mount fails",
+			responses: []cannedResponse{{match: "Mount-VHD -Path", err: errors.New("powershell error: exit status 1")}},
+			wantErr:   "failed to mount VHDX",
+		},
+		{
+			name:      "no drive letter",
+			responses: []cannedResponse{{match: "Mount-VHD -Path", output: "DiskNumber: 3\r\n"}},
+			wantErr:   "failed to extract drive letter",
+		},
+		{
+			name: "copy not verified",
+			responses: []cannedResponse{
+				{match: "Mount-VHD -Path", output: "DRIVE_LETTER:F\r\n"},
+				{match: "WriteAllBytes($dest", output: "File copied successfully. Size: 0 bytes\r\n"},
+			},
+			wantErr: "config copy verification failed",
+		},
+		{
+			name: "copy fails",
+			responses: []cannedResponse{
+				{match: "Mount-VHD -Path", output: "DRIVE_LETTER:F\r\n"},
+				{match: "WriteAllBytes($dest", err: errors.New("powershell error: exit status 1")},
+			},
+			wantErr: "failed to copy config to VHDX",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, executor := newScriptedManager(tt.responses...)
+
+			err := manager.InjectConfig(`D:\vms\runner-1.vhdx`, RunnerConfig{Name: "runner-1"})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+			// The disk must never be left mounted
+			if !executor.ran(`Dismount-VHD -Path "D:\vms\runner-1.vhdx"`) {
+				t.Error("Expected the VHDX to be dismounted")
+			}
+		})
+	}
+}
+
+func TestHyperVManager_ExecuteScriptInVM_Failures(t *testing.T) {
+	manager, _ := newScriptedManager(
+		cannedResponse{match: "Invoke-Command -VMName", output: "Attempt 1 of 10 to connect to VM...\r\n"},
+	)
+	if err := manager.ExecuteScriptInVM("runner-1", "Write-Output hi"); err == nil {
+		t.Error("Expected error when the success marker is missing")
+	}
+
+	manager, _ = newScriptedManager(
+		cannedResponse{match: "Invoke-Command -VMName", err: errors.New("powershell error: exit status 1")},
+	)
+	if err := manager.ExecuteScriptInVM("runner-1", "Write-Output hi"); err == nil {
+		t.Error("Expected error when PowerShell fails")
+	}
+}
+
+func TestHyperVManager_CreateVM_Failures(t *testing.T) {
+	manager, executor := newScriptedManager(
+		cannedResponse{match: "DriveInfo", output: "STORAGE_FREE:107374182400|D:\\vms\r\n"},
+		cannedResponse{match: "New-VHD", err: errors.New("powershell error: exit status 1")},
+	)
+
+	err := manager.CreateVM(&VMSlot{Index: 1, Name: "runner-1"})
+	if err == nil || !strings.Contains(err.Error(), "failed to create differencing disk") {
+		t.Fatalf("Expected differencing disk error, got %v", err)
+	}
+	if executor.ran("New-VM") {
+		t.Error("Expected no VM to be created after the disk failed")
+	}
+
+	// Every storage path below the reserve blocks creation before anything is written
+	manager, executor = newScriptedManager(
+		cannedResponse{match: "DriveInfo", output: "STORAGE_FREE:1073741824|D:\\vms\r\n"},
+	)
+	if err := manager.CreateVM(&VMSlot{Index: 1, Name: "runner-1"}); !errors.Is(err, ErrInsufficientStorage) {
+		t.Fatalf("Expected ErrInsufficientStorage, got %v", err)
+	}
+	if len(executor.scripts) != 1 {
+		t.Errorf("Expected only the free space check to run, got %d scripts", len(executor.scripts))
+	}
+}
+
+// hostResponses are the canned answers a healthy Hyper-V host gives during CreateVM
+func hostResponses() []cannedResponse {
+	return []cannedResponse{
+		{match: "Invoke-Command -VMName", output: "SCRIPT_EXECUTION_SUCCESS\r\n"},
+		{match: "DriveInfo", output: "STORAGE_FREE:107374182400|D:\\vms\r\n"},
+		{match: "Mount-VHD -Path", output: "DRIVE_LETTER:F\r\n"},
+		{match: "WriteAllBytes($dest", output: "SUCCESS\r\n"},
+		{match: "$vm.State", output: "Running\r\n"},
+	}
+}
+
+func TestHyperVManager_RebuildsCheckpointWhenSourceChanges(t *testing.T) {
+	manager, executor := newScriptedManager(cannedResponse{match: "Guest ready for checkpoint", output: "SCRIPT_EXECUTION_SUCCESS\r\n"})
+	builds := func() int {
+		count := 0
+		for _, script := range executor.scripts {
+			if strings.Contains(script, "Export-VM") {
+				count++
+			}
+		}
+		return count
+	}
+
+	slot := &VMSlot{Index: 1, Name: "runner-1"}
+	for range 2 {
+		if err := manager.ensureCheckpoint(slot); err != nil {
+			t.Fatalf("ensureCheckpoint failed: %v", err)
+		}
+	}
+	if builds() != 1 {
+		t.Fatalf("Expected the checkpoint to be built once, got %d builds", builds())
+	}
+
+	// A reload pointing template_path at a new template must not keep restoring the old one
+	cfg := goldenConfig()
+	cfg.HyperV.TemplatePath = `C:\templates\runner-v2.vhdx`
+	manager.UpdateConfig(cfg)
+	if err := manager.ensureCheckpoint(slot); err != nil {
+		t.Fatalf("ensureCheckpoint after reload failed: %v", err)
+	}
+	if builds() != 2 || !executor.ran(`New-VHD -ParentPath "C:\templates\runner-v2.vhdx"`) {
+		t.Errorf("Expected the checkpoint to be rebuilt from the new template, got %d builds", builds())
+	}
+
+	// Slots keep the hardware of the checkpoint VM, so new hardware needs a new checkpoint too
+	cfg.HyperV.VMMemoryMB = 8192
+	cfg.HyperV.Hardware.NestedVirtualization = true
+	manager.UpdateConfig(cfg)
+	if err := manager.ensureCheckpoint(slot); err != nil {
+		t.Fatalf("ensureCheckpoint after hardware reload failed: %v", err)
+	}
+	if builds() != 3 || !executor.ran(`New-VM -Name "runner-checkpoint" -MemoryStartupBytes 8192MB`) {
+		t.Errorf("Expected the checkpoint to be rebuilt with the new hardware, got %d builds", builds())
+	}
+	if err := manager.ensureCheckpoint(slot); err != nil || builds() != 3 {
+		t.Errorf("Expected the rebuilt checkpoint to be reused, got %d builds (err: %v)", builds(), err)
+	}
+}
+
+func TestMultiHostManager_Placement(t *testing.T) {
+	cfg := goldenConfig()
+	cfg.Runners.PoolSize = 3
+	cfg.HyperV.Hosts = []config.HostConfig{
+		{Name: "hv01", Address: "hv01", Transport: config.TransportWinRM, Capacity: 1},
+		{Name: "hv02", Address: "hv02", Transport: config.TransportWinRM, Capacity: 2},
+	}
+	hv01 := &scriptedExecutor{responses: hostResponses()}
+	hv02 := &scriptedExecutor{responses: hostResponses()}
+	manager := NewMultiHostManagerWithExecutors(cfg, []CommandExecutor{hv01, hv02}, testLogger())
+
+	slots := make([]*VMSlot, 4)
+	for i := range slots {
+		slots[i] = &VMSlot{Index: i + 1, Name: fmt.Sprintf("runner-%d", i+1)}
+	}
+
+	for i, wantHost := range []string{"hv01", "hv02", "hv02"} {
+		if err := manager.CreateVM(slots[i]); err != nil {
+			t.Fatalf("CreateVM %s failed: %v", slots[i].Name, err)
+		}
+		if slots[i].Host != wantHost {
+			t.Errorf("Expected %s on %s, got %q", slots[i].Name, wantHost, slots[i].Host)
+		}
+	}
+
+	if err := manager.CreateVM(slots[3]); !errors.Is(err, ErrNoHostCapacity) {
+		t.Fatalf("Expected ErrNoHostCapacity with every host full, got %v", err)
+	}
+
+	// Operations on a VM go to its host only
+	hv01.scripts, hv02.scripts = nil, nil
+	if _, err := manager.GetVMState("runner-1"); err != nil {
+		t.Fatalf("GetVMState failed: %v", err)
+	}
+	if len(hv01.scripts) != 1 || len(hv02.scripts) != 0 {
+		t.Errorf("Expected GetVMState to run on hv01 only, got %d and %d scripts", len(hv01.scripts), len(hv02.scripts))
+	}
+	if _, err := manager.GetVMState("runner-9"); !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("Expected ErrVMNotFound for a VM that was never placed, got %v", err)
+	}
+
+	// Destroying a VM frees its place for the next one
+	if err := manager.DestroyVM(slots[1]); err != nil {
+		t.Fatalf("DestroyVM failed: %v", err)
+	}
+	if !hv02.ran(`$_.Name -eq "runner-2"`) || !hv02.ran("Remove-VM") || hv01.ran("Remove-VM") {
+		t.Error("Expected runner-2 to be removed from hv02 only")
+	}
+	if err := manager.CreateVM(slots[3]); err != nil {
+		t.Fatalf("CreateVM after destroy failed: %v", err)
+	}
+	if slots[3].Host != "hv02" {
+		t.Errorf("Expected runner-4 on hv02, got %q", slots[3].Host)
+	}
+
+	// Fleet-wide operations run on every host
+	hv01.scripts, hv02.scripts = nil, nil
+	if err := manager.CleanupLeftoverResources("runner-"); err != nil {
+		t.Fatalf("CleanupLeftoverResources failed: %v", err)
+	}
+	if len(hv01.scripts) == 0 || len(hv02.scripts) == 0 {
+		t.Error("Expected cleanup to run on every host")
+	}
+}
+
+func TestMultiHostManager_KeepsFailedVMOnItsHost(t *testing.T) {
+	cfg := goldenConfig()
+	cfg.HyperV.Hosts = []config.HostConfig{
+		{Name: "hv01", Address: "hv01", Transport: config.TransportWinRM, Capacity: 2},
+		{Name: "hv02", Address: "hv02", Transport: config.TransportWinRM, Capacity: 2},
+	}
+	hv01 := &scriptedExecutor{responses: append([]cannedResponse{{match: "New-VHD", err: errors.New("powershell error: exit status 1")}}, hostResponses()...)}
+	hv02 := &scriptedExecutor{responses: hostResponses()}
+	manager := NewMultiHostManagerWithExecutors(cfg, []CommandExecutor{hv01, hv02}, testLogger())
+
+	slot := &VMSlot{Index: 1, Name: "runner-1"}
+	if err := manager.CreateVM(slot); err == nil {
+		t.Fatal("Expected CreateVM to fail")
+	}
+
+	// The failed VM was never torn down, so the retry stays where its leftovers are
+	if err := manager.CreateVM(slot); err == nil || slot.Host != "hv01" {
+		t.Errorf("Expected the retry to stay on hv01, got host %q (err: %v)", slot.Host, err)
+	}
+}
+
+func TestMultiHostManager_MovesSlotsOffUnreachableHost(t *testing.T) {
+	cfg := goldenConfig()
+	cfg.HyperV.Hosts = []config.HostConfig{
+		{Name: "hv01", Address: "hv01", Transport: config.TransportWinRM, Capacity: 2},
+		{Name: "hv02", Address: "hv02", Transport: config.TransportWinRM, Capacity: 2},
+	}
+	hv01 := &scriptedExecutor{responses: hostResponses()}
+	hv02 := &scriptedExecutor{responses: hostResponses()}
+	manager := NewMultiHostManagerWithExecutors(cfg, []CommandExecutor{hv01, hv02}, testLogger())
+
+	slots := []*VMSlot{{Index: 1, Name: "runner-1"}, {Index: 2, Name: "runner-2"}, {Index: 3, Name: "runner-3"}}
+	for _, slot := range slots[:2] {
+		if err := manager.CreateVM(slot); err != nil {
+			t.Fatalf("CreateVM %s failed: %v", slot.Name, err)
+		}
+	}
+	if slots[0].Host != "hv01" || slots[1].Host != "hv02" {
+		t.Fatalf("Expected one VM per host, got %q and %q", slots[0].Host, slots[1].Host)
+	}
+
+	// hv01 goes down
+	unreachable := newPowerShellError("hv01", "", "ssh: connect to host hv01 port 22: Connection refused", errors.New("exit status 255"))
+	hv01.responses = []cannedResponse{{match: "", err: unreachable}}
+
+	// A short outage is waited out
+	if _, err := manager.GetVMState("runner-1"); !errors.Is(err, ErrHostUnavailable) {
+		t.Fatalf("Expected ErrHostUnavailable while the host is down, got %v", err)
+	}
+
+	// A long one gives the VM up, so the slot is recreated elsewhere
+	manager.hosts[0].downSince = time.Now().Add(-hostFailoverDelay)
+	if _, err := manager.GetVMState("runner-1"); !errors.Is(err, ErrVMNotFound) {
+		t.Fatalf("Expected ErrVMNotFound after the failover delay, got %v", err)
+	}
+	if err := manager.CreateVM(slots[0]); err != nil {
+		t.Fatalf("CreateVM on the remaining host failed: %v", err)
+	}
+	if slots[0].Host != "hv02" {
+		t.Errorf("Expected runner-1 to move to hv02, got %q", slots[0].Host)
+	}
+
+	// A VM that cannot be destroyed on an unreachable host is given up right away
+	if err := manager.CreateVM(slots[2]); !errors.Is(err, ErrHostUnavailable) {
+		t.Fatalf("Expected ErrHostUnavailable placing on the down host with hv02 full, got %v", err)
+	}
+	if err := manager.DestroyVM(slots[1]); err != nil {
+		t.Fatalf("DestroyVM failed: %v", err)
+	}
+	if err := manager.CreateVM(slots[2]); err != nil {
+		t.Fatalf("CreateVM after the failed attempt failed: %v", err)
+	}
+	if slots[2].Host != "hv02" {
+		t.Errorf("Expected runner-3 on hv02 while hv01 is down, got %q", slots[2].Host)
+	}
+
+	// Once hv01 answers again it takes new VMs
+	hv01.responses = hostResponses()
+	if err := manager.CleanupLeftoverResources("runner-"); err != nil {
+		t.Fatalf("CleanupLeftoverResources failed: %v", err)
+	}
+	if err := manager.CreateVM(slots[1]); err != nil {
+		t.Fatalf("CreateVM after the host came back failed: %v", err)
+	}
+	if slots[1].Host != "hv01" {
+		t.Errorf("Expected runner-2 on hv01 once it is back, got %q", slots[1].Host)
+	}
+}
+
+func TestWinRMPowerShell_WrapsScript(t *testing.T) {
+	local := &scriptedExecutor{responses: []cannedResponse{{match: "Invoke-Command", err: errors.New("powershell error: exit status 1")}}}
+	executor := &winrmPowerShell{
+		host:  config.HostConfig{Name: "hv01", Address: "hv01.example.com", Port: 5986, UseSSL: true, Username: `CORP\pool`, Password: "it's secret"},
+		local: local,
+	}
+
+	_, err := executor.Run(`(Get-VM -Name "runner-1").State`)
+	if err == nil || !strings.Contains(err.Error(), "host hv01") {
+		t.Errorf("Expected error naming the host, got %v", err)
+	}
+	if len(local.scripts) != 1 {
+		t.Fatalf("Expected one local script, got %d", len(local.scripts))
+	}
+
+	wrapper := local.scripts[0]
+	for _, want := range []string{
+		"ComputerName = 'hv01.example.com'",
+		"Port         = 5986",
+		"UseSSL       = $true",
+		"ConvertTo-SecureString 'it''s secret'",
+		`PSCredential('CORP\pool', $password)`,
+		// base64 of the script, so it needs no escaping
+		"KEdldC1WTSAtTmFtZSAicnVubmVyLTEiKS5TdGF0ZQ==",
+	} {
+		if !strings.Contains(wrapper, want) {
+			t.Errorf("Expected wrapper to contain %q\n%s", want, wrapper)
+		}
+	}
+}
+
+func TestPowerShellError_Classification(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   error
+	}{
+		{`Get-VM : Hyper-V was unable to find a virtual machine with name "runner-1".`, ErrVMNotFound},
+		{`New-VM : Hyper-V was unable to find a virtual switch with name "Pool Switch".`, ErrSwitchNotFound},
+		{`Connecting to remote server hv01 failed with the following error message : Access is denied.`, ErrAccessDenied},
+		{`Connecting to remote server hv01 failed with the following error message : WinRM cannot complete the operation.`, ErrHostUnavailable},
+		{`ssh: connect to host hv02 port 22: Connection refused`, ErrHostUnavailable},
+		{`Start-VM : 'runner-1' could not initialize memory: Ran out of memory (0x8007000E).`, ErrInsufficientMemory},
+		{`Failed to execute script after 10 attempts: The credential is invalid.`, ErrGuestAuthFailed},
+		{`Failed to execute script after 10 attempts: An error has occurred which Windows PowerShell cannot handle. A remote session might have ended.`, ErrGuestUnreachable},
+		{`Failed to execute script after 10 attempts: Script exited with code 1`, nil},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("failed to create VM: %w", newPowerShellError("", "", tt.stderr, errors.New("exit status 1")))
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("Expected %q to be classified as %v, got %v", tt.stderr, tt.want, err)
+		}
+		var psErr *PowerShellError
+		if !errors.As(err, &psErr) || psErr.Kind != tt.want {
+			t.Errorf("Expected kind %v for %q, got %+v", tt.want, tt.stderr, psErr)
+		}
+	}
+
+	// What a guest raised over PowerShell Direct is never a host access problem
+	manager, _ := newScriptedManager(cannedResponse{match: "Invoke-Command", err: newPowerShellError("", "", "Failed to execute script after 10 attempts: Access is denied.", errors.New("exit status 1"))})
+	if err := manager.ExecuteScriptInVM("runner-1", "Write-Host hi"); err == nil || errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected an unclassified guest failure, got %v", err)
+	}
+
+	// Remote hosts name themselves in the error, which keeps its classification
+	local := &scriptedExecutor{responses: []cannedResponse{{match: "Invoke-Command", err: newPowerShellError("", "", "Access is denied.", errors.New("exit status 1"))}}}
+	executor := &winrmPowerShell{host: config.HostConfig{Name: "hv01", Address: "hv01.example.com", Port: 5985}, local: local}
+	if _, err := executor.Run("Get-VM"); !errors.Is(err, ErrAccessDenied) || !strings.HasPrefix(err.Error(), "host hv01: ") {
+		t.Errorf("Expected a classified error naming the host, got %v", err)
+	}
+
+	// The script's output is kept for the message but never classified
+	output := strings.Repeat("x", 2*maxErrorStdout) + "\nCreating VM runner-1\nAccess is denied.\n"
+	psErr := newPowerShellError("", output, "Script exited with code 1", errors.New("exit status 1"))
+	if psErr.Kind != nil {
+		t.Errorf("Expected stdout to be ignored by the classifier, got %v", psErr.Kind)
+	}
+	if !strings.Contains(psErr.Error(), "stdout: ...") || !strings.Contains(psErr.Error(), "Creating VM runner-1") || len(psErr.Stdout) > maxErrorStdout+3 {
+		t.Errorf("Expected the trimmed tail of stdout in the error, got %q", psErr.Error())
+	}
+}
+
+func TestEncodePowerShellCommand(t *testing.T) {
+	// powershell.exe -EncodedCommand expects base64 of UTF-16LE
+	if got := encodePowerShellCommand("dir"); got != "ZABpAHIA" {
+		t.Errorf("Expected ZABpAHIA, got %s", got)
+	}
+}
+
+// policyConfig returns the golden configuration with a restrictive network policy
+func policyConfig() config.Config {
+	cfg := goldenConfig()
+	cfg.HyperV.Network.Adapters[0].StaticIP = &config.StaticIPConfig{
+		StartAddress: "10.0.5.10",
+		PrefixLength: 24,
+		Gateway:      "10.0.5.1",
+		DNSServers:   []string{"10.0.5.53"},
+	}
+	cfg.HyperV.Network.Policy = config.NetworkPolicyConfig{
+		AllowedEgress: []config.EgressRuleConfig{
+			{CIDR: "0.0.0.0/0", Ports: []int{80, 443}, Protocol: "tcp"},
+			{CIDR: "10.0.5.2/32", Ports: []int{3000}, Protocol: "tcp"},
+		},
+		BlockVMToVM:     true,
+		VMNetworks:      []string{"10.0.5.0/24"},
+		BlockedNetworks: []string{"192.168.10.0/24"},
+	}
+	return cfg
+}
+
+func TestNetworkPolicyRules(t *testing.T) {
+	if rules := networkPolicyRules(goldenConfig().HyperV.Network); len(rules) != 0 {
+		t.Errorf("Expected no port ACLs without a policy, got %+v", rules)
+	}
+
+	want := []aclRule{
+		{Action: "Deny", Direction: "Outbound", Weight: 1},
+		{Action: "Allow", Direction: "Outbound", RemoteIP: "0.0.0.0/0", Protocol: "TCP", Port: 80, Weight: 2},
+		{Action: "Allow", Direction: "Outbound", RemoteIP: "0.0.0.0/0", Protocol: "TCP", Port: 443, Weight: 3},
+		{Action: "Deny", Direction: "Outbound", RemoteIP: "10.0.5.0/24", Weight: 4},
+		// Allowed egress inside the VM network must outrank the VM network deny
+		{Action: "Allow", Direction: "Outbound", RemoteIP: "10.0.5.2/32", Protocol: "TCP", Port: 3000, Weight: 5},
+		{Action: "Allow", Direction: "Outbound", RemoteIP: "10.0.5.53/32", Protocol: "UDP", Port: 53, Weight: 6},
+		{Action: "Allow", Direction: "Outbound", RemoteIP: "10.0.5.53/32", Protocol: "TCP", Port: 53, Weight: 7},
+		{Action: "Allow", Direction: "Outbound", Protocol: "UDP", Port: 67, Weight: 8},
+		{Action: "Deny", Direction: "Outbound", RemoteIP: "192.168.10.0/24", Weight: 9},
+		{Action: "Deny", Direction: "Inbound", RemoteIP: "192.168.10.0/24", Weight: 10},
+	}
+	got := networkPolicyRules(policyConfig().HyperV.Network)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d rules, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Rule %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	// Resolvers from DHCP are unknown, so DHCP adapters may reach DNS on any address
+	dhcp := policyConfig().HyperV.Network
+	dhcp.Adapters[0].StaticIP = nil
+	got = networkPolicyRules(dhcp)
+	for _, rule := range []aclRule{
+		{Action: "Allow", Direction: "Outbound", Protocol: "UDP", Port: 53, Weight: 6},
+		{Action: "Allow", Direction: "Outbound", Protocol: "TCP", Port: 53, Weight: 7},
+	} {
+		if !slices.Contains(got, rule) {
+			t.Errorf("Expected DHCP adapters to be allowed DNS with %+v, got %+v", rule, got)
+		}
+	}
+}
+
+func TestHyperVManager_NetworkPolicy_Golden(t *testing.T) {
+	manager := NewHyperVManagerWithExecutor(policyConfig(), &scriptedExecutor{}, testLogger())
+
+	commands, err := manager.networkAdapterCommands("runner-1", 1)
+	if err != nil {
+		t.Fatalf("networkAdapterCommands failed: %v", err)
+	}
+	assertGolden(t, "network_policy", []string{commands})
+}
+
+func TestHyperVManager_VerifyNetworkPolicy(t *testing.T) {
+	var applied strings.Builder
+	for _, rule := range networkPolicyRules(policyConfig().HyperV.Network) {
+		// Read back the way Hyper-V reports it: unset fields as ANY, single addresses without a prefix length
+		remoteIP, protocol, port := "ANY", "ANY", "ANY"
+		if rule.RemoteIP != "" {
+			remoteIP = strings.TrimSuffix(rule.RemoteIP, "/32")
+		}
+		if rule.Protocol != "" {
+			protocol = rule.Protocol
+		}
+		if rule.Port != 0 {
+			port = strconv.Itoa(rule.Port)
+		}
+		fmt.Fprintf(&applied, "ACL:Network Adapter 1|%d|%s|%s|%s|%s|%s\r\n", rule.Weight, rule.Action, rule.Direction, remoteIP, protocol, port)
+	}
+
+	executor := &scriptedExecutor{responses: []cannedResponse{{match: "Get-VMNetworkAdapterExtendedAcl", output: applied.String()}}}
+	manager := NewHyperVManagerWithExecutor(policyConfig(), executor, testLogger())
+	if err := manager.verifyNetworkPolicy("runner-1"); err != nil {
+		t.Errorf("Expected the applied policy to verify, got %v", err)
+	}
+
+	// A rule that did not stick, plus one nobody asked for
+	tampered := strings.Replace(applied.String(), "ACL:Network Adapter 1|10|Deny|Inbound|192.168.10.0/24|ANY|ANY\r\n", "ACL:Network Adapter 1|11|Allow|Inbound|ANY|ANY|ANY\r\n", 1)
+	executor.responses[0].output = tampered
+	err := manager.verifyNetworkPolicy("runner-1")
+	if err == nil || !strings.Contains(err.Error(), "10|Deny|Inbound|192.168.10.0/24||") || !strings.Contains(err.Error(), "11|Allow|Inbound|||") {
+		t.Errorf("Expected verification to report the missing and unexpected rules, got %v", err)
+	}
+
+	// A rule with the right weight and action but a wider remote address or another port is not the policy
+	for _, widened := range []string{
+		strings.Replace(applied.String(), "|Allow|Outbound|10.0.5.2|TCP|3000", "|Allow|Outbound|ANY|TCP|3000", 1),
+		strings.Replace(applied.String(), "|Allow|Outbound|0.0.0.0/0|TCP|443", "|Allow|Outbound|0.0.0.0/0|TCP|ANY", 1),
+		strings.Replace(applied.String(), "|Allow|Outbound|10.0.5.53|UDP|53", "|Allow|Outbound|10.0.5.53|ANY|53", 1),
+	} {
+		executor.responses[0].output = widened
+		if widened == applied.String() {
+			t.Fatal("Expected the test to alter the applied rules")
+		}
+		if err := manager.verifyNetworkPolicy("runner-1"); err == nil {
+			t.Errorf("Expected verification to fail for\n%s", widened)
+		}
+	}
+
+	// CreateVM stops before the VM is started or given a token
+	executor = &scriptedExecutor{responses: append([]cannedResponse{{match: "Get-VMNetworkAdapterExtendedAcl", output: ""}}, hostResponses()...)}
+	manager = NewHyperVManagerWithExecutor(policyConfig(), executor, testLogger())
+	if err := manager.CreateVM(&VMSlot{Index: 1, Name: "runner-1"}); err == nil {
+		t.Fatal("Expected CreateVM to fail when the policy is missing")
+	}
+	if executor.ran("Start-VM") || executor.ran("Mount-VHD") {
+		t.Error("Expected no config injection or boot after failed policy verification")
+	}
+}
+
+func TestHyperVManager_MeasureVM(t *testing.T) {
+	manager, executor := newScriptedManager(
+		cannedResponse{match: "Measure-VM", output: "WARNING: chatter\r\nMETER:3600|1850|3072|4096|2900|1200|800|350|42\r\n"},
+	)
+
+	usage, err := manager.MeasureVM(&VMSlot{Name: "runner-1"})
+	if err != nil {
+		t.Fatalf("MeasureVM failed: %v", err)
+	}
+	want := ResourceUsage{
+		SampledAt:          usage.SampledAt,
+		MeteredFor:         time.Hour,
+		AvgCPUMHz:          1850,
+		AvgMemoryMB:        3072,
+		MaxMemoryMB:        4096,
+		MemoryDemandMB:     2900,
+		PeakMemoryDemandMB: 2900,
+		DiskReadMB:         1200,
+		DiskWrittenMB:      800,
+		NetworkInboundMB:   350,
+		NetworkOutboundMB:  42,
+	}
+	if usage != want {
+		t.Errorf("Expected %+v, got %+v", want, usage)
+	}
+	if !executor.ran(`Get-VM -Name "runner-1"`) {
+		t.Error("Expected the sample to be taken from runner-1")
+	}
+
+	for _, output := range []string{"", "METER:1|2|3\r\n", "METER:a|2|3|4|5|6|7|8|9\r\n"} {
+		if _, err := parseMeterOutput(output, time.Now()); err == nil {
+			t.Errorf("Expected error for metering output %q", output)
+		}
+	}
+}
+
+func TestHyperVManager_InspectVM(t *testing.T) {
+	manager, executor := newScriptedManager(
+		cannedResponse{match: "Get-VMHardDiskDrive", output: "MEMORY_MB:4096\r\nCPU_COUNT:2\r\nDISK:D:\\vms\\runner-1.vhdx\r\nDISK:D:\\vms\\runner-1-scratch.vhdx\r\n"},
+	)
+
+	hardware, err := manager.InspectVM("runner-1")
+	if err != nil {
+		t.Fatalf("InspectVM failed: %v", err)
+	}
+	want := VMHardware{MemoryMB: 4096, CPUCount: 2, Disks: []string{`D:\vms\runner-1.vhdx`, `D:\vms\runner-1-scratch.vhdx`}}
+	if !reflect.DeepEqual(hardware, want) {
+		t.Errorf("Expected %+v, got %+v", want, hardware)
+	}
+	if !executor.ran(`$_.Name -eq "runner-1"`) {
+		t.Error("Expected runner-1 to be inspected")
+	}
+
+	// A VM deleted in Hyper-V Manager is reported as such by every lookup
+	manager, _ = newScriptedManager(cannedResponse{match: "Get-VM |", output: "VM_NOT_FOUND\r\n"})
+	if _, err := manager.InspectVM("runner-1"); !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("Expected ErrVMNotFound from InspectVM, got %v", err)
+	}
+	if _, err := manager.GetVMState("runner-1"); !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("Expected ErrVMNotFound from GetVMState, got %v", err)
+	}
+	if err := manager.DestroyVM(&VMSlot{Name: "runner-1"}); err != nil {
+		t.Errorf("Expected DestroyVM to clean up after a deleted VM, got %v", err)
+	}
+	if name, err := manager.QuarantineVM(&VMSlot{Index: 1, Name: "runner-1"}, "failed"); name != "" || err != nil {
+		t.Errorf("Expected QuarantineVM to skip a deleted VM, got %q, %v", name, err)
+	}
+
+	// Host errors are not mistaken for a deleted VM
+	manager, _ = newScriptedManager(cannedResponse{match: "Get-VM |", err: errors.New("The Hyper-V Virtual Machine Management service is not running")})
+	if _, err := manager.GetVMState("runner-1"); err == nil || errors.Is(err, ErrVMNotFound) {
+		t.Errorf("Expected a plain error when the host cannot list VMs, got %v", err)
+	}
+}
+
+func TestHyperVManager_ProbeGuest(t *testing.T) {
+	manager, executor := newScriptedManager(
+		cannedResponse{match: "Runner.Listener", output: "LISTENER:True\r\nFREE_DISK_BYTES:10737418240\r\nLOG_AGE_SECONDS:120\r\n"},
+	)
+	manager.config.Monitoring.GuestProbe.TimeoutSeconds = 20
+
+	status, err := manager.ProbeGuest(&VMSlot{Name: "runner-1"})
+	if err != nil {
+		t.Fatalf("ProbeGuest failed: %v", err)
+	}
+	if !status.ListenerRunning || status.FreeDiskBytes != 10<<30 || !status.HeartbeatAt.IsZero() {
+		t.Errorf("Unexpected guest status: %+v", status)
+	}
+	if age := time.Since(status.LastLogAt); age < 2*time.Minute || age > 3*time.Minute {
+		t.Errorf("Expected the last log line to be about 2 minutes old, got %v", age)
+	}
+	assertGolden(t, "probe_guest", executor.scripts)
+
+	// A guest that answers nothing usable is unreachable
+	manager, _ = newScriptedManager(cannedResponse{match: "Runner.Listener", output: "\r\n"})
+	if _, err := manager.ProbeGuest(&VMSlot{Name: "runner-1"}); !errors.Is(err, ErrGuestUnreachable) {
+		t.Errorf("Expected ErrGuestUnreachable for an empty answer, got %v", err)
+	}
+
+	// Linux guests report their readings as KVP items
+	heartbeat := time.Now().Add(-time.Minute).Unix()
+	executor = &scriptedExecutor{responses: []cannedResponse{{match: "GuestExchangeItems", output: fmt.Sprintf(
+		"KVP:runner-heartbeat=%d\r\nKVP:runner-listener=0\r\nKVP:runner-free-disk-bytes=1024\r\nKVP:runner-last-log=0\r\n", heartbeat)}}}
+	manager = NewHyperVManagerWithExecutor(linuxConfig(), executor, testLogger())
+	status, err = manager.ProbeGuest(&VMSlot{Name: "runner-1"})
+	if err != nil {
+		t.Fatalf("ProbeGuest failed for a Linux guest: %v", err)
+	}
+	if status.ListenerRunning || status.FreeDiskBytes != 1024 || status.HeartbeatAt.Unix() != heartbeat || !status.LastLogAt.IsZero() {
+		t.Errorf("Unexpected Linux guest status: %+v", status)
+	}
+
+	executor.responses = []cannedResponse{{match: "GuestExchangeItems", output: "\r\n"}}
+	if _, err := manager.ProbeGuest(&VMSlot{Name: "runner-1"}); !errors.Is(err, ErrGuestUnreachable) {
+		t.Errorf("Expected ErrGuestUnreachable without KVP readings, got %v", err)
+	}
+}
+
+func TestHyperVManager_MeteringCommands(t *testing.T) {
+	manager, _ := newScriptedManager()
+	if cmd := manager.meteringCommands("runner-1"); cmd != "" {
+		t.Errorf("Expected no metering commands when disabled, got %q", cmd)
+	}
+
+	manager.config.HyperV.Metering.Enabled = true
+	if cmd := manager.meteringCommands("runner-1"); !strings.Contains(cmd, `Enable-VMResourceMetering -VMName "runner-1"`) {
+		t.Errorf("Expected metering to be enabled, got %q", cmd)
+	}
+}
+
+func TestHyperVManager_ExtraDisks_Golden(t *testing.T) {
+	manager, _ := newScriptedManager()
+	slot := &VMSlot{Index: 1, Name: "runner-1", StoragePath: `E:\vms`}
+	if cmd := manager.extraDiskCommands(slot); cmd != "" {
+		t.Errorf("Expected no extra disk commands without disks, got %q", cmd)
+	}
+
+	manager.config.HyperV.Disks = config.DisksConfig{
+		ToolCache: config.ToolCacheDiskConfig{Path: `C:\templates\toolcache-2026.10.vhdx`, DriveLetter: "T"},
+		Scratch:   config.ScratchDiskConfig{SizeGB: 100, DriveLetter: "S"},
+	}
+	assertGolden(t, "extra_disks", []string{manager.extraDiskCommands(slot)})
+
+	runnerConfig, err := manager.runnerConfigFor(slot)
+	if err != nil {
+		t.Fatalf("runnerConfigFor failed: %v", err)
+	}
+	if runnerConfig.ToolCacheDrive != "T" || runnerConfig.ScratchDrive != "S" {
+		t.Errorf("Expected drive letters T and S in the runner config, got %q and %q",
+			runnerConfig.ToolCacheDrive, runnerConfig.ScratchDrive)
+	}
+}
+
+// linuxConfig returns the golden configuration for a Linux template with KVP config delivery
+func linuxConfig() config.Config {
+	cfg := goldenConfig()
+	cfg.HyperV.GuestOS = config.GuestLinux
+	cfg.HyperV.ConfigInjection = config.InjectionKVP
+	cfg.Monitoring.CreationTimeoutMinutes = 5
+	return cfg
+}
+
+func TestHyperVManager_CreateVM_Linux(t *testing.T) {
+	executor := &scriptedExecutor{responses: []cannedResponse{
+		{match: "DriveInfo", output: "STORAGE_FREE:107374182400|E:\\vms\r\n"},
+		{match: `Get-VMIntegrationService -VMName "runner-1" -Name "Heartbeat"`, output: "HEARTBEAT_OK\n"},
+		{match: "Get-VMNetworkAdapter", output: "192.168.0.10\r\n"},
+	}}
+	manager := NewHyperVManagerWithExecutor(linuxConfig(), executor, testLogger())
+	slot := &VMSlot{Index: 1, Name: "runner-1", RunnerToken: "AABBCC"}
+
+	if err := manager.CreateVM(slot); err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+	if !executor.ran(`Add-VMDvdDrive -VMName "runner-1" -Path "E:\vms\runner-1-seed.iso"`) {
+		t.Error("Expected the cloud-init seed ISO to be attached")
+	}
+	if !executor.ran(`$items["runner-config-chunks"]`) {
+		t.Error("Expected the runner config to be pushed over KVP")
+	}
+	if executor.ran("Invoke-Command -VMName") || executor.ran("Mount-VHD") {
+		t.Error("Expected no PowerShell Direct or disk mounts for a Linux guest")
+	}
+	if slot.IPAddress != "192.168.0.10" {
+		t.Errorf("Expected IP address 192.168.0.10, got %q", slot.IPAddress)
+	}
+
+	runnerConfig, err := manager.runnerConfigFor(slot)
+	if err != nil {
+		t.Fatalf("runnerConfigFor failed: %v", err)
+	}
+	if runnerConfig.Labels != "self-hosted,Linux,X64,ephemeral,owner-pool-a,gpu" {
+		t.Errorf("Expected Linux default labels, got %q", runnerConfig.Labels)
+	}
+}
+
+func TestHyperVManager_CreateVM_LinuxHeartbeatTimeout(t *testing.T) {
+	executor := &scriptedExecutor{responses: []cannedResponse{
+		{match: "DriveInfo", output: "STORAGE_FREE:107374182400|E:\\vms\r\n"},
+		{match: "Get-VMIntegrationService", err: errors.New("Guest heartbeat not OK before the deadline (status: No Contact)")},
+	}}
+	manager := NewHyperVManagerWithExecutor(linuxConfig(), executor, testLogger())
+
+	err := manager.CreateVM(&VMSlot{Index: 1, Name: "runner-1"})
+	if err == nil || !strings.Contains(err.Error(), "No Contact") {
+		t.Fatalf("Expected heartbeat timeout error, got %v", err)
+	}
+}
+
+func TestHyperVManager_CloudInitSeedFiles(t *testing.T) {
+	cfg := linuxConfig()
+	cfg.HyperV.Network.Adapters = []config.NetworkAdapterConfig{
+		{Name: "Network Adapter 1", SwitchName: "Internal", MACAddressStart: "00155D0A0001", StaticIP: &config.StaticIPConfig{
+			StartAddress: "10.0.5.10",
+			PrefixLength: 24,
+			Gateway:      "10.0.5.1",
+			DNSServers:   []string{"10.0.5.53", "10.0.5.54"},
+		}},
+		{Name: "Network Adapter 2", SwitchName: "Default Switch", MACAddressStart: "00155D0B0001"},
+	}
+	manager := NewHyperVManagerWithExecutor(cfg, &scriptedExecutor{}, testLogger())
+	slot := &VMSlot{Index: 2, Name: "runner-2"}
+
+	files, err := manager.cloudInitSeedFiles(slot, RunnerConfig{Name: "runner-2"}, false)
+	if err != nil {
+		t.Fatalf("cloudInitSeedFiles failed: %v", err)
+	}
+	seed := make(map[string]string)
+	for _, file := range files {
+		seed[file.Name] = string(file.Data)
+	}
+
+	if _, ok := seed["runner-config.json"]; ok {
+		t.Error("Expected no runner config on the seed when it is delivered over KVP")
+	}
+	if seed["meta-data"] != "instance-id: runner-2\nlocal-hostname: runner-2\n" {
+		t.Errorf("Unexpected meta-data: %q", seed["meta-data"])
+	}
+	encodedScript := base64.StdEncoding.EncodeToString([]byte(configureRunnerLinuxScript))
+	if !strings.HasPrefix(seed["user-data"], "#cloud-config\n") || !strings.Contains(seed["user-data"], encodedScript) {
+		t.Errorf("Expected user-data to install configure-runner.sh, got %q", seed["user-data"])
+	}
+
+	wantNetwork := `version: 2
+ethernets:
+  eth0:
+    match:
+      macaddress: "00:15:5d:0a:00:02"
+    dhcp4: false
+    addresses: ["10.0.5.11/24"]
+    routes:
+      - to: default
+        via: "10.0.5.1"
+    nameservers:
+      addresses: ["10.0.5.53", "10.0.5.54"]
+  eth1:
+    match:
+      macaddress: "00:15:5d:0b:00:02"
+    dhcp4: true
+`
+	if seed["network-config"] != wantNetwork {
+		t.Errorf("Unexpected network-config:\n%s\nwant:\n%s", seed["network-config"], wantNetwork)
+	}
+}
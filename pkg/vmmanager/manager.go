@@ -3,6 +3,8 @@ package vmmanager
 import (
 	"sync"
 	"time"
+
+	"hyperv-runner-pool/pkg/config"
 )
 
 // VMManager is the interface for VM operations
@@ -14,17 +16,72 @@ type VMManager interface {
 	InjectConfig(vhdxPath string, config RunnerConfig) error
 	RunPowerShell(command string) (string, error)
 	CleanupLeftoverResources(namePrefix string) error
+	ValidateTemplate(templatePath string) error
+	GetDiskUsage(slot *VMSlot) (int64, error)
+	CollectDiagnostics(slot *VMSlot, reason string) (string, error)
+	QuarantineVM(slot *VMSlot, reason string) (string, error)
+	PruneQuarantine() error
+	MeasureVM(slot *VMSlot) (ResourceUsage, error)
+	CollectGarbage(namePrefix string, slots []*VMSlot, gracePeriod time.Duration) ([]string, error)
+	UpdateConfig(cfg config.Config)
+	InspectVM(vmName string) (VMHardware, error)
+	ProbeGuest(slot *VMSlot) (GuestStatus, error)
+}
+
+// GuestStatus is what the in-guest liveness probe found
+type GuestStatus struct {
+	ListenerRunning bool      // Whether the runner's Runner.Listener process is alive
+	FreeDiskBytes   int64     // Free space on the guest's system disk
+	LastLogAt       time.Time // When the newest runner log got its last line (zero: no log yet)
+	HeartbeatAt     time.Time // When the configure script last wrote its heartbeat (zero: none)
+}
+
+// VMHardware is the memory, CPU and disk configuration of a VM as found on its host
+type VMHardware struct {
+	MemoryMB int64    // Startup memory
+	CPUCount int      // Virtual processors
+	Disks    []string // Paths of the attached virtual hard disks
+}
+
+// ResourceUsage is one resource metering sample of a VM
+// Totals and averages cover the VM's whole lifecycle, since metering starts when the VM is created
+type ResourceUsage struct {
+	SampledAt          time.Time
+	MeteredFor         time.Duration // Time covered by the metering counters
+	AvgCPUMHz          int64         // Average CPU usage
+	AvgMemoryMB        int64         // Average memory assigned
+	MaxMemoryMB        int64         // Peak memory assigned
+	MemoryDemandMB     int64         // Memory demand when sampled
+	PeakMemoryDemandMB int64         // Highest memory demand seen by any sample
+	DiskReadMB         int64         // Data read from the VM's disks
+	DiskWrittenMB      int64         // Data written to the VM's disks
+	NetworkInboundMB   int64         // Network traffic received
+	NetworkOutboundMB  int64         // Network traffic sent
 }
 
 // RunnerConfig is the configuration sent to VMs for runner registration
 type RunnerConfig struct {
-	Token        string `json:"token"`
-	Organization string `json:"organization"`
-	Repository   string `json:"repository"`
-	Name         string `json:"name"`
-	Labels       string `json:"labels"`
-	RunnerGroup  string `json:"runner_group,omitempty"` // Optional: for org-level runners only
-	CacheURL     string `json:"cache_url,omitempty"`     // Optional: URL to local cache server
+	Token            string           `json:"token"`
+	Organization     string           `json:"organization"`
+	Repository       string           `json:"repository"`
+	Name             string           `json:"name"`
+	Labels           string           `json:"labels"`
+	RunnerGroup      string           `json:"runner_group,omitempty"`      // Optional: for org-level runners only
+	CacheURL         string           `json:"cache_url,omitempty"`         // Optional: URL to local cache server
+	Network          []AdapterAddress `json:"network,omitempty"`           // Optional: static IPv4 settings applied by the guest
+	ToolCacheDrive   string           `json:"tool_cache_drive,omitempty"`  // Optional: drive letter for the shared tool cache disk
+	ScratchDrive     string           `json:"scratch_drive,omitempty"`     // Optional: drive letter for the scratch disk, holds the work directory
+	HeartbeatSeconds int              `json:"heartbeat_seconds,omitempty"` // Optional: how often the guest writes its heartbeat for the liveness probe
+}
+
+// AdapterAddress is the static IPv4 configuration for one guest network adapter
+// The guest finds the adapter by its Hyper-V device name
+type AdapterAddress struct {
+	AdapterName  string   `json:"adapter_name"`
+	IPAddress    string   `json:"ip_address"`
+	PrefixLength int      `json:"prefix_length"`
+	Gateway      string   `json:"gateway,omitempty"`
+	DNSServers   []string `json:"dns_servers,omitempty"`
 }
 
 // VMState represents the lifecycle state of a VM
@@ -40,12 +97,36 @@ const (
 
 // VMSlot represents a slot in the VM pool
 type VMSlot struct {
-	Name               string
-	State              VMState
-	RunnerToken        string
-	JobID              int64
-	CreatedAt          time.Time // When VM creation started
-	LastHealthCheck    time.Time // Last successful health check
-	HealthCheckFailures int       // Consecutive health check failures
-	mu                 sync.Mutex
+	Index               int // 1-based position in the pool, used for per-slot addressing
+	Name                string
+	State               VMState
+	RunnerToken         string
+	JobID               int64
+	CreatedAt           time.Time     // When VM creation started
+	LastHealthCheck     time.Time     // Last successful health check
+	HealthCheckFailures int           // Consecutive health check failures
+	PowerStateErrors    int           // Consecutive errors reading the VM's power state
+	OfflineReadings     int           // Consecutive readings of the runner as offline in GitHub
+	MissingReadings     int           // Consecutive readings without the runner in GitHub
+	GitHubErrors        int           // Consecutive errors looking up the runner in GitHub
+	GuestProbeFailures  int           // Consecutive failed in-guest liveness probes
+	Guest               GuestStatus   // Latest in-guest liveness probe result (zero: not probed yet)
+	IPAddress           string        // Primary IPv4 address of the VM, if known
+	TemplateVersion     string        // Template version the VM was built from (empty: active version)
+	TemplatePath        string        // VHDX the template version resolved to when the VM was built (empty: unknown)
+	RegisteredAt        time.Time     // When the runner was first seen online in GitHub
+	RunnerID            int64         // GitHub ID of the slot's runner, recorded when it is first seen (0: not seen yet)
+	RunnerBusy          bool          // Whether the runner was running a job at the last health check
+	JobStartedAt        time.Time     // When the runner was first seen busy and the slot entered the running state
+	RunnerGoneAt        time.Time     // When the runner was first missing after its job, while the VM powers off (zero: not missing)
+	HardwareProfile     string        // Fingerprint of the hardware settings the VM was built with
+	BuiltMemoryMB       int           // vm_memory_mb the VM was built with
+	BuiltCPUCount       int           // vm_cpu_count the VM was built with
+	Hardware            VMHardware    // Hardware found by the first health check, compared by later ones (zero: not inspected yet)
+	Drift               string        // Hardware drift flagged by the last health check (empty: none)
+	StoragePath         string        // Storage path holding the VM's disks
+	DiskSizeBytes       int64         // Last measured size of the VM's differencing disk
+	Host                string        // Hyper-V host the VM was placed on (empty: the local host)
+	Usage               ResourceUsage // Latest resource metering sample (zero: not sampled yet)
+	mu                  sync.Mutex
 }
@@ -3,46 +3,163 @@ package vmmanager
 import (
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
 	"sync"
 	"time"
+
+	"hyperv-runner-pool/pkg/config"
 )
 
 // MockVMManager implements VMManager for testing on non-Windows platforms
+// A scenario adds latency, failures, job completion and externally deleted VMs
 type MockVMManager struct {
 	simulatedVMs map[string]string
+	generations  map[string]int         // VM name -> creation count, so a finished job only stops its own VM
+	altered      map[string]VMHardware  // VM name -> hardware changed behind the orchestrator's back
+	guests       map[string]GuestStatus // VM name -> guest probe result set by SetGuestStatus
+	calls        map[string]int         // call name -> number of calls made
+	scenario     config.MockScenarioConfig
+	rng          *rand.Rand
 	mu           sync.Mutex
 	logger       *slog.Logger
 }
 
-// NewMockVMManager creates a new mock VM manager
+// NewMockVMManager creates a new mock VM manager that always succeeds after a short delay
 func NewMockVMManager(logger *slog.Logger) *MockVMManager {
+	return NewMockVMManagerWithScenario(config.MockScenarioConfig{
+		CreateVM:  config.MockCallConfig{LatencyMinMS: 500, LatencyMaxMS: 500},
+		DestroyVM: config.MockCallConfig{LatencyMinMS: 300, LatencyMaxMS: 300},
+	}, logger)
+}
+
+// NewMockVMManagerWithScenario creates a mock VM manager that follows a scenario
+func NewMockVMManagerWithScenario(scenario config.MockScenarioConfig, logger *slog.Logger) *MockVMManager {
+	seed := scenario.Seed
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
 	return &MockVMManager{
 		simulatedVMs: make(map[string]string),
+		generations:  make(map[string]int),
+		altered:      make(map[string]VMHardware),
+		guests:       make(map[string]GuestStatus),
+		calls:        make(map[string]int),
+		scenario:     scenario,
+		rng:          rand.New(rand.NewPCG(seed, seed)),
 		logger:       logger.With("component", "mock"),
 	}
 }
 
+// simulateCall waits out the call's latency and decides whether it fails
+func (m *MockVMManager) simulateCall(name string, call config.MockCallConfig) error {
+	m.mu.Lock()
+	m.calls[name]++
+	number := m.calls[name]
+	latency := call.LatencyMinMS
+	if call.LatencyMaxMS > latency {
+		latency += m.rng.IntN(call.LatencyMaxMS - latency + 1)
+	}
+	fail := slices.Contains(call.FailCalls, number) ||
+		(call.FailureProbability > 0 && m.rng.Float64() < call.FailureProbability)
+	m.mu.Unlock()
+
+	time.Sleep(time.Duration(latency) * time.Millisecond)
+
+	if fail {
+		m.logger.Debug("Simulated call failure", "call", name, "number", number)
+		return fmt.Errorf("simulated %s failure (call %d)", name, number)
+	}
+	return nil
+}
+
+// Calls returns how many times a VMManager method has been called, e.g. Calls("CreateVM")
+func (m *MockVMManager) Calls(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[name]
+}
+
 // CreateVM simulates VM creation
 func (m *MockVMManager) CreateVM(slot *VMSlot) error {
+	if err := m.simulateCall("CreateVM", m.scenario.CreateVM); err != nil {
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Simulate creation delay
-	time.Sleep(500 * time.Millisecond)
-
 	m.simulatedVMs[slot.Name] = "Running"
-	m.logger.Debug("VM created (simulated)", "vm_name", slot.Name)
+	m.generations[slot.Name]++
+	delete(m.altered, slot.Name)
+	delete(m.guests, slot.Name)
+	slot.IPAddress = fmt.Sprintf("192.0.2.%d", slot.Index)
+
+	// The runner shuts the guest down once its job is done
+	if m.scenario.JobMaxSeconds > 0 {
+		duration := time.Duration(m.scenario.JobMinSeconds) * time.Second
+		if spread := m.scenario.JobMaxSeconds - m.scenario.JobMinSeconds; spread > 0 {
+			duration += time.Duration(m.rng.Int64N(int64(spread) * int64(time.Second)))
+		}
+		generation := m.generations[slot.Name]
+		time.AfterFunc(duration, func() { m.completeJob(slot.Name, generation) })
+		m.logger.Debug("Simulated job scheduled", "vm_name", slot.Name, "duration", duration.Round(time.Millisecond))
+	}
+
+	m.logger.Debug("VM created (simulated)", "vm_name", slot.Name, "ip_address", slot.IPAddress)
 	return nil
 }
 
+// completeJob turns a VM off once its simulated job ends, unless it was replaced meanwhile
+func (m *MockVMManager) completeJob(vmName string, generation int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.generations[vmName] != generation || m.simulatedVMs[vmName] != "Running" {
+		return
+	}
+	m.simulatedVMs[vmName] = "Off"
+	m.logger.Debug("Simulated job completed, VM turned off", "vm_name", vmName)
+}
+
+// VanishVM deletes a VM behind the orchestrator's back, as an operator or another tool might
+func (m *MockVMManager) VanishVM(vmName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.simulatedVMs, vmName)
+}
+
+// AlterVM changes a VM's hardware behind the orchestrator's back, as an operator might in Hyper-V Manager
+func (m *MockVMManager) AlterVM(vmName string, hardware VMHardware) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.altered[vmName] = hardware
+}
+
+// InspectVM returns the simulated hardware of a VM, as created or as altered by AlterVM
+func (m *MockVMManager) InspectVM(vmName string) (VMHardware, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.simulatedVMs[vmName]; !exists {
+		return VMHardware{}, fmt.Errorf("%w: %s", ErrVMNotFound, vmName)
+	}
+	if hardware, altered := m.altered[vmName]; altered {
+		return hardware, nil
+	}
+	return VMHardware{MemoryMB: 4096, CPUCount: 2, Disks: []string{vmName + ".vhdx"}}, nil
+}
+
 // DestroyVM simulates VM destruction
 func (m *MockVMManager) DestroyVM(slot *VMSlot) error {
+	if err := m.simulateCall("DestroyVM", m.scenario.DestroyVM); err != nil {
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Simulate destruction delay
-	time.Sleep(300 * time.Millisecond)
-
 	delete(m.simulatedVMs, slot.Name)
 	m.logger.Debug("VM destroyed (simulated)", "vm_name", slot.Name)
 	return nil
@@ -50,12 +167,22 @@ func (m *MockVMManager) DestroyVM(slot *VMSlot) error {
 
 // GetVMState simulates getting VM state
 func (m *MockVMManager) GetVMState(vmName string) (string, error) {
+	if err := m.simulateCall("GetVMState", m.scenario.GetVMState); err != nil {
+		return "", err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if _, exists := m.simulatedVMs[vmName]; exists && m.scenario.VanishProbability > 0 &&
+		m.rng.Float64() < m.scenario.VanishProbability {
+		delete(m.simulatedVMs, vmName)
+		m.logger.Debug("VM vanished (simulated)", "vm_name", vmName)
+	}
+
 	state, exists := m.simulatedVMs[vmName]
 	if !exists {
-		return "", fmt.Errorf("VM not found: %s", vmName)
+		return "", fmt.Errorf("%w: %s", ErrVMNotFound, vmName)
 	}
 	return state, nil
 }
@@ -77,3 +204,111 @@ func (m *MockVMManager) CleanupLeftoverResources(namePrefix string) error {
 	m.logger.Debug("Cleanup leftover resources (simulated)", "name_prefix", namePrefix)
 	return nil
 }
+
+// GetDiskUsage returns the simulated disk size stored on the slot
+func (m *MockVMManager) GetDiskUsage(slot *VMSlot) (int64, error) {
+	return slot.DiskSizeBytes, nil
+}
+
+// CollectDiagnostics simulates pulling a diagnostics bundle out of the guest
+func (m *MockVMManager) CollectDiagnostics(slot *VMSlot, reason string) (string, error) {
+	m.logger.Debug("Diagnostics collected (simulated)", "vm_name", slot.Name, "reason", reason)
+	return "mock-diagnostics\\" + slot.Name, nil
+}
+
+// SetGuestStatus makes the guest probe of a VM report a status, e.g. a runner listener that died
+func (m *MockVMManager) SetGuestStatus(vmName string, status GuestStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.guests[vmName] = status
+}
+
+// ProbeGuest returns the status set by SetGuestStatus, or a healthy guest that just logged
+func (m *MockVMManager) ProbeGuest(slot *VMSlot) (GuestStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.simulatedVMs[slot.Name]; !exists {
+		return GuestStatus{}, fmt.Errorf("%w: %s", ErrVMNotFound, slot.Name)
+	}
+	if status, set := m.guests[slot.Name]; set {
+		return status, nil
+	}
+	now := time.Now()
+	return GuestStatus{ListenerRunning: true, FreeDiskBytes: 50 << 30, LastLogAt: now, HeartbeatAt: now}, nil
+}
+
+// QuarantineVM simulates moving a failed VM out of the pool
+func (m *MockVMManager) QuarantineVM(slot *VMSlot, reason string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.simulatedVMs[slot.Name]; !exists {
+		return "", nil
+	}
+	quarantinedName := fmt.Sprintf("quarantine-%s-%s", slot.Name, time.Now().Format("20060102-150405"))
+	delete(m.simulatedVMs, slot.Name)
+	m.simulatedVMs[quarantinedName] = "Off"
+	m.logger.Debug("VM quarantined (simulated)", "vm_name", slot.Name, "quarantined_name", quarantinedName, "reason", reason)
+	return quarantinedName, nil
+}
+
+// CollectGarbage removes simulated pool VMs that no slot owns
+// Simulated VMs have no creation time, so the grace period is not applied
+func (m *MockVMManager) CollectGarbage(namePrefix string, slots []*VMSlot, gracePeriod time.Duration) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var collected []string
+	for vmName := range m.simulatedVMs {
+		index, found := strings.CutPrefix(vmName, namePrefix)
+		if !found || index == "" || strings.Trim(index, "0123456789") != "" ||
+			slices.ContainsFunc(slots, func(slot *VMSlot) bool { return slot.Name == vmName }) {
+			continue
+		}
+		delete(m.simulatedVMs, vmName)
+		collected = append(collected, "removed VM "+vmName)
+	}
+	slices.Sort(collected)
+	return collected, nil
+}
+
+// PruneQuarantine simulates removing expired quarantined VMs
+func (m *MockVMManager) PruneQuarantine() error {
+	return nil
+}
+
+// UpdateConfig is a no-op; the mock scenario is fixed when the mock is created
+func (m *MockVMManager) UpdateConfig(cfg config.Config) {
+	m.logger.Debug("Configuration updated (simulated)", "pool_size", cfg.Runners.PoolSize)
+}
+
+// ValidateTemplate simulates template validation
+func (m *MockVMManager) ValidateTemplate(templatePath string) error {
+	m.logger.Debug("Template validated (simulated)", "template_path", templatePath)
+	return nil
+}
+
+// MeasureVM returns a simulated resource usage sample that grows with the VM's uptime
+func (m *MockVMManager) MeasureVM(slot *VMSlot) (ResourceUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.simulatedVMs[slot.Name]; !exists {
+		return ResourceUsage{}, fmt.Errorf("%w: %s", ErrVMNotFound, slot.Name)
+	}
+	uptime := time.Since(slot.CreatedAt).Round(time.Second)
+	return ResourceUsage{
+		SampledAt:          time.Now(),
+		MeteredFor:         uptime,
+		AvgCPUMHz:          1200,
+		AvgMemoryMB:        2048,
+		MaxMemoryMB:        4096,
+		MemoryDemandMB:     1536,
+		PeakMemoryDemandMB: 1536,
+		DiskReadMB:         int64(uptime.Seconds()) * 2,
+		DiskWrittenMB:      int64(uptime.Seconds()),
+		NetworkInboundMB:   int64(uptime.Seconds()) * 3,
+		NetworkOutboundMB:  int64(uptime.Seconds()) / 2,
+	}, nil
+}
@@ -0,0 +1,355 @@
+package vmmanager
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"hyperv-runner-pool/pkg/config"
+)
+
+// hostManager is one Hyper-V host in a multi-host pool
+type hostManager struct {
+	name      string
+	capacity  int
+	manager   *HyperVManager
+	downSince time.Time // When the host stopped answering, zero while it answers
+}
+
+// hostFailoverDelay is how long a host must stay unreachable before the VMs placed on it are
+// given up and their slots recreated on other hosts
+const hostFailoverDelay = 2 * time.Minute
+
+// MultiHostManager places slot VMs on several Hyper-V hosts and routes every
+// operation on a VM to the host it was placed on
+type MultiHostManager struct {
+	hosts      []*hostManager
+	placements map[string]*hostManager // VM name -> host the VM lives on
+	mu         sync.Mutex
+	logger     *slog.Logger
+}
+
+// NewMultiHostManager creates a manager for the hosts in hyperv.hosts
+func NewMultiHostManager(cfg config.Config, logger *slog.Logger) *MultiHostManager {
+	executors := make([]CommandExecutor, len(cfg.HyperV.Hosts))
+	for i, host := range cfg.HyperV.Hosts {
+		executors[i] = newHostExecutor(host, logger.With("component", "hyperv", "host", host.Name))
+	}
+	return NewMultiHostManagerWithExecutors(cfg, executors, logger)
+}
+
+// NewMultiHostManagerWithExecutors creates a manager whose hosts run scripts through
+// the given executors, one per entry in hyperv.hosts
+func NewMultiHostManagerWithExecutors(cfg config.Config, executors []CommandExecutor, logger *slog.Logger) *MultiHostManager {
+	m := &MultiHostManager{
+		placements: make(map[string]*hostManager),
+		logger:     logger.With("component", "placement"),
+	}
+	for i, host := range cfg.HyperV.Hosts {
+		m.hosts = append(m.hosts, &hostManager{
+			name:     host.Name,
+			capacity: host.Capacity,
+			manager:  NewHyperVManagerWithExecutor(cfg, executors[i], logger.With("host", host.Name)),
+		})
+	}
+	return m
+}
+
+// place picks the host for a slot's next VM
+// A slot whose previous VM was never torn down stays on its host so the leftovers are reused;
+// otherwise the host with the lowest load relative to its capacity wins, in configuration order on ties
+// Hosts that stopped answering are only used when every answering host is full
+func (m *MultiHostManager) place(slot *VMSlot) (*hostManager, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if host, ok := m.placements[slot.Name]; ok {
+		slot.Host = host.name
+		return host, nil
+	}
+
+	load := make(map[*hostManager]int)
+	for _, host := range m.placements {
+		load[host]++
+	}
+
+	var best *hostManager
+	for _, host := range m.hosts {
+		if load[host] >= host.capacity {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = host
+		case best.downSince.IsZero() != host.downSince.IsZero():
+			// A host that answers wins over one that does not, whatever their load
+			if host.downSince.IsZero() {
+				best = host
+			}
+		case load[host]*best.capacity < load[best]*host.capacity:
+			// Compare load[host]/capacity without floating point
+			best = host
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%w: all %d hosts are at capacity", ErrNoHostCapacity, len(m.hosts))
+	}
+
+	m.placements[slot.Name] = best
+	slot.Host = best.name
+	m.logger.Info("Placed VM on host",
+		"vm_name", slot.Name,
+		"host", best.name,
+		"host_load", load[best]+1,
+		"host_capacity", best.capacity)
+	return best, nil
+}
+
+// hostFor returns the host a VM was placed on
+func (m *MultiHostManager) hostFor(vmName string) (*hostManager, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	host, ok := m.placements[vmName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not placed on any host", ErrVMNotFound, vmName)
+	}
+	return host, nil
+}
+
+// release frees a VM's placement once the VM no longer exists under its name
+func (m *MultiHostManager) release(vmName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.placements, vmName)
+}
+
+// observe records whether a host answered and returns how long it has been unreachable
+// Only ErrHostUnavailable counts as no answer; any other error still came from the host
+func (m *MultiHostManager) observe(host *hostManager, err error) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !errors.Is(err, ErrHostUnavailable) {
+		if !host.downSince.IsZero() {
+			m.logger.Info("Host is reachable again", "host", host.name, "down_for", time.Since(host.downSince).Round(time.Second))
+			host.downSince = time.Time{}
+		}
+		return 0
+	}
+	if host.downSince.IsZero() {
+		m.logger.Warn("Host is unreachable, placing new VMs on other hosts", "host", host.name, "error", err)
+		host.downSince = time.Now()
+	}
+	return max(time.Since(host.downSince), time.Nanosecond)
+}
+
+// abandon frees the placement of a VM on a host that cannot be reached, so its slot is recreated
+// elsewhere; a copy left behind on the host is collected as garbage once the host is back
+func (m *MultiHostManager) abandon(host *hostManager, vmName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.placements[vmName] == host {
+		delete(m.placements, vmName)
+		m.logger.Warn("Giving up VM on unreachable host", "vm_name", vmName, "host", host.name)
+	}
+}
+
+// forEachHost runs fn on every host in parallel and joins the errors
+func (m *MultiHostManager) forEachHost(fn func(host *hostManager) error) error {
+	errs := make([]error, len(m.hosts))
+	var wg sync.WaitGroup
+	for i, host := range m.hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := fn(host)
+			m.observe(host, err)
+			if err != nil {
+				errs[i] = fmt.Errorf("host %s: %w", host.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// CreateVM places the slot on a host and creates its VM there
+func (m *MultiHostManager) CreateVM(slot *VMSlot) error {
+	host, err := m.place(slot)
+	if err != nil {
+		return err
+	}
+	err = host.manager.CreateVM(slot)
+	if m.observe(host, err) > 0 {
+		// Nothing of the VM can be reused while the host is gone, so the retry may go elsewhere
+		m.abandon(host, slot.Name)
+	}
+	return err
+}
+
+// DestroyVM destroys a VM on its host and frees the placement
+// A VM whose host cannot be reached is given up as well, so the slot's next VM goes to another host
+func (m *MultiHostManager) DestroyVM(slot *VMSlot) error {
+	host, err := m.hostFor(slot.Name)
+	if err != nil {
+		return err
+	}
+	err = host.manager.DestroyVM(slot)
+	if m.observe(host, err) > 0 {
+		m.abandon(host, slot.Name)
+	}
+	if err != nil {
+		return err
+	}
+	m.release(slot.Name)
+	return nil
+}
+
+// GetVMState gets the state of a VM from its host
+// Once the host has been unreachable for hostFailoverDelay the VM is given up and reported missing,
+// so the slot is recreated on another host
+func (m *MultiHostManager) GetVMState(vmName string) (string, error) {
+	host, err := m.hostFor(vmName)
+	if err != nil {
+		return "", err
+	}
+	state, err := host.manager.GetVMState(vmName)
+	if m.observe(host, err) >= hostFailoverDelay {
+		m.abandon(host, vmName)
+		return "", fmt.Errorf("%w: %s was lost with unreachable host %s: %v", ErrVMNotFound, vmName, host.name, err)
+	}
+	return state, err
+}
+
+// InspectVM inspects a VM's hardware on its host
+func (m *MultiHostManager) InspectVM(vmName string) (VMHardware, error) {
+	host, err := m.hostFor(vmName)
+	if err != nil {
+		return VMHardware{}, err
+	}
+	return host.manager.InspectVM(vmName)
+}
+
+// InjectConfig writes the runner config into a VHDX on the host of the VM named in the config
+func (m *MultiHostManager) InjectConfig(vhdxPath string, config RunnerConfig) error {
+	host, err := m.hostFor(config.Name)
+	if err != nil {
+		return err
+	}
+	return host.manager.InjectConfig(vhdxPath, config)
+}
+
+// RunPowerShell executes a PowerShell command on the first configured host
+func (m *MultiHostManager) RunPowerShell(command string) (string, error) {
+	return m.hosts[0].manager.RunPowerShell(command)
+}
+
+// CleanupLeftoverResources removes leftover VMs and disks on every host
+func (m *MultiHostManager) CleanupLeftoverResources(namePrefix string) error {
+	return m.forEachHost(func(host *hostManager) error {
+		return host.manager.CleanupLeftoverResources(namePrefix)
+	})
+}
+
+// UpdateConfig replaces the configuration of every host
+// The hosts themselves and their capacities are fixed when the manager is created
+func (m *MultiHostManager) UpdateConfig(cfg config.Config) {
+	for _, host := range m.hosts {
+		host.manager.UpdateConfig(cfg)
+	}
+}
+
+// ValidateTemplate checks the template on every host, since each host keeps its own copy
+func (m *MultiHostManager) ValidateTemplate(templatePath string) error {
+	return m.forEachHost(func(host *hostManager) error {
+		return host.manager.ValidateTemplate(templatePath)
+	})
+}
+
+// GetDiskUsage measures a VM's differencing disk on its host
+func (m *MultiHostManager) GetDiskUsage(slot *VMSlot) (int64, error) {
+	host, err := m.hostFor(slot.Name)
+	if err != nil {
+		return 0, err
+	}
+	return host.manager.GetDiskUsage(slot)
+}
+
+// CollectDiagnostics collects a diagnostics bundle into the diagnostics path on the VM's host
+func (m *MultiHostManager) CollectDiagnostics(slot *VMSlot, reason string) (string, error) {
+	host, err := m.hostFor(slot.Name)
+	if err != nil {
+		return "", err
+	}
+	return host.manager.CollectDiagnostics(slot, reason)
+}
+
+// ProbeGuest probes the guest of a VM from the VM's host
+func (m *MultiHostManager) ProbeGuest(slot *VMSlot) (GuestStatus, error) {
+	host, err := m.hostFor(slot.Name)
+	if err != nil {
+		return GuestStatus{}, err
+	}
+	return host.manager.ProbeGuest(slot)
+}
+
+// QuarantineVM quarantines a VM on its host and frees the placement, since the VM is renamed
+func (m *MultiHostManager) QuarantineVM(slot *VMSlot, reason string) (string, error) {
+	host, err := m.hostFor(slot.Name)
+	if err != nil {
+		return "", err
+	}
+	name, err := host.manager.QuarantineVM(slot, reason)
+	if err != nil {
+		return "", err
+	}
+	if name != "" {
+		m.release(slot.Name)
+	}
+	return name, nil
+}
+
+// PruneQuarantine prunes quarantined VMs on every host
+func (m *MultiHostManager) PruneQuarantine() error {
+	return m.forEachHost(func(host *hostManager) error {
+		return host.manager.PruneQuarantine()
+	})
+}
+
+// CollectGarbage collects orphaned resources on every host
+// Each host only keeps the VMs of slots placed on it, so a leftover copy on another host is collected
+func (m *MultiHostManager) CollectGarbage(namePrefix string, slots []*VMSlot, gracePeriod time.Duration) ([]string, error) {
+	var mu sync.Mutex
+	var collected []string
+	err := m.forEachHost(func(host *hostManager) error {
+		var placed []*VMSlot
+		m.mu.Lock()
+		for _, slot := range slots {
+			if m.placements[slot.Name] == host {
+				placed = append(placed, slot)
+			}
+		}
+		m.mu.Unlock()
+
+		resources, err := host.manager.CollectGarbage(namePrefix, placed, gracePeriod)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, resource := range resources {
+			collected = append(collected, fmt.Sprintf("%s on %s", resource, host.name))
+		}
+		return err
+	})
+	return collected, err
+}
+
+// MeasureVM samples a VM's resource usage on its host
+func (m *MultiHostManager) MeasureVM(slot *VMSlot) (ResourceUsage, error) {
+	host, err := m.hostFor(slot.Name)
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	return host.manager.MeasureVM(slot)
+}
@@ -4,6 +4,9 @@
 
 $ErrorActionPreference = "Stop"
 
+# Keep a transcript in the guest so diagnostics collection can pull it out of failed VMs
+Start-Transcript -Path "C:\configure-runner.log" -Append | Out-Null
+
 Write-Host "=========================================="
 Write-Host "GitHub Actions Runner Setup"
 Write-Host "=========================================="
@@ -11,9 +14,124 @@ Write-Host "=========================================="
 $runnerPath = "C:\actions-runner"
 $configPath = "C:\runner-config.json"
 
-# Verify config file exists
-if (-not (Test-Path $configPath)) {
-    throw "Runner configuration file not found at $configPath. The orchestrator should inject this before running this script."
+# Locate the runner configuration
+# Depending on the injection strategy it is written to C:\ directly (mount, copy_file, checkpoint),
+# found on an attached seed ISO (iso), or pushed as KVP items (kvp)
+$kvpKey = "HKLM:\SOFTWARE\Microsoft\Virtual Machine\External"
+$configDeadline = (Get-Date).AddSeconds(120)
+while (-not (Test-Path $configPath)) {
+    # Seed ISO attached as a DVD drive
+    $dvdDrives = Get-CimInstance -ClassName Win32_LogicalDisk -ErrorAction SilentlyContinue | Where-Object { $_.DriveType -eq 5 }
+    foreach ($drive in $dvdDrives) {
+        $seedConfig = Join-Path "$($drive.DeviceID)\" "runner-config.json"
+        if (Test-Path $seedConfig) {
+            Write-Host "Found runner configuration on seed ISO at $seedConfig"
+            Copy-Item -Path $seedConfig -Destination $configPath -Force
+            break
+        }
+    }
+    if (Test-Path $configPath) {
+        break
+    }
+
+    # Host-to-guest KVP items, split into base64 chunks
+    $kvp = Get-ItemProperty -Path $kvpKey -ErrorAction SilentlyContinue
+    if ($kvp -and $kvp."runner-config-chunks") {
+        $chunkCount = [int]$kvp."runner-config-chunks"
+        $encoded = -join (0..($chunkCount - 1) | ForEach-Object { $kvp."runner-config-$_" })
+        if ($encoded.Length -gt 0 -and $encoded.Length % 4 -eq 0) {
+            Write-Host "Found runner configuration in KVP data exchange ($chunkCount chunks)"
+            [System.IO.File]::WriteAllBytes($configPath, [System.Convert]::FromBase64String($encoded))
+            break
+        }
+    }
+
+    if ((Get-Date) -gt $configDeadline) {
+        throw "Runner configuration file not found at $configPath, on a seed ISO or in KVP data. The orchestrator should inject this before running this script."
+    }
+    Start-Sleep -Seconds 2
+}
+
+# Step 0: Apply static network addressing before anything needs the network
+$networkConfig = (Get-Content -Path $configPath -Raw | ConvertFrom-Json).network
+if ($networkConfig) {
+    Write-Host ""
+    Write-Host "Step 0: Configuring Static Network Addresses..."
+    Write-Host "--------------------------------------------"
+
+    foreach ($entry in $networkConfig) {
+        # Hyper-V device naming exposes the host-side adapter name to the guest
+        $property = Get-NetAdapterAdvancedProperty -DisplayName "Hyper-V Network Adapter Name" -ErrorAction SilentlyContinue |
+            Where-Object { $_.DisplayValue -eq $entry.adapter_name } |
+            Select-Object -First 1
+        if (-not $property) {
+            throw "Network adapter `"$($entry.adapter_name)`" not found in guest"
+        }
+        $ifIndex = (Get-NetAdapter -Name $property.Name).ifIndex
+
+        Write-Host "  $($entry.adapter_name): $($entry.ip_address)/$($entry.prefix_length)"
+        Set-NetIPInterface -InterfaceIndex $ifIndex -Dhcp Disabled
+        Get-NetIPAddress -InterfaceIndex $ifIndex -AddressFamily IPv4 -ErrorAction SilentlyContinue |
+            Remove-NetIPAddress -Confirm:$false -ErrorAction SilentlyContinue
+        Get-NetRoute -InterfaceIndex $ifIndex -DestinationPrefix "0.0.0.0/0" -ErrorAction SilentlyContinue |
+            Remove-NetRoute -Confirm:$false -ErrorAction SilentlyContinue
+
+        $addressArgs = @{
+            InterfaceIndex = $ifIndex
+            IPAddress      = $entry.ip_address
+            PrefixLength   = $entry.prefix_length
+        }
+        if ($entry.gateway) {
+            $addressArgs.DefaultGateway = $entry.gateway
+        }
+        New-NetIPAddress @addressArgs | Out-Null
+
+        if ($entry.dns_servers) {
+            Set-DnsClientServerAddress -InterfaceIndex $ifIndex -ServerAddresses $entry.dns_servers
+        }
+    }
+
+    Write-Host "Static network configuration applied"
+}
+
+# Step 0b: Bring the tool cache and scratch disks online under their configured drive letters
+$diskConfig = Get-Content -Path $configPath -Raw | ConvertFrom-Json
+if ($diskConfig.tool_cache_drive -or $diskConfig.scratch_drive) {
+    Write-Host ""
+    Write-Host "Step 0b: Preparing Extra Disks..."
+    Write-Host "--------------------------------------------"
+
+    foreach ($disk in Get-Disk | Where-Object { -not $_.IsBoot -and -not $_.IsSystem }) {
+        if ($disk.IsOffline) {
+            Set-Disk -Number $disk.Number -IsOffline $false
+        }
+        if ($disk.IsReadOnly) {
+            Set-Disk -Number $disk.Number -IsReadOnly $false
+        }
+        $disk = Get-Disk -Number $disk.Number
+
+        if ($disk.PartitionStyle -eq "RAW") {
+            # Only the scratch disk is blank, the tool cache disk is always formatted
+            if (-not $diskConfig.scratch_drive) {
+                continue
+            }
+            Write-Host "  Formatting scratch disk $($disk.Number) as $($diskConfig.scratch_drive):"
+            Initialize-Disk -Number $disk.Number -PartitionStyle GPT
+            New-Partition -DiskNumber $disk.Number -UseMaximumSize -DriveLetter $diskConfig.scratch_drive |
+                Format-Volume -FileSystem NTFS -NewFileSystemLabel "Scratch" -Confirm:$false | Out-Null
+        } elseif ($diskConfig.tool_cache_drive) {
+            $partition = Get-Partition -DiskNumber $disk.Number |
+                Where-Object { $_.Type -eq "Basic" } |
+                Sort-Object -Property Size -Descending |
+                Select-Object -First 1
+            if ($partition -and "$($partition.DriveLetter)" -ne $diskConfig.tool_cache_drive) {
+                Set-Partition -DiskNumber $disk.Number -PartitionNumber $partition.PartitionNumber -NewDriveLetter $diskConfig.tool_cache_drive
+            }
+            Write-Host "  Tool cache disk $($disk.Number) mounted as $($diskConfig.tool_cache_drive):"
+        }
+    }
+
+    Write-Host "Extra disks ready"
 }
 
 # Step 1: Download and install GitHub Actions Runner if not already present
@@ -132,6 +250,12 @@ if ($config.runner_group -and -not $config.repository) {
     Write-Host "Using runner group: $($config.runner_group)"
 }
 
+# Keep job workspaces on the scratch disk so the system disk only holds the OS and tools
+if ($config.scratch_drive) {
+    $configArgs += @("--work", "$($config.scratch_drive):\_work")
+    Write-Host "Using work directory: $($config.scratch_drive):\_work"
+}
+
 & .\config.cmd @configArgs
 
 if ($LASTEXITCODE -ne 0) {
@@ -140,6 +264,21 @@ if ($LASTEXITCODE -ne 0) {
 
 Write-Host "Runner configured successfully!"
 
+# Point the setup-* actions at the pre-warmed tool cache, plus any variables shipped on the disk itself
+if ($config.tool_cache_drive) {
+    $toolCache = "$($config.tool_cache_drive):\hostedtoolcache"
+    if (Test-Path $toolCache) {
+        Add-Content -Path "$runnerPath\.env" -Value "RUNNER_TOOL_CACHE=$toolCache"
+        Add-Content -Path "$runnerPath\.env" -Value "AGENT_TOOLSDIRECTORY=$toolCache"
+        Write-Host "Using tool cache: $toolCache"
+    }
+    $toolCacheEnv = "$($config.tool_cache_drive):\runner.env"
+    if (Test-Path $toolCacheEnv) {
+        Get-Content -Path $toolCacheEnv | Add-Content -Path "$runnerPath\.env"
+        Write-Host "Loaded runner environment from $toolCacheEnv"
+    }
+}
+
 Write-Host ""
 Write-Host "Step 4: Starting Runner..."
 Write-Host "--------------------------------------------"
@@ -208,6 +347,18 @@ if ($config.cache_url) {
 
 Write-Host ""
 
+# Write a heartbeat for the orchestrator's liveness probe while the runner runs
+if ($config.heartbeat_seconds) {
+    Write-Host "Writing liveness heartbeat every $($config.heartbeat_seconds) seconds"
+    Start-Job -ScriptBlock {
+        param($path, $seconds)
+        while ($true) {
+            Set-Content -Path $path -Value ([DateTimeOffset]::UtcNow.ToUnixTimeSeconds())
+            Start-Sleep -Seconds $seconds
+        }
+    } -ArgumentList "$runnerPath\heartbeat", ([int]$config.heartbeat_seconds) | Out-Null
+}
+
 # Run the runner (this will block until job completes)
 # Using --once flag to run a single job then exit
 & .\run.cmd --once
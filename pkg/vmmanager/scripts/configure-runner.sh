@@ -0,0 +1,273 @@
+#!/bin/bash
+# Configure GitHub Actions Runner on a Linux guest
+# cloud-init writes this script from the seed ISO and starts it once the guest has booted
+# It downloads, installs, configures, and runs the ephemeral runner, then powers the VM off
+
+set -euo pipefail
+
+# Keep a log in the guest for troubleshooting failed VMs
+exec >>/var/log/configure-runner.log 2>&1
+
+RUNNER_USER="runner"
+RUNNER_PATH="/opt/actions-runner"
+CONFIG_PATH="/etc/runner-config.json"
+KVP_POOL="/var/lib/hyperv/.kvp_pool_0"
+KVP_GUEST_POOL="/var/lib/hyperv/.kvp_pool_1"
+
+echo "=========================================="
+echo "GitHub Actions Runner Setup"
+echo "=========================================="
+
+# kvp_value prints a host-to-guest KVP item written by hv_kvp_daemon
+# Each record in the pool file is a 512 byte key followed by a 2048 byte value, NUL padded
+kvp_value() {
+    local size count i key record
+    [ -f "$KVP_POOL" ] || return 1
+    size=$(stat -c %s "$KVP_POOL")
+    count=$((size / 2560))
+    for ((i = 0; i < count; i++)); do
+        record=$(mktemp)
+        dd if="$KVP_POOL" of="$record" bs=2560 skip="$i" count=1 status=none
+        key=$(head -c 512 "$record" | tr -d "\0")
+        if [ "$key" = "$1" ]; then
+            tail -c 2048 "$record" | tr -d "\0"
+            rm -f "$record"
+            return 0
+        fi
+        rm -f "$record"
+    done
+    return 1
+}
+
+# kvp_report replaces the guest-to-host KVP items with the given key value pairs
+# The host reads them as the VM's guest exchange items; records use the same layout as kvp_value
+kvp_report() {
+    local pool field
+    pool=$(mktemp)
+    field=$(mktemp)
+    while [ $# -ge 2 ]; do
+        printf "%s" "$1" >"$field" && truncate -s 512 "$field" && cat "$field" >>"$pool"
+        printf "%s" "$2" >"$field" && truncate -s 2048 "$field" && cat "$field" >>"$pool"
+        shift 2
+    done
+    rm -f "$field"
+    mv -f "$pool" "$KVP_GUEST_POOL"
+}
+
+# report_liveness reports the readings of the orchestrator's liveness probe every $1 seconds
+# Linux guests have no PowerShell Direct, so the probe reads these KVP items instead of asking the guest
+report_liveness() {
+    local listener log last_log
+    while true; do
+        listener=0
+        if pgrep -f Runner.Listener >/dev/null; then
+            listener=1
+        fi
+        # The newest runner log was last modified when its last line was written
+        last_log=0
+        log=$(ls -t "$RUNNER_PATH"/_diag/Runner_*.log 2>/dev/null | head -n 1 || true)
+        if [ -n "$log" ]; then
+            last_log=$(stat -c %Y "$log")
+        fi
+        kvp_report \
+            runner-heartbeat "$(date +%s)" \
+            runner-listener "$listener" \
+            runner-free-disk-bytes "$(df --output=avail -B1 / | tail -n 1 | tr -d "[:space:]")" \
+            runner-last-log "$last_log" || true
+        sleep "$1"
+    done
+}
+
+# config_value prints a top-level field of the runner configuration, or nothing when it is missing
+config_value() {
+    python3 -c 'import json, sys; value = json.load(open(sys.argv[1])).get(sys.argv[2]); print("" if value is None else value)' "$CONFIG_PATH" "$1"
+}
+
+# Locate the runner configuration
+# Depending on the injection strategy it is found on the cloud-init seed ISO (iso) or pushed as KVP items (kvp)
+deadline=$((SECONDS + 120))
+while [ ! -f "$CONFIG_PATH" ]; do
+    for seed in /dev/disk/by-label/cidata /dev/disk/by-label/CIDATA; do
+        [ -e "$seed" ] || continue
+        mountpoint=$(mktemp -d)
+        if mount -o ro "$seed" "$mountpoint" 2>/dev/null; then
+            if [ -f "$mountpoint/runner-config.json" ]; then
+                echo "Found runner configuration on seed ISO"
+                install -m 600 "$mountpoint/runner-config.json" "$CONFIG_PATH"
+            fi
+            umount "$mountpoint"
+        fi
+        rmdir "$mountpoint"
+        break
+    done
+    [ -f "$CONFIG_PATH" ] && break
+
+    # Host-to-guest KVP items, split into base64 chunks
+    chunks=$(kvp_value "runner-config-chunks" || true)
+    if [ -n "$chunks" ]; then
+        encoded=""
+        for ((i = 0; i < chunks; i++)); do
+            encoded+=$(kvp_value "runner-config-$i" || true)
+        done
+        if [ -n "$encoded" ] && [ $((${#encoded} % 4)) -eq 0 ]; then
+            echo "Found runner configuration in KVP data exchange ($chunks chunks)"
+            (umask 077 && echo "$encoded" | base64 -d >"$CONFIG_PATH")
+            break
+        fi
+    fi
+
+    if [ "$SECONDS" -gt "$deadline" ]; then
+        echo "Runner configuration not found on a seed ISO or in KVP data. The orchestrator should inject this before starting the VM."
+        exit 1
+    fi
+    sleep 2
+done
+
+# Step 0: Create the unprivileged user the runner runs as, with passwordless sudo like hosted runners
+if ! id "$RUNNER_USER" >/dev/null 2>&1; then
+    echo ""
+    echo "Step 0: Creating runner user..."
+    echo "--------------------------------------------"
+    useradd --create-home --shell /bin/bash "$RUNNER_USER"
+    echo "$RUNNER_USER ALL=(ALL) NOPASSWD:ALL" >"/etc/sudoers.d/$RUNNER_USER"
+    chmod 440 "/etc/sudoers.d/$RUNNER_USER"
+fi
+
+# Step 0b: Mount the tool cache and scratch disks
+# The scratch disk is the only blank disk; the tool cache disk already carries a file system
+work_dir=""
+tool_cache_mount=""
+if [ -n "$(config_value tool_cache_drive)" ] || [ -n "$(config_value scratch_drive)" ]; then
+    echo ""
+    echo "Step 0b: Preparing Extra Disks..."
+    echo "--------------------------------------------"
+
+    for disk in $(lsblk -dnpo NAME,TYPE | awk '$2 == "disk" { print $1 }'); do
+        # Skip the system disk and anything else already in use
+        if lsblk -nro MOUNTPOINT "$disk" | grep -q .; then
+            continue
+        fi
+
+        if [ -z "$(lsblk -nro FSTYPE "$disk" | tr -d "[:space:]")" ]; then
+            [ -n "$(config_value scratch_drive)" ] || continue
+            echo "  Formatting scratch disk $disk"
+            mkfs.ext4 -q -L scratch "$disk"
+            mkdir -p /mnt/scratch
+            mount "$disk" /mnt/scratch
+            mkdir -p /mnt/scratch/_work
+            chown "$RUNNER_USER:$RUNNER_USER" /mnt/scratch/_work
+            work_dir="/mnt/scratch/_work"
+        elif [ -n "$(config_value tool_cache_drive)" ]; then
+            partition=$(lsblk -bnrpo SIZE,NAME,FSTYPE "$disk" | awk 'NF == 3 { print $1, $2 }' | sort -n | tail -n 1 | cut -d " " -f 2)
+            [ -n "$partition" ] || continue
+            mkdir -p /mnt/toolcache
+            mount "$partition" /mnt/toolcache
+            tool_cache_mount="/mnt/toolcache"
+            echo "  Tool cache disk $disk mounted at $tool_cache_mount"
+        fi
+    done
+
+    echo "Extra disks ready"
+fi
+
+# Step 1: Download and install GitHub Actions Runner if not already present
+if [ ! -x "$RUNNER_PATH/config.sh" ]; then
+    echo ""
+    echo "Step 1: Installing GitHub Actions Runner..."
+    echo "--------------------------------------------"
+
+    mkdir -p "$RUNNER_PATH"
+    download_url=$(curl -fsSL "https://api.github.com/repos/actions/runner/releases/latest" |
+        python3 -c 'import json, sys; print(next(a["browser_download_url"] for a in json.load(sys.stdin)["assets"] if "linux-x64" in a["name"] and a["name"].endswith(".tar.gz")))')
+    echo "Downloading from: $download_url"
+    curl -fsSL "$download_url" | tar -xz -C "$RUNNER_PATH"
+    "$RUNNER_PATH/bin/installdependencies.sh"
+
+    echo "GitHub Actions Runner installed successfully!"
+else
+    echo ""
+    echo "Step 1: Runner Already Installed"
+    echo "--------------------------------------------"
+    echo "GitHub Actions Runner already present at $RUNNER_PATH"
+fi
+chown -R "$RUNNER_USER:$RUNNER_USER" "$RUNNER_PATH"
+cd "$RUNNER_PATH"
+
+echo ""
+echo "Step 2: Reading Runner Configuration..."
+echo "--------------------------------------------"
+organization=$(config_value organization)
+repository=$(config_value repository)
+runner_group=$(config_value runner_group)
+cache_url=$(config_value cache_url)
+echo "Configuration loaded:"
+echo "  Organization: $organization"
+echo "  Repository: $repository"
+echo "  Name: $(config_value name)"
+echo "  Labels: $(config_value labels)"
+
+echo ""
+echo "Step 3: Configuring Runner..."
+echo "--------------------------------------------"
+url="https://github.com/$organization"
+if [ -n "$repository" ]; then
+    url="$url/$repository"
+fi
+config_args=(
+    --unattended
+    --url "$url"
+    --token "$(config_value token)"
+    --name "$(config_value name)"
+    --labels "$(config_value labels)"
+    --ephemeral
+    --disableupdate
+)
+# Runner groups only apply to org-level runners
+if [ -n "$runner_group" ] && [ -z "$repository" ]; then
+    config_args+=(--runnergroup "$runner_group")
+fi
+# Keep job workspaces on the scratch disk so the system disk only holds the OS and tools
+if [ -n "$work_dir" ]; then
+    config_args+=(--work "$work_dir")
+fi
+
+runuser -u "$RUNNER_USER" -- ./config.sh "${config_args[@]}"
+echo "Runner configured successfully!"
+
+# Point the setup-* actions at the pre-warmed tool cache, plus any variables shipped on the disk itself
+if [ -n "$tool_cache_mount" ]; then
+    if [ -d "$tool_cache_mount/hostedtoolcache" ]; then
+        echo "RUNNER_TOOL_CACHE=$tool_cache_mount/hostedtoolcache" >>.env
+        echo "AGENT_TOOLSDIRECTORY=$tool_cache_mount/hostedtoolcache" >>.env
+    fi
+    if [ -f "$tool_cache_mount/runner.env" ]; then
+        cat "$tool_cache_mount/runner.env" >>.env
+    fi
+fi
+
+# Patch the runner for a custom cache server, see configure-runner.ps1 for details
+if [ -n "$cache_url" ]; then
+    echo "Configuring custom cache server: $cache_url"
+    sed -i "s/ACTIONS_RESULTS_URL/ACTIONS_RESULTS_ORL/g" bin/Runner.Worker.dll
+    echo "CUSTOM_ACTIONS_RESULTS_URL=$cache_url" >>.env
+fi
+chown "$RUNNER_USER:$RUNNER_USER" .env 2>/dev/null || true
+
+echo ""
+echo "Step 4: Starting Runner..."
+echo "--------------------------------------------"
+echo "Running in ephemeral single-job mode..."
+heartbeat_seconds=$(config_value heartbeat_seconds)
+if [ -n "$heartbeat_seconds" ]; then
+    echo "Reporting liveness every $heartbeat_seconds seconds"
+    report_liveness "$heartbeat_seconds" &
+fi
+runuser -u "$RUNNER_USER" -- ./run.sh --once || echo "Runner exited with code $?"
+
+echo ""
+echo "=========================================="
+echo "Job Complete - Shutting Down"
+echo "=========================================="
+echo "The orchestrator will detect the powered off VM and recreate it."
+rm -f "$CONFIG_PATH"
+shutdown -h now
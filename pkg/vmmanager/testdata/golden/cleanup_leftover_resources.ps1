@@ -0,0 +1,89 @@
+# ---- script 1 ----
+
+		$ErrorActionPreference = "Continue"
+		$namePrefix = "runner-"
+		$storagePaths = @("D:\vms", "E:\vms")
+		$cleaned = 0
+		$foreignNames = @{}
+		$adoptUntagged = -not (Get-VM | Where-Object {
+			$_.Name -match "^$([regex]::Escape("runner-"))\d+$" -and
+				(@($_.Notes -split "\r?\n") | Where-Object { $_ -like "hyperv-runner-pool owner=*" -and $_ -ne "hyperv-runner-pool owner=pool-a" })
+		})
+
+		# Find and remove VMs matching the prefix followed by digits only
+		# This ensures we only match numbered pool VMs like "github-runner-1", "github-runner-2"
+		# and NOT other VMs like "github-runner-basic", "github-runner-template", etc.
+		$vms = Get-VM | Where-Object { $_.Name -match "^$([regex]::Escape($namePrefix))\d+$" }
+		foreach ($vm in $vms) {
+			if (-not ((@($vm.Notes -split "\r?\n") -contains "hyperv-runner-pool owner=pool-a") -or ($adoptUntagged -and -not (@($vm.Notes -split "\r?\n") -like "hyperv-runner-pool owner=*")))) {
+				Write-Output "Skipping VM not owned by this instance: $($vm.Name)"
+				$foreignNames[$vm.Name] = $true
+				continue
+			}
+			Write-Output "Removing VM: $($vm.Name)"
+			try {
+				Stop-VM -Name $vm.Name -TurnOff -Force -ErrorAction SilentlyContinue
+				Remove-VM -Name $vm.Name -Force -ErrorAction Stop
+				$cleaned++
+				Write-Output "  Removed successfully"
+			} catch {
+				Write-Output "  Warning: Failed to remove VM: $_"
+			}
+		}
+
+		# Find and remove orphaned VHDX files matching the prefix followed by digits only,
+		# including the tool cache and scratch disks of each slot
+		foreach ($storagePath in $storagePaths) {
+			if (Test-Path $storagePath) {
+				$vhdxFiles = Get-ChildItem -Path $storagePath -Filter "$namePrefix*.vhdx" -ErrorAction SilentlyContinue |
+					Where-Object { $_.BaseName -match "^($([regex]::Escape($namePrefix))\d+)(-toolcache|-scratch)?$" -and -not $foreignNames[$Matches[1]] }
+				foreach ($file in $vhdxFiles) {
+					Write-Output "Removing VHDX: $($file.Name)"
+					try {
+						# Try to dismount if mounted
+						Dismount-VHD -Path $file.FullName -ErrorAction SilentlyContinue
+
+						# Delete the file
+						Remove-Item -Path $file.FullName -Force -ErrorAction Stop
+						$cleaned++
+						Write-Output "  Removed successfully"
+					} catch {
+						Write-Output "  Warning: Failed to remove VHDX: $_"
+					}
+				}
+
+				# Find and remove seed ISOs attached by the iso config injection strategy
+				$isoFiles = Get-ChildItem -Path $storagePath -Filter "$namePrefix*-seed.iso" -ErrorAction SilentlyContinue |
+					Where-Object { $_.BaseName -match "^($([regex]::Escape($namePrefix))\d+)-seed$" -and -not $foreignNames[$Matches[1]] }
+				foreach ($file in $isoFiles) {
+					Write-Output "Removing seed ISO: $($file.Name)"
+					try {
+						Remove-Item -Path $file.FullName -Force -ErrorAction Stop
+						$cleaned++
+						Write-Output "  Removed successfully"
+					} catch {
+						Write-Output "  Warning: Failed to remove seed ISO: $_"
+					}
+				}
+
+				# Find and remove VM directories left by checkpoint restores
+				$vmDirs = Get-ChildItem -Path $storagePath -Directory -ErrorAction SilentlyContinue |
+					Where-Object { $_.Name -match "^$([regex]::Escape($namePrefix))\d+$" -and -not $foreignNames[$_.Name] }
+				foreach ($dir in $vmDirs) {
+					Write-Output "Removing VM directory: $($dir.Name)"
+					try {
+						Remove-Item -Path $dir.FullName -Recurse -Force -ErrorAction Stop
+						$cleaned++
+						Write-Output "  Removed successfully"
+					} catch {
+						Write-Output "  Warning: Failed to remove VM directory: $_"
+					}
+				}
+			}
+		}
+
+		Write-Output "Cleanup complete. Removed $cleaned resources."
+		if ($cleaned -gt 0) {
+			Write-Output "CLEANUP_PERFORMED"
+		}
+	
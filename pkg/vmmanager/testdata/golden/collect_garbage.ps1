@@ -0,0 +1,79 @@
+# ---- script 1 ----
+
+		$ErrorActionPreference = "Continue"
+		$namePrefix = "runner-"
+		$storagePaths = @("D:\vms", "E:\vms")
+		$cutoff = (Get-Date).AddSeconds(-1800)
+		$owned = @{}
+		$owned["runner-1"] = "D:\vms"
+		$owned["runner-2"] = ""
+		$keep = @{}
+		$adoptUntagged = -not (Get-VM | Where-Object {
+			$_.Name -match "^$([regex]::Escape("runner-"))\d+$" -and
+				(@($_.Notes -split "\r?\n") | Where-Object { $_ -like "hyperv-runner-pool owner=*" -and $_ -ne "hyperv-runner-pool owner=pool-a" })
+		})
+
+		# Pool VMs without a slot, e.g. beyond a reduced pool size
+		$vms = Get-VM | Where-Object { $_.Name -match "^$([regex]::Escape($namePrefix))\d+$" }
+		foreach ($vm in $vms) {
+			if ($owned.ContainsKey($vm.Name) -or -not ((@($vm.Notes -split "\r?\n") -contains "hyperv-runner-pool owner=pool-a") -or ($adoptUntagged -and -not (@($vm.Notes -split "\r?\n") -like "hyperv-runner-pool owner=*"))) -or $vm.CreationTime -gt $cutoff) {
+				$keep[$vm.Name] = $true
+				continue
+			}
+			try {
+				Stop-VM -VM $vm -TurnOff -Force -ErrorAction SilentlyContinue
+				Remove-VM -VM $vm -Force -ErrorAction Stop
+				Write-Output "GC_REMOVED:VM $($vm.Name)"
+			} catch {
+				$keep[$vm.Name] = $true
+				Write-Output "GC_FAILED:VM $($vm.Name): $_"
+			}
+		}
+
+		# Slot disks still mounted on the host; injection takes seconds, so an old mount is stale
+		foreach ($disk in Get-Disk | Where-Object { $_.Location -like "*.vhdx" }) {
+			$file = Get-Item -Path $disk.Location -ErrorAction SilentlyContinue
+			if (-not $file -or $storagePaths -notcontains $file.DirectoryName -or $file.LastWriteTime -gt $cutoff) {
+				continue
+			}
+			if ($file.BaseName -match "^$([regex]::Escape($namePrefix))\d+(-toolcache|-scratch)?$") {
+				try {
+					Dismount-VHD -Path $file.FullName -ErrorAction Stop
+					Write-Output "GC_DISMOUNTED:$($file.FullName)"
+				} catch {
+					Write-Output "GC_FAILED:$($file.FullName): $_"
+				}
+			}
+		}
+
+		# Disks, seed ISOs and checkpoint directories of slots whose files live elsewhere or do not exist
+		foreach ($storagePath in $storagePaths) {
+			if (-not (Test-Path $storagePath)) {
+				continue
+			}
+			foreach ($item in Get-ChildItem -Path $storagePath -ErrorAction SilentlyContinue) {
+				if ($item.PSIsContainer) {
+					$orphan = $item.Name -match "^($([regex]::Escape($namePrefix))\d+)$"
+				} else {
+					$orphan = $item.Name -match "^($([regex]::Escape($namePrefix))\d+)((-toolcache|-scratch)?\.vhdx|-seed\.iso)$"
+				}
+				if (-not $orphan -or $item.LastWriteTime -gt $cutoff) {
+					continue
+				}
+				$name = $Matches[1]
+				if ($keep[$name] -or ($owned.ContainsKey($name) -and $owned[$name] -in @("", $storagePath))) {
+					continue
+				}
+				# A VM that still uses the file, e.g. one of another instance, keeps it in use
+				if (Get-VM -Name $name -ErrorAction SilentlyContinue) {
+					continue
+				}
+				try {
+					Remove-Item -Path $item.FullName -Recurse -Force -ErrorAction Stop
+					Write-Output "GC_REMOVED:$($item.FullName)"
+				} catch {
+					Write-Output "GC_FAILED:$($item.FullName): $_"
+				}
+			}
+		}
+	
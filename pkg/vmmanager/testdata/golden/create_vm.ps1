@@ -0,0 +1,536 @@
+# ---- script 1 ----
+
+		$ErrorActionPreference = "Stop"
+		foreach ($path in @("D:\vms", "E:\vms")) {
+			New-Item -Path $path -ItemType Directory -Force | Out-Null
+			$root = [System.IO.Path]::GetPathRoot((Resolve-Path -LiteralPath $path).ProviderPath)
+			$free = (New-Object System.IO.DriveInfo($root)).AvailableFreeSpace
+			Write-Output "STORAGE_FREE:$free|$path"
+		}
+	
+# ---- script 2 ----
+New-VHD -ParentPath "C:\templates\runner.vhdx" -Path "E:\vms\runner-1.vhdx" -Differencing
+# ---- script 3 ----
+
+		New-VM -Name "runner-1" -MemoryStartupBytes 4096MB -Generation 2 -VHDPath "E:\vms\runner-1.vhdx"
+		Set-VM -Name "runner-1" -ProcessorCount 2
+		Set-VM -Name "runner-1" -AutomaticStartAction Nothing
+		Set-VM -Name "runner-1" -AutomaticStopAction ShutDown
+		$vmDrive = Get-VMHardDiskDrive -VMName "runner-1"
+		Set-VMFirmware -VMName "runner-1" -BootOrder $vmDrive
+	
+		Set-VM -Name "runner-1" -Notes "hyperv-runner-pool owner=pool-a"
+	
+		Add-VMNetworkAdapter -VMName "runner-1" -Name "Network Adapter 1" -SwitchName "Default Switch" -DeviceNaming On
+
+# ---- script 4 ----
+
+		$ErrorActionPreference = "Stop"
+		$disk = Mount-VHD -Path "E:\vms\runner-1.vhdx" -Passthru
+		$diskNumber = $disk.Number
+		Write-Output "DiskNumber: $diskNumber"
+
+		# Get all partitions to see what's available
+		$partitions = Get-Partition -DiskNumber $diskNumber
+		Write-Output "Partitions found: $($partitions.Count)"
+		$partitions | ForEach-Object {
+			Write-Output "  Partition $($_.PartitionNumber): Type=$($_.Type), Size=$($_.Size), DriveLetter=$($_.DriveLetter)"
+		}
+
+		# Try to find the main Windows partition
+		# It should be the largest Basic partition, or the one with a drive letter
+		$partition = $partitions | Where-Object { $_.Type -eq 'Basic' -and $_.DriveLetter } | Select-Object -First 1
+
+		if (-not $partition) {
+			# If no partition with drive letter, try to assign one to the largest Basic partition
+			$partition = $partitions | Where-Object { $_.Type -eq 'Basic' } | Sort-Object Size -Descending | Select-Object -First 1
+			if ($partition -and -not $partition.DriveLetter) {
+				Write-Output "Assigning drive letter to partition $($partition.PartitionNumber)..."
+				$partition | Add-PartitionAccessPath -AssignDriveLetter
+				$partition = Get-Partition -DiskNumber $diskNumber -PartitionNumber $partition.PartitionNumber
+			}
+		}
+
+		if (-not $partition) {
+			throw "No suitable partition found on disk"
+		}
+
+		$driveLetter = $partition.DriveLetter
+		if (-not $driveLetter) {
+			throw "Failed to get drive letter for partition"
+		}
+
+		Write-Output "DRIVE_LETTER:$driveLetter"
+	
+# ---- script 5 ----
+
+		$ErrorActionPreference = "Stop"
+		$dest = "F:\runner-config.json"
+
+		Write-Output "Writing to: $dest"
+		[System.IO.File]::WriteAllBytes($dest, [System.Convert]::FromBase64String("eyJ0b2tlbiI6IkFBQkJDQyIsIm9yZ2FuaXphdGlvbiI6InRlc3Qtb3JnIiwicmVwb3NpdG9yeSI6InRlc3QtcmVwbyIsIm5hbWUiOiJydW5uZXItMSIsImxhYmVscyI6InNlbGYtaG9zdGVkLFdpbmRvd3MsWDY0LGVwaGVtZXJhbCxvd25lci1wb29sLWEsZ3B1In0="))
+
+		if (-not (Test-Path $dest)) {
+			throw "Copy failed - destination file not found: $dest"
+		}
+
+		$copiedSize = (Get-Item $dest).Length
+		Write-Output "File copied successfully. Size: $copiedSize bytes"
+
+		# Verify content
+		$content = Get-Content $dest -Raw
+		Write-Output "Content preview: $($content.Substring(0, [Math]::Min(100, $content.Length)))..."
+
+		Write-Output "SUCCESS"
+	
+# ---- script 6 ----
+Dismount-VHD -Path "E:\vms\runner-1.vhdx"
+# ---- script 7 ----
+Start-VM -Name "runner-1"
+# ---- script 8 ----
+
+		$ErrorActionPreference = "Stop"
+		$vmName = "runner-1"
+		$username = "Administrator"
+		$password = "password"
+
+		# Create credential object
+		$securePassword = ConvertTo-SecureString $password -AsPlainText -Force
+		$credential = New-Object System.Management.Automation.PSCredential ($username, $securePassword)
+
+		# The script to execute in the VM
+		$scriptContent = @'
+# Configure GitHub Actions Runner
+# This script is injected and executed by the orchestrator after VM creation
+# It downloads, installs, configures, and runs the ephemeral runner directly
+
+$ErrorActionPreference = "Stop"
+
+# Keep a transcript in the guest so diagnostics collection can pull it out of failed VMs
+Start-Transcript -Path "C:\configure-runner.log" -Append | Out-Null
+
+Write-Host "=========================================="
+Write-Host "GitHub Actions Runner Setup"
+Write-Host "=========================================="
+
+$runnerPath = "C:\actions-runner"
+$configPath = "C:\runner-config.json"
+
+# Locate the runner configuration
+# Depending on the injection strategy it is written to C:\ directly (mount, copy_file, checkpoint),
+# found on an attached seed ISO (iso), or pushed as KVP items (kvp)
+$kvpKey = "HKLM:\SOFTWARE\Microsoft\Virtual Machine\External"
+$configDeadline = (Get-Date).AddSeconds(120)
+while (-not (Test-Path $configPath)) {
+    # Seed ISO attached as a DVD drive
+    $dvdDrives = Get-CimInstance -ClassName Win32_LogicalDisk -ErrorAction SilentlyContinue | Where-Object { $_.DriveType -eq 5 }
+    foreach ($drive in $dvdDrives) {
+        $seedConfig = Join-Path "$($drive.DeviceID)\" "runner-config.json"
+        if (Test-Path $seedConfig) {
+            Write-Host "Found runner configuration on seed ISO at $seedConfig"
+            Copy-Item -Path $seedConfig -Destination $configPath -Force
+            break
+        }
+    }
+    if (Test-Path $configPath) {
+        break
+    }
+
+    # Host-to-guest KVP items, split into base64 chunks
+    $kvp = Get-ItemProperty -Path $kvpKey -ErrorAction SilentlyContinue
+    if ($kvp -and $kvp."runner-config-chunks") {
+        $chunkCount = [int]$kvp."runner-config-chunks"
+        $encoded = -join (0..($chunkCount - 1) | ForEach-Object { $kvp."runner-config-$_" })
+        if ($encoded.Length -gt 0 -and $encoded.Length % 4 -eq 0) {
+            Write-Host "Found runner configuration in KVP data exchange ($chunkCount chunks)"
+            [System.IO.File]::WriteAllBytes($configPath, [System.Convert]::FromBase64String($encoded))
+            break
+        }
+    }
+
+    if ((Get-Date) -gt $configDeadline) {
+        throw "Runner configuration file not found at $configPath, on a seed ISO or in KVP data. The orchestrator should inject this before running this script."
+    }
+    Start-Sleep -Seconds 2
+}
+
+# Step 0: Apply static network addressing before anything needs the network
+$networkConfig = (Get-Content -Path $configPath -Raw | ConvertFrom-Json).network
+if ($networkConfig) {
+    Write-Host ""
+    Write-Host "Step 0: Configuring Static Network Addresses..."
+    Write-Host "--------------------------------------------"
+
+    foreach ($entry in $networkConfig) {
+        # Hyper-V device naming exposes the host-side adapter name to the guest
+        $property = Get-NetAdapterAdvancedProperty -DisplayName "Hyper-V Network Adapter Name" -ErrorAction SilentlyContinue |
+            Where-Object { $_.DisplayValue -eq $entry.adapter_name } |
+            Select-Object -First 1
+        if (-not $property) {
+            throw "Network adapter `"$($entry.adapter_name)`" not found in guest"
+        }
+        $ifIndex = (Get-NetAdapter -Name $property.Name).ifIndex
+
+        Write-Host "  $($entry.adapter_name): $($entry.ip_address)/$($entry.prefix_length)"
+        Set-NetIPInterface -InterfaceIndex $ifIndex -Dhcp Disabled
+        Get-NetIPAddress -InterfaceIndex $ifIndex -AddressFamily IPv4 -ErrorAction SilentlyContinue |
+            Remove-NetIPAddress -Confirm:$false -ErrorAction SilentlyContinue
+        Get-NetRoute -InterfaceIndex $ifIndex -DestinationPrefix "0.0.0.0/0" -ErrorAction SilentlyContinue |
+            Remove-NetRoute -Confirm:$false -ErrorAction SilentlyContinue
+
+        $addressArgs = @{
+            InterfaceIndex = $ifIndex
+            IPAddress      = $entry.ip_address
+            PrefixLength   = $entry.prefix_length
+        }
+        if ($entry.gateway) {
+            $addressArgs.DefaultGateway = $entry.gateway
+        }
+        New-NetIPAddress @addressArgs | Out-Null
+
+        if ($entry.dns_servers) {
+            Set-DnsClientServerAddress -InterfaceIndex $ifIndex -ServerAddresses $entry.dns_servers
+        }
+    }
+
+    Write-Host "Static network configuration applied"
+}
+
+# Step 0b: Bring the tool cache and scratch disks online under their configured drive letters
+$diskConfig = Get-Content -Path $configPath -Raw | ConvertFrom-Json
+if ($diskConfig.tool_cache_drive -or $diskConfig.scratch_drive) {
+    Write-Host ""
+    Write-Host "Step 0b: Preparing Extra Disks..."
+    Write-Host "--------------------------------------------"
+
+    foreach ($disk in Get-Disk | Where-Object { -not $_.IsBoot -and -not $_.IsSystem }) {
+        if ($disk.IsOffline) {
+            Set-Disk -Number $disk.Number -IsOffline $false
+        }
+        if ($disk.IsReadOnly) {
+            Set-Disk -Number $disk.Number -IsReadOnly $false
+        }
+        $disk = Get-Disk -Number $disk.Number
+
+        if ($disk.PartitionStyle -eq "RAW") {
+            # Only the scratch disk is blank, the tool cache disk is always formatted
+            if (-not $diskConfig.scratch_drive) {
+                continue
+            }
+            Write-Host "  Formatting scratch disk $($disk.Number) as $($diskConfig.scratch_drive):"
+            Initialize-Disk -Number $disk.Number -PartitionStyle GPT
+            New-Partition -DiskNumber $disk.Number -UseMaximumSize -DriveLetter $diskConfig.scratch_drive |
+                Format-Volume -FileSystem NTFS -NewFileSystemLabel "Scratch" -Confirm:$false | Out-Null
+        } elseif ($diskConfig.tool_cache_drive) {
+            $partition = Get-Partition -DiskNumber $disk.Number |
+                Where-Object { $_.Type -eq "Basic" } |
+                Sort-Object -Property Size -Descending |
+                Select-Object -First 1
+            if ($partition -and "$($partition.DriveLetter)" -ne $diskConfig.tool_cache_drive) {
+                Set-Partition -DiskNumber $disk.Number -PartitionNumber $partition.PartitionNumber -NewDriveLetter $diskConfig.tool_cache_drive
+            }
+            Write-Host "  Tool cache disk $($disk.Number) mounted as $($diskConfig.tool_cache_drive):"
+        }
+    }
+
+    Write-Host "Extra disks ready"
+}
+
+# Step 1: Download and install GitHub Actions Runner if not already present
+if (-not (Test-Path "$runnerPath\config.cmd")) {
+    Write-Host ""
+    Write-Host "Step 1: Installing GitHub Actions Runner..."
+    Write-Host "--------------------------------------------"
+
+    # Create runner directory
+    Write-Host "Creating runner directory at $runnerPath..."
+    New-Item -Path $runnerPath -ItemType Directory -Force | Out-Null
+    Set-Location $runnerPath
+
+    # Download the latest runner
+    Write-Host "Downloading GitHub Actions Runner..."
+    try {
+        $latestRelease = Invoke-RestMethod -Uri "https://api.github.com/repos/actions/runner/releases/latest"
+        $downloadUrl = $latestRelease.assets | Where-Object { $_.name -like "*win-x64*.zip" } | Select-Object -First 1 -ExpandProperty browser_download_url
+
+        if (-not $downloadUrl) {
+            throw "Could not find Windows x64 runner in latest release"
+        }
+
+        Write-Host "Downloading from: $downloadUrl"
+        Invoke-WebRequest -Uri $downloadUrl -OutFile "actions-runner.zip" -UseBasicParsing
+    } catch {
+        throw "Failed to download GitHub Actions Runner: $_"
+    }
+
+    # Extract runner
+    Write-Host "Extracting runner..."
+    try {
+        Add-Type -AssemblyName System.IO.Compression.FileSystem
+        [System.IO.Compression.ZipFile]::ExtractToDirectory("$runnerPath\actions-runner.zip", $runnerPath)
+    } catch {
+        throw "Failed to extract runner: $_"
+    }
+
+    # Cleanup zip file
+    Remove-Item -Path "$runnerPath\actions-runner.zip" -Force -ErrorAction SilentlyContinue
+
+    # Verify extraction
+    if (-not (Test-Path "$runnerPath\config.cmd")) {
+        throw "Runner extraction failed - config.cmd not found"
+    }
+
+    Write-Host "GitHub Actions Runner installed successfully!"
+} else {
+    Write-Host ""
+    Write-Host "Step 1: Runner Already Installed"
+    Write-Host "--------------------------------------------"
+    Write-Host "GitHub Actions Runner already present at $runnerPath"
+}
+
+# Change to runner directory for configuration
+Set-Location $runnerPath
+
+Write-Host ""
+Write-Host "Step 2: Reading Runner Configuration..."
+Write-Host "--------------------------------------------"
+try {
+    $configJson = Get-Content -Path $configPath -Raw
+    $config = $configJson | ConvertFrom-Json
+} catch {
+    throw "Failed to read configuration: $_"
+}
+
+Write-Host "Configuration loaded:"
+Write-Host "  Organization: $($config.organization)"
+Write-Host "  Repository: $($config.repository)"
+Write-Host "  Name: $($config.name)"
+Write-Host "  Labels: $($config.labels)"
+if ($config.runner_group) {
+    Write-Host "  Runner Group: $($config.runner_group)"
+}
+if ($config.cache_url) {
+    Write-Host "  Cache URL: $($config.cache_url)"
+}
+
+Write-Host ""
+Write-Host "Step 3: Configuring Runner..."
+Write-Host "--------------------------------------------"
+
+# Remove any existing runner configuration
+if (Test-Path ".runner") {
+    Write-Host "Removing existing runner configuration..."
+    .\config.cmd remove --token $config.token
+}
+
+# Configure runner
+Write-Host "Registering runner with GitHub..."
+$configArgs = @(
+    "--unattended",
+    "--url"
+)
+
+if ($config.repository) {
+    # Repository-level runner
+    $configArgs += "https://github.com/$($config.organization)/$($config.repository)"
+} else {
+    # Organization-level runner
+    $configArgs += "https://github.com/$($config.organization)"
+}
+
+$configArgs += @(
+    "--token", $config.token,
+    "--name", $config.name,
+    "--labels", $config.labels,
+    "--ephemeral",
+    "--disableupdate"
+)
+
+# Add runner group if specified (org-level runners only)
+if ($config.runner_group -and -not $config.repository) {
+    $configArgs += @("--runnergroup", $config.runner_group)
+    Write-Host "Using runner group: $($config.runner_group)"
+}
+
+# Keep job workspaces on the scratch disk so the system disk only holds the OS and tools
+if ($config.scratch_drive) {
+    $configArgs += @("--work", "$($config.scratch_drive):\_work")
+    Write-Host "Using work directory: $($config.scratch_drive):\_work"
+}
+
+& .\config.cmd @configArgs
+
+if ($LASTEXITCODE -ne 0) {
+    throw "Failed to configure runner (exit code: $LASTEXITCODE)"
+}
+
+Write-Host "Runner configured successfully!"
+
+# Point the setup-* actions at the pre-warmed tool cache, plus any variables shipped on the disk itself
+if ($config.tool_cache_drive) {
+    $toolCache = "$($config.tool_cache_drive):\hostedtoolcache"
+    if (Test-Path $toolCache) {
+        Add-Content -Path "$runnerPath\.env" -Value "RUNNER_TOOL_CACHE=$toolCache"
+        Add-Content -Path "$runnerPath\.env" -Value "AGENT_TOOLSDIRECTORY=$toolCache"
+        Write-Host "Using tool cache: $toolCache"
+    }
+    $toolCacheEnv = "$($config.tool_cache_drive):\runner.env"
+    if (Test-Path $toolCacheEnv) {
+        Get-Content -Path $toolCacheEnv | Add-Content -Path "$runnerPath\.env"
+        Write-Host "Loaded runner environment from $toolCacheEnv"
+    }
+}
+
+Write-Host ""
+Write-Host "Step 4: Starting Runner..."
+Write-Host "--------------------------------------------"
+Write-Host "Running in ephemeral single-job mode..."
+Write-Host "Runner will wait for a job, execute it, then exit."
+
+# Patch runner for custom cache server if URL is provided
+if ($config.cache_url) {
+    Write-Host ""
+    Write-Host "Configuring custom cache server..."
+    Write-Host "  Cache URL: $($config.cache_url)"
+
+    # Patch the runner binary to use custom cache server
+    # GitHub''s runner doesn''t natively support custom ACTIONS_RESULTS_URL,
+    # so we need to patch the Runner.Worker.dll binary
+    #
+    # This replaces the string "ACTIONS_RESULTS_URL" with "ACTIONS_RESULTS_ORL"
+    # in the binary, which allows us to use CUSTOM_ACTIONS_RESULTS_URL env var
+    # See: https://gha-cache-server.falcondev.io/getting-started
+
+    $workerDllPath = "$runnerPath\bin\Runner.Worker.dll"
+
+    if (Test-Path $workerDllPath) {
+        Write-Host "  Patching runner binary for custom cache server..."
+
+        try {
+            # Read the binary file
+            $bytes = [System.IO.File]::ReadAllBytes($workerDllPath)
+
+            # Convert to string for pattern matching (using ASCII encoding)
+            $content = [System.Text.Encoding]::ASCII.GetString($bytes)
+
+            # Replace ACTIONS_RESULTS_URL with ACTIONS_RESULTS_ORL
+            # This effectively disables the hardcoded URL check
+            $oldPattern = "ACTIONS_RESULTS_URL"
+            $newPattern = "ACTIONS_RESULTS_ORL"
+
+            if ($content.Contains($oldPattern)) {
+                $content = $content.Replace($oldPattern, $newPattern)
+
+                # Convert back to bytes
+                $patchedBytes = [System.Text.Encoding]::ASCII.GetBytes($content)
+
+                # Write patched binary
+                [System.IO.File]::WriteAllBytes($workerDllPath, $patchedBytes)
+
+                Write-Host "  Runner binary patched successfully"
+
+                # Now set the custom cache URL environment variable
+                $env:CUSTOM_ACTIONS_RESULTS_URL = $config.cache_url
+                Write-Host "  Custom cache server URL set: $($config.cache_url)"
+            } else {
+                Write-Host "  Runner appears to be already patched or incompatible"
+                Write-Host "  Attempting to use cache server anyway..."
+                $env:CUSTOM_ACTIONS_RESULTS_URL = $config.cache_url
+            }
+        } catch {
+            Write-Host "  WARNING: Failed to patch runner binary: $_"
+            Write-Host "  Cache server may not work correctly"
+        }
+    } else {
+        Write-Host "  WARNING: Runner.Worker.dll not found at $workerDllPath"
+        Write-Host "  Skipping runner patching"
+    }
+}
+
+Write-Host ""
+
+# Write a heartbeat for the orchestrator''s liveness probe while the runner runs
+if ($config.heartbeat_seconds) {
+    Write-Host "Writing liveness heartbeat every $($config.heartbeat_seconds) seconds"
+    Start-Job -ScriptBlock {
+        param($path, $seconds)
+        while ($true) {
+            Set-Content -Path $path -Value ([DateTimeOffset]::UtcNow.ToUnixTimeSeconds())
+            Start-Sleep -Seconds $seconds
+        }
+    } -ArgumentList "$runnerPath\heartbeat", ([int]$config.heartbeat_seconds) | Out-Null
+}
+
+# Run the runner (this will block until job completes)
+# Using --once flag to run a single job then exit
+& .\run.cmd --once
+
+Write-Host ""
+Write-Host "=========================================="
+Write-Host "Job Complete - Shutting Down"
+Write-Host "=========================================="
+Write-Host "Runner has completed its job and will shut down."
+Write-Host "The orchestrator will detect this and recreate the VM."
+Write-Host ""
+
+# Give a brief moment for any final cleanup
+Start-Sleep -Seconds 2
+
+# Shutdown the VM - orchestrator will recreate it
+Stop-Computer -Force
+
+'@
+
+		# Execute script in VM with retries
+		$maxRetries = 10
+		$retryCount = 0
+		$retryDelay = 10
+
+		while ($retryCount -lt $maxRetries) {
+			try {
+				Write-Output "Attempt $($retryCount + 1) of $maxRetries to connect to VM..."
+
+				# Execute the script in the VM
+				$result = Invoke-Command -VMName $vmName -Credential $credential -ScriptBlock {
+					param($script)
+
+					# Write script to temp file and execute it
+					$tempScript = "$env:TEMP\configure-runner-$([guid]::NewGuid()).ps1"
+					Set-Content -Path $tempScript -Value $script -Force
+
+					try {
+						& powershell.exe -ExecutionPolicy Bypass -NoProfile -File $tempScript 2>&1
+						$exitCode = $LASTEXITCODE
+						Remove-Item $tempScript -Force -ErrorAction SilentlyContinue
+
+						if ($exitCode -ne 0) {
+							throw "Script exited with code $exitCode"
+						}
+					} catch {
+						Remove-Item $tempScript -Force -ErrorAction SilentlyContinue
+						throw
+					}
+				} -ArgumentList $scriptContent
+
+				# Output the result
+				$result | ForEach-Object { Write-Output $_ }
+
+				Write-Output "SCRIPT_EXECUTION_SUCCESS"
+				break
+			} catch {
+				$retryCount++
+				if ($retryCount -lt $maxRetries) {
+					Write-Output "Connection failed: $_"
+					Write-Output "Waiting $retryDelay seconds before retry..."
+					Start-Sleep -Seconds $retryDelay
+				} else {
+					throw "Failed to execute script after $maxRetries attempts: $_"
+				}
+			}
+		}
+	
+# ---- script 9 ----
+
+		$adapter = Get-VMNetworkAdapter -VMName "runner-1" -Name "Network Adapter 1"
+		$adapter.IPAddresses | Where-Object { $_ -match '^\d+\.\d+\.\d+\.\d+$' } | Select-Object -First 1
+	
@@ -0,0 +1,20 @@
+# ---- script 1 ----
+Stop-VM -Name "runner-2" -TurnOff -Force -ErrorAction SilentlyContinue
+# ---- script 2 ----
+
+		$ErrorActionPreference = "Stop"
+		$vm = Get-VM | Where-Object { $_.Name -eq "runner-2" }
+		if (-not $vm) {
+			Write-Output "VM_NOT_FOUND"
+			return
+		}
+		Remove-VM -VM $vm -Force
+	
+# ---- script 3 ----
+
+		Remove-Item -Path "E:\vms\runner-2.vhdx" -Force -ErrorAction SilentlyContinue
+		Remove-Item -Path "E:\vms\runner-2-toolcache.vhdx" -Force -ErrorAction SilentlyContinue
+		Remove-Item -Path "E:\vms\runner-2-scratch.vhdx" -Force -ErrorAction SilentlyContinue
+		Remove-Item -Path "E:\vms\runner-2-seed.iso" -Force -ErrorAction SilentlyContinue
+		Remove-Item -Path "E:\vms\runner-2" -Recurse -Force -ErrorAction SilentlyContinue
+	
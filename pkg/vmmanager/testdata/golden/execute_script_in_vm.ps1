@@ -0,0 +1,64 @@
+# ---- script 1 ----
+
+		$ErrorActionPreference = "Stop"
+		$vmName = "runner-1"
+		$username = "Administrator"
+		$password = "password"
+
+		# Create credential object
+		$securePassword = ConvertTo-SecureString $password -AsPlainText -Force
+		$credential = New-Object System.Management.Automation.PSCredential ($username, $securePassword)
+
+		# The script to execute in the VM
+		$scriptContent = @'
+Write-Output ''hello''
+'@
+
+		# Execute script in VM with retries
+		$maxRetries = 10
+		$retryCount = 0
+		$retryDelay = 10
+
+		while ($retryCount -lt $maxRetries) {
+			try {
+				Write-Output "Attempt $($retryCount + 1) of $maxRetries to connect to VM..."
+
+				# Execute the script in the VM
+				$result = Invoke-Command -VMName $vmName -Credential $credential -ScriptBlock {
+					param($script)
+
+					# Write script to temp file and execute it
+					$tempScript = "$env:TEMP\configure-runner-$([guid]::NewGuid()).ps1"
+					Set-Content -Path $tempScript -Value $script -Force
+
+					try {
+						& powershell.exe -ExecutionPolicy Bypass -NoProfile -File $tempScript 2>&1
+						$exitCode = $LASTEXITCODE
+						Remove-Item $tempScript -Force -ErrorAction SilentlyContinue
+
+						if ($exitCode -ne 0) {
+							throw "Script exited with code $exitCode"
+						}
+					} catch {
+						Remove-Item $tempScript -Force -ErrorAction SilentlyContinue
+						throw
+					}
+				} -ArgumentList $scriptContent
+
+				# Output the result
+				$result | ForEach-Object { Write-Output $_ }
+
+				Write-Output "SCRIPT_EXECUTION_SUCCESS"
+				break
+			} catch {
+				$retryCount++
+				if ($retryCount -lt $maxRetries) {
+					Write-Output "Connection failed: $_"
+					Write-Output "Waiting $retryDelay seconds before retry..."
+					Start-Sleep -Seconds $retryDelay
+				} else {
+					throw "Failed to execute script after $maxRetries attempts: $_"
+				}
+			}
+		}
+	
@@ -0,0 +1,7 @@
+# ---- script 1 ----
+
+		New-VHD -ParentPath "C:\templates\toolcache-2026.10.vhdx" -Path "E:\vms\runner-1-toolcache.vhdx" -Differencing -ErrorAction Stop | Out-Null
+		Add-VMHardDiskDrive -VMName "runner-1" -Path "E:\vms\runner-1-toolcache.vhdx" -ErrorAction Stop
+		New-VHD -Path "E:\vms\runner-1-scratch.vhdx" -SizeBytes 100GB -Dynamic -ErrorAction Stop | Out-Null
+		Add-VMHardDiskDrive -VMName "runner-1" -Path "E:\vms\runner-1-scratch.vhdx" -ErrorAction Stop
+
@@ -0,0 +1,62 @@
+# ---- script 1 ----
+
+		$ErrorActionPreference = "Stop"
+		$disk = Mount-VHD -Path "D:\vms\runner-1.vhdx" -Passthru
+		$diskNumber = $disk.Number
+		Write-Output "DiskNumber: $diskNumber"
+
+		# Get all partitions to see what's available
+		$partitions = Get-Partition -DiskNumber $diskNumber
+		Write-Output "Partitions found: $($partitions.Count)"
+		$partitions | ForEach-Object {
+			Write-Output "  Partition $($_.PartitionNumber): Type=$($_.Type), Size=$($_.Size), DriveLetter=$($_.DriveLetter)"
+		}
+
+		# Try to find the main Windows partition
+		# It should be the largest Basic partition, or the one with a drive letter
+		$partition = $partitions | Where-Object { $_.Type -eq 'Basic' -and $_.DriveLetter } | Select-Object -First 1
+
+		if (-not $partition) {
+			# If no partition with drive letter, try to assign one to the largest Basic partition
+			$partition = $partitions | Where-Object { $_.Type -eq 'Basic' } | Sort-Object Size -Descending | Select-Object -First 1
+			if ($partition -and -not $partition.DriveLetter) {
+				Write-Output "Assigning drive letter to partition $($partition.PartitionNumber)..."
+				$partition | Add-PartitionAccessPath -AssignDriveLetter
+				$partition = Get-Partition -DiskNumber $diskNumber -PartitionNumber $partition.PartitionNumber
+			}
+		}
+
+		if (-not $partition) {
+			throw "No suitable partition found on disk"
+		}
+
+		$driveLetter = $partition.DriveLetter
+		if (-not $driveLetter) {
+			throw "Failed to get drive letter for partition"
+		}
+
+		Write-Output "DRIVE_LETTER:$driveLetter"
+	
+# ---- script 2 ----
+
+		$ErrorActionPreference = "Stop"
+		$dest = "G:\runner-config.json"
+
+		Write-Output "Writing to: $dest"
+		[System.IO.File]::WriteAllBytes($dest, [System.Convert]::FromBase64String("eyJ0b2tlbiI6IkFBQkJDQyIsIm9yZ2FuaXphdGlvbiI6InRlc3Qtb3JnIiwicmVwb3NpdG9yeSI6IiIsIm5hbWUiOiJydW5uZXItMSIsImxhYmVscyI6InNlbGYtaG9zdGVkIn0="))
+
+		if (-not (Test-Path $dest)) {
+			throw "Copy failed - destination file not found: $dest"
+		}
+
+		$copiedSize = (Get-Item $dest).Length
+		Write-Output "File copied successfully. Size: $copiedSize bytes"
+
+		# Verify content
+		$content = Get-Content $dest -Raw
+		Write-Output "Content preview: $($content.Substring(0, [Math]::Min(100, $content.Length)))..."
+
+		Write-Output "SUCCESS"
+	
+# ---- script 3 ----
+Dismount-VHD -Path "D:\vms\runner-1.vhdx"
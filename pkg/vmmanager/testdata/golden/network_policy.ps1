@@ -0,0 +1,14 @@
+# ---- script 1 ----
+
+		Add-VMNetworkAdapter -VMName "runner-1" -Name "Network Adapter 1" -SwitchName "Default Switch" -DeviceNaming On
+		Add-VMNetworkAdapterExtendedAcl -VMName "runner-1" -VMNetworkAdapterName "Network Adapter 1" -Action Deny -Direction Outbound -Weight 1
+		Add-VMNetworkAdapterExtendedAcl -VMName "runner-1" -VMNetworkAdapterName "Network Adapter 1" -Action Allow -Direction Outbound -Weight 2 -RemoteIPAddress "0.0.0.0/0" -Protocol "TCP" -RemotePort "80"
+		Add-VMNetworkAdapterExtendedAcl -VMName "runner-1" -VMNetworkAdapterName "Network Adapter 1" -Action Allow -Direction Outbound -Weight 3 -RemoteIPAddress "0.0.0.0/0" -Protocol "TCP" -RemotePort "443"
+		Add-VMNetworkAdapterExtendedAcl -VMName "runner-1" -VMNetworkAdapterName "Network Adapter 1" -Action Deny -Direction Outbound -Weight 4 -RemoteIPAddress "10.0.5.0/24"
+		Add-VMNetworkAdapterExtendedAcl -VMName "runner-1" -VMNetworkAdapterName "Network Adapter 1" -Action Allow -Direction Outbound -Weight 5 -RemoteIPAddress "10.0.5.2/32" -Protocol "TCP" -RemotePort "3000"
+		Add-VMNetworkAdapterExtendedAcl -VMName "runner-1" -VMNetworkAdapterName "Network Adapter 1" -Action Allow -Direction Outbound -Weight 6 -RemoteIPAddress "10.0.5.53/32" -Protocol "UDP" -RemotePort "53"
+		Add-VMNetworkAdapterExtendedAcl -VMName "runner-1" -VMNetworkAdapterName "Network Adapter 1" -Action Allow -Direction Outbound -Weight 7 -RemoteIPAddress "10.0.5.53/32" -Protocol "TCP" -RemotePort "53"
+		Add-VMNetworkAdapterExtendedAcl -VMName "runner-1" -VMNetworkAdapterName "Network Adapter 1" -Action Allow -Direction Outbound -Weight 8 -Protocol "UDP" -RemotePort "67"
+		Add-VMNetworkAdapterExtendedAcl -VMName "runner-1" -VMNetworkAdapterName "Network Adapter 1" -Action Deny -Direction Outbound -Weight 9 -RemoteIPAddress "192.168.10.0/24"
+		Add-VMNetworkAdapterExtendedAcl -VMName "runner-1" -VMNetworkAdapterName "Network Adapter 1" -Action Deny -Direction Inbound -Weight 10 -RemoteIPAddress "192.168.10.0/24"
+
@@ -0,0 +1,40 @@
+# ---- script 1 ----
+
+		$ErrorActionPreference = "Stop"
+		$job = Start-Job -ScriptBlock {
+			param($vmName, $username, $password)
+			$ErrorActionPreference = "Stop"
+			$securePassword = ConvertTo-SecureString $password -AsPlainText -Force
+			$credential = New-Object System.Management.Automation.PSCredential ($username, $securePassword)
+
+			Invoke-Command -VMName $vmName -Credential $credential -ScriptBlock {
+				$now = Get-Date
+				$listener = Get-Process -Name "Runner.Listener" -ErrorAction SilentlyContinue
+				Write-Output "LISTENER:$([bool]$listener)"
+				$disk = Get-CimInstance -ClassName Win32_LogicalDisk -Filter "DeviceID='$env:SystemDrive'"
+				Write-Output "FREE_DISK_BYTES:$($disk.FreeSpace)"
+				$log = Get-ChildItem -Path "C:\actions-runner\_diag\Runner_*.log" -ErrorAction SilentlyContinue |
+					Sort-Object LastWriteTime -Descending | Select-Object -First 1
+				if ($log) {
+					Write-Output "LOG_AGE_SECONDS:$([int]($now - $log.LastWriteTime).TotalSeconds)"
+				}
+				$heartbeat = Get-Item -Path "C:\actions-runner\heartbeat" -ErrorAction SilentlyContinue
+				if ($heartbeat) {
+					Write-Output "HEARTBEAT_AGE_SECONDS:$([int]($now - $heartbeat.LastWriteTime).TotalSeconds)"
+				}
+			}
+		} -ArgumentList "runner-1", "Administrator", "password"
+
+		if (-not (Wait-Job $job -Timeout 20)) {
+			Stop-Job $job
+			Remove-Job $job -Force
+			throw "Guest did not answer the liveness probe within 20 seconds"
+		}
+		if ($job.State -eq "Failed") {
+			$reason = $job.ChildJobs[0].JobStateInfo.Reason
+			Remove-Job $job -Force
+			throw "Guest liveness probe failed: $reason"
+		}
+		Receive-Job $job
+		Remove-Job $job -Force
+	
@@ -1,10 +1,18 @@
 package vmmanager
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"hyperv-runner-pool/pkg/config"
 )
 
 // testLogger creates a logger for tests (discards output)
@@ -130,6 +138,73 @@ func TestMockVMManager_RunPowerShell(t *testing.T) {
 	}
 }
 
+func TestMockVMManager_ScriptedFailures(t *testing.T) {
+	manager := NewMockVMManagerWithScenario(config.MockScenarioConfig{
+		CreateVM:   config.MockCallConfig{FailCalls: []int{2}},
+		DestroyVM:  config.MockCallConfig{FailureProbability: 1},
+		GetVMState: config.MockCallConfig{LatencyMinMS: 20, LatencyMaxMS: 40},
+	}, testLogger())
+	slot := &VMSlot{Index: 1, Name: "runner-1"}
+
+	for call, wantErr := range []bool{false, true, false} {
+		err := manager.CreateVM(slot)
+		if (err != nil) != wantErr {
+			t.Errorf("CreateVM call %d: expected failure %v, got %v", call+1, wantErr, err)
+		}
+	}
+	if manager.Calls("CreateVM") != 3 {
+		t.Errorf("Expected 3 CreateVM calls, got %d", manager.Calls("CreateVM"))
+	}
+
+	if err := manager.DestroyVM(slot); err == nil {
+		t.Error("Expected DestroyVM to fail with failure_probability 1")
+	}
+
+	start := time.Now()
+	if _, err := manager.GetVMState(slot.Name); err != nil {
+		t.Fatalf("GetVMState failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected at least 20ms of simulated latency, got %v", elapsed)
+	}
+}
+
+func TestMockVMManager_JobCompletion(t *testing.T) {
+	manager := NewMockVMManagerWithScenario(config.MockScenarioConfig{JobMinSeconds: 1, JobMaxSeconds: 1}, testLogger())
+	slot := &VMSlot{Index: 1, Name: "runner-1"}
+
+	if err := manager.CreateVM(slot); err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+	if state, _ := manager.GetVMState(slot.Name); state != "Running" {
+		t.Fatalf("Expected Running before the job ends, got %q", state)
+	}
+
+	time.Sleep(1200 * time.Millisecond)
+	if state, _ := manager.GetVMState(slot.Name); state != "Off" {
+		t.Errorf("Expected Off after the job ends, got %q", state)
+	}
+}
+
+func TestMockVMManager_VanishingVMs(t *testing.T) {
+	manager := NewMockVMManagerWithScenario(config.MockScenarioConfig{VanishProbability: 1}, testLogger())
+	slot := &VMSlot{Index: 1, Name: "runner-1"}
+
+	if err := manager.CreateVM(slot); err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+	if _, err := manager.GetVMState(slot.Name); err == nil {
+		t.Error("Expected the VM to have vanished")
+	}
+
+	manager = NewMockVMManagerWithScenario(config.MockScenarioConfig{}, testLogger())
+	manager.CreateVM(slot)
+	manager.VanishVM(slot.Name)
+	if _, err := manager.GetVMState(slot.Name); !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("Expected VanishVM to delete the VM, got %v", err)
+	}
+}
+
 // ========================================
 // RunnerConfig Tests
 // ========================================
@@ -167,6 +242,229 @@ func TestRunnerConfig_JSONSerialization(t *testing.T) {
 	}
 }
 
+func TestConfigSeedScript_EmbedsConfig(t *testing.T) {
+	config := RunnerConfig{
+		Token:        "test-token-123",
+		Organization: "test-org",
+		Name:         "runner-1",
+		Labels:       "self-hosted,Windows,X64,ephemeral,it's-quoted",
+	}
+
+	script, err := configSeedScript(config)
+	if err != nil {
+		t.Fatalf("Failed to build seed script: %v", err)
+	}
+
+	// ExecuteScriptInVM doubles single quotes, so the prelude must not contain any
+	if strings.Contains(script, "'") {
+		t.Error("Seed script must not contain single quotes")
+	}
+
+	match := regexp.MustCompile(`FromBase64String\("([A-Za-z0-9+/=]+)"\)`).FindStringSubmatch(script)
+	if match == nil {
+		t.Fatalf("Seed script does not embed base64 config: %s", script)
+	}
+
+	decodedJSON, err := base64.StdEncoding.DecodeString(match[1])
+	if err != nil {
+		t.Fatalf("Failed to decode embedded config: %v", err)
+	}
+
+	var decoded RunnerConfig
+	if err := json.Unmarshal(decodedJSON, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal embedded config: %v", err)
+	}
+	if decoded.Token != config.Token || decoded.Name != config.Name || decoded.Labels != config.Labels {
+		t.Errorf("Embedded config mismatch: expected %+v, got %+v", config, decoded)
+	}
+
+	// Without static addressing the prelude waits for DHCP on the hot-added adapter
+	if !strings.Contains(script, "Get-NetIPAddress") {
+		t.Error("Expected seed script to wait for a DHCP address")
+	}
+
+	config.Network = []AdapterAddress{{AdapterName: "build", IPAddress: "10.0.5.10", PrefixLength: 24}}
+	script, err = configSeedScript(config)
+	if err != nil {
+		t.Fatalf("Failed to build seed script: %v", err)
+	}
+	if strings.Contains(script, "Get-NetIPAddress") {
+		t.Error("Seed script should not wait for DHCP when static addresses are configured")
+	}
+}
+
+// ========================================
+// Hyper-V Script Builder Tests
+// ========================================
+
+func TestHyperVManager_HardwareProfileCommands(t *testing.T) {
+	secureBoot := false
+	cfg := config.Config{
+		HyperV: config.HyperVConfig{
+			VMMemoryMB: 4096,
+			Hardware: config.HardwareConfig{
+				DynamicMemory:  config.DynamicMemoryConfig{Enabled: true, MinimumMB: 2048, MaximumMB: 8192, BufferPercent: 20},
+				SecureBoot:     &secureBoot,
+				VTPM:           true,
+				CheckpointType: "Disabled",
+			},
+		},
+	}
+	manager := NewHyperVManager(cfg, testLogger())
+
+	cmd := manager.hardwareProfileCommands("runner-1")
+	for _, want := range []string{
+		`Set-VMMemory -VMName "runner-1" -DynamicMemoryEnabled $true -MinimumBytes 2048MB -StartupBytes 4096MB -MaximumBytes 8192MB -Buffer 20`,
+		`Set-VMFirmware -VMName "runner-1" -EnableSecureBoot Off`,
+		`Enable-VMTPM -VMName "runner-1"`,
+		`Set-VM -Name "runner-1" -CheckpointType Disabled`,
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("Expected hardware commands to contain %q, got:\n%s", want, cmd)
+		}
+	}
+	if strings.Contains(cmd, "ExposeVirtualizationExtensions") {
+		t.Error("Nested virtualization should not be enabled")
+	}
+
+	// The default profile leaves Hyper-V defaults untouched
+	defaultManager := NewHyperVManager(config.Config{}, testLogger())
+	if cmd := defaultManager.hardwareProfileCommands("runner-1"); cmd != "" {
+		t.Errorf("Expected no commands for default profile, got:\n%s", cmd)
+	}
+}
+
+func TestParseTemplateCheck(t *testing.T) {
+	const path = `C:\templates\runner.vhdx`
+	const hash = "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08"
+
+	tests := []struct {
+		name            string
+		output          string
+		requireManifest bool
+		wantErr         []string
+	}{
+		{
+			name:   "valid with manifest",
+			output: "TEMPLATE_EXISTS:true\r\nTEMPLATE_READONLY:True\r\nTEMPLATE_SIGNATURE:vhdxfile\r\nTEMPLATE_MANIFEST:" + strings.ToLower(hash) + "\r\nTEMPLATE_SHA256:" + hash + "\r\n",
+		},
+		{
+			name:   "valid without manifest",
+			output: "TEMPLATE_EXISTS:true\nTEMPLATE_READONLY:True\nTEMPLATE_SIGNATURE:vhdxfile\n",
+		},
+		{
+			name:    "missing",
+			output:  "TEMPLATE_EXISTS:false\n",
+			wantErr: []string{"file not found"},
+		},
+		{
+			name:            "manifest required",
+			output:          "TEMPLATE_EXISTS:true\nTEMPLATE_READONLY:True\nTEMPLATE_SIGNATURE:vhdxfile\n",
+			requireManifest: true,
+			wantErr:         []string{"manifest"},
+		},
+		{
+			name:    "every problem reported",
+			output:  "TEMPLATE_EXISTS:true\nTEMPLATE_READONLY:False\nTEMPLATE_SIGNATURE:conectix\nTEMPLATE_MANIFEST:abc\nTEMPLATE_SHA256:" + hash + "\nTEMPLATE_ATTACHED:packer-build\n",
+			wantErr: []string{"not read-only", "VHDX header", "does not match manifest", "packer-build"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseTemplateCheck(path, tt.output, tt.requireManifest)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrTemplateInvalid) {
+				t.Fatalf("Expected ErrTemplateInvalid, got %v", err)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Expected error to contain %q, got %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHyperVManager_ConfigInjector(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     configInjector
+	}{
+		{strategy: "", want: &mountInjector{}},
+		{strategy: config.InjectionMount, want: &mountInjector{}},
+		{strategy: config.InjectionISO, want: &isoInjector{}},
+		{strategy: config.InjectionKVP, want: &kvpInjector{}},
+		{strategy: config.InjectionCopyFile, want: &copyFileInjector{}},
+	}
+
+	for _, tt := range tests {
+		manager := NewHyperVManager(config.Config{HyperV: config.HyperVConfig{ConfigInjection: tt.strategy}}, testLogger())
+		if got, want := fmt.Sprintf("%T", manager.injector), fmt.Sprintf("%T", tt.want); got != want {
+			t.Errorf("Strategy %q: expected %s, got %s", tt.strategy, want, got)
+		}
+	}
+}
+
+func TestKVPChunks(t *testing.T) {
+	encoded := strings.Repeat("A", 2*kvpChunkSize+10)
+	chunks := kvpChunks(encoded)
+	if len(chunks) != 3 || len(chunks[2]) != 10 {
+		t.Fatalf("Expected 3 chunks with a 10 byte tail, got %d", len(chunks))
+	}
+	if strings.Join(chunks, "") != encoded {
+		t.Error("Chunks do not reassemble to the original value")
+	}
+	if chunks := kvpChunks("abc"); len(chunks) != 1 || chunks[0] != "abc" {
+		t.Errorf("Expected a single chunk, got %v", chunks)
+	}
+}
+
+func TestHyperVManager_DiagnosticsBundlePath(t *testing.T) {
+	manager := NewHyperVManager(config.Config{HyperV: config.HyperVConfig{
+		Diagnostics: config.DiagnosticsConfig{Path: `D:\diagnostics`},
+	}}, testLogger())
+	slot := &VMSlot{Name: "runner-1", CreatedAt: time.Date(2025, 11, 3, 14, 5, 9, 0, time.UTC)}
+
+	slotDir, bundleDir := manager.diagnosticsBundlePath(slot, time.Now())
+	if slotDir != `D:\diagnostics\runner-1` {
+		t.Errorf("Unexpected slot directory %s", slotDir)
+	}
+	if bundleDir != `D:\diagnostics\runner-1\20251103-140509` {
+		t.Errorf("Unexpected bundle directory %s", bundleDir)
+	}
+}
+
+func TestChooseStoragePath(t *testing.T) {
+	const gb = int64(1 << 30)
+	output := fmt.Sprintf("STORAGE_FREE:%d|D:\\vms\r\nSTORAGE_FREE:%d|E:\\vms\r\n", 30*gb, 80*gb)
+
+	path, free, err := chooseStoragePath(output, 20*gb)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if path != `E:\vms` || free != 80*gb {
+		t.Errorf("Expected E:\\vms with 80 GB free, got %s with %d bytes", path, free)
+	}
+
+	_, _, err = chooseStoragePath(output, 100*gb)
+	if !errors.Is(err, ErrInsufficientStorage) {
+		t.Fatalf("Expected ErrInsufficientStorage, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `D:\vms has 30.0 GB free`) {
+		t.Errorf("Expected error to list free space per path, got %v", err)
+	}
+
+	if _, _, err := chooseStoragePath("unexpected output", 0); err == nil {
+		t.Error("Expected error for unparseable output")
+	}
+}
+
 // ========================================
 // VMSlot Tests
 // ========================================